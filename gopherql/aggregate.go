@@ -0,0 +1,512 @@
+package gopherql
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// AggregateCall is an aggregate function applied to the rows of a group. Arg
+// is nil for COUNT(*), which counts rows rather than values. Distinct
+// aggregates each distinct value of Arg once.
+type AggregateCall struct {
+	Func     string
+	Arg      Expr
+	Distinct bool
+}
+
+// aggregateFunctions are the functions computed over the rows of a group.
+var aggregateFunctions = map[string]bool{
+	"COUNT": true, "SUM": true, "AVG": true, "MIN": true, "MAX": true,
+}
+
+// accumulator computes one aggregate, one value at a time. NULL values are
+// ignored by every aggregate.
+type accumulator interface {
+	add(val Value) error
+	result() (Value, error)
+}
+
+func newAccumulator(call AggregateCall) (accumulator, error) {
+
+	if call.Distinct {
+		inner := call
+		inner.Distinct = false
+		acc, err := newAccumulator(inner)
+		if err != nil {
+			return nil, err
+		}
+		return &distinctAccumulator{inner: acc, seen: map[string]bool{}}, nil
+	}
+
+	switch call.Func {
+	case "COUNT":
+		return &countAccumulator{}, nil
+	case "SUM":
+		return &sumAccumulator{}, nil
+	case "AVG":
+		return &avgAccumulator{}, nil
+	case "MIN":
+		return &extremeAccumulator{sign: -1}, nil
+	case "MAX":
+		return &extremeAccumulator{sign: 1}, nil
+	}
+
+	return nil, SQLStateError{Code: "42883", Msg: fmt.Sprintf("function %s does not exist", call.Func)}
+}
+
+func undefinedAggregate(name string, t ColumnType) SQLStateError {
+	return SQLStateError{Code: "42883", Msg: fmt.Sprintf("function %s(%s) does not exist", name, t)}
+}
+
+// misplacedAggregate reports an aggregate function where no rows are being
+// grouped.
+func misplacedAggregate(clause string) SQLStateError {
+	return SQLStateError{Code: "42803", Msg: fmt.Sprintf("aggregate functions are not allowed in %s", clause)}
+}
+
+// distinctAccumulator passes each distinct value on to inner once. The values
+// seen are held in memory, even by a HashAggregate that spills its groups, so
+// a DISTINCT aggregate over many distinct values needs room for all of them.
+type distinctAccumulator struct {
+	inner accumulator
+	seen  map[string]bool
+}
+
+func (a *distinctAccumulator) add(val Value) error {
+	if val.IsNull {
+		return nil
+	}
+	key, _ := hashKey(Row{val})
+	if a.seen[key] {
+		return nil
+	}
+	a.seen[key] = true
+	return a.inner.add(val)
+}
+
+func (a *distinctAccumulator) result() (Value, error) {
+	return a.inner.result()
+}
+
+type countAccumulator struct {
+	count int64
+}
+
+func (a *countAccumulator) add(val Value) error {
+	if !val.IsNull {
+		a.count++
+	}
+	return nil
+}
+
+func (a *countAccumulator) result() (Value, error) {
+	return NewInt64Value(a.count), nil
+}
+
+// sumAccumulator adds numbers with the arithmetic of +, so integer sums stay
+// exact and report overflow. The sum of no values is NULL.
+type sumAccumulator struct {
+	sum   Value
+	count int64
+}
+
+func (a *sumAccumulator) add(val Value) error {
+	if val.IsNull {
+		return nil
+	}
+	if !isNumeric(val.Type) {
+		return undefinedAggregate("SUM", val.Type)
+	}
+	if a.count == 0 {
+		a.sum = val
+	} else {
+		sum, err := evalArithmetic("+", a.sum, val)
+		if err != nil {
+			return err
+		}
+		a.sum = sum
+	}
+	a.count++
+	return nil
+}
+
+func (a *sumAccumulator) result() (Value, error) {
+	if a.count == 0 {
+		return NewNullValue(Float64Column), nil
+	}
+	if isInteger(a.sum.Type) {
+		return NewInt64Value(a.sum.IntValue), nil
+	}
+	return a.sum, nil
+}
+
+// avgAccumulator sums values as SUM does and divides by their count once, at
+// the end. The average of integers or DECIMALs is a DECIMAL, divided as /
+// divides them, and that of floats a double.
+type avgAccumulator struct {
+	sum sumAccumulator
+}
+
+func (a *avgAccumulator) add(val Value) error {
+	if val.IsNull {
+		return nil
+	}
+	if !isNumeric(val.Type) {
+		return undefinedAggregate("AVG", val.Type)
+	}
+	return a.sum.add(val)
+}
+
+func (a *avgAccumulator) result() (Value, error) {
+	if a.sum.count == 0 {
+		return NewNullValue(Float64Column), nil
+	}
+	sum := a.sum.sum
+	if isInteger(sum.Type) {
+		sum = NewDecimalValue(sum.IntValue, 0)
+	}
+	return evalArithmetic("/", sum, NewDecimalValue(a.sum.count, 0))
+}
+
+// extremeAccumulator keeps the smallest value when sign is -1 and the
+// largest when it is 1.
+type extremeAccumulator struct {
+	sign  int
+	value Value
+	found bool
+}
+
+func (a *extremeAccumulator) add(val Value) error {
+	if val.IsNull {
+		return nil
+	}
+	if !a.found {
+		a.value, a.found = val, true
+		return nil
+	}
+	cmp, err := compareValues(val, a.value)
+	if err != nil {
+		return err
+	}
+	if cmp*a.sign > 0 {
+		a.value = val
+	}
+	return nil
+}
+
+func (a *extremeAccumulator) result() (Value, error) {
+	if !a.found {
+		return NewNullValue(unknownType), nil
+	}
+	return a.value, nil
+}
+
+// aggregateGroup is a group being aggregated: its grouping values and an
+// accumulator for each aggregate.
+type aggregateGroup struct {
+	values       Row
+	accumulators []accumulator
+}
+
+func newGroup(values Row, calls []AggregateCall) (*aggregateGroup, error) {
+	group := &aggregateGroup{values: values, accumulators: make([]accumulator, len(calls))}
+	for idx, call := range calls {
+		acc, err := newAccumulator(call)
+		if err != nil {
+			return nil, err
+		}
+		group.accumulators[idx] = acc
+	}
+	return group, nil
+}
+
+func (g *aggregateGroup) add(calls []AggregateCall, scope Scope) error {
+	for idx, call := range calls {
+		val := NewBoolValue(true)
+		if call.Arg != nil {
+			var err error
+			if val, err = Eval(call.Arg, scope); err != nil {
+				return err
+			}
+		}
+		if err := g.accumulators[idx].add(val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// row returns the grouping values followed by the aggregate results.
+func (g *aggregateGroup) row() (Row, error) {
+	row := append(Row{}, g.values...)
+	for _, acc := range g.accumulators {
+		val, err := acc.result()
+		if err != nil {
+			return nil, err
+		}
+		row = append(row, val)
+	}
+	return row, nil
+}
+
+// spillPartitions is the number of partitions a hash aggregation splits the
+// rows of the groups it cannot hold in memory into.
+const spillPartitions = 16
+
+// HashAggregate groups the rows of Input by the values of GroupBy and
+// computes Aggregates over each group. Its rows hold the grouping values
+// followed by the aggregate results. Without GroupBy all rows form a single
+// group, which exists even when Input is empty.
+//
+// Once MaxGroups groups are held, rows of any other group are spilled to
+// temporary pages, split by a hash of their grouping values into
+// partitions. Each partition is aggregated in turn after the groups held in
+// memory are returned, so a group never spans partitions. Zero MaxGroups
+// holds every group in memory.
+type HashAggregate struct {
+	Input      Operator
+	GroupBy    []Expr
+	Aggregates []AggregateCall
+	// Names names the grouping columns and then the aggregates.
+	Names     []ResultColumn
+	MaxGroups int
+
+	// level counts the partitionings above this aggregation, varying the
+	// hash so that a partition splits when it spills again.
+	level      int
+	groups     []*aggregateGroup
+	pos        int
+	pager      *tempPager
+	partitions []*spillRun
+	partition  *HashAggregate
+}
+
+func (a *HashAggregate) Open() error {
+
+	if err := a.Close(); err != nil {
+		return err
+	}
+	if err := a.Input.Open(); err != nil {
+		return err
+	}
+	defer a.Input.Close()
+
+	columns := a.Input.Columns()
+	byKey := map[string]*aggregateGroup{}
+	for {
+		row, err := a.Input.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+		scope := resultScope{columns: columns, row: row}
+
+		values, err := evalAll(a.GroupBy, scope)
+		if err != nil {
+			return err
+		}
+		key, _ := hashKey(values)
+		group := byKey[key]
+		if group == nil {
+			if a.MaxGroups > 0 && len(a.groups) >= a.MaxGroups {
+				if err := a.spill(key, row); err != nil {
+					return err
+				}
+				continue
+			}
+			if group, err = newGroup(values, a.Aggregates); err != nil {
+				return err
+			}
+			byKey[key] = group
+			a.groups = append(a.groups, group)
+		}
+
+		if err := group.add(a.Aggregates, scope); err != nil {
+			return err
+		}
+	}
+
+	if len(a.groups) == 0 && len(a.GroupBy) == 0 {
+		group, err := newGroup(Row{}, a.Aggregates)
+		if err != nil {
+			return err
+		}
+		a.groups = append(a.groups, group)
+	}
+
+	for _, run := range a.partitions {
+		if err := run.Finish(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// spill writes a row of a group not held in memory to its partition.
+func (a *HashAggregate) spill(key string, row Row) error {
+
+	if a.pager == nil {
+		pager, err := newTempPager()
+		if err != nil {
+			return err
+		}
+		a.pager = pager
+		a.partitions = make([]*spillRun, spillPartitions)
+		for idx := range a.partitions {
+			a.partitions[idx] = newSpillRun(pager)
+		}
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte{byte(a.level)})
+	hash.Write([]byte(key))
+	return a.partitions[hash.Sum32()%spillPartitions].Write(row)
+}
+
+func (a *HashAggregate) Next() (Row, error) {
+
+	for {
+		if a.pos < len(a.groups) {
+			a.pos++
+			return a.groups[a.pos-1].row()
+		}
+		a.groups = nil
+
+		if a.partition != nil {
+			row, err := a.partition.Next()
+			if err != nil || row != nil {
+				return row, err
+			}
+			if err := a.partition.Close(); err != nil {
+				return nil, err
+			}
+			a.partition = nil
+		}
+
+		if len(a.partitions) == 0 {
+			return nil, nil
+		}
+		run := a.partitions[0]
+		a.partitions = a.partitions[1:]
+		if run.rows == 0 {
+			continue
+		}
+
+		a.partition = &HashAggregate{
+			Input:      &spillScan{run: run, columns: a.Input.Columns()},
+			GroupBy:    a.GroupBy,
+			Aggregates: a.Aggregates,
+			Names:      a.Names,
+			MaxGroups:  a.MaxGroups,
+			level:      a.level + 1,
+		}
+		if err := a.partition.Open(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (a *HashAggregate) Close() error {
+
+	a.groups, a.pos, a.partitions = nil, 0, nil
+
+	var err error
+	if a.partition != nil {
+		err = a.partition.Close()
+		a.partition = nil
+	}
+	if a.pager != nil {
+		if closeErr := a.pager.Close(); err == nil {
+			err = closeErr
+		}
+		a.pager = nil
+	}
+	return err
+}
+
+func (a *HashAggregate) Columns() []ResultColumn {
+	return a.Names
+}
+
+// SortAggregate computes the same rows as HashAggregate from input ordered
+// by the values of GroupBy, so that the rows of each group are adjacent. It
+// holds a single group at a time and returns the groups in input order.
+type SortAggregate struct {
+	Input      Operator
+	GroupBy    []Expr
+	Aggregates []AggregateCall
+	// Names names the grouping columns and then the aggregates.
+	Names []ResultColumn
+
+	// next is the first input row not yet aggregated.
+	next     Row
+	returned int
+}
+
+func (a *SortAggregate) Open() error {
+	a.next, a.returned = nil, 0
+	if err := a.Input.Open(); err != nil {
+		return err
+	}
+	return a.advance()
+}
+
+func (a *SortAggregate) advance() (err error) {
+	a.next, err = a.Input.Next()
+	return err
+}
+
+func (a *SortAggregate) Next() (Row, error) {
+
+	if a.next == nil {
+		if a.returned > 0 || len(a.GroupBy) > 0 {
+			return nil, nil
+		}
+		a.returned++
+		group, err := newGroup(Row{}, a.Aggregates)
+		if err != nil {
+			return nil, err
+		}
+		return group.row()
+	}
+
+	columns := a.Input.Columns()
+	var group *aggregateGroup
+	var groupKey string
+	for a.next != nil {
+		scope := resultScope{columns: columns, row: a.next}
+		values, err := evalAll(a.GroupBy, scope)
+		if err != nil {
+			return nil, err
+		}
+		key, _ := hashKey(values)
+		if group == nil {
+			if group, err = newGroup(values, a.Aggregates); err != nil {
+				return nil, err
+			}
+			groupKey = key
+		} else if key != groupKey {
+			break
+		}
+		if err := group.add(a.Aggregates, scope); err != nil {
+			return nil, err
+		}
+		if err := a.advance(); err != nil {
+			return nil, err
+		}
+	}
+
+	a.returned++
+	return group.row()
+}
+
+func (a *SortAggregate) Close() error {
+	a.next = nil
+	return a.Input.Close()
+}
+
+func (a *SortAggregate) Columns() []ResultColumn {
+	return a.Names
+}
@@ -0,0 +1,221 @@
+package gopherql
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAggregate_GroupBy(t *testing.T) {
+	dbFile := "groupByTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	db := openExecutorDB(t, dbFile,
+		"CREATE TABLE sales (id INTEGER PRIMARY KEY, region TEXT, item TEXT, amount INTEGER)",
+		"INSERT INTO sales VALUES (1, 'North', 'pen', 10), (2, 'South', 'ink', 20), (3, 'North', 'pen', 5), (4, 'East', 'cap', NULL), (5, 'South', 'pen', 7), (6, 'North', 'cap', 1)",
+	)
+	defer db.Close()
+
+	for _, test := range []struct {
+		sql      string
+		expected string
+	}{
+		{"SELECT COUNT(*), COUNT(amount), SUM(amount), MIN(item), MAX(amount) FROM sales", "6,5,43,cap,20"},
+		{"SELECT AVG(amount) FROM sales WHERE region = 'South'", "13.5000000000000000"},
+		{"SELECT COUNT(*), SUM(amount) FROM sales WHERE id > 100", "0,NULL"},
+		{"SELECT region, COUNT(*), SUM(amount) FROM sales GROUP BY region ORDER BY region", "East,1,NULL;North,3,16;South,2,27"},
+		{"SELECT region, SUM(amount) AS total FROM sales GROUP BY region ORDER BY total DESC", "East,NULL;South,27;North,16"},
+		{"SELECT item, COUNT(DISTINCT region) FROM sales GROUP BY 1 ORDER BY 1 DESC", "pen,2;ink,1;cap,2"},
+		{"SELECT region AS r FROM sales GROUP BY r HAVING COUNT(*) > 1 ORDER BY r", "North;South"},
+		{"SELECT region FROM sales GROUP BY region HAVING MAX(amount) < 20 ORDER BY COUNT(*) DESC", "North"},
+		{"SELECT UPPER(item), SUM(amount) + 1 FROM sales GROUP BY UPPER(item) ORDER BY 2", "CAP,2;INK,21;PEN,23"},
+		{"SELECT region, item, COUNT(*) FROM sales GROUP BY region, item ORDER BY item, region LIMIT 3", "East,cap,1;North,cap,1;South,ink,1"},
+		{"SELECT COUNT(*) FROM sales GROUP BY region HAVING region <> 'North' ORDER BY 1", "1;2"},
+	} {
+		if found := joinRows(queryRows(t, db, test.sql)); found != test.expected {
+			t.Errorf("unexpected result for %q: %s", test.sql, found)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		sql    string
+		sorted bool
+	}{
+		{"SELECT id, COUNT(*) FROM sales GROUP BY id ORDER BY id", true},
+		{"SELECT region, COUNT(*) FROM sales GROUP BY region ORDER BY region DESC", false},
+		{"SELECT region, COUNT(*) FROM sales GROUP BY region ORDER BY 2", false},
+		{"SELECT region, COUNT(*) FROM sales GROUP BY region", false},
+	} {
+		stmt, err := Parse(test.sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := tx.planSelect(stmt.(*SelectStmt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		input := plan.(*Project).Input
+		if sort, ok := input.(*Sort); ok {
+			input = sort.Input
+		}
+		if _, sorted := input.(*SortAggregate); sorted != test.sorted {
+			t.Errorf("unexpected aggregate for %q: %T", test.sql, input)
+		}
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		sql  string
+		code string
+	}{
+		{"SELECT region, item FROM sales GROUP BY region", "42803"},
+		{"SELECT id FROM sales WHERE COUNT(*) > 1", "42803"},
+		{"SELECT region FROM sales GROUP BY COUNT(*)", "42803"},
+		{"SELECT SUM(COUNT(*)) FROM sales", "42803"},
+		{"SELECT COUNT(*) FROM sales GROUP BY 2", "42P10"},
+		{"SELECT missing FROM sales GROUP BY region", "42703"},
+		{"SELECT SUM(*) FROM sales", "42883"},
+		{"SELECT SUM(region) FROM sales", "42883"},
+		{"SELECT region FROM sales GROUP BY region HAVING COUNT(*)", "42804"},
+		{"UPDATE sales SET amount = COUNT(*)", "42803"},
+	} {
+		_, err := db.Exec(test.sql)
+		expectSQLState(t, err, test.code)
+	}
+
+	err = db.RegisterFunction(Function{Name: "count", Call: func(args []Value) (Value, error) { return args[0], nil }})
+	expectSQLState(t, err, "42723")
+}
+
+func TestAggregate_NumericValues(t *testing.T) {
+	dbFile := "aggregateNumericTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	db := openExecutorDB(t, dbFile,
+		"CREATE TABLE nums (id INTEGER PRIMARY KEY, i BIGINT, d DECIMAL(18,1), f FLOAT8)",
+		"INSERT INTO nums VALUES (1, 1, 1.0, 1.0), (2, 2, 0.1, 0.5), (3, 1, 1.0, 1.5), (4, NULL, 0.1, NULL)",
+	)
+	defer db.Close()
+
+	// Numbers that compare equal group together and count as one distinct
+	// value, whatever their types.
+	for _, test := range []struct {
+		sql      string
+		expected string
+	}{
+		{"SELECT CASE WHEN id < 3 THEN i ELSE d END AS k, COUNT(*) FROM nums GROUP BY k ORDER BY 2, 1", "0.1,1;2,1;1,2"},
+		{"SELECT COUNT(*) FROM nums GROUP BY CASE WHEN id = 1 THEN f ELSE i END ORDER BY 1", "1;1;2"},
+		{"SELECT COUNT(DISTINCT CASE WHEN id = 1 THEN d ELSE i END) FROM nums", "2"},
+		{"SELECT COUNT(DISTINCT CASE WHEN id = 1 THEN f ELSE d END) FROM nums", "2"},
+		{"SELECT AVG(i), AVG(d), AVG(f) FROM nums", "1.3333333333333333,0.5500000000000000,1"},
+		{"SELECT AVG(d) FROM nums WHERE id IN (2, 4)", "0.1000000000000000"},
+	} {
+		if found := joinRows(queryRows(t, db, test.sql)); found != test.expected {
+			t.Errorf("unexpected result for %q: %s", test.sql, found)
+		}
+	}
+
+	// The sum behind an average overflows as that of SUM does, rather than
+	// losing precision.
+	for id := 5; id < 15; id++ {
+		if _, err := db.Exec(fmt.Sprintf("INSERT INTO nums VALUES (%d, 9223372036854775807, 99999999999999999.9, 0)", id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, sql := range []string{"SELECT AVG(i) FROM nums", "SELECT AVG(d) FROM nums"} {
+		_, err := db.Exec(sql)
+		expectSQLState(t, err, "22003")
+	}
+}
+
+func TestAggregate_Spill(t *testing.T) {
+	dbFile := "spillTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	db, err := Open(dbFile, &Options{SpillRows: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE big (id INTEGER PRIMARY KEY, body TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	insertRows(t, db, "big", 500)
+
+	// Group g holds the ids g, g + 50, ... g + 450, which sum to 10g + 2250.
+	groups := []string{}
+	for g := 0; g < 50; g++ {
+		groups = append(groups, fmt.Sprintf("%d,10,%d", g, 10*g+2250))
+	}
+	expected := strings.Join(groups, ";")
+
+	for _, sql := range []string{
+		"SELECT id % 50, COUNT(*), SUM(id) FROM big GROUP BY 1 ORDER BY 3",
+		"SELECT id % 50, COUNT(*), SUM(id) FROM big GROUP BY 1 ORDER BY 1",
+		"SELECT id % 50, COUNT(DISTINCT body || id), SUM(id) FROM big GROUP BY 1 HAVING MIN(id) < 50 ORDER BY 1",
+	} {
+		if found := joinRows(queryRows(t, db, sql)); found != expected {
+			t.Errorf("unexpected result for %q: %s", sql, found)
+		}
+	}
+
+	ids := []string{}
+	for id := 499; id >= 0; id-- {
+		ids = append(ids, fmt.Sprint(id))
+	}
+	if found := joinRows(queryRows(t, db, "SELECT id FROM big ORDER BY id DESC")); found != strings.Join(ids, ";") {
+		t.Errorf("unexpected sorted rows: %s", found)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	table, err := tx.Table("", "BIG")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aggregate := &HashAggregate{
+		Input:      &TableScan{Tx: tx, Table: table},
+		GroupBy:    []Expr{&BinaryExpr{Op: "%", Left: &Identifier{Name: "ID"}, Right: &IntegerLiteral{Value: 100}}},
+		Aggregates: []AggregateCall{{Func: "COUNT"}},
+		Names:      []ResultColumn{{Name: "G"}, {Name: "COUNT"}},
+		MaxGroups:  10,
+	}
+	if err := aggregate.Open(); err != nil {
+		t.Fatal(err)
+	}
+	if aggregate.pager == nil {
+		t.Fatal("expected the aggregate to spill")
+	}
+	spillFile := aggregate.pager.file.Name()
+
+	seen := map[string]bool{}
+	for _, row := range drainRows(t, aggregate.Next) {
+		if seen[row[0]] || row[1] != "5" {
+			t.Errorf("unexpected group: %s", strings.Join(row, ","))
+		}
+		seen[row[0]] = true
+	}
+	if len(seen) != 100 {
+		t.Errorf("expected 100 groups, got: %d", len(seen))
+	}
+	if err := aggregate.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(spillFile); !os.IsNotExist(err) {
+		t.Errorf("expected the spill file to be removed, got: %v", err)
+	}
+}
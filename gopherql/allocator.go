@@ -0,0 +1,131 @@
+package gopherql
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// PageAllocator hands out the pages of every Btree sharing a Pager. Pages a
+// tree no longer needs are pushed onto a free list and reused before the
+// file is grown, so freeing the pages of one tree never moves another.
+//
+// The free list is threaded through the free pages themselves, each one
+// recording the number of the next. Its head and length are kept in the
+// Header, so the list survives a restart.
+type PageAllocator struct {
+	Pager    Pager
+	PageSize int
+	// FirstPage is the lowest page number a tree may use. A FilePager keeps
+	// page 0 for the Header.
+	FirstPage int
+	FreeList  int
+	FreeCount int
+}
+
+func NewPageAllocator(pager Pager) *PageAllocator {
+	return &PageAllocator{
+		Pager:    pager,
+		PageSize: defaultPgSize,
+	}
+}
+
+func newFreePage(next int, size int) *Page {
+	page := NewPage(kindFree, size)
+	binary.BigEndian.PutUint32(page.Data, uint32(next))
+	return page
+}
+
+// nextFree returns the page following a free page in the free list.
+func (p *Page) nextFree() int {
+	return int(binary.BigEndian.Uint32(p.Data))
+}
+
+// Allocate stores page in a free page, or appends it when none is free, and
+// returns its number.
+func (a *PageAllocator) Allocate(page *Page) (int, error) {
+
+	if a.FreeCount == 0 {
+		return a.Pager.AppendPage(page)
+	}
+
+	num := a.FreeList
+	free, err := a.Pager.FetchPage(num)
+	if err != nil {
+		return -1, err
+	}
+	if free.Kind != kindFree {
+		return -1, fmt.Errorf("page %d on the free list is in use", num)
+	}
+
+	if err := a.Pager.StorePage(num, page); err != nil {
+		return -1, err
+	}
+	a.FreeList = free.nextFree()
+	a.FreeCount--
+	return num, nil
+}
+
+// Free pushes a page onto the free list. The page must no longer be
+// reachable from any tree.
+func (a *PageAllocator) Free(num int) error {
+
+	if err := a.Pager.StorePage(num, newFreePage(a.FreeList, a.PageSize)); err != nil {
+		return err
+	}
+	a.FreeList = num
+	a.FreeCount++
+	return nil
+}
+
+// FreePages returns the pages on the free list, in the order they will be
+// reused.
+func (a *PageAllocator) FreePages() ([]int, error) {
+
+	pages := make([]int, 0, a.FreeCount)
+	num := a.FreeList
+	for len(pages) < a.FreeCount {
+		page, err := a.Pager.FetchPage(num)
+		if err != nil {
+			return nil, err
+		}
+		if page.Kind != kindFree {
+			return nil, fmt.Errorf("page %d on the free list is in use", num)
+		}
+		pages = append(pages, num)
+		num = page.nextFree()
+	}
+	return pages, nil
+}
+
+// setFreePages replaces the free list with pages, the first of which is
+// reused first.
+func (a *PageAllocator) setFreePages(pages []int) error {
+
+	a.FreeList, a.FreeCount = 0, 0
+	for idx := len(pages) - 1; idx >= 0; idx-- {
+		if err := a.Free(pages[idx]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Tree returns the tree rooted at page root.
+func (a *PageAllocator) Tree(root int) *Btree {
+	return &Btree{
+		PageSize:  a.PageSize,
+		Pager:     a.Pager,
+		Allocator: a,
+		Root:      root,
+	}
+}
+
+// CreateTree allocates the root of a new, empty tree.
+func (a *PageAllocator) CreateTree() (*Btree, error) {
+
+	root, err := a.Allocate(NewPage(kindLeaf, a.PageSize))
+	if err != nil {
+		return nil, err
+	}
+	return a.Tree(root), nil
+}
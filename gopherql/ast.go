@@ -0,0 +1,576 @@
+package gopherql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Statement is the root of a parsed SQL statement.
+type Statement interface {
+	statement()
+}
+
+// Expr is any expression. String renders it back as SQL that parses to the
+// same expression.
+type Expr interface {
+	expr()
+	String() string
+}
+
+type TableName struct {
+	Schema string
+	Name   string
+}
+
+func (t TableName) String() string {
+	if t.Schema == "" {
+		return quoteIdentifier(t.Name)
+	}
+	return quoteIdentifier(t.Schema) + "." + quoteIdentifier(t.Name)
+}
+
+type ColumnDef struct {
+	Name       string
+	Type       ColumnType
+	Size       int
+	Precision  int
+	Scale      int
+	NotNull    bool
+	PrimaryKey bool
+	Default    Expr
+}
+
+// CheckDef is a CHECK constraint. Column is set when it was declared as part
+// of a column definition, and Name when it was given one.
+type CheckDef struct {
+	Name   string
+	Column string
+	Expr   Expr
+}
+
+type UniqueDef struct {
+	Name    string
+	Columns []string
+}
+
+type CreateTableStmt struct {
+	Name        TableName
+	IfNotExists bool
+	Columns     []ColumnDef
+	PrimaryKey  []string
+	Checks      []CheckDef
+	Uniques     []UniqueDef
+}
+
+type DropTableStmt struct {
+	Name     TableName
+	IfExists bool
+}
+
+// CreateIndexStmt creates an index in the schema of the table it is on.
+type CreateIndexStmt struct {
+	Name        string
+	Table       TableName
+	Columns     []string
+	Unique      bool
+	IfNotExists bool
+}
+
+type DropIndexStmt struct {
+	Name     TableName
+	IfExists bool
+}
+
+type InsertStmt struct {
+	Table   TableName
+	Columns []string
+	Rows    [][]Expr
+}
+
+type SelectColumn struct {
+	Expr  Expr
+	Alias string
+}
+
+type OrderTerm struct {
+	Expr Expr
+	Desc bool
+}
+
+// FromItem is a source of rows in a FROM clause, a TableRef or a JoinExpr.
+type FromItem interface {
+	fromItem()
+	String() string
+}
+
+// TableRef is a table read by a query. Alias, when set, replaces the table
+// name in column references.
+type TableRef struct {
+	Table TableName
+	Alias string
+}
+
+type JoinType int
+
+const (
+	InnerJoin JoinType = iota
+	LeftJoin
+	RightJoin
+	FullJoin
+	CrossJoin
+)
+
+// JoinExpr joins two sources of rows. On is nil for a CROSS JOIN, and for
+// tables listed with commas.
+type JoinExpr struct {
+	Type  JoinType
+	Left  FromItem
+	Right FromItem
+	On    Expr
+}
+
+func (*TableRef) fromItem() {}
+func (*JoinExpr) fromItem() {}
+
+type SelectStmt struct {
+	Columns []SelectColumn
+	From    FromItem
+	Where   Expr
+	GroupBy []Expr
+	Having  Expr
+	OrderBy []OrderTerm
+	Limit   Expr
+	Offset  Expr
+}
+
+type Assignment struct {
+	Column string
+	Value  Expr
+}
+
+type UpdateStmt struct {
+	Table TableName
+	Set   []Assignment
+	Where Expr
+}
+
+type DeleteStmt struct {
+	Table TableName
+	Where Expr
+}
+
+// VacuumStmt removes dead row versions and compacts the database file.
+type VacuumStmt struct{}
+
+// ExplainStmt shows the plan of a query, running it to report what each
+// operator did when Analyze is set.
+type ExplainStmt struct {
+	Analyze bool
+	Query   *SelectStmt
+}
+
+// AnalyzeStmt collects the statistics of a table for the planner, or of
+// every table of the default schema when Table is nil.
+type AnalyzeStmt struct {
+	Table *TableName
+}
+
+func (*CreateTableStmt) statement() {}
+func (*DropTableStmt) statement()   {}
+func (*CreateIndexStmt) statement() {}
+func (*DropIndexStmt) statement()   {}
+func (*InsertStmt) statement()      {}
+func (*SelectStmt) statement()      {}
+func (*UpdateStmt) statement()      {}
+func (*DeleteStmt) statement()      {}
+func (*VacuumStmt) statement()      {}
+func (*AnalyzeStmt) statement()     {}
+func (*ExplainStmt) statement()     {}
+
+type NullLiteral struct{}
+
+type BoolLiteral struct {
+	Value bool
+}
+
+type IntegerLiteral struct {
+	Value int64
+}
+
+// NumberLiteral keeps the source text of a number with a fraction or an
+// exponent, so that exact values are not lost before their type is known.
+type NumberLiteral struct {
+	Value string
+}
+
+type StringLiteral struct {
+	Value string
+}
+
+// Identifier is a column reference, optionally qualified by a table name.
+type Identifier struct {
+	Table string
+	Name  string
+}
+
+// Star is the * of a select list or COUNT(*), optionally qualified.
+type Star struct {
+	Table string
+}
+
+type UnaryExpr struct {
+	Op      string
+	Operand Expr
+}
+
+type BinaryExpr struct {
+	Op    string
+	Left  Expr
+	Right Expr
+}
+
+type IsNullExpr struct {
+	Expr Expr
+	Not  bool
+}
+
+// FunctionCall calls a function by name. A call bound to a function
+// registered with the database keeps it in fn, other calls are looked up
+// among the built-in functions.
+type FunctionCall struct {
+	Name string
+	Args []Expr
+	// Distinct is set for an aggregate over distinct values, as in
+	// COUNT(DISTINCT x).
+	Distinct bool
+	fn       *Function
+}
+
+// BetweenExpr is Expr BETWEEN Low AND High, both bounds included.
+type BetweenExpr struct {
+	Expr Expr
+	Low  Expr
+	High Expr
+	Not  bool
+}
+
+// InExpr tests Expr against a list of values.
+type InExpr struct {
+	Expr Expr
+	List []Expr
+	Not  bool
+}
+
+// LikeExpr matches Expr against a LIKE pattern, ignoring case for ILIKE.
+// Escape is nil for the default escape character, a backslash.
+type LikeExpr struct {
+	Expr       Expr
+	Pattern    Expr
+	Escape     Expr
+	IgnoreCase bool
+	Not        bool
+}
+
+type WhenClause struct {
+	Cond   Expr
+	Result Expr
+}
+
+// CaseExpr is either form of CASE. With an Operand each WHEN holds a value
+// compared with it, without one each WHEN holds a condition.
+type CaseExpr struct {
+	Operand Expr
+	Whens   []WhenClause
+	Else    Expr
+}
+
+// CastExpr converts Expr to the type of Target, as CAST(Expr AS type).
+type CastExpr struct {
+	Expr   Expr
+	Target *Column
+}
+
+func (*NullLiteral) expr()    {}
+func (*BoolLiteral) expr()    {}
+func (*IntegerLiteral) expr() {}
+func (*NumberLiteral) expr()  {}
+func (*StringLiteral) expr()  {}
+func (*Identifier) expr()     {}
+func (*Star) expr()           {}
+func (*UnaryExpr) expr()      {}
+func (*BinaryExpr) expr()     {}
+func (*IsNullExpr) expr()     {}
+func (*FunctionCall) expr()   {}
+func (*BetweenExpr) expr()    {}
+func (*InExpr) expr()         {}
+func (*LikeExpr) expr()       {}
+func (*CaseExpr) expr()       {}
+func (*CastExpr) expr()       {}
+
+// quoteIdentifier only quotes names that would not survive case folding or
+// that clash with a keyword.
+func quoteIdentifier(name string) string {
+	plain := name != "" && !keywords[name]
+	for idx, r := range name {
+		isUpper := r >= 'A' && r <= 'Z'
+		isDigit := r >= '0' && r <= '9'
+		if !(isUpper || r == '_' || (isDigit && idx > 0)) {
+			plain = false
+		}
+	}
+	if plain {
+		return name
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func (e *NullLiteral) String() string {
+	return "NULL"
+}
+
+func (e *BoolLiteral) String() string {
+	if e.Value {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+func (e *IntegerLiteral) String() string {
+	return fmt.Sprintf("%d", e.Value)
+}
+
+func (e *NumberLiteral) String() string {
+	return e.Value
+}
+
+func (e *StringLiteral) String() string {
+	return "'" + strings.ReplaceAll(e.Value, "'", "''") + "'"
+}
+
+func (e *Identifier) String() string {
+	if e.Table == "" {
+		return quoteIdentifier(e.Name)
+	}
+	return quoteIdentifier(e.Table) + "." + quoteIdentifier(e.Name)
+}
+
+func (e *Star) String() string {
+	if e.Table == "" {
+		return "*"
+	}
+	return quoteIdentifier(e.Table) + ".*"
+}
+
+func (e *UnaryExpr) String() string {
+	if e.Op == "NOT" {
+		return "(NOT " + e.Operand.String() + ")"
+	}
+	return e.Op + "(" + e.Operand.String() + ")"
+}
+
+func (e *BinaryExpr) String() string {
+	return "(" + e.Left.String() + " " + e.Op + " " + e.Right.String() + ")"
+}
+
+func (e *IsNullExpr) String() string {
+	if e.Not {
+		return "(" + e.Expr.String() + " IS NOT NULL)"
+	}
+	return "(" + e.Expr.String() + " IS NULL)"
+}
+
+// String writes the calls with a syntax of their own, such as EXTRACT, the
+// way they are written in SQL.
+func (e *FunctionCall) String() string {
+	switch {
+	case sqlValueFunctions[e.Name] && len(e.Args) == 0:
+		return e.Name
+	case e.Name == "EXTRACT" && len(e.Args) == 2:
+		if field, ok := e.Args[0].(*StringLiteral); ok {
+			return "EXTRACT(" + field.Value + " FROM " + e.Args[1].String() + ")"
+		}
+	case e.Name == "POSITION" && len(e.Args) == 2:
+		return "POSITION(" + e.Args[0].String() + " IN " + e.Args[1].String() + ")"
+	}
+
+	args := make([]string, len(e.Args))
+	for idx, arg := range e.Args {
+		args[idx] = arg.String()
+	}
+	if e.Distinct {
+		return e.Name + "(DISTINCT " + strings.Join(args, ", ") + ")"
+	}
+	return e.Name + "(" + strings.Join(args, ", ") + ")"
+}
+
+// walkExpr calls visit for expr and then for each expression within it.
+func walkExpr(expr Expr, visit func(Expr)) {
+
+	if expr == nil {
+		return
+	}
+	visit(expr)
+
+	children := []Expr{}
+	switch e := expr.(type) {
+	case *UnaryExpr:
+		children = append(children, e.Operand)
+	case *BinaryExpr:
+		children = append(children, e.Left, e.Right)
+	case *IsNullExpr:
+		children = append(children, e.Expr)
+	case *FunctionCall:
+		children = append(children, e.Args...)
+	case *BetweenExpr:
+		children = append(children, e.Expr, e.Low, e.High)
+	case *InExpr:
+		children = append(append(children, e.Expr), e.List...)
+	case *LikeExpr:
+		children = append(children, e.Expr, e.Pattern, e.Escape)
+	case *CaseExpr:
+		children = append(children, e.Operand, e.Else)
+		for _, when := range e.Whens {
+			children = append(children, when.Cond, when.Result)
+		}
+	case *CastExpr:
+		children = append(children, e.Expr)
+	}
+
+	for _, child := range children {
+		walkExpr(child, visit)
+	}
+}
+
+// transformExpr returns a copy of expr in which every subexpression replace
+// returns a replacement for is replaced, leaving expr itself unchanged. The
+// replacements are not transformed further.
+func transformExpr(expr Expr, replace func(Expr) (Expr, bool)) Expr {
+
+	if expr == nil {
+		return nil
+	}
+	if replaced, ok := replace(expr); ok {
+		return replaced
+	}
+
+	transform := func(expr Expr) Expr {
+		return transformExpr(expr, replace)
+	}
+	transformAll := func(exprs []Expr) []Expr {
+		result := make([]Expr, len(exprs))
+		for idx, expr := range exprs {
+			result[idx] = transform(expr)
+		}
+		return result
+	}
+
+	switch e := expr.(type) {
+	case *UnaryExpr:
+		return &UnaryExpr{Op: e.Op, Operand: transform(e.Operand)}
+	case *BinaryExpr:
+		return &BinaryExpr{Op: e.Op, Left: transform(e.Left), Right: transform(e.Right)}
+	case *IsNullExpr:
+		return &IsNullExpr{Expr: transform(e.Expr), Not: e.Not}
+	case *FunctionCall:
+		return &FunctionCall{Name: e.Name, Args: transformAll(e.Args), Distinct: e.Distinct, fn: e.fn}
+	case *BetweenExpr:
+		return &BetweenExpr{Expr: transform(e.Expr), Low: transform(e.Low), High: transform(e.High), Not: e.Not}
+	case *InExpr:
+		return &InExpr{Expr: transform(e.Expr), List: transformAll(e.List), Not: e.Not}
+	case *LikeExpr:
+		return &LikeExpr{
+			Expr: transform(e.Expr), Pattern: transform(e.Pattern), Escape: transform(e.Escape),
+			IgnoreCase: e.IgnoreCase, Not: e.Not,
+		}
+	case *CaseExpr:
+		whens := make([]WhenClause, len(e.Whens))
+		for idx, when := range e.Whens {
+			whens[idx] = WhenClause{Cond: transform(when.Cond), Result: transform(when.Result)}
+		}
+		return &CaseExpr{Operand: transform(e.Operand), Whens: whens, Else: transform(e.Else)}
+	case *CastExpr:
+		return &CastExpr{Expr: transform(e.Expr), Target: e.Target}
+	}
+	return expr
+}
+
+func notPrefix(not bool) string {
+	if not {
+		return "NOT "
+	}
+	return ""
+}
+
+func (e *BetweenExpr) String() string {
+	return "(" + e.Expr.String() + " " + notPrefix(e.Not) + "BETWEEN " + e.Low.String() + " AND " + e.High.String() + ")"
+}
+
+func (e *InExpr) String() string {
+	items := make([]string, len(e.List))
+	for idx, item := range e.List {
+		items[idx] = item.String()
+	}
+	return "(" + e.Expr.String() + " " + notPrefix(e.Not) + "IN (" + strings.Join(items, ", ") + "))"
+}
+
+func (e *LikeExpr) String() string {
+	op := "LIKE"
+	if e.IgnoreCase {
+		op = "ILIKE"
+	}
+	text := "(" + e.Expr.String() + " " + notPrefix(e.Not) + op + " " + e.Pattern.String()
+	if e.Escape != nil {
+		text += " ESCAPE " + e.Escape.String()
+	}
+	return text + ")"
+}
+
+func (e *CaseExpr) String() string {
+	text := "CASE"
+	if e.Operand != nil {
+		text += " " + e.Operand.String()
+	}
+	for _, when := range e.Whens {
+		text += " WHEN " + when.Cond.String() + " THEN " + when.Result.String()
+	}
+	if e.Else != nil {
+		text += " ELSE " + e.Else.String()
+	}
+	return text + " END"
+}
+
+func (t JoinType) String() string {
+	switch t {
+	case LeftJoin:
+		return "LEFT JOIN"
+	case RightJoin:
+		return "RIGHT JOIN"
+	case FullJoin:
+		return "FULL JOIN"
+	case CrossJoin:
+		return "CROSS JOIN"
+	}
+	return "JOIN"
+}
+
+func (t *TableRef) String() string {
+	if t.Alias == "" {
+		return t.Table.String()
+	}
+	return t.Table.String() + " AS " + quoteIdentifier(t.Alias)
+}
+
+func (j *JoinExpr) String() string {
+	right := j.Right.String()
+	if _, ok := j.Right.(*JoinExpr); ok {
+		right = "(" + right + ")"
+	}
+	if j.On == nil {
+		return j.Left.String() + " " + j.Type.String() + " " + right
+	}
+	return j.Left.String() + " " + j.Type.String() + " " + right + " ON " + j.On.String()
+}
+
+func (e *CastExpr) String() string {
+	return "CAST(" + e.Expr.String() + " AS " + e.Target.TypeName() + ")"
+}
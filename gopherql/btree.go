@@ -2,45 +2,58 @@ package gopherql
 
 import (
 	"bytes"
-	"encoding/binary"
+	"errors"
+	"fmt"
 	"sort"
 )
 
+// Btree is one of the trees stored by a Pager. Its root page never moves, as
+// splitting the root moves its contents into two new children instead, so
+// the root can be recorded wherever the tree is referenced from.
 type Btree struct {
-	PageSize int
-	Pager    Pager
+	PageSize  int
+	Pager     Pager
+	Allocator *PageAllocator
+	// Root is the page number of the root page, or pagerRoot to leave it to
+	// the Pager, which then holds a single tree whose root is created on the
+	// first Add.
+	Root int
 }
 
-type ReversibleInts []int
+// pagerRoot is the Root of a tree whose root page the Pager records. Page 0
+// may be the root of a tree created by a PageAllocator, so it cannot be.
+const pagerRoot = -1
 
-func (r ReversibleInts) Len() int {
-	return len(r)
-}
-
-func (r ReversibleInts) Less(i, j int) bool {
-	return r[i] < r[j]
+func NewBTree(pager Pager) *Btree {
+	return &Btree{
+		PageSize:  defaultPgSize,
+		Pager:     pager,
+		Allocator: NewPageAllocator(pager),
+		Root:      pagerRoot,
+	}
 }
 
-func (r ReversibleInts) Swap(i, j int) {
-	r[i], r[j] = r[j], r[i]
+func (bt Btree) rootPage() int {
+	if bt.Root != pagerRoot {
+		return bt.Root
+	}
+	return bt.Pager.GetRootPage()
 }
 
-func NewBTree(pager Pager) *Btree {
-	return &Btree{
-		PageSize: defaultPgSize,
-		Pager:    pager,
-	}
+// isEmpty reports whether the tree has no root page yet.
+func (bt Btree) isEmpty() bool {
+	return bt.Root == pagerRoot && bt.Pager.TotalPages() == 0
 }
 
 func (bt Btree) SearchPage(key []byte) ([]int, []int, error) {
 
-	if bt.Pager.TotalPages() == 0 {
+	if bt.isEmpty() {
 		return []int{}, []int{}, nil
 	}
 
 	path := []int{}
 	depthIterator := []int{}
-	currentPage := bt.Pager.GetRootPage()
+	currentPage := bt.rootPage()
 
 	for {
 		path = append(path, currentPage)
@@ -77,178 +90,558 @@ func (bt Btree) SearchPage(key []byte) ([]int, []int, error) {
 	return path, depthIterator, nil
 }
 
-func (bt Btree) Update(old, new *PageObject, transID int) []int {
+// maxObjectLength is the largest object stored inline. Anything bigger is
+// written as blob pieces so that any split always leaves both halves fitting.
+func (bt Btree) maxObjectLength() int {
+	return (bt.PageSize - pageHeaderSize) / 4
+}
+
+func (bt Btree) Add(obj *PageObject) error {
+
+	if obj.Length() > bt.maxObjectLength() {
+		return bt.addBlob(obj)
+	}
+
+	return bt.add(obj)
+}
+
+// addBlob chunks a large value into full size pieces plus a trailing fragment,
+// then stores a reference object under the original key.
+func (bt Btree) addBlob(obj *PageObject) error {
 
-	if bt.Pager.TotalPages() == 0 {
-		return []int{}
+	chunkSize := bt.maxObjectLength() - pageObjectPrefixLength - len(blobObjectKey(obj.Key, 0))
+	if chunkSize <= 0 {
+		return errors.New("key too large for page")
 	}
 
-	if bytes.Compare(old.Key, new.Key) == 0 {
-		// TODO - Update single page
-		return []int{}
+	pieces := len(obj.Value) / chunkSize
+	for part := 0; part < pieces; part++ {
+		chunk := obj.Value[part*chunkSize : (part+1)*chunkSize]
+		blob := NewBlobPageObject(obj.Key, chunk, obj.TransactionID, obj.DeleteID, uint32(part))
+		if err := bt.add(blob); err != nil {
+			return err
+		}
+	}
+
+	hasFrag := len(obj.Value)%chunkSize != 0
+	if hasFrag {
+		frag := NewFragmentPageObject(obj.Key, obj.Value[pieces*chunkSize:], obj.TransactionID, obj.DeleteID)
+		if err := bt.add(frag); err != nil {
+			return err
+		}
 	}
-	return []int{}
+
+	return bt.add(NewReferencePageObject(obj.Key, obj.TransactionID, obj.DeleteID, uint32(pieces), hasFrag))
 }
 
-func (bt Btree) Remove(key []byte, transID int, handleBlob bool) error {
+func (bt Btree) add(obj *PageObject) error {
 
-	path, _, err := bt.SearchPage(key)
+	if bt.isEmpty() {
+		page := NewPage(kindLeaf, bt.PageSize)
+		if err := page.Add(obj); err != nil {
+			return err
+		}
+		pageNumber, err := bt.Allocator.Allocate(page)
+		if err != nil {
+			return err
+		}
+		return bt.Pager.SetRootPage(pageNumber)
+	}
+
+	path, _, err := bt.SearchPage(obj.Key)
 	if err != nil {
 		return err
 	}
 	pageNumber := path[len(path)-1]
-	emptyPages := []int{}
 
 	page, err := bt.Pager.FetchPage(pageNumber)
 	if err != nil {
 		return err
 	}
 
-	objToDelete := page.Get(key, transID)
+	if page.CanFit(obj) {
+		if err := page.Add(obj); err != nil {
+			return err
+		}
+		return bt.Pager.StorePage(pageNumber, page)
+	}
 
-	if handleBlob && objToDelete.IsBlobRef {
+	objects := page.Objects()
+	if len(page.Versions(obj.Key, objects)) >= 2 {
+		return SQLStateError{
+			Code: "40001",
+			Msg:  "avoiding concurrent write on individual row",
+		}
+	}
 
-		blobPieces, hasFrag := objToDelete.BlobInfo()
+	return bt.split(path, len(path)-1, kindLeaf, append(objects, obj))
+}
 
-		for part := 0; part < blobPieces; part++ {
-			if err := bt.Remove(blobObjectKey(key, uint32(part)), transID, false); err != nil {
-				return err
-			}
-		}
+// split divides the objects destined for path[level] between the existing
+// page and a newly allocated sibling, then pushes the sibling's first key
+// into the parent. Splitting the root moves both halves into new children,
+// growing the tree by one level while the root keeps its page number.
+func (bt Btree) split(path []int, level int, kind byte, objects []*PageObject) error {
 
-		if hasFrag {
-			// TODO - is this correct?
-			if err := bt.Remove(newBlobFragmentKey(key), transID, false); err != nil {
-				return err
-			}
+	sort.Sort(PageObjects(objects))
+	leftObjects, rightObjects := splitObjects(objects)
+
+	left, err := bt.newPageWith(kind, leftObjects)
+	if err != nil {
+		return err
+	}
+	right, err := bt.newPageWith(kind, rightObjects)
+	if err != nil {
+		return err
+	}
+
+	if level == 0 {
+		leftNumber, err := bt.Allocator.Allocate(left)
+		if err != nil {
+			return err
+		}
+		rightNumber, err := bt.Allocator.Allocate(right)
+		if err != nil {
+			return err
 		}
+		root, err := bt.newPageWith(kindNotLeaf, []*PageObject{
+			newPointerObject(left.Head().Key, leftNumber),
+			newPointerObject(right.Head().Key, rightNumber),
+		})
+		if err != nil {
+			return err
+		}
+		return bt.Pager.StorePage(path[0], root)
+	}
 
-		return bt.Remove(key, transID, false)
+	if err := bt.Pager.StorePage(path[level], left); err != nil {
+		return err
+	}
+	rightNumber, err := bt.Allocator.Allocate(right)
+	if err != nil {
+		return err
 	}
 
-	page.Delete(key, transID)
-	if err := bt.Pager.StorePage(pageNumber, page); err != nil {
+	parentNumber := path[level-1]
+	parent, err := bt.Pager.FetchPage(parentNumber)
+	if err != nil {
 		return err
 	}
 
-	if page.IsEmpty() {
-		if pageNumber == bt.Pager.GetRootPage() {
-			return bt.Pager.TruncateAll()
+	pointer := newPointerObject(right.Head().Key, rightNumber)
+	if parent.CanFit(pointer) {
+		if err := parent.Add(pointer); err != nil {
+			return err
 		}
+		return bt.Pager.StorePage(parentNumber, parent)
+	}
+
+	return bt.split(path, level-1, kindNotLeaf, append(parent.Objects(), pointer))
+}
 
-		emptyPages = append(emptyPages, pageNumber)
+// splitObjects cuts sorted objects roughly in half by size, never separating
+// versions of the same key.
+func splitObjects(objects []*PageObject) ([]*PageObject, []*PageObject) {
+
+	total := 0
+	for _, obj := range objects {
+		total += obj.Length()
 	}
 
-	if len(path) > 1 {
-		for pathIdx := len(path) - 2; pathIdx >= 0; pathIdx-- {
-			t, err := bt.Pager.FetchPage(path[pathIdx])
-			if err != nil {
-				return err
-			}
-			didDelete := t.Delete(key, 0)
+	mid, size := 0, 0
+	for mid < len(objects)-1 && size+objects[mid].Length() <= total/2 {
+		size += objects[mid].Length()
+		mid++
+	}
+	if mid == 0 {
+		mid = 1
+	}
 
-			lowerPage, err := bt.Pager.FetchPage(path[pathIdx+1])
-			if err != nil {
-				return err
-			}
-			if !lowerPage.IsEmpty() && didDelete {
-				lowerBound := lowerPage.Head().Key
+	cut := mid
+	for cut < len(objects) && bytes.Equal(objects[cut].Key, objects[cut-1].Key) {
+		cut++
+	}
+	if cut == len(objects) {
+		cut = mid
+		for cut > 1 && bytes.Equal(objects[cut].Key, objects[cut-1].Key) {
+			cut--
+		}
+	}
+
+	return objects[:cut], objects[cut:]
+}
+
+func (bt Btree) newPageWith(kind byte, objects []*PageObject) (*Page, error) {
+	page := NewPage(kind, bt.PageSize)
+	for _, obj := range objects {
+		if !page.CanFit(obj) {
+			return nil, errors.New("objects cannot fit in split page")
+		}
+		if err := page.Add(obj); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
 
-				buf := NewByteWriter()
-				buf.WriteUint32(path[pathIdx+1])
-				buf.WriteBytes(make([]byte, defaultPgSize-uint32Size))
+func newPointerObject(key []byte, pageNumber int) *PageObject {
+	buf := NewByteWriter()
+	buf.WriteUint32(pageNumber)
+	return NewPageObject(key, buf.Bytes(), 0, 0)
+}
 
-				obj := NewPageObject(lowerBound[:], buf.Bytes(), 0, 0)
+// Get returns the version of key written by transID, reassembling the full
+// value when it was stored as a blob. A missing key returns nil.
+func (bt Btree) Get(key []byte, transID int) (*PageObject, error) {
 
-				if err = t.Add(obj); err != nil {
-					return err
-				}
-			}
+	obj, err := bt.get(key, transID)
+	if err != nil || obj == nil {
+		return obj, err
+	}
 
-			err = bt.Pager.StorePage(path[pathIdx], t)
-			if err != nil {
-				return err
-			}
+	if obj.IsBlobRef {
+		return bt.resolveBlob(obj)
+	}
+	return obj, nil
+}
+
+func (bt Btree) get(key []byte, transID int) (*PageObject, error) {
+
+	if bt.isEmpty() {
+		return nil, nil
+	}
+
+	path, _, err := bt.SearchPage(key)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := bt.Pager.FetchPage(path[len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	return page.Get(key, transID), nil
+}
+
+// resolveBlob replaces a blob reference with an object holding the value
+// concatenated from all of its pieces.
+func (bt Btree) resolveBlob(ref *PageObject) (*PageObject, error) {
+
+	blobPieces, hasFrag := ref.BlobInfo()
+	transID := int(ref.TransactionID)
+
+	value := bytes.Buffer{}
+	for part := 0; part < blobPieces; part++ {
+		piece, err := bt.get(blobObjectKey(ref.Key, uint32(part)), transID)
+		if err != nil {
+			return nil, err
+		}
+		if piece == nil {
+			return nil, fmt.Errorf("missing blob piece %d for key: %s", part, ref.Key)
+		}
+		value.Write(piece.Value)
+	}
+
+	if hasFrag {
+		frag, err := bt.get(newBlobFragmentKey(ref.Key), transID)
+		if err != nil {
+			return nil, err
+		}
+		if frag == nil {
+			return nil, fmt.Errorf("missing blob fragment for key: %s", ref.Key)
+		}
+		value.Write(frag.Value)
+	}
+
+	return &PageObject{
+		Key:           ref.Key,
+		Value:         value.Bytes(),
+		TransactionID: ref.TransactionID,
+		DeleteID:      ref.DeleteID,
+	}, nil
+}
+
+// Update replaces old with new on behalf of transID and returns the leaf pages
+// holding the affected keys. Rows created by transID are rewritten in place,
+// rows created by other transactions are expired so that older snapshots can
+// still see them.
+func (bt Btree) Update(old, new *PageObject, transID int) ([]int, error) {
+
+	if bt.isEmpty() {
+		return nil, errors.New("cannot update empty tree")
+	}
+
+	stored, err := bt.get(old.Key, int(old.TransactionID))
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		return nil, errors.New("row to update does not exist")
+	}
+
+	// The stored version is checked rather than old, which may have been
+	// read before another transaction expired it.
+	if stored.DeleteID != 0 {
+		return nil, SQLStateError{
+			Code: "40001",
+			Msg:  "row has already been deleted or updated",
+		}
+	}
+
+	replacement := NewPageObject(new.Key, new.Value, uint32(transID), 0)
+	sameKey := bytes.Compare(old.Key, new.Key) == 0
+	ownRow := old.TransactionID == uint32(transID)
+
+	if sameKey && ownRow {
+		pageNumber, page, err := bt.leafFor(old.Key)
+		if err != nil {
+			return nil, err
+		}
 
-			if t.IsEmpty() {
-				if path[pathIdx] == bt.Pager.GetRootPage() {
-					return bt.Pager.TruncateAll()
-				}
+		fits := int(page.Used)-stored.Length()+replacement.Length() <= page.Size()
+		if !stored.IsBlobRef && replacement.Length() <= bt.maxObjectLength() && fits {
+			page.Delete(old.Key, transID)
+			if err := page.Add(replacement); err != nil {
+				return nil, err
+			}
+			return []int{pageNumber}, bt.Pager.StorePage(pageNumber, page)
+		}
+	}
 
-				emptyPages = append(emptyPages, path[pathIdx])
+	// The new version must have room before the old one is touched, so a
+	// conflict leaves the tree unchanged.
+	if !(sameKey && ownRow) {
+		_, page, err := bt.leafFor(new.Key)
+		if err != nil {
+			return nil, err
+		}
+		if len(page.Versions(new.Key, page.Objects())) >= 2 {
+			return nil, SQLStateError{
+				Code: "40001",
+				Msg:  "avoiding concurrent write on individual row",
 			}
+		}
+	}
 
+	if ownRow {
+		if err := bt.Remove(old.Key, transID, true); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := bt.Expire(old.Key, int(old.TransactionID), transID); err != nil {
+			return nil, err
 		}
 	}
 
-	//TODO - Fill empty pages
-	bt.FillEmptyPages(emptyPages)
+	if err := bt.Add(replacement); err != nil {
+		return nil, err
+	}
 
-	return nil
+	modified := []int{}
+	for _, key := range [][]byte{old.Key, new.Key} {
+		pageNumber, _, err := bt.leafFor(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(modified) == 0 || modified[0] != pageNumber {
+			modified = append(modified, pageNumber)
+		}
+	}
+
+	return modified, nil
 }
 
-func (bt Btree) FillEmptyPages(emptyPages []int) error {
+// rewrite replaces the value of the version of key written by transID with
+// one of the same length, so that no page has to split or move. Values
+// stored as blobs are rewritten piece by piece.
+func (bt Btree) rewrite(key []byte, transID int, value []byte) error {
 
-	_ = sort.Reverse(ReversibleInts(emptyPages))
+	pageNumber, page, err := bt.leafFor(key)
+	if err != nil {
+		return err
+	}
+	obj := page.Get(key, transID)
+	if obj == nil {
+		return fmt.Errorf("cannot rewrite missing key: %s", key)
+	}
+	if !obj.IsBlobRef {
+		if len(obj.Value) != len(value) {
+			return errors.New("rewritten value must keep its length")
+		}
+		return bt.replaceValue(pageNumber, page, obj, value)
+	}
+
+	blobPieces, hasFrag := obj.BlobInfo()
+	keys := [][]byte{}
+	for part := 0; part < blobPieces; part++ {
+		keys = append(keys, blobObjectKey(key, uint32(part)))
+	}
+	if hasFrag {
+		keys = append(keys, newBlobFragmentKey(key))
+	}
 
-	for _, emptyPage := range emptyPages {
-		lastPage, err := bt.Pager.FetchPage(bt.Pager.TotalPages() - 1)
+	offset := 0
+	for _, pieceKey := range keys {
+		pageNumber, page, err := bt.leafFor(pieceKey)
 		if err != nil {
 			return err
 		}
-		lastPageKey := lastPage.Head().Key
-		pathToLastPage, _, err := bt.SearchPage(lastPageKey)
-		if err != nil {
+		piece := page.Get(pieceKey, transID)
+		if piece == nil {
+			return fmt.Errorf("missing blob piece for key: %s", key)
+		}
+		end := offset + len(piece.Value)
+		if end > len(value) {
+			return errors.New("rewritten value must keep its length")
+		}
+		if err := bt.replaceValue(pageNumber, page, piece, value[offset:end]); err != nil {
 			return err
 		}
+		offset = end
+	}
+	if offset != len(value) {
+		return errors.New("rewritten value must keep its length")
+	}
+	return nil
+}
 
-		for idx := 0; idx < len(pathToLastPage)-1; idx++ {
-			if pathToLastPage[idx+1] == bt.Pager.TotalPages()-1 {
-				ancestor, err := bt.Pager.FetchPage(pathToLastPage[idx])
-				if err != nil {
-					return err
-				}
-				ancestor.Delete(lastPageKey, 0)
-
-				var buffer []byte
-				binary.LittleEndian.PutUint32(buffer, uint32(emptyPage))
-
-				newObj := NewPageObject(lastPageKey, buffer, 0, 0)
-				if err := ancestor.Add(newObj); err != nil {
-					return err
-				}
-				if err := bt.Pager.StorePage(pathToLastPage[idx], ancestor); err != nil {
-					return err
-				}
+func (bt Btree) replaceValue(pageNumber int, page *Page, obj *PageObject, value []byte) error {
+
+	replacement := copyObject(obj)
+	replacement.Value = value
+
+	page.Delete(replacement.Key, int(replacement.TransactionID))
+	if err := page.Add(replacement); err != nil {
+		return err
+	}
+	return bt.Pager.StorePage(pageNumber, page)
+}
+
+func (bt Btree) leafFor(key []byte) (int, *Page, error) {
+
+	path, _, err := bt.SearchPage(key)
+	if err != nil {
+		return -1, nil, err
+	}
+	if len(path) == 0 {
+		return -1, nil, errors.New("tree is empty")
+	}
+
+	pageNumber := path[len(path)-1]
+	page, err := bt.Pager.FetchPage(pageNumber)
+	if err != nil {
+		return -1, nil, err
+	}
+	return pageNumber, page, nil
+}
+
+// Remove deletes the version of key written by transID. Pages left empty are
+// unlinked from their parent and returned to the allocator, except for the
+// root, which stays behind as an empty leaf.
+func (bt Btree) Remove(key []byte, transID int, handleBlob bool) error {
+
+	if bt.isEmpty() {
+		return nil
+	}
+
+	path, depthIterator, err := bt.SearchPage(key)
+	if err != nil {
+		return err
+	}
+	pageNumber := path[len(path)-1]
+
+	page, err := bt.Pager.FetchPage(pageNumber)
+	if err != nil {
+		return err
+	}
+
+	objToDelete := page.Get(key, transID)
+
+	if handleBlob && objToDelete != nil && objToDelete.IsBlobRef {
+
+		blobPieces, hasFrag := objToDelete.BlobInfo()
+
+		for part := 0; part < blobPieces; part++ {
+			if err := bt.Remove(blobObjectKey(key, uint32(part)), transID, false); err != nil {
+				return err
+			}
+		}
+
+		if hasFrag {
+			// TODO - is this correct?
+			if err := bt.Remove(newBlobFragmentKey(key), transID, false); err != nil {
+				return err
 			}
 		}
 
-		if err := bt.Pager.StorePage(emptyPage, lastPage); err != nil {
+		return bt.Remove(key, transID, false)
+	}
+
+	page.Delete(key, transID)
+	if err := bt.Pager.StorePage(pageNumber, page); err != nil {
+		return err
+	}
+
+	lower := page
+	for level := len(path) - 2; level >= 0; level-- {
+		parent, err := bt.Pager.FetchPage(path[level])
+		if err != nil {
 			return err
 		}
+		pointer := parent.Objects()[depthIterator[level]]
 
-		if bt.Pager.GetRootPage() == bt.Pager.TotalPages()-1 {
-			if err := bt.Pager.SetRootPage(emptyPage); err != nil {
+		switch {
+		case lower.IsEmpty():
+			parent.Delete(pointer.Key, 0)
+			if err := bt.Allocator.Free(path[level+1]); err != nil {
+				return err
+			}
+		case bytes.Equal(pointer.Key, key):
+			parent.Delete(pointer.Key, 0)
+			if err := parent.Add(newPointerObject(lower.Head().Key, path[level+1])); err != nil {
 				return err
 			}
+		default:
+			return nil
 		}
 
-		if err := bt.Pager.TruncateLastPage(); err != nil {
+		if level == 0 && parent.IsEmpty() {
+			parent = NewPage(kindLeaf, bt.PageSize)
+		}
+		if err := bt.Pager.StorePage(path[level], parent); err != nil {
 			return err
 		}
-
+		lower = parent
 	}
 
-	rootPage := bt.Pager.GetRootPage()
-	rtPage, err := bt.Pager.FetchPage(rootPage)
-	if err != nil {
-		return err
-	}
+	return nil
+}
+
+// Drop returns every page of the tree to the allocator, including the root.
+func (bt Btree) Drop() error {
 
-	if rtPage.IsEmpty() {
-		return bt.Pager.TruncateAll()
+	if bt.isEmpty() {
+		return nil
 	}
 
+	pending := []int{bt.rootPage()}
+	for len(pending) > 0 {
+		pageNumber := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		page, err := bt.Pager.FetchPage(pageNumber)
+		if err != nil {
+			return err
+		}
+		if page.Kind != kindLeaf {
+			for _, obj := range page.Objects() {
+				pending = append(pending, pointerPage(obj))
+			}
+		}
+		if err := bt.Allocator.Free(pageNumber); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -266,10 +659,7 @@ func (bt Btree) Expire(key []byte, transID, delID int) (int, error) {
 	}
 
 	if page.Expire(key, transID, delID) {
-		err = bt.Pager.StorePage(pageNumber, page)
-		if err != nil {
-			return pageNumber, err
-		}
+		return pageNumber, bt.Pager.StorePage(pageNumber, page)
 	}
 	return -1, nil
 }
@@ -0,0 +1,281 @@
+package gopherql
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestBtree_Add(t *testing.T) {
+
+	pager := NewMemoryPager()
+	bt := NewBTree(pager)
+
+	keys := rand.New(rand.NewSource(1)).Perm(2000)
+	for _, k := range keys {
+		key := []byte(fmt.Sprintf("key-%05d", k))
+		val := []byte(fmt.Sprintf("value-%05d", k))
+		if err := bt.Add(NewPageObject(key, val, 2, 0)); err != nil {
+			t.Fatalf("unexpected error adding %s: %s", key, err)
+		}
+	}
+
+	root, err := pager.FetchPage(pager.GetRootPage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Kind != kindNotLeaf {
+		t.Error("expected root to have been split")
+	}
+
+	for k := 0; k < 2000; k++ {
+		key := []byte(fmt.Sprintf("key-%05d", k))
+		path, _, err := bt.SearchPage(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, err := pager.FetchPage(path[len(path)-1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj := page.Get(key, 2)
+		if obj == nil {
+			t.Fatalf("could not find key: %s", key)
+		}
+		if !bytes.Equal(obj.Value, []byte(fmt.Sprintf("value-%05d", k))) {
+			t.Errorf("unexpected value for %s: %s", key, obj.Value)
+		}
+	}
+}
+
+func TestBtree_AddVersions(t *testing.T) {
+
+	bt := NewBTree(NewMemoryPager())
+	key := []byte("versioned")
+
+	if err := bt.Add(NewPageObject(key, []byte("one"), 2, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := bt.Add(NewPageObject(key, []byte("two"), 3, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	err := bt.Add(NewPageObject(key, []byte("three"), 4, 0))
+	if sqlErr, ok := err.(SQLStateError); !ok || sqlErr.Code != "40001" {
+		t.Errorf("expected serialization error, got: %v", err)
+	}
+}
+
+func TestPage_AddKeepsOrder(t *testing.T) {
+
+	page := NewPage(kindLeaf, defaultPgSize)
+	for _, k := range []string{"b", "c", "a"} {
+		if err := page.Add(NewPageObject([]byte(k), []byte(k+k), 2, 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for idx, obj := range page.Objects() {
+		expected := string(rune('a' + idx))
+		if string(obj.Key) != expected || string(obj.Value) != expected+expected {
+			t.Errorf("unexpected object at %d: %s=%s", idx, obj.Key, obj.Value)
+		}
+	}
+}
+
+func TestBtree_AddBlob(t *testing.T) {
+
+	pager := NewMemoryPager()
+	bt := NewBTree(pager)
+
+	large := bytes.Repeat([]byte("0123456789"), 1000)
+	if err := bt.Add(NewPageObject([]byte("large"), large, 2, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := bt.Add(NewPageObject([]byte("small"), []byte("value"), 2, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := bt.Get([]byte("large"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj == nil || !bytes.Equal(obj.Value, large) {
+		t.Fatal("blob value was not reassembled")
+	}
+
+	if err := bt.Remove([]byte("large"), 2, true); err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err = bt.Get(blobObjectKey([]byte("large"), 0), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj != nil {
+		t.Error("expected blob pieces to be removed")
+	}
+
+	obj, err = bt.Get([]byte("small"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj == nil || string(obj.Value) != "value" {
+		t.Error("expected small value to remain")
+	}
+}
+
+func TestBtree_Update(t *testing.T) {
+
+	bt := NewBTree(NewMemoryPager())
+
+	original := NewPageObject([]byte("row"), []byte("original"), 2, 0)
+	if err := bt.Add(original); err != nil {
+		t.Fatal(err)
+	}
+
+	pages, err := bt.Update(original, NewPageObject([]byte("row"), []byte("rewritten"), 2, 0), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 1 {
+		t.Errorf("expected a single modified page, got: %v", pages)
+	}
+
+	obj, err := bt.Get([]byte("row"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj == nil || string(obj.Value) != "rewritten" {
+		t.Fatal("expected row to be rewritten in place")
+	}
+
+	if _, err := bt.Update(obj, NewPageObject([]byte("row"), []byte("newer"), 0, 0), 3); err != nil {
+		t.Fatal(err)
+	}
+
+	old, err := bt.Get([]byte("row"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old == nil || old.DeleteID != 3 {
+		t.Error("expected previous version to be expired by transaction 3")
+	}
+
+	newer, err := bt.Get([]byte("row"), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newer == nil || string(newer.Value) != "newer" {
+		t.Fatal("expected new version written by transaction 3")
+	}
+
+	if _, err := bt.Update(old, NewPageObject([]byte("row"), []byte("conflict"), 0, 0), 4); err == nil {
+		t.Error("expected updating an expired row to fail")
+	}
+
+	// A copy read before the row was expired must not expire it again.
+	_, err = bt.Update(obj, NewPageObject([]byte("elsewhere"), []byte("conflict"), 0, 0), 4)
+	expectSQLState(t, err, "40001")
+	if old, err = bt.Get([]byte("row"), 2); err != nil || old == nil || old.DeleteID != 3 {
+		t.Errorf("expected the delete of transaction 3 to be kept, got: %+v, %v", old, err)
+	}
+
+	if _, err := bt.Update(newer, NewPageObject([]byte("moved"), []byte("newer"), 0, 0), 3); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := bt.Get([]byte("row"), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != nil {
+		t.Error("expected own version to be removed when key changes")
+	}
+
+	moved, err := bt.Get([]byte("moved"), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved == nil || string(moved.Value) != "newer" {
+		t.Error("expected row under new key")
+	}
+}
+
+func TestBtree_MultipleTrees(t *testing.T) {
+
+	allocator := NewPageAllocator(NewMemoryPager())
+	first, err := allocator.CreateTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := allocator.CreateTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Root != 0 {
+		t.Fatalf("expected the first tree to be rooted at page 0, got: %d", first.Root)
+	}
+	// The Pager's own root must not be taken for that of a tree at page 0.
+	if err := allocator.Pager.SetRootPage(second.Root); err != nil {
+		t.Fatal(err)
+	}
+
+	for k := 0; k < 1000; k++ {
+		key := []byte(fmt.Sprintf("key-%05d", k))
+		if err := first.Add(NewPageObject(key, []byte("first"), 2, 0)); err != nil {
+			t.Fatal(err)
+		}
+		if err := second.Add(NewPageObject(key, []byte("second"), 2, 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	root, err := first.Pager.FetchPage(first.Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Kind != kindNotLeaf {
+		t.Error("expected the root to have been split in place")
+	}
+
+	for k := 0; k < 1000; k++ {
+		if err := first.Remove([]byte(fmt.Sprintf("key-%05d", k)), 2, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root, err = first.Pager.FetchPage(first.Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Kind != kindLeaf || !root.IsEmpty() {
+		t.Error("expected an emptied tree to keep an empty root leaf")
+	}
+	if allocator.FreeCount == 0 {
+		t.Error("expected emptied pages to be freed")
+	}
+
+	if err := first.Drop(); err != nil {
+		t.Fatal(err)
+	}
+	totalPages := allocator.Pager.TotalPages()
+	third, err := allocator.CreateTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.Root != first.Root || allocator.Pager.TotalPages() != totalPages {
+		t.Errorf("expected a freed page to be reused, got root %d", third.Root)
+	}
+
+	for k := 0; k < 1000; k++ {
+		key := []byte(fmt.Sprintf("key-%05d", k))
+		obj, err := second.Get(key, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if obj == nil || string(obj.Value) != "second" {
+			t.Fatalf("expected %s to be untouched in the other tree", key)
+		}
+	}
+}
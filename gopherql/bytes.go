@@ -77,6 +77,11 @@ func (b *ByteReader) Advance(step int) {
 	b.Offset += step
 }
 
+// Remaining returns the number of bytes left to read.
+func (b *ByteReader) Remaining() int {
+	return len(b.Contents) - b.Offset
+}
+
 type ByteWriter struct {
 	Contents []byte
 }
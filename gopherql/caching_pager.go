@@ -0,0 +1,202 @@
+package gopherql
+
+import (
+	"container/list"
+	"errors"
+)
+
+// CachingPager keeps up to capacity pages of another Pager in memory, evicting
+// the least recently used unpinned page when full. Stored pages are only
+// written back to the underlying pager on eviction or Flush.
+type CachingPager struct {
+	pager    Pager
+	capacity int
+	entries  map[int]*list.Element
+	lru      *list.List
+	stats    CacheStats
+}
+
+type CacheStats struct {
+	Hits      int
+	Misses    int
+	Evictions int
+}
+
+type cacheEntry struct {
+	num   int
+	page  *Page
+	dirty bool
+	pins  int
+}
+
+func NewCachingPager(pager Pager, capacity int) *CachingPager {
+	return &CachingPager{
+		pager:    pager,
+		capacity: capacity,
+		entries:  map[int]*list.Element{},
+		lru:      list.New(),
+	}
+}
+
+func (c *CachingPager) Stats() CacheStats {
+	return c.stats
+}
+
+// FetchPage returns a copy of the cached page, as a FilePager does, so that
+// a caller changing it and failing before StorePage leaves the cache intact.
+func (c *CachingPager) FetchPage(num int) (*Page, error) {
+
+	if elem, ok := c.entries[num]; ok {
+		c.stats.Hits++
+		c.lru.MoveToFront(elem)
+		return pageFromBytes(elem.Value.(*cacheEntry).page.Bytes()), nil
+	}
+
+	c.stats.Misses++
+	page, err := c.pager.FetchPage(num)
+	if err != nil {
+		return nil, err
+	}
+
+	return pageFromBytes(page.Bytes()), c.insert(&cacheEntry{num: num, page: page})
+}
+
+func (c *CachingPager) StorePage(num int, p *Page) error {
+
+	if elem, ok := c.entries[num]; ok {
+		entry := elem.Value.(*cacheEntry)
+		entry.page = p
+		entry.dirty = true
+		c.lru.MoveToFront(elem)
+		return nil
+	}
+
+	return c.insert(&cacheEntry{num: num, page: p, dirty: true})
+}
+
+func (c *CachingPager) AppendPage(page *Page) (int, error) {
+
+	num, err := c.pager.AppendPage(page)
+	if err != nil {
+		return -1, err
+	}
+
+	return num, c.insert(&cacheEntry{num: num, page: page})
+}
+
+// Pin fetches a page and protects it from eviction until it is unpinned.
+func (c *CachingPager) Pin(num int) (*Page, error) {
+
+	page, err := c.FetchPage(num)
+	if err != nil {
+		return nil, err
+	}
+	c.entries[num].Value.(*cacheEntry).pins++
+
+	return page, nil
+}
+
+func (c *CachingPager) Unpin(num int) error {
+
+	elem, ok := c.entries[num]
+	if !ok || elem.Value.(*cacheEntry).pins == 0 {
+		return errors.New("page is not pinned")
+	}
+	elem.Value.(*cacheEntry).pins--
+
+	return nil
+}
+
+func (c *CachingPager) insert(entry *cacheEntry) error {
+
+	c.entries[entry.num] = c.lru.PushFront(entry)
+
+	// Pinned pages are skipped, so the cache may briefly grow beyond its
+	// capacity when every page is pinned.
+	for elem := c.lru.Back(); elem != nil && c.lru.Len() > c.capacity; {
+		victim := elem.Value.(*cacheEntry)
+		prev := elem.Prev()
+
+		if victim.pins == 0 && victim != entry {
+			if err := c.evict(elem); err != nil {
+				return err
+			}
+		}
+		elem = prev
+	}
+
+	return nil
+}
+
+func (c *CachingPager) evict(elem *list.Element) error {
+
+	entry := elem.Value.(*cacheEntry)
+	if entry.dirty {
+		if err := c.pager.StorePage(entry.num, entry.page); err != nil {
+			return err
+		}
+	}
+
+	c.lru.Remove(elem)
+	delete(c.entries, entry.num)
+	c.stats.Evictions++
+
+	return nil
+}
+
+// writeBack stores every dirty page in the underlying pager.
+func (c *CachingPager) writeBack() error {
+
+	for elem := c.lru.Back(); elem != nil; elem = elem.Prev() {
+		entry := elem.Value.(*cacheEntry)
+		if !entry.dirty {
+			continue
+		}
+		if err := c.pager.StorePage(entry.num, entry.page); err != nil {
+			return err
+		}
+		entry.dirty = false
+	}
+
+	return nil
+}
+
+func (c *CachingPager) clear() {
+	c.entries = map[int]*list.Element{}
+	c.lru.Init()
+}
+
+func (c *CachingPager) TruncateAll() error {
+	c.clear()
+	return c.pager.TruncateAll()
+}
+
+// TruncateLastPage writes back and drops every cached page first, as the
+// cache cannot tell which page number is the last one.
+func (c *CachingPager) TruncateLastPage() error {
+	if err := c.writeBack(); err != nil {
+		return err
+	}
+	c.clear()
+	return c.pager.TruncateLastPage()
+}
+
+func (c *CachingPager) TotalPages() int {
+	return c.pager.TotalPages()
+}
+
+func (c *CachingPager) GetRootPage() int {
+	return c.pager.GetRootPage()
+}
+
+func (c *CachingPager) SetRootPage(num int) error {
+	return c.pager.SetRootPage(num)
+}
+
+// Flush writes back every dirty page and then flushes the underlying pager.
+func (c *CachingPager) Flush() error {
+	if err := c.writeBack(); err != nil {
+		return err
+	}
+	return c.pager.Flush()
+}
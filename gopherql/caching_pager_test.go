@@ -0,0 +1,162 @@
+package gopherql
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestCachingPager_HitsAndEviction(t *testing.T) {
+
+	memory := NewMemoryPager()
+	for idx := 0; idx < 3; idx++ {
+		if _, err := memory.AppendPage(NewPage(kindLeaf, defaultPgSize)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cache := NewCachingPager(memory, 2)
+
+	if _, err := cache.Pin(0); err != nil {
+		t.Fatal(err)
+	}
+
+	replacement := NewPage(kindNotLeaf, defaultPgSize)
+	if err := cache.StorePage(1, replacement); err != nil {
+		t.Fatal(err)
+	}
+	if memory.Pages[1] == replacement {
+		t.Error("expected store to be held in the cache")
+	}
+
+	if _, err := cache.FetchPage(2); err != nil {
+		t.Fatal(err)
+	}
+	if memory.Pages[1] != replacement {
+		t.Error("expected dirty page to be written back on eviction")
+	}
+
+	if _, err := cache.FetchPage(0); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 1 || stats.Misses != 2 || stats.Evictions != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	if err := cache.Unpin(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Unpin(0); err == nil {
+		t.Error("expected unpinning an unpinned page to fail")
+	}
+}
+
+func TestCachingPager_Btree(t *testing.T) {
+
+	memory := NewMemoryPager()
+	cache := NewCachingPager(memory, 4)
+	bt := NewBTree(cache)
+
+	for k := 0; k < 1000; k++ {
+		key := []byte(fmt.Sprintf("key-%05d", k))
+		if err := bt.Add(NewPageObject(key, []byte("value"), 2, 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cache.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	uncached := NewBTree(memory)
+	for k := 0; k < 1000; k++ {
+		key := []byte(fmt.Sprintf("key-%05d", k))
+		obj, err := uncached.Get(key, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if obj == nil {
+			t.Fatalf("expected %s to be written back", key)
+		}
+	}
+
+	if cache.Stats().Hits == 0 {
+		t.Error("expected cache hits while building the tree")
+	}
+}
+
+// failingFreePager fails to store pages freed onto the free list.
+type failingFreePager struct {
+	Pager
+}
+
+func (p failingFreePager) StorePage(num int, page *Page) error {
+	if page.Kind == kindFree {
+		return errors.New("cannot store free page")
+	}
+	return p.Pager.StorePage(num, page)
+}
+
+func TestCachingPager_FailedUpdate(t *testing.T) {
+
+	memory := NewMemoryPager()
+	cache := NewCachingPager(memory, 64)
+	bt := NewBTree(cache)
+	bt.Allocator.Pager = failingFreePager{cache}
+
+	for k := 0; k < 200; k++ {
+		key := []byte(fmt.Sprintf("key-%05d", k))
+		if err := bt.Add(NewPageObject(key, bytes.Repeat([]byte("v"), 100), 2, 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Empty the last leaf but for one row, whose update moves it to another
+	// leaf. Removing the emptied leaf from the root then fails to free it.
+	root, err := cache.FetchPage(bt.rootPage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pointers := root.Objects()
+	leaf, err := cache.FetchPage(pointerPage(pointers[len(pointers)-1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := [][]byte{}
+	for _, key := range leaf.Keys() {
+		keys = append(keys, append([]byte{}, key...))
+	}
+	for _, key := range keys[1:] {
+		if err := bt.Remove(key, 2, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cache.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if root, err = cache.FetchPage(bt.rootPage()); err != nil {
+		t.Fatal(err)
+	}
+	before := root.Bytes()
+
+	last, err := bt.Get(keys[0], 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bt.Update(last, NewPageObject([]byte("key-00000-moved"), last.Value, 2, 0), 2); err == nil {
+		t.Fatal("expected the update to fail")
+	}
+
+	after, err := cache.FetchPage(bt.rootPage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(after.Bytes(), before) {
+		t.Error("expected the failed update to leave the cached root unchanged")
+	}
+	if !bytes.Equal(memory.Pages[bt.rootPage()].Bytes(), before) {
+		t.Error("expected the cached root to agree with the pager beneath")
+	}
+}
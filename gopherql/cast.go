@@ -0,0 +1,262 @@
+package gopherql
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Layouts accepted when reading dates and times from strings.
+var (
+	dateLayouts      = []string{"2006-01-02"}
+	timeLayouts      = []string{"15:04:05.999999999", "15:04"}
+	timestampLayouts = []string{"2006-01-02 15:04:05.999999999", "2006-01-02T15:04:05.999999999", "2006-01-02 15:04", "2006-01-02"}
+	zoneLayouts      = []string{"Z07:00", "Z07", "Z0700", " Z07:00", " Z07", " MST"}
+)
+
+func invalidText(v Value, target *Column) SQLStateError {
+	code := "22P02"
+	switch target.Type {
+	case DateColumn, TimeColumn, TimestampColumn, TimestampTzColumn:
+		code = "22007"
+	}
+	return SQLStateError{Code: code, Msg: fmt.Sprintf("invalid input syntax for type %s: %q", target.Type, v.StringValue)}
+}
+
+func parseTime(text string, layouts []string, zoned bool) (time.Time, bool) {
+	text = strings.TrimSpace(text)
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			return t, true
+		}
+		if !zoned {
+			continue
+		}
+		for _, zone := range zoneLayouts {
+			if t, err := time.Parse(layout+zone, text); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// castValue converts v to the type of the target column, as when a value is
+// assigned to it. Whether the result fits the column's length or precision
+// is left to Column.checkValue.
+func castValue(v Value, target *Column) (Value, error) {
+
+	if v.IsNull {
+		return NewNullValue(target.Type), nil
+	}
+	if v.Type == target.Type && target.Type != DecimalColumn && target.Type != CharColumn {
+		return v, nil
+	}
+
+	mismatch := datatypeMismatch("cannot convert type %s to %s", v.Type, target.TypeName())
+
+	if isString(v.Type) && !isString(target.Type) {
+		return castFromString(v, target)
+	}
+
+	switch target.Type {
+	case StringColumn:
+		return NewStringValue(v.String()), nil
+
+	case CharColumn:
+		str := v.String()
+		if v.Type == CharColumn || v.Type == StringColumn {
+			str = v.StringValue
+		}
+		if pad := target.Size - len([]rune(str)); pad > 0 {
+			str += strings.Repeat(" ", pad)
+		}
+		return NewCharValue(str), nil
+
+	case Int16Column, Int32Column, Int64Column:
+		if !isNumeric(v.Type) {
+			return Value{}, mismatch
+		}
+		return castToInteger(v, target.Type)
+
+	case Float32Column, Float64Column:
+		if !isNumeric(v.Type) {
+			return Value{}, mismatch
+		}
+		if target.Type == Float32Column {
+			return NewFloat32Value(float32(numericFloat(v))), nil
+		}
+		return NewFloat64Value(numericFloat(v)), nil
+
+	case DecimalColumn:
+		if !isNumeric(v.Type) {
+			return Value{}, mismatch
+		}
+		return castToDecimal(v, target)
+
+	case DateColumn, TimestampColumn, TimestampTzColumn:
+		if v.Type != DateColumn && v.Type != TimestampColumn && v.Type != TimestampTzColumn {
+			return Value{}, mismatch
+		}
+		t := v.Time()
+		switch target.Type {
+		case DateColumn:
+			return NewDateValue(t), nil
+		case TimestampColumn:
+			return NewTimestampValue(t), nil
+		}
+		return NewTimestampTzValue(t), nil
+
+	case TimeColumn:
+		if v.Type != TimestampColumn && v.Type != TimestampTzColumn {
+			return Value{}, mismatch
+		}
+		return NewTimeValue(v.Time()), nil
+	}
+
+	return Value{}, mismatch
+}
+
+func castFromString(v Value, target *Column) (Value, error) {
+
+	text := v.StringValue
+	switch target.Type {
+	case BoolColumn:
+		switch strings.ToLower(strings.TrimSpace(text)) {
+		case "t", "true", "y", "yes", "on", "1":
+			return NewBoolValue(true), nil
+		case "f", "false", "n", "no", "off", "0":
+			return NewBoolValue(false), nil
+		}
+
+	case Int16Column, Int32Column, Int64Column:
+		val, err := strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+		if err == nil {
+			return castToInteger(NewInt64Value(val), target.Type)
+		}
+
+	case Float32Column, Float64Column:
+		val, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+		if err == nil {
+			if target.Type == Float32Column {
+				return NewFloat32Value(float32(val)), nil
+			}
+			return NewFloat64Value(val), nil
+		}
+
+	case DecimalColumn:
+		rat, ok := new(big.Rat).SetString(strings.TrimSpace(text))
+		if ok {
+			return decimalFromRat(rat, target)
+		}
+
+	case BytesColumn:
+		if strings.HasPrefix(text, `\x`) {
+			data, err := hex.DecodeString(text[2:])
+			if err == nil {
+				return NewBytesValue(data), nil
+			}
+			break
+		}
+		return NewBytesValue([]byte(text)), nil
+
+	case DateColumn:
+		if t, ok := parseTime(text, dateLayouts, false); ok {
+			return NewDateValue(t), nil
+		}
+
+	case TimeColumn:
+		if t, ok := parseTime(text, timeLayouts, false); ok {
+			return NewTimeValue(t), nil
+		}
+
+	case TimestampColumn:
+		if t, ok := parseTime(text, timestampLayouts, false); ok {
+			return NewTimestampValue(t), nil
+		}
+
+	case TimestampTzColumn:
+		if t, ok := parseTime(text, timestampLayouts, true); ok {
+			return NewTimestampTzValue(t), nil
+		}
+	}
+
+	return Value{}, invalidText(v, target)
+}
+
+func castToInteger(v Value, target ColumnType) (Value, error) {
+
+	var val int64
+	switch {
+	case isInteger(v.Type):
+		val = v.IntValue
+	case v.Type == DecimalColumn:
+		rounded, ok := roundRat(decimalRat(v))
+		if !ok {
+			return Value{}, numericOutOfRange(target)
+		}
+		val = rounded
+	default:
+		f := math.Round(v.FloatValue)
+		if math.IsNaN(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return Value{}, numericOutOfRange(target)
+		}
+		val = int64(f)
+	}
+
+	switch target {
+	case Int16Column:
+		if val < math.MinInt16 || val > math.MaxInt16 {
+			return Value{}, numericOutOfRange(target)
+		}
+		return NewInt16Value(int16(val)), nil
+	case Int32Column:
+		if val < math.MinInt32 || val > math.MaxInt32 {
+			return Value{}, numericOutOfRange(target)
+		}
+		return NewInt32Value(int32(val)), nil
+	}
+	return NewInt64Value(val), nil
+}
+
+func castToDecimal(v Value, target *Column) (Value, error) {
+
+	if v.Type == Float32Column || v.Type == Float64Column {
+		if math.IsNaN(v.FloatValue) || math.IsInf(v.FloatValue, 0) {
+			return Value{}, numericOutOfRange(DecimalColumn)
+		}
+		return decimalFromRat(new(big.Rat).SetFloat64(v.FloatValue), target)
+	}
+	return decimalFromRat(decimalRat(v), target)
+}
+
+// decimalFromRat rounds rat half away from zero to the target's scale.
+func decimalFromRat(rat *big.Rat, target *Column) (Value, error) {
+
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(target.Scale)), nil)
+	scaled := new(big.Rat).Mul(rat, new(big.Rat).SetInt(scale))
+
+	unscaled, ok := roundRat(scaled)
+	if !ok {
+		return Value{}, numericOutOfRange(DecimalColumn)
+	}
+	return NewDecimalValue(unscaled, target.Scale), nil
+}
+
+func roundRat(rat *big.Rat) (int64, bool) {
+
+	half := big.NewRat(1, 2)
+	if rat.Sign() < 0 {
+		half.Neg(half)
+	}
+	rounded := new(big.Rat).Add(rat, half)
+	quotient := new(big.Int).Quo(rounded.Num(), rounded.Denom())
+	if !quotient.IsInt64() {
+		return 0, false
+	}
+	return quotient.Int64(), true
+}
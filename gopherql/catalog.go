@@ -0,0 +1,545 @@
+package gopherql
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The catalog stores each Table definition in its Btree under a reserved key
+// of the form 'T' schema 0x00 name, written and read through a Transaction so
+// that DDL commits and rolls back with the rest of the transaction. Rows and
+// index entries live in trees of their own, whose roots the definitions
+// record.
+const catalogTablePrefix = 'T'
+
+// Indexes created with CREATE INDEX are stored under 'X' schema 0x00 name, as
+// index names are unique within a schema rather than a table.
+const catalogIndexDefPrefix = 'X'
+
+// A tree dropped by a committed transaction is kept until no older snapshot
+// can read it. Until then its root is recorded under 'D' root, so that one
+// still kept when the database is closed or crashes is freed on restart.
+const catalogDroppedPrefix = 'D'
+
+func droppedTreeKey(root int) []byte {
+	buffer := NewByteWriter()
+	buffer.WriteByte(catalogDroppedPrefix)
+	buffer.WriteUint32(root)
+	return buffer.Bytes()
+}
+
+func catalogKey(prefix byte, schema, name string) []byte {
+	buffer := bytes.Buffer{}
+	buffer.WriteByte(prefix)
+	buffer.WriteString(schema)
+	buffer.WriteByte(0)
+	buffer.WriteString(name)
+	return buffer.Bytes()
+}
+
+// catalogRange returns the key range holding every entry of a schema.
+func catalogRange(prefix byte, schema string) ([]byte, []byte) {
+	start := catalogKey(prefix, schema, "")
+	end := append([]byte{}, start...)
+	end[len(end)-1] = 1
+	return start, end
+}
+
+func validateName(name string) error {
+	if name == "" || strings.ContainsRune(name, 0) {
+		return SQLStateError{Code: "42602", Msg: fmt.Sprintf("invalid name: %q", name)}
+	}
+	return nil
+}
+
+func undefinedTable(schema, name string) SQLStateError {
+	return SQLStateError{
+		Code: "42P01",
+		Msg:  fmt.Sprintf("relation %s does not exist", TableName{Schema: schema, Name: name}),
+	}
+}
+
+func (tx *Transaction) CreateTable(table *Table) error {
+
+	table.Schema = schemaOrDefault(table.Schema)
+	if err := validateName(table.Schema); err != nil {
+		return err
+	}
+	if err := validateName(table.Name); err != nil {
+		return err
+	}
+
+	key := catalogKey(catalogTablePrefix, table.Schema, table.Name)
+	if err := tx.checkNameFree(key, false, table.Schema, table.Name); err != nil {
+		return err
+	}
+
+	var err error
+	if table.RootPage, err = tx.createTree(); err != nil {
+		return err
+	}
+	for idx := range table.Uniques {
+		if table.Uniques[idx].RootPage, err = tx.createTree(); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Add(key, table.Bytes()); err != nil {
+		return err
+	}
+	tx.schemaChanged = true
+
+	return nil
+}
+
+// checkNameFree fails with 42P07 when the catalog entry under key is taken,
+// either visibly to the transaction or by one that committed after it began.
+// An entry written by a concurrent transaction that could still commit is a
+// serialization failure, as only one of the two may create it.
+func (tx *Transaction) checkNameFree(key []byte, taken bool, schema, name string) error {
+
+	existing, err := tx.Get(key)
+	if err != nil {
+		return err
+	}
+	versions, err := tx.manager.btree.Versions(key)
+	if err != nil {
+		return err
+	}
+	for _, obj := range versions {
+		if !tx.Snapshot.Conflicts(obj) {
+			continue
+		}
+		if tx.manager.isActive(int(obj.TransactionID)) {
+			return tx.abortOnConflict(SQLStateError{
+				Code: "40001",
+				Msg:  fmt.Sprintf("could not serialize access due to concurrent creation of %s", TableName{Schema: schema, Name: name}),
+			})
+		}
+		taken = true
+	}
+
+	if taken || existing != nil {
+		return SQLStateError{
+			Code: "42P07",
+			Msg:  fmt.Sprintf("relation %s already exists", TableName{Schema: schema, Name: name}),
+		}
+	}
+	return nil
+}
+
+// Table returns the definition of a table visible to the transaction.
+func (tx *Transaction) Table(schema, name string) (*Table, error) {
+
+	schema = schemaOrDefault(schema)
+	obj, err := tx.Get(catalogKey(catalogTablePrefix, schema, name))
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, undefinedTable(schema, name)
+	}
+
+	table, err := TableFromBytes(obj.Value)
+	if err != nil {
+		return nil, err
+	}
+	table.Schema = schema
+
+	return table, nil
+}
+
+// Tables lists the tables of a schema in name order.
+func (tx *Transaction) Tables(schema string) ([]*Table, error) {
+
+	schema = schemaOrDefault(schema)
+	start, end := catalogRange(catalogTablePrefix, schema)
+
+	it, err := tx.Scan(start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	tables := []*Table{}
+	for {
+		obj, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		if obj == nil {
+			return tables, nil
+		}
+
+		table, err := TableFromBytes(obj.Value)
+		if err != nil {
+			return nil, err
+		}
+		table.Schema = schema
+		tables = append(tables, table)
+	}
+}
+
+func (tx *Transaction) DropTable(schema, name string) error {
+
+	schema = schemaOrDefault(schema)
+	obj, err := tx.Get(catalogKey(catalogTablePrefix, schema, name))
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		return undefinedTable(schema, name)
+	}
+
+	table, err := TableFromBytes(obj.Value)
+	if err != nil {
+		return err
+	}
+	table.Schema = schema
+
+	indexes, err := tx.catalogIndexes(table)
+	if err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		indexObj, err := tx.Get(catalogKey(catalogIndexDefPrefix, schema, index.Name))
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(indexObj); err != nil {
+			return err
+		}
+		if err := tx.dropTree(index.RootPage, indexObj); err != nil {
+			return err
+		}
+	}
+
+	stats, err := tx.Get(catalogKey(catalogStatsPrefix, schema, name))
+	if err != nil {
+		return err
+	}
+	if stats != nil {
+		if err := tx.Delete(stats); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Delete(obj); err != nil {
+		return err
+	}
+	for _, root := range table.treeRoots() {
+		if err := tx.dropTree(root, obj); err != nil {
+			return err
+		}
+	}
+	tx.schemaChanged = true
+
+	return nil
+}
+
+// treeRoots returns the roots of the trees holding the table's rows and the
+// indexes backing its UNIQUE constraints.
+func (t *Table) treeRoots() []int {
+	roots := []int{t.RootPage}
+	for _, unique := range t.Uniques {
+		roots = append(roots, unique.RootPage)
+	}
+	return roots
+}
+
+// createTree allocates an empty tree for a table or index created by the
+// transaction. Should the transaction roll back, undo frees it again.
+func (tx *Transaction) createTree() (int, error) {
+
+	if err := tx.checkActive(); err != nil {
+		return 0, err
+	}
+	bt, err := tx.manager.btree.Allocator.CreateTree()
+	if err != nil {
+		return 0, err
+	}
+	tx.createdTrees[bt.Root] = true
+	return bt.Root, nil
+}
+
+// dropTree frees the tree rooted at root, whose catalog entry obj has just
+// been deleted. A tree created by the transaction itself is freed right
+// away, as no other transaction can have seen it. Any other is freed after
+// commit, once no older snapshot can still read it.
+func (tx *Transaction) dropTree(root int, obj *PageObject) error {
+
+	if int(obj.TransactionID) == tx.ID {
+		tx.createdTrees[root] = false
+		return tx.manager.tree(root).Drop()
+	}
+	tx.droppedTrees = append(tx.droppedTrees, root)
+	return tx.Add(droppedTreeKey(root), nil)
+}
+
+// entryRoots returns the roots of the trees recorded by a catalog entry, or
+// none when the entry is not a table or index definition.
+func entryRoots(obj *PageObject) ([]int, error) {
+
+	switch obj.Key[0] {
+	case catalogTablePrefix:
+		table, err := TableFromBytes(obj.Value)
+		if err != nil {
+			return nil, err
+		}
+		return table.treeRoots(), nil
+	case catalogIndexDefPrefix:
+		index, err := IndexFromBytes(obj.Value)
+		if err != nil {
+			return nil, err
+		}
+		return []int{index.RootPage}, nil
+	}
+	return nil, nil
+}
+
+// replaceEntryRoot returns the value of a catalog entry with the tree root
+// from replaced by to. The value keeps its length.
+func replaceEntryRoot(obj *PageObject, from, to int) ([]byte, error) {
+
+	switch obj.Key[0] {
+	case catalogTablePrefix:
+		table, err := TableFromBytes(obj.Value)
+		if err != nil {
+			return nil, err
+		}
+		if table.RootPage == from {
+			table.RootPage = to
+		}
+		for idx := range table.Uniques {
+			if table.Uniques[idx].RootPage == from {
+				table.Uniques[idx].RootPage = to
+			}
+		}
+		return table.Bytes(), nil
+	case catalogIndexDefPrefix:
+		index, err := IndexFromBytes(obj.Value)
+		if err != nil {
+			return nil, err
+		}
+		if index.RootPage == from {
+			index.RootPage = to
+		}
+		return index.Bytes(), nil
+	}
+	return obj.Value, nil
+}
+
+// catalogEntries calls fn with every version of every table and index
+// definition, whether visible or not, along with the roots it records.
+func (tm *TransactionManager) catalogEntries(fn func(obj *PageObject, roots []int) error) error {
+
+	it, err := tm.btree.Iterator(nil, nil)
+	if err != nil {
+		return err
+	}
+
+	for {
+		obj, err := it.nextRaw()
+		if err != nil {
+			return err
+		}
+		if obj == nil {
+			return nil
+		}
+		if obj.Key[0] != catalogTablePrefix && obj.Key[0] != catalogIndexDefPrefix {
+			continue
+		}
+
+		if obj, err = it.resolve(obj); err != nil {
+			return err
+		}
+		roots, err := entryRoots(obj)
+		if err != nil {
+			return err
+		}
+		if err := fn(obj, roots); err != nil {
+			return err
+		}
+	}
+}
+
+// catalogTrees returns the roots recorded by the catalog entries undoAll has
+// to consider for ids. Existing trees are those referenced by a version that
+// is live, or whose deletion by ids or another running transaction may still
+// be rolled back. Created trees are referenced only by versions ids wrote.
+func (tm *TransactionManager) catalogTrees(ids map[int]bool) (existing []int, created []int, err error) {
+
+	running := map[int]bool{}
+	for _, activeID := range tm.header.ActiveTransactions {
+		running[int(activeID)] = true
+	}
+
+	referenced := map[int]bool{}
+	written := map[int]bool{}
+	err = tm.catalogEntries(func(obj *PageObject, roots []int) error {
+		if obj.DeleteID != 0 && !running[int(obj.DeleteID)] {
+			return nil
+		}
+		for _, root := range roots {
+			if ids[int(obj.TransactionID)] {
+				written[root] = true
+			} else {
+				referenced[root] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for root := range referenced {
+		existing = append(existing, root)
+	}
+	for root := range written {
+		if !referenced[root] {
+			created = append(created, root)
+		}
+	}
+	sort.Ints(existing)
+	sort.Ints(created)
+	return existing, created, nil
+}
+
+func undefinedIndex(schema, name string) SQLStateError {
+	return SQLStateError{
+		Code: "42704",
+		Msg:  fmt.Sprintf("index %s does not exist", TableName{Schema: schema, Name: name}),
+	}
+}
+
+// CreateIndex creates an index on an existing table and adds an entry to it
+// for every row already in the table.
+func (tx *Transaction) CreateIndex(index *Index) error {
+
+	index.Schema = schemaOrDefault(index.Schema)
+	if err := validateName(index.Name); err != nil {
+		return err
+	}
+
+	table, err := tx.Table(index.Schema, index.Table)
+	if err != nil {
+		return err
+	}
+	if _, err := index.columnIndexes(table); err != nil {
+		return err
+	}
+
+	taken := false
+	for _, unique := range table.Uniques {
+		if unique.Name == index.Name {
+			taken = true
+		}
+	}
+	key := catalogKey(catalogIndexDefPrefix, index.Schema, index.Name)
+	if err := tx.checkNameFree(key, taken, index.Schema, index.Name); err != nil {
+		return err
+	}
+
+	if index.RootPage, err = tx.createTree(); err != nil {
+		return err
+	}
+	if err := tx.Add(key, index.Bytes()); err != nil {
+		return err
+	}
+	tx.schemaChanged = true
+
+	return tx.buildIndex(table, index)
+}
+
+// Index returns an index created with CREATE INDEX.
+func (tx *Transaction) Index(schema, name string) (*Index, error) {
+
+	schema = schemaOrDefault(schema)
+	obj, err := tx.Get(catalogKey(catalogIndexDefPrefix, schema, name))
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, undefinedIndex(schema, name)
+	}
+
+	index, err := IndexFromBytes(obj.Value)
+	if err != nil {
+		return nil, err
+	}
+	index.Schema = schema
+
+	return index, nil
+}
+
+// catalogIndexes returns the indexes created on the table with CREATE INDEX.
+func (tx *Transaction) catalogIndexes(table *Table) ([]*Index, error) {
+
+	schema := schemaOrDefault(table.Schema)
+	start, end := catalogRange(catalogIndexDefPrefix, schema)
+
+	it, err := tx.Scan(start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := []*Index{}
+	for {
+		obj, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		if obj == nil {
+			return indexes, nil
+		}
+
+		index, err := IndexFromBytes(obj.Value)
+		if err != nil {
+			return nil, err
+		}
+		if index.Table == table.Name {
+			index.Schema = schema
+			indexes = append(indexes, index)
+		}
+	}
+}
+
+// TableIndexes returns every index on the table, including those backing its
+// UNIQUE constraints.
+func (tx *Transaction) TableIndexes(table *Table) ([]*Index, error) {
+
+	indexes, err := tx.catalogIndexes(table)
+	if err != nil {
+		return nil, err
+	}
+	return append(table.constraintIndexes(), indexes...), nil
+}
+
+func (tx *Transaction) DropIndex(schema, name string) error {
+
+	schema = schemaOrDefault(schema)
+	obj, err := tx.Get(catalogKey(catalogIndexDefPrefix, schema, name))
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		return undefinedIndex(schema, name)
+	}
+
+	index, err := IndexFromBytes(obj.Value)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Delete(obj); err != nil {
+		return err
+	}
+	if err := tx.dropTree(index.RootPage, obj); err != nil {
+		return err
+	}
+	tx.schemaChanged = true
+
+	return nil
+}
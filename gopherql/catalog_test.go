@@ -0,0 +1,325 @@
+package gopherql
+
+import "testing"
+
+func TestCatalog_CreateSurvivesRestart(t *testing.T) {
+	dbFile := "catalogTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	db, err := Open(dbFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec("CREATE TABLE users (id BIGINT PRIMARY KEY, name VARCHAR(64) NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("CREATE TABLE app.orders (id BIGINT, PRIMARY KEY (id))"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Exec("CREATE TABLE users (id BIGINT)")
+	if sqlErr, ok := err.(SQLStateError); !ok || sqlErr.Code != "42P07" {
+		t.Errorf("expected duplicate table error, got: %v", err)
+	}
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS users (id BIGINT)"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	version := db.header.SchemaVersion
+	if version != 2 {
+		t.Errorf("expected schema version 2, got: %d", version)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(dbFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if db.header.SchemaVersion != version {
+		t.Errorf("expected schema version to persist, got: %d", db.header.SchemaVersion)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	table, err := tx.Table("", "USERS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.Schema != defaultSchema || len(table.Columns) != 2 || table.PrimaryKeys[0] != "ID" {
+		t.Errorf("unexpected table: %+v", table)
+	}
+
+	tables, err := tx.Tables("APP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tables) != 1 || tables[0].Name != "ORDERS" {
+		t.Errorf("unexpected tables in schema: %v", tables)
+	}
+}
+
+func TestCatalog_DropFollowsTransaction(t *testing.T) {
+
+	tm, _ := newTestTransactionManager()
+
+	setup, _ := tm.Begin()
+	if _, err := setup.Exec("CREATE TABLE t (a BIGINT)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := setup.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	dropper, _ := tm.Begin()
+	if _, err := dropper.Exec("DROP TABLE t"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := dropper.Table("", "T"); err == nil {
+		t.Error("expected table to be gone for the dropping transaction")
+	}
+
+	concurrent, _ := tm.Begin()
+	if _, err := concurrent.Table("", "T"); err != nil {
+		t.Errorf("expected table to remain for other transactions: %s", err)
+	}
+
+	if err := dropper.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	after, _ := tm.Begin()
+	if _, err := after.Table("", "T"); err != nil {
+		t.Errorf("expected rolled back drop to keep the table: %s", err)
+	}
+
+	_, err := after.Exec("DROP TABLE missing")
+	if sqlErr, ok := err.(SQLStateError); !ok || sqlErr.Code != "42P01" {
+		t.Errorf("expected undefined table error, got: %v", err)
+	}
+}
+
+func TestCatalog_ConcurrentCreate(t *testing.T) {
+
+	tm, _ := newTestTransactionManager()
+
+	first, _ := tm.Begin()
+	second, _ := tm.Begin()
+	if _, err := first.Exec("CREATE TABLE t (a BIGINT)"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := second.Exec("CREATE TABLE t (a BIGINT, b BIGINT, c BIGINT)")
+	expectSQLState(t, err, "40001")
+	if err := second.Commit(); err == nil {
+		t.Error("expected the conflicting transaction to be rolled back")
+	}
+
+	third, _ := tm.Begin()
+	if err := first.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = third.Exec("CREATE TABLE t (a BIGINT, b BIGINT)")
+	expectSQLState(t, err, "42P07")
+	if _, err := third.Exec("CREATE TABLE IF NOT EXISTS t (a BIGINT, b BIGINT)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := third.Exec("CREATE INDEX t_a ON t (a)"); err == nil {
+		t.Error("expected a table created after the snapshot to stay invisible")
+	}
+	if err := third.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	indexer, _ := tm.Begin()
+	concurrent, _ := tm.Begin()
+	if _, err := indexer.Exec("CREATE INDEX t_a ON t (a)"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = concurrent.Exec("CREATE INDEX t_a ON t (a)")
+	expectSQLState(t, err, "40001")
+	if err := indexer.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only one definition was ever committed, so none is left once dropped.
+	dropper, _ := tm.Begin()
+	if _, err := dropper.Exec("DROP TABLE t"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dropper.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := tm.Begin()
+	if _, err := after.Table("", "T"); err == nil {
+		t.Error("expected no definition of the table to be left")
+	}
+}
+
+func TestCatalog_DroppedTreesAreFreed(t *testing.T) {
+
+	tm, _ := newTestTransactionManager()
+	isFree := func(root int) bool {
+		free, err := tm.btree.Allocator.FreePages()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, page := range free {
+			if page == root {
+				return true
+			}
+		}
+		return false
+	}
+
+	setup, _ := tm.Begin()
+	for _, sql := range []string{
+		"CREATE TABLE t (a BIGINT PRIMARY KEY, b TEXT UNIQUE)",
+		"INSERT INTO t VALUES (1, 'one'), (2, 'two')",
+	} {
+		if _, err := setup.Exec(sql); err != nil {
+			t.Fatalf("unexpected error for %q: %s", sql, err)
+		}
+	}
+	if err := setup.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, _ := tm.Begin()
+	table, err := reader.Table("", "T")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dropper, _ := tm.Begin()
+	if _, err := dropper.Exec("DROP TABLE t"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dropper.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if isFree(table.RootPage) {
+		t.Fatal("expected the rows to be kept while an older transaction runs")
+	}
+	if rows, err := reader.ScanRows(table); err != nil {
+		t.Fatal(err)
+	} else if obj, _, err := rows.Next(); err != nil || obj == nil {
+		t.Errorf("expected the older transaction to still read rows, got: %v", err)
+	}
+
+	if err := reader.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	for _, root := range table.treeRoots() {
+		if !isFree(root) {
+			t.Errorf("expected page %d to be freed", root)
+		}
+	}
+
+	created, _ := tm.Begin()
+	if _, err := created.Exec("CREATE TABLE u (a BIGINT)"); err != nil {
+		t.Fatal(err)
+	}
+	table, err = created.Table("", "U")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := created.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if !isFree(table.RootPage) {
+		t.Error("expected rolling back a CREATE TABLE to free its tree")
+	}
+
+	crashed, _ := tm.Begin()
+	if _, err := crashed.Exec("CREATE TABLE v (a BIGINT)"); err != nil {
+		t.Fatal(err)
+	}
+	table, err = crashed.Table("", "V")
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted := NewTransactionManager(tm.header, tm.btree, tm.persist)
+	if err := restarted.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	if !isFree(table.RootPage) {
+		t.Error("expected recovery to free the tree of a crashed CREATE TABLE")
+	}
+}
+
+func TestCatalog_PendingDropsFreedOnReopen(t *testing.T) {
+	dbFile := "pendingDropTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	db, err := Open(dbFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("CREATE TABLE t (a BIGINT PRIMARY KEY, b TEXT UNIQUE)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO t VALUES (1, 'one'), (2, 'two')"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The reader keeps the dropped table's trees from being freed, and is
+	// still running when the database is closed.
+	reader, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := reader.Table("", "T")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("DROP TABLE t"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(dbFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	free, err := db.btree.Allocator.FreePages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	freed := map[int]bool{}
+	for _, page := range free {
+		freed[page] = true
+	}
+	for _, root := range table.treeRoots() {
+		if !freed[root] {
+			t.Errorf("expected page %d to be freed on reopen, free pages: %v", root, free)
+		}
+	}
+
+	// Freeing them is not repeated by later restarts.
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = Open(dbFile, nil); err != nil {
+		t.Fatal(err)
+	}
+	if after, err := db.btree.Allocator.FreePages(); err != nil || len(after) != len(free) {
+		t.Errorf("expected the free list to be unchanged, got: %v, %v", after, err)
+	}
+}
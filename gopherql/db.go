@@ -0,0 +1,169 @@
+package gopherql
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+type Options struct {
+	// CachePages is the number of pages held by a CachingPager in front of
+	// the file. Zero disables the cache.
+	CachePages int
+	// SpillRows is the number of rows a sort, or groups an aggregation,
+	// holds in memory before spilling to temporary pages. Zero uses a
+	// default of 100000.
+	SpillRows int
+}
+
+// DB is an open database file. It holds an exclusive lock on the file until
+// it is closed.
+type DB struct {
+	file         *os.File
+	filePager    *FilePager
+	header       *Header
+	btree        *Btree
+	transactions *TransactionManager
+}
+
+// Open opens the database at path, creating it if it does not exist. Any
+// write-ahead log is replayed and transactions left active by a crash are
+// rolled back before Open returns.
+func Open(path string, opts *Options) (*DB, error) {
+
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := NewDatabaseFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	db, err := open(file, opts)
+	if err != nil {
+		unlockFile(file)
+		file.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func open(file *os.File, opts *Options) (*DB, error) {
+
+	filePager, err := NewFilePager(file, defaultPgSize, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := filePager.ReadHeader()
+	if err != nil {
+		return nil, err
+	}
+	if header.Version != currentVersion {
+		return nil, fmt.Errorf("unsupported database version: %d", header.Version)
+	}
+	if int(header.PageSize) != defaultPgSize {
+		return nil, fmt.Errorf("unsupported page size: %d", header.PageSize)
+	}
+
+	var pager Pager = filePager
+	if opts.CachePages > 0 {
+		pager = NewCachingPager(filePager, opts.CachePages)
+	}
+	allocator := NewPageAllocator(pager)
+	allocator.FirstPage = 1
+	allocator.FreeList = int(header.FreeListPage)
+	allocator.FreeCount = int(header.FreePageCount)
+
+	db := &DB{
+		file:      file,
+		filePager: filePager,
+		header:    header,
+		btree:     allocator.Tree(int(header.RootPage)),
+	}
+
+	// The catalog is the first tree of a new file. Every other tree has its
+	// root recorded in the catalog.
+	if header.RootPage == 0 {
+		catalog, err := allocator.CreateTree()
+		if err != nil {
+			return nil, err
+		}
+		db.btree.Root = catalog.Root
+		if err := db.writeHeader(header); err != nil {
+			return nil, err
+		}
+		if err := pager.Flush(); err != nil {
+			return nil, err
+		}
+	}
+	if err := filePager.SetRootPage(int(header.RootPage)); err != nil {
+		return nil, err
+	}
+
+	db.transactions = NewTransactionManager(header, db.btree, db.writeHeader)
+	db.transactions.spillRows = opts.SpillRows
+	if opts.SpillRows == 0 {
+		db.transactions.spillRows = defaultSpillRows
+	}
+
+	if err := db.transactions.Recover(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// writeHeader copies the catalog root and the free list into the header and
+// logs it.
+func (db *DB) writeHeader(header *Header) error {
+	header.RootPage = uint32(db.btree.Root)
+	header.FreeListPage = uint32(db.btree.Allocator.FreeList)
+	header.FreePageCount = uint32(db.btree.Allocator.FreeCount)
+	return db.filePager.WriteHeader(header)
+}
+
+func (db *DB) Begin() (*Transaction, error) {
+	if db.file == nil {
+		return nil, errors.New("database is closed")
+	}
+	return db.transactions.Begin()
+}
+
+// Close flushes every page and the header into the database file, removes the
+// write-ahead log and releases the file lock. Transactions still active are
+// rolled back the next time the file is opened.
+func (db *DB) Close() error {
+
+	if db.file == nil {
+		return errors.New("database is closed")
+	}
+
+	if err := db.writeHeader(db.header); err != nil {
+		return err
+	}
+	if err := db.btree.Pager.Flush(); err != nil {
+		return err
+	}
+	if err := db.filePager.Close(); err != nil {
+		return err
+	}
+	if err := unlockFile(db.file); err != nil {
+		return err
+	}
+
+	err := db.file.Close()
+	db.file = nil
+	return err
+}
@@ -0,0 +1,68 @@
+package gopherql
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDB_OpenClose(t *testing.T) {
+	dbFile := "openCloseTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	db, err := Open(dbFile, &Options{CachePages: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(dbFile, nil); err == nil {
+		t.Error("expected second open to fail while the file is locked")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := 0; k < 500; k++ {
+		key := []byte(fmt.Sprintf("key-%05d", k))
+		if err := tx.Add(key, []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(dbFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if db.header.RootPage == 0 {
+		t.Error("expected root page to be persisted")
+	}
+
+	reader, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reader.ID <= tx.ID {
+		t.Errorf("expected transaction ids to continue from %d, got: %d", tx.ID, reader.ID)
+	}
+
+	for k := 0; k < 500; k++ {
+		key := []byte(fmt.Sprintf("key-%05d", k))
+		obj, err := reader.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if obj == nil {
+			t.Fatalf("expected %s to survive reopening", key)
+		}
+	}
+}
@@ -0,0 +1,839 @@
+package gopherql
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// unknownType is the type of a bare NULL, which takes the type of whatever it
+// is assigned to or compared with.
+const unknownType ColumnType = 255
+
+// Scope resolves the column references of an expression to values.
+type Scope interface {
+	Lookup(table, name string) (Value, error)
+}
+
+func undefinedColumn(table, name string) SQLStateError {
+	return SQLStateError{
+		Code: "42703",
+		Msg:  fmt.Sprintf("column %s does not exist", (&Identifier{Table: table, Name: name}).String()),
+	}
+}
+
+func datatypeMismatch(format string, args ...interface{}) SQLStateError {
+	return SQLStateError{Code: "42804", Msg: fmt.Sprintf(format, args...)}
+}
+
+// emptyScope has no columns, as in a DEFAULT expression.
+type emptyScope struct{}
+
+func (emptyScope) Lookup(table, name string) (Value, error) {
+	return Value{}, undefinedColumn(table, name)
+}
+
+// rowScope resolves columns against a single row of a table.
+type rowScope struct {
+	table *Table
+	row   Row
+}
+
+func (s rowScope) Lookup(table, name string) (Value, error) {
+	if table != "" && table != s.table.Name {
+		return Value{}, undefinedColumn(table, name)
+	}
+	idx := s.table.ColumnIndex(name)
+	if idx < 0 {
+		return Value{}, undefinedColumn(table, name)
+	}
+	return s.row[idx], nil
+}
+
+// Eval evaluates an expression, resolving column references through scope.
+func Eval(expr Expr, scope Scope) (Value, error) {
+
+	switch e := expr.(type) {
+	case *NullLiteral:
+		return NewNullValue(unknownType), nil
+	case *BoolLiteral:
+		return NewBoolValue(e.Value), nil
+	case *IntegerLiteral:
+		return NewInt64Value(e.Value), nil
+	case *NumberLiteral:
+		return numberValue(e.Value)
+	case *StringLiteral:
+		return NewStringValue(e.Value), nil
+	case *Identifier:
+		return scope.Lookup(e.Table, e.Name)
+	case *UnaryExpr:
+		return evalUnary(e, scope)
+	case *BinaryExpr:
+		return evalBinary(e, scope)
+	case *IsNullExpr:
+		val, err := Eval(e.Expr, scope)
+		if err != nil {
+			return Value{}, err
+		}
+		return NewBoolValue(val.IsNull != e.Not), nil
+	case *BetweenExpr:
+		return evalBetween(e, scope)
+	case *InExpr:
+		return evalIn(e, scope)
+	case *LikeExpr:
+		return evalLike(e, scope)
+	case *CaseExpr:
+		return evalCase(e, scope)
+	case *CastExpr:
+		val, err := Eval(e.Expr, scope)
+		if err != nil {
+			return Value{}, err
+		}
+		return castExplicit(val, e.Target)
+	case *FunctionCall:
+		return evalFunction(e, scope)
+	}
+
+	return Value{}, SQLStateError{Code: "0A000", Msg: fmt.Sprintf("expression not supported here: %s", expr)}
+}
+
+// EvalCondition evaluates a WHERE or CHECK condition. A NULL result is
+// returned as unknown, so callers can tell it apart from false.
+func EvalCondition(expr Expr, scope Scope) (result bool, unknown bool, err error) {
+
+	val, err := Eval(expr, scope)
+	if err != nil {
+		return false, false, err
+	}
+	if val.IsNull {
+		return false, true, nil
+	}
+	if val.Type != BoolColumn {
+		return false, false, datatypeMismatch("argument of condition must be type BOOLEAN, not type %s", val.Type)
+	}
+	return val.BoolValue, false, nil
+}
+
+func isInteger(t ColumnType) bool {
+	return t == Int16Column || t == Int32Column || t == Int64Column
+}
+
+func isNumeric(t ColumnType) bool {
+	return isInteger(t) || t == DecimalColumn || t == Float32Column || t == Float64Column
+}
+
+func isString(t ColumnType) bool {
+	return t == StringColumn || t == CharColumn
+}
+
+func evalUnary(e *UnaryExpr, scope Scope) (Value, error) {
+
+	val, err := Eval(e.Operand, scope)
+	if err != nil {
+		return Value{}, err
+	}
+
+	switch e.Op {
+	case "NOT":
+		if val.IsNull {
+			return NewNullValue(BoolColumn), nil
+		}
+		if val.Type != BoolColumn {
+			return Value{}, datatypeMismatch("argument of NOT must be type BOOLEAN, not type %s", val.Type)
+		}
+		return NewBoolValue(!val.BoolValue), nil
+
+	case "-", "+":
+		if !isNumeric(val.Type) && val.Type != unknownType {
+			return Value{}, datatypeMismatch("operator does not exist: %s %s", e.Op, val.Type)
+		}
+		if val.IsNull || e.Op == "+" {
+			return val, nil
+		}
+		if isInteger(val.Type) || val.Type == DecimalColumn {
+			if val.IntValue == math.MinInt64 {
+				return Value{}, numericOutOfRange(val.Type)
+			}
+			val.IntValue = -val.IntValue
+		} else {
+			val.FloatValue = -val.FloatValue
+		}
+		return val, nil
+	}
+
+	return Value{}, SQLStateError{Code: "0A000", Msg: fmt.Sprintf("operator not supported: %s", e.Op)}
+}
+
+func evalBinary(e *BinaryExpr, scope Scope) (Value, error) {
+
+	left, err := Eval(e.Left, scope)
+	if err != nil {
+		return Value{}, err
+	}
+
+	if e.Op == "AND" || e.Op == "OR" {
+		return evalLogical(e, left, scope)
+	}
+
+	right, err := Eval(e.Right, scope)
+	if err != nil {
+		return Value{}, err
+	}
+
+	switch e.Op {
+	case "=", "<>", "<", "<=", ">", ">=":
+		return compareOperator(e.Op, left, right)
+
+	case "||":
+		if left.IsNull || right.IsNull {
+			return NewNullValue(StringColumn), nil
+		}
+		return NewStringValue(left.String() + right.String()), nil
+
+	case "+", "-", "*", "/", "%":
+		return evalArithmetic(e.Op, left, right)
+	}
+
+	return Value{}, SQLStateError{Code: "0A000", Msg: fmt.Sprintf("operator not supported: %s", e.Op)}
+}
+
+// numberValue types a number written with a fraction or an exponent as a
+// DECIMAL of the scale it is written with, as PostgreSQL does. A number too
+// large or too precise for a DECIMAL is a double instead.
+func numberValue(text string) (Value, error) {
+
+	mantissa, exponent := text, 0
+	if pos := strings.IndexAny(text, "eE"); pos >= 0 {
+		mantissa = text[:pos]
+		exponent, _ = strconv.Atoi(text[pos+1:])
+	}
+	scale := 0
+	if pos := strings.IndexByte(mantissa, '.'); pos >= 0 {
+		scale = len(mantissa) - pos - 1
+	}
+	if scale -= exponent; scale < 0 {
+		scale = 0
+	}
+
+	if rat, ok := new(big.Rat).SetString(text); ok && scale <= maxDecimalPrecision {
+		if val, err := decimalFromRat(rat, &Column{Type: DecimalColumn, Scale: scale}); err == nil {
+			return val, nil
+		}
+	}
+	val, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return Value{}, SQLStateError{Code: "22003", Msg: fmt.Sprintf("number %s is out of range", text)}
+	}
+	return NewFloat64Value(val), nil
+}
+
+// evalLogical applies AND and OR with three-valued logic: FALSE AND NULL is
+// FALSE and TRUE OR NULL is TRUE, otherwise a NULL operand gives NULL.
+func evalLogical(e *BinaryExpr, left Value, scope Scope) (Value, error) {
+
+	toBool := func(val Value) (Value, error) {
+		if val.Type != BoolColumn && !(val.IsNull && val.Type == unknownType) {
+			return Value{}, datatypeMismatch("argument of %s must be type BOOLEAN, not type %s", e.Op, val.Type)
+		}
+		return val, nil
+	}
+
+	left, err := toBool(left)
+	if err != nil {
+		return Value{}, err
+	}
+	decided := e.Op == "OR"
+	if !left.IsNull && left.BoolValue == decided {
+		return NewBoolValue(decided), nil
+	}
+
+	right, err := Eval(e.Right, scope)
+	if err != nil {
+		return Value{}, err
+	}
+	right, err = toBool(right)
+	if err != nil {
+		return Value{}, err
+	}
+	if !right.IsNull && right.BoolValue == decided {
+		return NewBoolValue(decided), nil
+	}
+	if left.IsNull || right.IsNull {
+		return NewNullValue(BoolColumn), nil
+	}
+	return NewBoolValue(!decided), nil
+}
+
+// compareOperator applies a comparison operator, giving NULL when either
+// operand is NULL.
+func compareOperator(op string, left, right Value) (Value, error) {
+	if left.IsNull || right.IsNull {
+		return NewNullValue(BoolColumn), nil
+	}
+	cmp, err := compareValues(left, right)
+	if err != nil {
+		return Value{}, err
+	}
+	return NewBoolValue(compareResult(op, cmp)), nil
+}
+
+func compareResult(op string, cmp int) bool {
+	switch op {
+	case "=":
+		return cmp == 0
+	case "<>":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	}
+	return cmp >= 0
+}
+
+// decimalRat returns an exact rational for any integer or DECIMAL value.
+func decimalRat(v Value) *big.Rat {
+	denominator := big.NewInt(1)
+	if v.Type == DecimalColumn && v.Scale > 0 {
+		denominator.Exp(big.NewInt(10), big.NewInt(int64(v.Scale)), nil)
+	}
+	return new(big.Rat).SetFrac(big.NewInt(v.IntValue), denominator)
+}
+
+func numericFloat(v Value) float64 {
+	switch {
+	case v.Type == Float32Column || v.Type == Float64Column:
+		return v.FloatValue
+	case v.Type == DecimalColumn:
+		f, _ := decimalRat(v).Float64()
+		return f
+	}
+	return float64(v.IntValue)
+}
+
+// compareValues orders two non-null values. Numbers compare across numeric
+// types, and a string compared with a date or time is read as one.
+func compareValues(left, right Value) (int, error) {
+
+	if isString(left.Type) && !isString(right.Type) {
+		converted, err := castValue(left, &Column{Type: right.Type, Precision: maxDecimalPrecision, Scale: right.Scale})
+		if err == nil {
+			left = converted
+		}
+	} else if isString(right.Type) && !isString(left.Type) {
+		converted, err := castValue(right, &Column{Type: left.Type, Precision: maxDecimalPrecision, Scale: left.Scale})
+		if err == nil {
+			right = converted
+		}
+	}
+
+	switch {
+	case isNumeric(left.Type) && isNumeric(right.Type):
+		if isInteger(left.Type) && isInteger(right.Type) {
+			return compareInts(left.IntValue, right.IntValue), nil
+		}
+		if left.Type == Float32Column || left.Type == Float64Column || right.Type == Float32Column || right.Type == Float64Column {
+			return compareFloats(numericFloat(left), numericFloat(right)), nil
+		}
+		return decimalRat(left).Cmp(decimalRat(right)), nil
+
+	case isString(left.Type) && isString(right.Type):
+		if left.Type == CharColumn || right.Type == CharColumn {
+			return strings.Compare(strings.TrimRight(left.StringValue, " "), strings.TrimRight(right.StringValue, " ")), nil
+		}
+		return strings.Compare(left.StringValue, right.StringValue), nil
+
+	case left.Type != right.Type:
+		return 0, datatypeMismatch("cannot compare %s with %s", left.Type, right.Type)
+
+	case left.Type == BoolColumn:
+		return compareInts(boolInt(left.BoolValue), boolInt(right.BoolValue)), nil
+
+	case left.Type == BytesColumn:
+		return strings.Compare(left.StringValue, right.StringValue), nil
+	}
+
+	return compareInts(left.IntValue, right.IntValue), nil
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareFloats orders NaN above every other number, as SQL does.
+func compareFloats(a, b float64) int {
+	switch {
+	case math.IsNaN(a) && math.IsNaN(b):
+		return 0
+	case math.IsNaN(a):
+		return 1
+	case math.IsNaN(b):
+		return -1
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func numericOutOfRange(t ColumnType) SQLStateError {
+	return SQLStateError{Code: "22003", Msg: fmt.Sprintf("%s out of range", t)}
+}
+
+func divisionByZero() SQLStateError {
+	return SQLStateError{Code: "22012", Msg: "division by zero"}
+}
+
+// evalArithmetic applies + - * / and % to numbers. Integers and DECIMALs stay
+// exact and report overflow, anything involving a float is computed in
+// double precision.
+func evalArithmetic(op string, left, right Value) (Value, error) {
+
+	for _, val := range []Value{left, right} {
+		if !isNumeric(val.Type) && val.Type != unknownType {
+			return Value{}, datatypeMismatch("operator does not exist: %s %s %s", left.Type, op, right.Type)
+		}
+	}
+	if left.IsNull || right.IsNull {
+		return NewNullValue(arithmeticType(left.Type, right.Type)), nil
+	}
+
+	if isInteger(left.Type) && isInteger(right.Type) {
+		a, b := left.IntValue, right.IntValue
+		var result int64
+		switch op {
+		case "+":
+			result = a + b
+			if (result > a) != (b > 0) {
+				return Value{}, numericOutOfRange(Int64Column)
+			}
+		case "-":
+			result = a - b
+			if (result < a) != (b > 0) {
+				return Value{}, numericOutOfRange(Int64Column)
+			}
+		case "*":
+			result = a * b
+			if a != 0 && (result/a != b || (a == -1 && b == math.MinInt64)) {
+				return Value{}, numericOutOfRange(Int64Column)
+			}
+		case "/", "%":
+			if b == 0 {
+				return Value{}, divisionByZero()
+			}
+			if a == math.MinInt64 && b == -1 {
+				if op == "%" {
+					return NewInt64Value(0), nil
+				}
+				return Value{}, numericOutOfRange(Int64Column)
+			}
+			if op == "/" {
+				result = a / b
+			} else {
+				result = a % b
+			}
+		}
+		return NewInt64Value(result), nil
+	}
+
+	if left.Type == DecimalColumn || right.Type == DecimalColumn {
+		if (isInteger(left.Type) || left.Type == DecimalColumn) && (isInteger(right.Type) || right.Type == DecimalColumn) {
+			return evalDecimalArithmetic(op, left, right)
+		}
+	}
+
+	a, b := numericFloat(left), numericFloat(right)
+	switch op {
+	case "+":
+		return NewFloat64Value(a + b), nil
+	case "-":
+		return NewFloat64Value(a - b), nil
+	case "*":
+		return NewFloat64Value(a * b), nil
+	}
+	if b == 0 {
+		return Value{}, divisionByZero()
+	}
+	if op == "/" {
+		return NewFloat64Value(a / b), nil
+	}
+	return NewFloat64Value(math.Mod(a, b)), nil
+}
+
+// arithmeticType is the type of the result of arithmetic on values of the
+// given types: integers give a BIGINT, integers and DECIMALs a DECIMAL and
+// anything else a double. A bare NULL leaves it unknown.
+func arithmeticType(left, right ColumnType) ColumnType {
+	exact := func(t ColumnType) bool { return isInteger(t) || t == DecimalColumn }
+	switch {
+	case left == unknownType || right == unknownType:
+		return unknownType
+	case isInteger(left) && isInteger(right):
+		return Int64Column
+	case exact(left) && exact(right):
+		return DecimalColumn
+	}
+	return Float64Column
+}
+
+// decimalDivisionScale is the scale of a quotient of DECIMALs of lower scale,
+// as far as its whole digits leave room for it.
+const decimalDivisionScale = 16
+
+// evalDecimalArithmetic applies an operator to integers and DECIMALs, at
+// least one of them a DECIMAL, with big.Rat so that the result is exact. A
+// sum or difference has the larger scale of the operands and a product the
+// sum of their scales, as in PostgreSQL. The fraction of a product or
+// quotient is rounded to the digits a DECIMAL has room for, but never below
+// the larger scale of the operands.
+func evalDecimalArithmetic(op string, left, right Value) (Value, error) {
+
+	a, b := decimalRat(left), decimalRat(right)
+	scale := left.Scale
+	if right.Scale > scale {
+		scale = right.Scale
+	}
+
+	result := new(big.Rat)
+	switch op {
+	case "+":
+		result.Add(a, b)
+	case "-":
+		result.Sub(a, b)
+	case "*":
+		result.Mul(a, b)
+		scale = fitScale(result, left.Scale+right.Scale, scale)
+	default:
+		if b.Sign() == 0 {
+			return Value{}, divisionByZero()
+		}
+		result.Quo(a, b)
+		if op == "/" {
+			wanted := scale
+			if wanted < decimalDivisionScale {
+				wanted = decimalDivisionScale
+			}
+			scale = fitScale(result, wanted, scale)
+			break
+		}
+		// The remainder takes the sign of the dividend, as the quotient is
+		// truncated towards zero.
+		truncated := new(big.Int).Quo(result.Num(), result.Denom())
+		result.Sub(a, new(big.Rat).Mul(b, new(big.Rat).SetInt(truncated)))
+	}
+	return decimalFromRat(result, &Column{Type: DecimalColumn, Scale: scale})
+}
+
+// fitScale returns the largest scale up to wanted, but no less than least,
+// that leaves room for the whole digits of rat in a DECIMAL.
+func fitScale(rat *big.Rat, wanted, least int) int {
+	whole := new(big.Int).Quo(new(big.Int).Abs(rat.Num()), rat.Denom())
+	if room := maxDecimalPrecision - len(whole.String()); wanted > room {
+		wanted = room
+	}
+	if wanted < least {
+		wanted = least
+	}
+	return wanted
+}
+
+// and3 and not3 apply AND and NOT to booleans that may be NULL.
+func and3(left, right Value) Value {
+	switch {
+	case !left.IsNull && !left.BoolValue, !right.IsNull && !right.BoolValue:
+		return NewBoolValue(false)
+	case left.IsNull || right.IsNull:
+		return NewNullValue(BoolColumn)
+	}
+	return NewBoolValue(true)
+}
+
+func not3(val Value) Value {
+	if val.IsNull {
+		return val
+	}
+	return NewBoolValue(!val.BoolValue)
+}
+
+func negateIf(not bool, val Value) Value {
+	if not {
+		return not3(val)
+	}
+	return val
+}
+
+func evalBetween(e *BetweenExpr, scope Scope) (Value, error) {
+
+	values, err := evalAll([]Expr{e.Expr, e.Low, e.High}, scope)
+	if err != nil {
+		return Value{}, err
+	}
+	above, err := compareOperator(">=", values[0], values[1])
+	if err != nil {
+		return Value{}, err
+	}
+	below, err := compareOperator("<=", values[0], values[2])
+	if err != nil {
+		return Value{}, err
+	}
+	return negateIf(e.Not, and3(above, below)), nil
+}
+
+// evalIn is TRUE when the value equals an item of the list. Otherwise it is
+// NULL if the value or any item is NULL, and FALSE if not.
+func evalIn(e *InExpr, scope Scope) (Value, error) {
+
+	val, err := Eval(e.Expr, scope)
+	if err != nil {
+		return Value{}, err
+	}
+
+	result := NewBoolValue(false)
+	for _, expr := range e.List {
+		item, err := Eval(expr, scope)
+		if err != nil {
+			return Value{}, err
+		}
+		equal, err := compareOperator("=", val, item)
+		if err != nil {
+			return Value{}, err
+		}
+		if equal.IsNull {
+			result = equal
+		} else if equal.BoolValue {
+			return negateIf(e.Not, equal), nil
+		}
+	}
+	return negateIf(e.Not, result), nil
+}
+
+func evalLike(e *LikeExpr, scope Scope) (Value, error) {
+
+	exprs := []Expr{e.Expr, e.Pattern}
+	if e.Escape != nil {
+		exprs = append(exprs, e.Escape)
+	}
+	values, err := evalAll(exprs, scope)
+	if err != nil {
+		return Value{}, err
+	}
+
+	op := "LIKE"
+	if e.IgnoreCase {
+		op = "ILIKE"
+	}
+	for _, val := range values {
+		if !isString(val.Type) && val.Type != unknownType {
+			return Value{}, datatypeMismatch("operator does not exist: %s %s %s", values[0].Type, op, values[1].Type)
+		}
+	}
+	for _, val := range values {
+		if val.IsNull {
+			return NewNullValue(BoolColumn), nil
+		}
+	}
+
+	escape := '\\'
+	if len(values) == 3 {
+		switch chars := []rune(values[2].StringValue); len(chars) {
+		case 0:
+			escape = -1
+		case 1:
+			escape = chars[0]
+		default:
+			return Value{}, SQLStateError{Code: "22019", Msg: "invalid escape string"}
+		}
+	}
+
+	text, pattern := stringText(values[0]), stringText(values[1])
+	if e.IgnoreCase {
+		text, pattern = strings.ToLower(text), strings.ToLower(pattern)
+	}
+	matched, err := likeMatch([]rune(text), []rune(pattern), escape)
+	if err != nil {
+		return Value{}, err
+	}
+	return negateIf(e.Not, NewBoolValue(matched)), nil
+}
+
+// stringText returns the text of a value, without the padding of a CHAR.
+func stringText(val Value) string {
+	switch val.Type {
+	case CharColumn:
+		return strings.TrimRight(val.StringValue, " ")
+	case StringColumn:
+		return val.StringValue
+	}
+	return val.String()
+}
+
+// likeToken is a character of a LIKE pattern: a literal, or the wildcard _
+// for any one character or % for any run of them.
+type likeToken struct {
+	char rune
+	one  bool
+	many bool
+}
+
+// likeMatch reports whether text matches a LIKE pattern. An escape of -1
+// means the pattern has no escape character.
+func likeMatch(text, pattern []rune, escape rune) (bool, error) {
+
+	tokens := make([]likeToken, 0, len(pattern))
+	for idx := 0; idx < len(pattern); idx++ {
+		switch char := pattern[idx]; {
+		case char == escape:
+			idx++
+			if idx == len(pattern) {
+				return false, SQLStateError{Code: "22025", Msg: "LIKE pattern must not end with escape character"}
+			}
+			tokens = append(tokens, likeToken{char: pattern[idx]})
+		case char == '_':
+			tokens = append(tokens, likeToken{one: true})
+		case char == '%':
+			tokens = append(tokens, likeToken{many: true})
+		default:
+			tokens = append(tokens, likeToken{char: char})
+		}
+	}
+
+	// Match greedily, going back to the last % whenever the rest fails.
+	pos, tok := 0, 0
+	star, mark := -1, 0
+	for pos < len(text) {
+		switch {
+		case tok < len(tokens) && !tokens[tok].many && (tokens[tok].one || tokens[tok].char == text[pos]):
+			pos++
+			tok++
+		case tok < len(tokens) && tokens[tok].many:
+			star, mark = tok, pos
+			tok++
+		case star >= 0:
+			mark++
+			pos, tok = mark, star+1
+		default:
+			return false, nil
+		}
+	}
+	for tok < len(tokens) && tokens[tok].many {
+		tok++
+	}
+	return tok == len(tokens), nil
+}
+
+// evalCase returns the result of the first WHEN that holds, or the ELSE
+// result, which is NULL when there is none. Results that are not chosen are
+// never evaluated.
+func evalCase(e *CaseExpr, scope Scope) (Value, error) {
+
+	var operand Value
+	if e.Operand != nil {
+		var err error
+		if operand, err = Eval(e.Operand, scope); err != nil {
+			return Value{}, err
+		}
+	}
+
+	for _, when := range e.Whens {
+		cond, err := Eval(when.Cond, scope)
+		if err != nil {
+			return Value{}, err
+		}
+		if e.Operand != nil {
+			if cond, err = compareOperator("=", operand, cond); err != nil {
+				return Value{}, err
+			}
+		} else if cond.Type != BoolColumn && !(cond.IsNull && cond.Type == unknownType) {
+			return Value{}, datatypeMismatch("argument of CASE/WHEN must be type BOOLEAN, not type %s", cond.Type)
+		}
+		if !cond.IsNull && cond.BoolValue {
+			return Eval(when.Result, scope)
+		}
+	}
+
+	if e.Else == nil {
+		return NewNullValue(unknownType), nil
+	}
+	return Eval(e.Else, scope)
+}
+
+// castExplicit converts a value as CAST does. Unlike an assignment, a string
+// too long for the target is cut short rather than rejected.
+func castExplicit(v Value, target *Column) (Value, error) {
+
+	converted, err := castValue(v, target)
+	if err != nil {
+		return Value{}, err
+	}
+	if isString(target.Type) && target.Size > 0 && !converted.IsNull {
+		if chars := []rune(converted.StringValue); len(chars) > target.Size {
+			converted.StringValue = string(chars[:target.Size])
+		}
+	}
+	return converted, target.checkValue(converted)
+}
+
+func evalFunction(e *FunctionCall, scope Scope) (Value, error) {
+
+	switch {
+	case e.Name == "COALESCE" && len(e.Args) > 0:
+		var val Value
+		for _, arg := range e.Args {
+			var err error
+			if val, err = Eval(arg, scope); err != nil || !val.IsNull {
+				return val, err
+			}
+		}
+		return val, nil
+
+	case e.Name == "NULLIF" && len(e.Args) == 2:
+		values, err := evalAll(e.Args, scope)
+		if err != nil {
+			return Value{}, err
+		}
+		equal, err := compareOperator("=", values[0], values[1])
+		if err != nil {
+			return Value{}, err
+		}
+		if !equal.IsNull && equal.BoolValue {
+			return NewNullValue(values[0].Type), nil
+		}
+		return values[0], nil
+
+	case aggregateFunctions[e.Name]:
+		// Aggregates are computed by the query plan, which replaces them
+		// with references to its results before evaluating anything.
+		return Value{}, misplacedAggregate("this context")
+	}
+
+	fn := e.fn
+	if fn == nil {
+		fn = builtinFunctions[e.Name]
+	}
+	if fn == nil {
+		return Value{}, SQLStateError{Code: "42883", Msg: fmt.Sprintf("function %s does not exist", e.Name)}
+	}
+	args, err := evalAll(e.Args, scope)
+	if err != nil {
+		return Value{}, err
+	}
+	return fn.call(args)
+}
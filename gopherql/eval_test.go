@@ -0,0 +1,130 @@
+package gopherql
+
+import "testing"
+
+func evalText(t *testing.T, sql string) (string, error) {
+	t.Helper()
+
+	expr, err := ParseExpr(sql)
+	if err != nil {
+		t.Fatalf("unexpected error parsing %q: %s", sql, err)
+	}
+	val, err := Eval(expr, emptyScope{})
+	if err != nil {
+		return "", err
+	}
+	return val.String(), nil
+}
+
+func TestEval_Expressions(t *testing.T) {
+
+	for _, test := range []struct {
+		sql      string
+		expected string
+	}{
+		{"7 / 2 + 7 % 2 * 2.5", "5.5"},
+		{"NULL AND FALSE", "FALSE"},
+		{"NULL OR TRUE", "TRUE"},
+		{"NOT (NULL AND TRUE)", "NULL"},
+		{"NULL IS NOT NULL", "FALSE"},
+		{"2 BETWEEN 1 AND 3", "TRUE"},
+		{"2 NOT BETWEEN 3 AND NULL", "TRUE"},
+		{"2 BETWEEN NULL AND 3", "NULL"},
+		{"'b' BETWEEN 'a' AND 'c'", "TRUE"},
+		{"2 IN (1, 2, NULL)", "TRUE"},
+		{"3 IN (1, 2, NULL)", "NULL"},
+		{"3 NOT IN (1, 2)", "TRUE"},
+		{"NULL IN (1)", "NULL"},
+		{"'abc' LIKE 'a%'", "TRUE"},
+		{"'abc' LIKE 'a_'", "FALSE"},
+		{"'abc' LIKE '%b%c'", "TRUE"},
+		{"'ABC' ILIKE 'a%C'", "TRUE"},
+		{"'a%c' LIKE 'a\\%c'", "TRUE"},
+		{"'abc' LIKE 'a!%c' ESCAPE '!'", "FALSE"},
+		{"'abc' NOT LIKE 'x%'", "TRUE"},
+		{"NULL LIKE 'x'", "NULL"},
+		{"CASE 2 WHEN 1 THEN 'one' WHEN 2 THEN 'two' END", "two"},
+		{"CASE WHEN 1 > 2 THEN 'yes' ELSE 'no' END", "no"},
+		{"CASE WHEN NULL THEN 1 END", "NULL"},
+		{"CASE WHEN TRUE THEN 1 ELSE 1 / 0 END", "1"},
+		{"CAST('42' AS INTEGER) + 1", "43"},
+		{"CAST(2.345 AS DECIMAL(4, 2))", "2.35"},
+		{"CAST('abcdef' AS VARCHAR(3))", "abc"},
+		{"'2021-03-04'::DATE", "2021-03-04"},
+		{"CAST(NULL AS BOOLEAN) IS NULL", "TRUE"},
+		{"COALESCE(NULL, NULL, 3, 1 / 0)", "3"},
+		{"NULLIF(1, 1) IS NULL", "TRUE"},
+		{"NULLIF('a', 'b')", "a"},
+	} {
+		found, err := evalText(t, test.sql)
+		if err != nil {
+			t.Errorf("unexpected error for %q: %s", test.sql, err)
+		} else if found != test.expected {
+			t.Errorf("expected %q to be %s, got: %s", test.sql, test.expected, found)
+		}
+	}
+
+	for _, test := range []struct {
+		sql  string
+		code string
+	}{
+		{"1 / 0", "22012"},
+		{"1.5 % 0", "22012"},
+		{"1 + 'a'", "42804"},
+		{"1 = TRUE", "42804"},
+		{"1 AND TRUE", "42804"},
+		{"NOT 1", "42804"},
+		{"1 LIKE 'a'", "42804"},
+		{"2 BETWEEN TRUE AND 3", "42804"},
+		{"1 IN (TRUE)", "42804"},
+		{"CASE WHEN 1 THEN 2 END", "42804"},
+		{"CAST(TRUE AS DATE)", "42804"},
+		{"CAST('x' AS INTEGER)", "22P02"},
+		{"CAST(123.4 AS DECIMAL(3, 1))", "22003"},
+		{"'a' LIKE 'a\\'", "22025"},
+		{"'a' LIKE 'a' ESCAPE 'xy'", "22019"},
+		{"NULLIF(1)", "42883"},
+	} {
+		_, err := evalText(t, test.sql)
+		expectSQLState(t, err, test.code)
+	}
+}
+
+func TestEval_ExactDecimals(t *testing.T) {
+
+	for _, test := range []struct {
+		sql      string
+		expected string
+	}{
+		{"CAST(0.10 AS DECIMAL(10, 2)) * 3", "0.30"},
+		{"0.1 + 0.2 = 0.3", "TRUE"},
+		{"0.1 + 0.2", "0.3"},
+		{"1.10 - 0.1", "1.00"},
+		{"1.5 * 1.5", "2.25"},
+		{"1.0 / 3", "0.3333333333333333"},
+		{"10 / 4.0", "2.5000000000000000"},
+		{"7.5 % 2", "1.5"},
+		{"-7.5 % 2", "-1.5"},
+		{"1.5e-3", "0.0015"},
+		{"2.5E2", "250"},
+		{"CAST(0.1 AS FLOAT8) + 0.2 = 0.3", "FALSE"},
+	} {
+		found, err := evalText(t, test.sql)
+		if err != nil {
+			t.Errorf("unexpected error for %q: %s", test.sql, err)
+		} else if found != test.expected {
+			t.Errorf("expected %q to be %s, got: %s", test.sql, test.expected, found)
+		}
+	}
+
+	for _, test := range []struct {
+		sql  string
+		code string
+	}{
+		{"1.0 / 0", "22012"},
+		{"99999999999.99 * 100000000", "22003"},
+	} {
+		_, err := evalText(t, test.sql)
+		expectSQLState(t, err, test.code)
+	}
+}
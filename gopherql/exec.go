@@ -0,0 +1,446 @@
+package gopherql
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Result struct {
+	RowsAffected int
+}
+
+// Exec runs a single statement in its own transaction, committing it when the
+// statement succeeds and rolling it back otherwise.
+func (db *DB) Exec(sql string) (Result, error) {
+
+	stmt, err := Parse(sql)
+	if err != nil {
+		return Result{}, err
+	}
+	if _, ok := stmt.(*VacuumStmt); ok {
+		return Result{}, db.Vacuum()
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return Result{}, err
+	}
+
+	result, err := tx.exec(stmt)
+	if err != nil {
+		if !tx.finished {
+			tx.Rollback()
+		}
+		return Result{}, err
+	}
+
+	return result, tx.Commit()
+}
+
+// Exec runs a statement in the transaction. A statement that fails has its
+// changes undone, leaving those of earlier statements in place.
+func (tx *Transaction) Exec(sql string) (Result, error) {
+
+	if err := tx.checkActive(); err != nil {
+		return Result{}, err
+	}
+
+	stmt, err := Parse(sql)
+	if err != nil {
+		return Result{}, err
+	}
+
+	sp := tx.savepoint()
+	result, err := tx.exec(stmt)
+	if err != nil && !tx.finished {
+		if undoErr := tx.rollbackTo(sp); undoErr != nil {
+			return Result{}, undoErr
+		}
+	}
+	return result, err
+}
+
+func (tx *Transaction) exec(stmt Statement) (Result, error) {
+
+	switch stmt := stmt.(type) {
+	case *CreateTableStmt:
+		return Result{}, tx.execCreateTable(stmt)
+	case *DropTableStmt:
+		return Result{}, tx.execDropTable(stmt)
+	case *CreateIndexStmt:
+		return Result{}, tx.execCreateIndex(stmt)
+	case *DropIndexStmt:
+		return Result{}, tx.execDropIndex(stmt)
+	case *InsertStmt:
+		return tx.execInsert(stmt)
+	case *SelectStmt:
+		return tx.execQuery(tx.query(stmt))
+	case *ExplainStmt:
+		return tx.execQuery(tx.explain(stmt))
+	case *UpdateStmt:
+		return tx.execUpdate(stmt)
+	case *DeleteStmt:
+		return tx.execDelete(stmt)
+	case *AnalyzeStmt:
+		return Result{}, tx.execAnalyze(stmt)
+	case *VacuumStmt:
+		return Result{}, SQLStateError{Code: "25001", Msg: "VACUUM cannot run inside a transaction block"}
+	}
+
+	return Result{}, SQLStateError{Code: "0A000", Msg: fmt.Sprintf("statement not supported: %T", stmt)}
+}
+
+func (tx *Transaction) execCreateTable(stmt *CreateTableStmt) error {
+
+	table, err := tx.tableFromStmt(stmt)
+	if err != nil {
+		return err
+	}
+
+	err = tx.CreateTable(table)
+	if sqlErr, ok := err.(SQLStateError); ok && sqlErr.Code == "42P07" && stmt.IfNotExists {
+		return nil
+	}
+	return err
+}
+
+func (tx *Transaction) tableFromStmt(stmt *CreateTableStmt) (*Table, error) {
+
+	table := &Table{
+		Schema:      stmt.Name.Schema,
+		Name:        stmt.Name.Name,
+		PrimaryKeys: stmt.PrimaryKey,
+	}
+
+	for _, def := range stmt.Columns {
+		if _, err := table.Column(def.Name); err == nil {
+			return nil, SQLStateError{Code: "42701", Msg: fmt.Sprintf("column %s specified more than once", def.Name)}
+		}
+		table.Columns = append(table.Columns, &Column{
+			Name:      def.Name,
+			Type:      def.Type,
+			NotNull:   def.NotNull,
+			Size:      def.Size,
+			Precision: def.Precision,
+			Scale:     def.Scale,
+		})
+	}
+
+	for _, key := range table.PrimaryKeys {
+		column, err := table.Column(key)
+		if err != nil {
+			return nil, SQLStateError{Code: "42703", Msg: fmt.Sprintf("column %s named in key does not exist", key)}
+		}
+		column.NotNull = true
+	}
+
+	names := constraintNames{}
+
+	for idx, def := range stmt.Columns {
+		if def.Default == nil {
+			continue
+		}
+		column := table.Columns[idx]
+		column.Default = def.Default.String()
+		if _, err := tx.defaultValue(column); err != nil {
+			if sqlErr, ok := err.(SQLStateError); ok && sqlErr.Code == "42703" {
+				return nil, SQLStateError{Code: "0A000", Msg: "cannot use column reference in DEFAULT expression"}
+			}
+			return nil, err
+		}
+	}
+
+	nulls := make(Row, len(table.Columns))
+	for idx, column := range table.Columns {
+		nulls[idx] = NewNullValue(column.Type)
+	}
+	for _, def := range stmt.Checks {
+		if _, _, err := EvalCondition(def.Expr, rowScope{table: table, row: nulls}); err != nil {
+			return nil, err
+		}
+		suffix := "CHECK"
+		if def.Column != "" {
+			suffix = def.Column + "_CHECK"
+		}
+		name, err := names.pick(def.Name, table.Name, suffix)
+		if err != nil {
+			return nil, err
+		}
+		table.Checks = append(table.Checks, CheckConstraint{Name: name, Expr: def.Expr.String()})
+	}
+
+	for _, def := range stmt.Uniques {
+		for _, column := range def.Columns {
+			if table.ColumnIndex(column) < 0 {
+				return nil, SQLStateError{Code: "42703", Msg: fmt.Sprintf("column %s named in key does not exist", column)}
+			}
+		}
+		name, err := names.pick(def.Name, table.Name, strings.Join(def.Columns, "_")+"_KEY")
+		if err != nil {
+			return nil, err
+		}
+		table.Uniques = append(table.Uniques, UniqueConstraint{Name: name, Columns: def.Columns})
+	}
+
+	return table, nil
+}
+
+// constraintNames hands out constraint names that are unique within a table.
+type constraintNames map[string]bool
+
+// pick returns the given name, or when there is none one made from the table
+// and a suffix, numbered if it is already taken.
+func (c constraintNames) pick(given, table, suffix string) (string, error) {
+
+	if given != "" {
+		if c[given] {
+			return "", SQLStateError{Code: "42710", Msg: fmt.Sprintf("constraint %s already exists", quoteIdentifier(given))}
+		}
+		c[given] = true
+		return given, nil
+	}
+
+	base := table + "_" + suffix
+	name := base
+	for n := 1; c[name]; n++ {
+		name = fmt.Sprintf("%s%d", base, n)
+	}
+	c[name] = true
+	return name, nil
+}
+
+func (tx *Transaction) execDropTable(stmt *DropTableStmt) error {
+
+	err := tx.DropTable(stmt.Name.Schema, stmt.Name.Name)
+	if sqlErr, ok := err.(SQLStateError); ok && sqlErr.Code == "42P01" && stmt.IfExists {
+		return nil
+	}
+	return err
+}
+
+func (tx *Transaction) execCreateIndex(stmt *CreateIndexStmt) error {
+
+	err := tx.CreateIndex(&Index{
+		Schema:  stmt.Table.Schema,
+		Name:    stmt.Name,
+		Table:   stmt.Table.Name,
+		Columns: stmt.Columns,
+		Unique:  stmt.Unique,
+	})
+	if sqlErr, ok := err.(SQLStateError); ok && sqlErr.Code == "42P07" && stmt.IfNotExists {
+		return nil
+	}
+	return err
+}
+
+func (tx *Transaction) execDropIndex(stmt *DropIndexStmt) error {
+
+	err := tx.DropIndex(stmt.Name.Schema, stmt.Name.Name)
+	if sqlErr, ok := err.(SQLStateError); ok && sqlErr.Code == "42704" && stmt.IfExists {
+		return nil
+	}
+	return err
+}
+
+// targetColumns resolves the column names of an INSERT or UPDATE to their
+// positions in the table.
+func targetColumns(table *Table, names []string) ([]int, error) {
+
+	indexes := make([]int, len(names))
+	seen := map[string]bool{}
+	for idx, name := range names {
+		if seen[name] {
+			return nil, SQLStateError{Code: "42701", Msg: fmt.Sprintf("column %s specified more than once", quoteIdentifier(name))}
+		}
+		seen[name] = true
+
+		indexes[idx] = table.ColumnIndex(name)
+		if indexes[idx] < 0 {
+			return nil, undefinedColumn("", name)
+		}
+	}
+	return indexes, nil
+}
+
+func (tx *Transaction) execInsert(stmt *InsertStmt) (Result, error) {
+
+	table, err := tx.Table(stmt.Table.Schema, stmt.Table.Name)
+	if err != nil {
+		return Result{}, err
+	}
+
+	names := stmt.Columns
+	if names == nil {
+		names = table.ColumnNames()
+	}
+	targets, err := targetColumns(table, names)
+	if err != nil {
+		return Result{}, err
+	}
+
+	for _, values := range stmt.Rows {
+		tx.bindFunctions(values...)
+		if err := tx.checkCalls(nil, values...); err != nil {
+			return Result{}, err
+		}
+		if len(values) > len(targets) {
+			return Result{}, SQLStateError{Code: "42601", Msg: "INSERT has more expressions than target columns"}
+		}
+		if len(values) < len(targets) {
+			return Result{}, SQLStateError{Code: "42601", Msg: "INSERT has more target columns than expressions"}
+		}
+
+		row := make(Row, len(table.Columns))
+		given := make([]bool, len(table.Columns))
+		for idx, expr := range values {
+			val, err := Eval(expr, emptyScope{})
+			if err != nil {
+				return Result{}, err
+			}
+			column := targets[idx]
+			if row[column], err = assignValue(table.Columns[column], val); err != nil {
+				return Result{}, err
+			}
+			given[column] = true
+		}
+		for idx, column := range table.Columns {
+			if !given[idx] {
+				if row[idx], err = tx.defaultValue(column); err != nil {
+					return Result{}, err
+				}
+			}
+		}
+
+		if err := tx.InsertRow(table, row); err != nil {
+			return Result{}, err
+		}
+	}
+
+	return Result{RowsAffected: len(stmt.Rows)}, nil
+}
+
+// storedRow is a row matched by an UPDATE or DELETE, kept with the version it
+// was read from.
+type storedRow struct {
+	obj *PageObject
+	row Row
+}
+
+// matchingRows collects the rows of table for which where is true, before any
+// of them are changed.
+func (tx *Transaction) matchingRows(table *Table, where Expr) ([]storedRow, error) {
+
+	it, err := tx.ScanRows(table)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []storedRow{}
+	for {
+		obj, row, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		if obj == nil {
+			return matches, nil
+		}
+
+		if where != nil {
+			result, _, err := EvalCondition(where, rowScope{table: table, row: row})
+			if err != nil {
+				return nil, err
+			}
+			if !result {
+				continue
+			}
+		}
+		matches = append(matches, storedRow{obj: obj, row: row})
+	}
+}
+
+func (tx *Transaction) execUpdate(stmt *UpdateStmt) (Result, error) {
+
+	table, err := tx.Table(stmt.Table.Schema, stmt.Table.Name)
+	if err != nil {
+		return Result{}, err
+	}
+
+	names := make([]string, len(stmt.Set))
+	for idx, assignment := range stmt.Set {
+		names[idx] = assignment.Column
+	}
+	targets, err := targetColumns(table, names)
+	if err != nil {
+		return Result{}, err
+	}
+
+	tx.bindFunctions(stmt.Where)
+	columns := tableColumns(table, table.Name)
+	for _, assignment := range stmt.Set {
+		tx.bindFunctions(assignment.Value)
+		if err := bindColumns(columns, assignment.Value); err != nil {
+			return Result{}, err
+		}
+		if err := tx.checkCalls(tableColumnType(table), assignment.Value); err != nil {
+			return Result{}, err
+		}
+	}
+	if err := bindColumns(columns, stmt.Where); err != nil {
+		return Result{}, err
+	}
+	if err := tx.checkCalls(tableColumnType(table), stmt.Where); err != nil {
+		return Result{}, err
+	}
+	matches, err := tx.matchingRows(table, stmt.Where)
+	if err != nil {
+		return Result{}, err
+	}
+
+	for _, match := range matches {
+		row := append(Row{}, match.row...)
+		for idx, assignment := range stmt.Set {
+			val, err := Eval(assignment.Value, rowScope{table: table, row: match.row})
+			if err != nil {
+				return Result{}, err
+			}
+			column := targets[idx]
+			if row[column], err = assignValue(table.Columns[column], val); err != nil {
+				return Result{}, err
+			}
+		}
+
+		if err := tx.UpdateRow(table, match.obj, row); err != nil {
+			return Result{}, err
+		}
+	}
+
+	return Result{RowsAffected: len(matches)}, nil
+}
+
+func (tx *Transaction) execDelete(stmt *DeleteStmt) (Result, error) {
+
+	table, err := tx.Table(stmt.Table.Schema, stmt.Table.Name)
+	if err != nil {
+		return Result{}, err
+	}
+
+	tx.bindFunctions(stmt.Where)
+	if err := bindColumns(tableColumns(table, table.Name), stmt.Where); err != nil {
+		return Result{}, err
+	}
+	if err := tx.checkCalls(tableColumnType(table), stmt.Where); err != nil {
+		return Result{}, err
+	}
+	matches, err := tx.matchingRows(table, stmt.Where)
+	if err != nil {
+		return Result{}, err
+	}
+
+	for _, match := range matches {
+		if err := tx.DeleteRow(table, match.obj); err != nil {
+			return Result{}, err
+		}
+	}
+
+	return Result{RowsAffected: len(matches)}, nil
+}
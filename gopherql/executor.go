@@ -0,0 +1,595 @@
+package gopherql
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+)
+
+// Operator is a node of a query plan. Open prepares it, each call to Next
+// returns one row, nil once the operator is exhausted, and Close releases
+// what Open acquired. Rows are pulled through the plan one at a time, so only
+// operators that must see all of their input before producing anything, such
+// as Sort, hold more than a row in memory.
+type Operator interface {
+	Open() error
+	Next() (Row, error)
+	Close() error
+	// Columns describes the rows returned by Next.
+	Columns() []ResultColumn
+}
+
+// ResultColumn names a column of the rows an operator produces. Table is the
+// table or alias a column reference may qualify it with, and is empty for
+// computed columns.
+type ResultColumn struct {
+	Table string
+	Name  string
+}
+
+// resultScope resolves column references against a row an operator produced.
+type resultScope struct {
+	columns []ResultColumn
+	row     Row
+}
+
+func (s resultScope) Lookup(table, name string) (Value, error) {
+	idx, err := findColumn(s.columns, table, name)
+	if err != nil {
+		return Value{}, err
+	}
+	return s.row[idx], nil
+}
+
+// findColumn returns the position of the column a reference names. An
+// unqualified name is ambiguous when columns of several tables have it.
+func findColumn(columns []ResultColumn, table, name string) (int, error) {
+	found := -1
+	for idx, column := range columns {
+		if column.Name != name || (table != "" && table != column.Table) {
+			continue
+		}
+		if found >= 0 {
+			return -1, SQLStateError{
+				Code: "42702",
+				Msg:  fmt.Sprintf("column reference %s is ambiguous", (&Identifier{Table: table, Name: name}).String()),
+			}
+		}
+		found = idx
+	}
+	if found < 0 {
+		return -1, undefinedColumn(table, name)
+	}
+	return found, nil
+}
+
+// bindColumns checks that every column reference of exprs names exactly one
+// of columns, so that a query naming a missing or ambiguous column fails as
+// it is planned rather than when a row first reaches the reference.
+func bindColumns(columns []ResultColumn, exprs ...Expr) error {
+	var err error
+	for _, expr := range exprs {
+		walkExpr(expr, func(expr Expr) {
+			if ident, ok := expr.(*Identifier); ok && err == nil {
+				_, err = findColumn(columns, ident.Table, ident.Name)
+			}
+		})
+	}
+	return err
+}
+
+// hashKey encodes values so that values comparing equal give equal keys.
+// Numbers are compared across numeric types, so they are encoded by their
+// value alone. Other values of one column share a type, so the key encoding
+// of the B-tree serves for them.
+func hashKey(values Row) (key string, hasNull bool) {
+
+	encoded := []byte{}
+	for _, val := range values {
+		switch {
+		case val.IsNull:
+			hasNull = true
+			encoded = append(encoded, 2)
+		case isNumeric(val.Type):
+			encoded = append(append(append(encoded, 3), numericKey(val)...), 0)
+		default:
+			encoded = appendKeyValue(append(encoded, 1), val)
+		}
+	}
+	return string(encoded), hasNull
+}
+
+// numericKey writes a number as an exact fraction in lowest terms. A float is
+// read as the shortest decimal that parses back to it, so that it meets the
+// integers and DECIMALs it compares equal with.
+func numericKey(v Value) string {
+
+	switch v.Type {
+	case Int16Column, Int32Column, Int64Column:
+		return strconv.FormatInt(v.IntValue, 10)
+	case DecimalColumn:
+		return decimalRat(v).RatString()
+	}
+	if math.IsNaN(v.FloatValue) || math.IsInf(v.FloatValue, 0) {
+		return strconv.FormatFloat(v.FloatValue, 'g', -1, 64)
+	}
+	rat, _ := new(big.Rat).SetString(strconv.FormatFloat(v.FloatValue, 'g', -1, 64))
+	return rat.RatString()
+}
+
+func evalAll(exprs []Expr, scope Scope) (Row, error) {
+	values := make(Row, len(exprs))
+	for idx, expr := range exprs {
+		val, err := Eval(expr, scope)
+		if err != nil {
+			return nil, err
+		}
+		values[idx] = val
+	}
+	return values, nil
+}
+
+// TableScan returns the rows of a table visible to the transaction, in
+// primary key order. Alias, when set, replaces the table name in column
+// references.
+type TableScan struct {
+	Tx    *Transaction
+	Table *Table
+	Alias string
+
+	rows *RowIterator
+}
+
+func (s *TableScan) Open() (err error) {
+	s.rows, err = s.Tx.ScanRows(s.Table)
+	return err
+}
+
+func (s *TableScan) Next() (Row, error) {
+	_, row, err := s.rows.Next()
+	return row, err
+}
+
+func (s *TableScan) Close() error {
+	s.rows = nil
+	return nil
+}
+
+func (s *TableScan) Columns() []ResultColumn {
+	return tableColumns(s.Table, s.Alias)
+}
+
+func tableColumns(table *Table, alias string) []ResultColumn {
+	if alias == "" {
+		alias = table.Name
+	}
+	columns := make([]ResultColumn, len(table.Columns))
+	for idx, column := range table.Columns {
+		columns[idx] = ResultColumn{Table: alias, Name: column.Name}
+	}
+	return columns
+}
+
+// PrimaryKeyScan returns the rows of a table whose leading primary key
+// columns equal Prefix and whose next column lies between Low and High, in
+// primary key order.
+type PrimaryKeyScan struct {
+	Tx     *Transaction
+	Table  *Table
+	Alias  string
+	Prefix Row
+	Low    *Bound
+	High   *Bound
+
+	rows *RowIterator
+}
+
+func (s *PrimaryKeyScan) Open() (err error) {
+	s.rows, err = s.Tx.ScanPrimaryKeyRange(s.Table, KeyRange{Prefix: s.Prefix, Low: s.Low, High: s.High})
+	return err
+}
+
+func (s *PrimaryKeyScan) Next() (Row, error) {
+	_, row, err := s.rows.Next()
+	return row, err
+}
+
+func (s *PrimaryKeyScan) Close() error {
+	s.rows = nil
+	return nil
+}
+
+func (s *PrimaryKeyScan) Columns() []ResultColumn {
+	return tableColumns(s.Table, s.Alias)
+}
+
+// IndexScan returns the rows of a table whose leading indexed columns equal
+// Prefix and whose next indexed column lies between Low and High, in index
+// order.
+type IndexScan struct {
+	Tx     *Transaction
+	Table  *Table
+	Alias  string
+	Index  *Index
+	Prefix Row
+	Low    *Bound
+	High   *Bound
+
+	rows *IndexIterator
+}
+
+func (s *IndexScan) Open() (err error) {
+	s.rows, err = s.Tx.ScanIndexRange(s.Table, s.Index, KeyRange{Prefix: s.Prefix, Low: s.Low, High: s.High})
+	return err
+}
+
+func (s *IndexScan) Next() (Row, error) {
+	_, row, err := s.rows.Next()
+	return row, err
+}
+
+func (s *IndexScan) Close() error {
+	s.rows = nil
+	return nil
+}
+
+func (s *IndexScan) Columns() []ResultColumn {
+	return tableColumns(s.Table, s.Alias)
+}
+
+// singleRow returns one row without columns, the input of a SELECT without
+// FROM.
+type singleRow struct {
+	done bool
+}
+
+func (s *singleRow) Open() error {
+	s.done = false
+	return nil
+}
+
+func (s *singleRow) Next() (Row, error) {
+	if s.done {
+		return nil, nil
+	}
+	s.done = true
+	return Row{}, nil
+}
+
+func (s *singleRow) Close() error {
+	return nil
+}
+
+func (s *singleRow) Columns() []ResultColumn {
+	return nil
+}
+
+// Filter passes on the rows of Input for which Cond is true.
+type Filter struct {
+	Input Operator
+	Cond  Expr
+}
+
+func (f *Filter) Open() error {
+	return f.Input.Open()
+}
+
+func (f *Filter) Next() (Row, error) {
+	columns := f.Input.Columns()
+	for {
+		row, err := f.Input.Next()
+		if err != nil || row == nil {
+			return nil, err
+		}
+		result, _, err := EvalCondition(f.Cond, resultScope{columns: columns, row: row})
+		if err != nil {
+			return nil, err
+		}
+		if result {
+			return row, nil
+		}
+	}
+}
+
+func (f *Filter) Close() error {
+	return f.Input.Close()
+}
+
+func (f *Filter) Columns() []ResultColumn {
+	return f.Input.Columns()
+}
+
+// Project evaluates Exprs against each row of Input, producing a row with a
+// column for each, named by Names.
+type Project struct {
+	Input Operator
+	Exprs []Expr
+	Names []ResultColumn
+}
+
+func (p *Project) Open() error {
+	return p.Input.Open()
+}
+
+func (p *Project) Next() (Row, error) {
+	row, err := p.Input.Next()
+	if err != nil || row == nil {
+		return nil, err
+	}
+	return evalAll(p.Exprs, resultScope{columns: p.Input.Columns(), row: row})
+}
+
+func (p *Project) Close() error {
+	return p.Input.Close()
+}
+
+func (p *Project) Columns() []ResultColumn {
+	return p.Names
+}
+
+// SortKey is an expression rows are ordered by. NULLs sort after every other
+// value, so they come last in ascending order and first in descending order.
+type SortKey struct {
+	Expr Expr
+	Desc bool
+}
+
+// Sort returns the rows of Input ordered by Keys, keeping the input order of
+// rows that compare equal. It reads all of its input when opened. Once more
+// than MaxRows rows are held, they are sorted and spilled to temporary pages
+// as a run, and the runs are merged as rows are read. Zero MaxRows keeps
+// every row in memory.
+type Sort struct {
+	Input   Operator
+	Keys    []SortKey
+	MaxRows int
+
+	rows  []sortedRow
+	pos   int
+	pager *tempPager
+	runs  []*spillRun
+	merge []*sortRun
+}
+
+type sortedRow struct {
+	keys Row
+	row  Row
+}
+
+// sortRun is a spilled run being merged, with its next row.
+type sortRun struct {
+	reader *spillReader
+	head   *sortedRow
+}
+
+func (s *Sort) Open() error {
+
+	if err := s.Close(); err != nil {
+		return err
+	}
+	if err := s.Input.Open(); err != nil {
+		return err
+	}
+	defer s.Input.Close()
+
+	exprs := make([]Expr, len(s.Keys))
+	for idx, key := range s.Keys {
+		exprs[idx] = key.Expr
+	}
+	columns := s.Input.Columns()
+
+	for {
+		row, err := s.Input.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+		keys, err := evalAll(exprs, resultScope{columns: columns, row: row})
+		if err != nil {
+			return err
+		}
+		s.rows = append(s.rows, sortedRow{keys: keys, row: row})
+
+		if s.MaxRows > 0 && len(s.rows) >= s.MaxRows {
+			if err := s.spill(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(s.runs) == 0 {
+		return s.sortRows()
+	}
+	if err := s.spill(); err != nil {
+		return err
+	}
+	for _, run := range s.runs {
+		merging := &sortRun{reader: run.Reader()}
+		if err := s.advance(merging); err != nil {
+			return err
+		}
+		s.merge = append(s.merge, merging)
+	}
+	return nil
+}
+
+func (s *Sort) sortRows() error {
+	var sortErr error
+	sort.SliceStable(s.rows, func(i, j int) bool {
+		cmp, err := compareSortKeys(s.Keys, s.rows[i].keys, s.rows[j].keys)
+		if err != nil && sortErr == nil {
+			sortErr = err
+		}
+		return cmp < 0
+	})
+	return sortErr
+}
+
+// spill sorts the rows held in memory and writes them out as a run, each
+// row preceded by its sort keys.
+func (s *Sort) spill() error {
+
+	if len(s.rows) == 0 {
+		return nil
+	}
+	if err := s.sortRows(); err != nil {
+		return err
+	}
+	if s.pager == nil {
+		pager, err := newTempPager()
+		if err != nil {
+			return err
+		}
+		s.pager = pager
+	}
+
+	run := newSpillRun(s.pager)
+	for _, row := range s.rows {
+		if err := run.Write(append(append(Row{}, row.keys...), row.row...)); err != nil {
+			return err
+		}
+	}
+	if err := run.Finish(); err != nil {
+		return err
+	}
+	s.runs = append(s.runs, run)
+	s.rows = nil
+	return nil
+}
+
+func (s *Sort) advance(run *sortRun) error {
+	row, err := run.reader.Next()
+	if err != nil || row == nil {
+		run.head = nil
+		return err
+	}
+	run.head = &sortedRow{keys: row[:len(s.Keys)], row: row[len(s.Keys):]}
+	return nil
+}
+
+func compareSortKeys(keys []SortKey, a, b Row) (int, error) {
+	for idx, key := range keys {
+		cmp, err := compareNullsLast(a[idx], b[idx])
+		if err != nil {
+			return 0, err
+		}
+		if key.Desc {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp, nil
+		}
+	}
+	return 0, nil
+}
+
+// compareNullsLast orders two values, taking NULL to be the largest.
+func compareNullsLast(a, b Value) (int, error) {
+	switch {
+	case a.IsNull && b.IsNull:
+		return 0, nil
+	case a.IsNull:
+		return 1, nil
+	case b.IsNull:
+		return -1, nil
+	}
+	return compareValues(a, b)
+}
+
+func (s *Sort) Next() (Row, error) {
+
+	if len(s.merge) == 0 {
+		if s.pos >= len(s.rows) {
+			return nil, nil
+		}
+		s.pos++
+		return s.rows[s.pos-1].row, nil
+	}
+
+	// Runs hold rows in input order, so taking the first of equal rows
+	// keeps the sort stable.
+	var next *sortRun
+	for _, run := range s.merge {
+		if run.head == nil {
+			continue
+		}
+		if next != nil {
+			cmp, err := compareSortKeys(s.Keys, run.head.keys, next.head.keys)
+			if err != nil {
+				return nil, err
+			}
+			if cmp >= 0 {
+				continue
+			}
+		}
+		next = run
+	}
+	if next == nil {
+		return nil, nil
+	}
+
+	row := next.head.row
+	return row, s.advance(next)
+}
+
+func (s *Sort) Close() error {
+	s.rows, s.pos, s.runs, s.merge = nil, 0, nil, nil
+	if s.pager == nil {
+		return nil
+	}
+	pager := s.pager
+	s.pager = nil
+	return pager.Close()
+}
+
+func (s *Sort) Columns() []ResultColumn {
+	return s.Input.Columns()
+}
+
+// Limit skips the first Offset rows of Input and returns at most Count of
+// the rest, or all of them when Count is negative.
+type Limit struct {
+	Input  Operator
+	Count  int64
+	Offset int64
+
+	returned int64
+}
+
+func (l *Limit) Open() error {
+	l.returned = 0
+	if err := l.Input.Open(); err != nil {
+		return err
+	}
+	for skipped := int64(0); skipped < l.Offset; skipped++ {
+		row, err := l.Input.Next()
+		if err != nil || row == nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *Limit) Next() (Row, error) {
+	if l.Count >= 0 && l.returned >= l.Count {
+		return nil, nil
+	}
+	row, err := l.Input.Next()
+	if err != nil || row == nil {
+		return nil, err
+	}
+	l.returned++
+	return row, nil
+}
+
+func (l *Limit) Close() error {
+	return l.Input.Close()
+}
+
+func (l *Limit) Columns() []ResultColumn {
+	return l.Input.Columns()
+}
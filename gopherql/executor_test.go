@@ -0,0 +1,322 @@
+package gopherql
+
+import (
+	"strings"
+	"testing"
+)
+
+// queryRows runs a query and returns its rows as strings.
+func queryRows(t *testing.T, db *DB, sql string) [][]string {
+	t.Helper()
+
+	rows, err := db.Query(sql)
+	if err != nil {
+		t.Fatalf("unexpected error for %q: %s", sql, err)
+	}
+	defer rows.Close()
+	return drainRows(t, rows.Next)
+}
+
+func drainRows(t *testing.T, next func() (Row, error)) [][]string {
+	t.Helper()
+
+	result := [][]string{}
+	for {
+		row, err := next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if row == nil {
+			return result
+		}
+		values := make([]string, len(row))
+		for idx, val := range row {
+			values[idx] = val.String()
+		}
+		result = append(result, values)
+	}
+}
+
+func joinRows(rows [][]string) string {
+	lines := make([]string, len(rows))
+	for idx, row := range rows {
+		lines[idx] = strings.Join(row, ",")
+	}
+	return strings.Join(lines, ";")
+}
+
+func openExecutorDB(t *testing.T, dbFile string, statements ...string) *DB {
+	t.Helper()
+
+	db, err := Open(dbFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sql := range statements {
+		if _, err := db.Exec(sql); err != nil {
+			db.Close()
+			t.Fatalf("unexpected error for %q: %s", sql, err)
+		}
+	}
+	return db
+}
+
+func TestExecutor_Select(t *testing.T) {
+	dbFile := "selectTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	db := openExecutorDB(t, dbFile,
+		"CREATE TABLE people (id INTEGER PRIMARY KEY, name TEXT, city TEXT, age INTEGER)",
+		"INSERT INTO people VALUES (1, 'Ann', 'Oslo', 30), (2, 'Bob', 'Rome', 25), (3, 'Cid', 'Oslo', NULL), (4, 'Dee', 'Oslo', 41)",
+		"CREATE INDEX people_city ON people (city)",
+	)
+	defer db.Close()
+
+	for _, test := range []struct {
+		sql      string
+		expected string
+	}{
+		{"SELECT * FROM people WHERE id = 2", "2,Bob,Rome,25"},
+		{"SELECT name FROM people WHERE city = 'Oslo' AND age > 35", "Dee"},
+		{"SELECT name, age FROM people ORDER BY age DESC", "Cid,NULL;Dee,41;Ann,30;Bob,25"},
+		{"SELECT name AS n FROM people ORDER BY n DESC LIMIT 2", "Dee;Cid"},
+		{"SELECT id * 10, name FROM people ORDER BY 2 LIMIT 2 OFFSET 1", "20,Bob;30,Cid"},
+		{"SELECT people.* FROM people WHERE city = 'Rome'", "2,Bob,Rome,25"},
+		{"SELECT 1 + 2, 'x' || 'y'", "3,xy"},
+		{"SELECT id FROM people LIMIT NULL OFFSET 3", "4"},
+	} {
+		if found := joinRows(queryRows(t, db, test.sql)); found != test.expected {
+			t.Errorf("unexpected result for %q: %s", test.sql, found)
+		}
+	}
+
+	rows, err := db.Query("SELECT id AS ident, name, age + 1 FROM people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := strings.Join(rows.Columns, ","); names != "IDENT,NAME,?column?" {
+		t.Errorf("unexpected column names: %s", names)
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := Parse("SELECT name FROM people WHERE age > 20 AND city = 'Oslo'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := tx.planSelect(stmt.(*SelectStmt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scan, ok := plan.(*Project).Input.(*Filter).Input.(*IndexScan); !ok || scan.Index.Name != "PEOPLE_CITY" {
+		t.Errorf("expected a scan of the city index, got: %T", plan.(*Project).Input.(*Filter).Input)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := db.Exec("SELECT * FROM people WHERE city = 'Oslo'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RowsAffected != 3 {
+		t.Errorf("expected 3 rows, got: %d", result.RowsAffected)
+	}
+
+	for _, test := range []struct {
+		sql  string
+		code string
+	}{
+		{"SELECT * FROM missing", "42P01"},
+		{"SELECT missing FROM people", "42703"},
+		{"SELECT *", "42601"},
+		{"SELECT id FROM people ORDER BY 3", "42P10"},
+		{"SELECT id FROM people LIMIT -1", "2201W"},
+		{"SELECT id FROM people OFFSET 'a'", "42804"},
+		{"SELECT id FROM people WHERE name", "42804"},
+	} {
+		rows, err := db.Query(test.sql)
+		if err == nil {
+			_, err = rows.Next()
+			rows.Close()
+		}
+		expectSQLState(t, err, test.code)
+	}
+}
+
+func TestExecutor_BindsColumns(t *testing.T) {
+	dbFile := "bindTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	db := openExecutorDB(t, dbFile, "CREATE TABLE a (id INTEGER PRIMARY KEY, name TEXT)")
+	defer db.Close()
+
+	// The table is empty, so no row ever reaches the references: they must
+	// be checked as the query is planned.
+	for _, sql := range []string{
+		"SELECT nosuch FROM a",
+		"SELECT * FROM a WHERE nosuch = 1",
+		"SELECT id FROM a ORDER BY nosuch",
+		"SELECT id FROM a GROUP BY nosuch",
+		"SELECT id FROM a GROUP BY id HAVING MAX(nosuch) > 1",
+		"SELECT COUNT(nosuch) FROM a",
+		"SELECT b.id FROM a",
+		"SELECT nosuch",
+		"EXPLAIN SELECT nosuch FROM a",
+	} {
+		_, err := db.Query(sql)
+		expectSQLState(t, err, "42703")
+	}
+	for _, sql := range []string{
+		"UPDATE a SET name = nosuch",
+		"UPDATE a SET name = 'x' WHERE nosuch = 1",
+		"DELETE FROM a WHERE nosuch = 1",
+	} {
+		_, err := db.Exec(sql)
+		expectSQLState(t, err, "42703")
+	}
+}
+
+func TestExecutor_Streams(t *testing.T) {
+	dbFile := "streamTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	db := openExecutorDB(t, dbFile, "CREATE TABLE big (id INTEGER PRIMARY KEY, body TEXT)")
+	defer db.Close()
+	insertRows(t, db, "big", 500)
+
+	rows, err := db.Query("SELECT id FROM big WHERE id >= 100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := rows.plan.(*Project).Input.(*Filter).Input.(*PrimaryKeyScan)
+	if plan.rows == nil {
+		t.Fatal("expected the scan to be open")
+	}
+	for expected := 100; expected < 103; expected++ {
+		row, err := rows.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if row[0].IntValue != int64(expected) {
+			t.Errorf("expected %d, got: %s", expected, row[0])
+		}
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.header.ActiveTransactions) != 0 {
+		t.Error("expected closing the rows to finish their transaction")
+	}
+	if _, err := rows.Next(); err == nil {
+		t.Error("expected an error reading closed rows")
+	}
+}
+
+func TestExecutor_Operators(t *testing.T) {
+	dbFile := "operatorsTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	db := openExecutorDB(t, dbFile,
+		"CREATE TABLE authors (id INTEGER PRIMARY KEY, name TEXT)",
+		"CREATE TABLE books (id INTEGER PRIMARY KEY, author INTEGER, pages INTEGER)",
+		"INSERT INTO authors VALUES (1, 'Ann'), (2, 'Bob'), (3, 'Cid')",
+		"INSERT INTO books VALUES (10, 1, 100), (11, 2, 250), (12, 1, 300), (13, NULL, 50)",
+	)
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	authors, err := tx.Table("", "AUTHORS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	books, err := tx.Table("", "BOOKS")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(op Operator) string {
+		t.Helper()
+		if err := op.Open(); err != nil {
+			t.Fatal(err)
+		}
+		defer op.Close()
+		return joinRows(drainRows(t, op.Next))
+	}
+
+	authorKey := &Identifier{Table: "A", Name: "ID"}
+	bookAuthor := &Identifier{Table: "BOOKS", Name: "AUTHOR"}
+	project := func(input Operator) Operator {
+		return &Project{
+			Input: input,
+			Exprs: []Expr{&Identifier{Name: "NAME"}, &Identifier{Table: "BOOKS", Name: "ID"}},
+			Names: []ResultColumn{{Name: "NAME"}, {Name: "BOOK"}},
+		}
+	}
+
+	nested := project(&NestedLoopJoin{
+		Left:  &TableScan{Tx: tx, Table: authors, Alias: "A"},
+		Right: &TableScan{Tx: tx, Table: books},
+		Cond:  &BinaryExpr{Op: "=", Left: authorKey, Right: bookAuthor},
+	})
+	if found := run(nested); found != "Ann,10;Ann,12;Bob,11" {
+		t.Errorf("unexpected nested loop join: %s", found)
+	}
+
+	hash := project(&HashJoin{
+		Left:      &TableScan{Tx: tx, Table: authors, Alias: "A"},
+		Right:     &TableScan{Tx: tx, Table: books},
+		LeftKeys:  []Expr{authorKey},
+		RightKeys: []Expr{bookAuthor},
+		Cond:      &BinaryExpr{Op: ">", Left: &Identifier{Name: "PAGES"}, Right: &IntegerLiteral{Value: 200}},
+	})
+	if found := run(hash); found != "Ann,12;Bob,11" {
+		t.Errorf("unexpected hash join: %s", found)
+	}
+
+	aggregate := &HashAggregate{
+		Input:   &TableScan{Tx: tx, Table: books},
+		GroupBy: []Expr{bookAuthor},
+		Aggregates: []AggregateCall{
+			{Func: "COUNT"},
+			{Func: "SUM", Arg: &Identifier{Name: "PAGES"}},
+			{Func: "MAX", Arg: &Identifier{Name: "PAGES"}},
+		},
+		Names: []ResultColumn{{Name: "AUTHOR"}, {Name: "COUNT"}, {Name: "SUM"}, {Name: "MAX"}},
+	}
+	sorted := &Sort{Input: aggregate, Keys: []SortKey{{Expr: &Identifier{Name: "SUM"}, Desc: true}}}
+	if found := run(sorted); found != "1,2,400,300;2,1,250,250;NULL,1,50,50" {
+		t.Errorf("unexpected aggregate: %s", found)
+	}
+
+	total := &HashAggregate{
+		Input: &Filter{
+			Input: &TableScan{Tx: tx, Table: books},
+			Cond:  &BinaryExpr{Op: ">", Left: &Identifier{Name: "PAGES"}, Right: &IntegerLiteral{Value: 1000}},
+		},
+		Aggregates: []AggregateCall{{Func: "COUNT"}, {Func: "AVG", Arg: &Identifier{Name: "PAGES"}}},
+		Names:      []ResultColumn{{Name: "COUNT"}, {Name: "AVG"}},
+	}
+	if found := run(total); found != "0,NULL" {
+		t.Errorf("unexpected aggregate over no rows: %s", found)
+	}
+
+	limited := &Limit{Input: &TableScan{Tx: tx, Table: authors}, Count: 1, Offset: 1}
+	if found := run(limited); found != "2,Bob" {
+		t.Errorf("unexpected limit: %s", found)
+	}
+}
@@ -0,0 +1,466 @@
+package gopherql
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// explain returns the plan of a query as rows of text, one line per operator
+// and per detail of one, each operator indented under the one reading it.
+// Projections that pass their input through unchanged are left out, and the
+// results of an aggregation are shown as the expressions they hold.
+// Every operator shows the rows and cost the planner estimated for it. With
+// ANALYZE the query is run first, and every operator shows the rows it
+// returned, how many times it was opened, and the time its calls took and
+// the pages they fetched from the Pager, those of its inputs included.
+func (tx *Transaction) explain(stmt *ExplainStmt) (*Rows, error) {
+
+	p := tx.newPlanner()
+	plan, err := p.planSelect(stmt.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	var footer []string
+	if stmt.Analyze {
+		start := time.Now()
+		plan = instrument(tx, plan)
+		if err := runPlan(plan); err != nil {
+			return nil, err
+		}
+		footer = append(footer, fmt.Sprintf("Execution Time: %s", milliseconds(time.Since(start))))
+	}
+
+	lines := []string{}
+	explainOperator(plan, 0, p.estimates, aggregateOutputs(plan), &lines)
+	lines = append(lines, footer...)
+
+	rows := make([]Row, len(lines))
+	for idx, line := range lines {
+		rows[idx] = Row{NewStringValue(line)}
+	}
+	result := &valuesScan{columns: []ResultColumn{{Name: "QUERY PLAN"}}, rows: rows}
+	return &Rows{Columns: []string{"QUERY PLAN"}, plan: result}, result.Open()
+}
+
+// runPlan reads every row of a plan.
+func runPlan(plan Operator) error {
+
+	if err := plan.Open(); err != nil {
+		plan.Close()
+		return err
+	}
+	for {
+		row, err := plan.Next()
+		if err != nil {
+			plan.Close()
+			return err
+		}
+		if row == nil {
+			return plan.Close()
+		}
+	}
+}
+
+func milliseconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f ms", float64(d)/float64(time.Millisecond))
+}
+
+// explainOperator adds the lines describing op and its inputs. Outputs
+// holds the expressions the columns of aggregations stand for.
+func explainOperator(op Operator, depth int, estimates map[Operator]estimate, outputs map[string]Expr, lines *[]string) {
+
+	analyzed, _ := op.(*analyzedOperator)
+	if analyzed != nil {
+		op = analyzed.Operator
+	}
+	if project, ok := op.(*Project); ok && identityProject(project) {
+		explainOperator(project.Input, depth, estimates, outputs, lines)
+		return
+	}
+	name, details := describeOperator(op, outputs)
+
+	line := name
+	if depth > 0 {
+		line = strings.Repeat(" ", 6*(depth-1)) + "  ->  " + name
+	}
+	if est, ok := estimates[op]; ok {
+		line += fmt.Sprintf("  (rows=%.0f cost=%.2f)", est.Rows, est.Cost)
+	}
+	if analyzed != nil {
+		line += fmt.Sprintf(" (actual rows=%d loops=%d time=%s pages=%d)",
+			analyzed.rows, analyzed.loops, milliseconds(analyzed.time), analyzed.pages)
+	}
+	*lines = append(*lines, line)
+
+	indent := strings.Repeat(" ", 6*depth+2)
+	for _, detail := range details {
+		*lines = append(*lines, indent+detail)
+	}
+	for _, input := range planInputs(op) {
+		explainOperator(*input, depth+1, estimates, outputs, lines)
+	}
+}
+
+// identityProject reports whether a projection returns the columns of its
+// input as they are, in their order.
+func identityProject(project *Project) bool {
+	input := project.Input.Columns()
+	if len(project.Exprs) != len(input) {
+		return false
+	}
+	for idx, expr := range project.Exprs {
+		ident, ok := expr.(*Identifier)
+		if !ok || project.Names[idx].Name != input[idx].Name {
+			return false
+		}
+		if pos, err := findColumn(input, ident.Table, ident.Name); err != nil || pos != idx {
+			return false
+		}
+	}
+	return true
+}
+
+// aggregateOutputs maps the columns the aggregations of a plan return,
+// $group0... and $agg0..., to the grouping values and aggregate calls they
+// hold.
+func aggregateOutputs(op Operator) map[string]Expr {
+
+	outputs := map[string]Expr{}
+	var collect func(op Operator)
+	collect = func(op Operator) {
+		if analyzed, ok := op.(*analyzedOperator); ok {
+			op = analyzed.Operator
+		}
+		var groupBy []Expr
+		var calls []AggregateCall
+		var names []ResultColumn
+		switch op := op.(type) {
+		case *HashAggregate:
+			groupBy, calls, names = op.GroupBy, op.Aggregates, op.Names
+		case *SortAggregate:
+			groupBy, calls, names = op.GroupBy, op.Aggregates, op.Names
+		}
+		for idx, expr := range groupBy {
+			outputs[names[idx].Name] = expr
+		}
+		for idx, call := range calls {
+			arg := Expr(&Star{})
+			if call.Arg != nil {
+				arg = call.Arg
+			}
+			outputs[names[len(groupBy)+idx].Name] = &FunctionCall{Name: call.Func, Args: []Expr{arg}, Distinct: call.Distinct}
+		}
+		for _, input := range planInputs(op) {
+			collect(*input)
+		}
+	}
+	collect(op)
+	return outputs
+}
+
+// shownExpr replaces the references of expr to the columns of aggregations
+// by the expressions they hold.
+func shownExpr(expr Expr, outputs map[string]Expr) Expr {
+	return transformExpr(expr, func(expr Expr) (Expr, bool) {
+		if ident, ok := expr.(*Identifier); ok && ident.Table == "" {
+			if output, ok := outputs[ident.Name]; ok {
+				return output, true
+			}
+		}
+		return nil, false
+	})
+}
+
+// planInputs returns the fields of an operator holding its inputs.
+func planInputs(op Operator) []*Operator {
+	switch op := op.(type) {
+	case *Filter:
+		return []*Operator{&op.Input}
+	case *Project:
+		return []*Operator{&op.Input}
+	case *Sort:
+		return []*Operator{&op.Input}
+	case *Limit:
+		return []*Operator{&op.Input}
+	case *HashAggregate:
+		return []*Operator{&op.Input}
+	case *SortAggregate:
+		return []*Operator{&op.Input}
+	case *NestedLoopJoin:
+		return []*Operator{&op.Left, &op.Right}
+	case *HashJoin:
+		return []*Operator{&op.Left, &op.Right}
+	case *MergeJoin:
+		return []*Operator{&op.Left, &op.Right}
+	case *IndexNestedLoopJoin:
+		return []*Operator{&op.Left}
+	}
+	return nil
+}
+
+// describeOperator names an operator and lists the details of what it does.
+func describeOperator(op Operator, outputs map[string]Expr) (string, []string) {
+
+	details := []string{}
+	detail := func(label string, expr Expr) {
+		if expr != nil {
+			details = append(details, label+": "+shownExpr(expr, outputs).String())
+		}
+	}
+
+	switch op := op.(type) {
+	case *singleRow:
+		return "Result", nil
+
+	case *TableScan:
+		return "Seq Scan on " + tableLabel(op.Table, op.Alias), nil
+
+	case *PrimaryKeyScan:
+		cond := keyCondition(op.Table.PrimaryKeys, KeyRange{Prefix: op.Prefix, Low: op.Low, High: op.High})
+		return "Primary Key Scan on " + tableLabel(op.Table, op.Alias), []string{"Key Cond: " + cond}
+
+	case *IndexScan:
+		cond := keyCondition(op.Index.Columns, KeyRange{Prefix: op.Prefix, Low: op.Low, High: op.High})
+		return fmt.Sprintf("Index Scan using %s on %s", quoteIdentifier(op.Index.Name), tableLabel(op.Table, op.Alias)),
+			[]string{"Index Cond: " + cond}
+
+	case *Filter:
+		detail("Filter", op.Cond)
+		return "Filter", details
+
+	case *Project:
+		exprs := make([]Expr, len(op.Exprs))
+		for idx, expr := range op.Exprs {
+			exprs[idx] = shownExpr(expr, outputs)
+		}
+		return "Project", []string{"Output: " + exprList(exprs)}
+
+	case *Sort:
+		keys := make([]string, len(op.Keys))
+		for idx, key := range op.Keys {
+			keys[idx] = shownExpr(key.Expr, outputs).String()
+			if key.Desc {
+				keys[idx] += " DESC"
+			}
+		}
+		return "Sort", []string{"Sort Key: " + strings.Join(keys, ", ")}
+
+	case *Limit:
+		if op.Count >= 0 {
+			details = append(details, fmt.Sprintf("Count: %d", op.Count))
+		}
+		if op.Offset > 0 {
+			details = append(details, fmt.Sprintf("Offset: %d", op.Offset))
+		}
+		return "Limit", details
+
+	case *HashAggregate:
+		return "HashAggregate", aggregateDetails(op.GroupBy, op.Aggregates)
+
+	case *SortAggregate:
+		return "GroupAggregate", aggregateDetails(op.GroupBy, op.Aggregates)
+
+	case *NestedLoopJoin:
+		detail("Join Filter", op.Cond)
+		if op.Type == InnerJoin {
+			return "Nested Loop", details
+		}
+		return joinLabel("Nested Loop", op.Type), details
+
+	case *HashJoin:
+		details = append(details, "Hash Cond: "+keyPairs(op.LeftKeys, op.RightKeys))
+		detail("Join Filter", op.Cond)
+		return joinLabel("Hash", op.Type), details
+
+	case *MergeJoin:
+		details = append(details, "Merge Cond: "+keyPairs(op.LeftKeys, op.RightKeys))
+		detail("Join Filter", op.Cond)
+		return joinLabel("Merge", op.Type), details
+
+	case *IndexNestedLoopJoin:
+		using := "primary key"
+		columns := op.Table.PrimaryKeys
+		if op.Index != nil {
+			using, columns = quoteIdentifier(op.Index.Name), op.Index.Columns
+		}
+		conds := make([]string, len(op.Keys))
+		for idx, key := range op.Keys {
+			conds[idx] = quoteIdentifier(columns[idx]) + " = " + key.String()
+		}
+		details = append(details, "Index Cond: "+strings.Join(conds, " AND "))
+		detail("Join Filter", op.Cond)
+		return fmt.Sprintf("%s using %s on %s", joinLabel("Index Nested Loop", op.Type), using, tableLabel(op.Table, op.Alias)), details
+	}
+	return fmt.Sprintf("%T", op), nil
+}
+
+// tableLabel names a scanned table, followed by its alias when it has one.
+func tableLabel(table *Table, alias string) string {
+	if alias == "" || alias == table.Name {
+		return quoteIdentifier(table.Name)
+	}
+	return quoteIdentifier(table.Name) + " " + quoteIdentifier(alias)
+}
+
+// joinLabel names a join by its method and type, as in "Hash Left Join".
+func joinLabel(method string, joinType JoinType) string {
+	switch joinType {
+	case LeftJoin:
+		return method + " Left Join"
+	case RightJoin:
+		return method + " Right Join"
+	case FullJoin:
+		return method + " Full Join"
+	}
+	return method + " Join"
+}
+
+// keyCondition writes the condition a KeyRange of the named columns selects.
+func keyCondition(columns []string, keyRange KeyRange) string {
+	conds := []string{}
+	for idx, val := range keyRange.Prefix {
+		conds = append(conds, quoteIdentifier(columns[idx])+" = "+valueLiteral(val))
+	}
+	bound := func(bound *Bound, exclusive, inclusive string) {
+		if bound == nil {
+			return
+		}
+		op := exclusive
+		if bound.Inclusive {
+			op = inclusive
+		}
+		conds = append(conds, quoteIdentifier(columns[len(keyRange.Prefix)])+" "+op+" "+valueLiteral(bound.Value))
+	}
+	bound(keyRange.Low, ">", ">=")
+	bound(keyRange.High, "<", "<=")
+	return strings.Join(conds, " AND ")
+}
+
+// valueLiteral writes a value as a literal of SQL.
+func valueLiteral(v Value) string {
+	if v.IsNull {
+		return "NULL"
+	}
+	if isNumeric(v.Type) || v.Type == BoolColumn {
+		return v.String()
+	}
+	return (&StringLiteral{Value: v.String()}).String()
+}
+
+func exprList(exprs []Expr) string {
+	texts := make([]string, len(exprs))
+	for idx, expr := range exprs {
+		texts[idx] = expr.String()
+	}
+	return strings.Join(texts, ", ")
+}
+
+func keyPairs(leftKeys, rightKeys []Expr) string {
+	pairs := make([]string, len(leftKeys))
+	for idx := range leftKeys {
+		pairs[idx] = leftKeys[idx].String() + " = " + rightKeys[idx].String()
+	}
+	return strings.Join(pairs, " AND ")
+}
+
+func aggregateDetails(groupBy []Expr, calls []AggregateCall) []string {
+	details := []string{}
+	if len(groupBy) > 0 {
+		details = append(details, "Group Key: "+exprList(groupBy))
+	}
+	texts := make([]string, len(calls))
+	for idx, call := range calls {
+		arg := "*"
+		if call.Arg != nil {
+			arg = call.Arg.String()
+		}
+		if call.Distinct {
+			arg = "DISTINCT " + arg
+		}
+		texts[idx] = call.Func + "(" + arg + ")"
+	}
+	if len(texts) > 0 {
+		details = append(details, "Aggregates: "+strings.Join(texts, ", "))
+	}
+	return details
+}
+
+// analyzedOperator wraps an operator of a plan run by EXPLAIN ANALYZE,
+// counting the rows it returns and the times it is opened, and adding up the
+// time its calls take and the pages the transaction fetches during them.
+type analyzedOperator struct {
+	Operator
+	tx *Transaction
+
+	rows  int
+	loops int
+	time  time.Duration
+	pages int
+}
+
+// instrument wraps every operator of a plan in an analyzedOperator.
+func instrument(tx *Transaction, op Operator) Operator {
+	for _, input := range planInputs(op) {
+		*input = instrument(tx, *input)
+	}
+	return &analyzedOperator{Operator: op, tx: tx}
+}
+
+// measure starts measuring a call, returning the function that ends it.
+func (a *analyzedOperator) measure() func() {
+	start, pages := time.Now(), a.tx.pagesFetched
+	return func() {
+		a.time += time.Since(start)
+		a.pages += a.tx.pagesFetched - pages
+	}
+}
+
+func (a *analyzedOperator) Open() error {
+	defer a.measure()()
+	a.loops++
+	return a.Operator.Open()
+}
+
+func (a *analyzedOperator) Next() (Row, error) {
+	defer a.measure()()
+	row, err := a.Operator.Next()
+	if row != nil {
+		a.rows++
+	}
+	return row, err
+}
+
+func (a *analyzedOperator) Close() error {
+	defer a.measure()()
+	return a.Operator.Close()
+}
+
+// valuesScan returns rows held in memory.
+type valuesScan struct {
+	columns []ResultColumn
+	rows    []Row
+	pos     int
+}
+
+func (s *valuesScan) Open() error {
+	s.pos = 0
+	return nil
+}
+
+func (s *valuesScan) Next() (Row, error) {
+	if s.pos >= len(s.rows) {
+		return nil, nil
+	}
+	s.pos++
+	return s.rows[s.pos-1], nil
+}
+
+func (s *valuesScan) Close() error {
+	return nil
+}
+
+func (s *valuesScan) Columns() []ResultColumn {
+	return s.columns
+}
@@ -0,0 +1,174 @@
+package gopherql
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// explainLines returns the lines of the plan EXPLAIN shows for sql, with the
+// estimates and measurements of each operator left out.
+func explainLines(t *testing.T, db *DB, sql string) ([]string, [][]string) {
+	t.Helper()
+
+	measures := regexp.MustCompile(`  \(rows=.*$`)
+	lines, found := []string{}, [][]string{}
+	for _, row := range queryRows(t, db, sql) {
+		lines = append(lines, measures.ReplaceAllString(row[0], ""))
+		found = append(found, explainMeasures.FindStringSubmatch(row[0]))
+	}
+	return lines, found
+}
+
+var explainMeasures = regexp.MustCompile(`\(rows=(\d+) cost=[0-9.]+\)(?: \(actual rows=(\d+) loops=(\d+) time=[0-9.]+ ms pages=(\d+)\))?$`)
+
+func TestExplain(t *testing.T) {
+	dbFile := "explainTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	db := openPlannerDB(t, dbFile)
+	defer db.Close()
+
+	lines, measures := explainLines(t, db, "EXPLAIN SELECT id, note FROM items WHERE price < 5 ORDER BY id DESC LIMIT 2")
+	expected := []string{
+		"Project",
+		"  Output: ID, NOTE",
+		"  ->  Limit",
+		"        Count: 2",
+		"        ->  Sort",
+		"              Sort Key: ID DESC",
+		"              ->  Filter",
+		"                    Filter: (PRICE < 5)",
+		"                    ->  Index Scan using ITEMS_PRICE on ITEMS",
+		"                          Index Cond: PRICE < 5",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected plan:\n%s", strings.Join(lines, "\n"))
+	}
+	if scan := measures[8]; scan == nil || scan[1] != "5" || scan[2] != "" {
+		t.Errorf("unexpected estimate of the index scan: %v", scan)
+	}
+
+	// Aggregation results show as the expressions they hold.
+	lines, _ = explainLines(t, db, "EXPLAIN SELECT category, COUNT(*) AS n, SUM(price) FROM items GROUP BY category HAVING COUNT(*) > 1 ORDER BY n DESC")
+	expected = []string{
+		"Project",
+		"  Output: CATEGORY, COUNT(*), SUM(PRICE)",
+		"  ->  Sort",
+		"        Sort Key: COUNT(*) DESC",
+		"        ->  Filter",
+		"              Filter: (COUNT(*) > 1)",
+		"              ->  HashAggregate",
+		"                    Group Key: CATEGORY",
+		"                    Aggregates: COUNT(*), SUM(PRICE)",
+		"                    ->  Seq Scan on ITEMS",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected plan:\n%s", strings.Join(lines, "\n"))
+	}
+
+	// A projection returning its input unchanged is left out.
+	lines, _ = explainLines(t, db, "EXPLAIN SELECT * FROM categories a JOIN categories b ON a.id < b.id")
+	expected = []string{
+		"Nested Loop",
+		"  Join Filter: (A.ID < B.ID)",
+		"  ->  Seq Scan on CATEGORIES A",
+		"  ->  Seq Scan on CATEGORIES B",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected plan:\n%s", strings.Join(lines, "\n"))
+	}
+
+	// A constant that changes when converted to the type of the key cannot
+	// look it up, while one that converts exactly can.
+	for _, test := range []struct {
+		sql      string
+		expected []string
+	}{
+		{"EXPLAIN SELECT * FROM items WHERE id = 7.5", []string{
+			"Filter",
+			"  Filter: (ID = 7.5)",
+			"  ->  Seq Scan on ITEMS",
+		}},
+		{"EXPLAIN SELECT * FROM items WHERE id = 7.0", []string{
+			"Filter",
+			"  Filter: (ID = 7.0)",
+			"  ->  Primary Key Scan on ITEMS",
+			"        Key Cond: ID = 7",
+		}},
+	} {
+		if lines, _ = explainLines(t, db, test.sql); strings.Join(lines, "\n") != strings.Join(test.expected, "\n") {
+			t.Errorf("unexpected plan for %q:\n%s", test.sql, strings.Join(lines, "\n"))
+		}
+	}
+
+	lines, measures = explainLines(t, db, "EXPLAIN ANALYZE SELECT a.id, b.id FROM categories a JOIN categories b ON a.id < b.id")
+	expected = []string{
+		"Project",
+		"  Output: A.ID, B.ID",
+		"  ->  Nested Loop",
+		"        Join Filter: (A.ID < B.ID)",
+		"        ->  Seq Scan on CATEGORIES A",
+		"        ->  Seq Scan on CATEGORIES B",
+		"Execution Time: ",
+	}
+	lines[len(lines)-1] = lines[len(lines)-1][:len("Execution Time: ")]
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected plan:\n%s", strings.Join(lines, "\n"))
+	}
+	for pos, test := range map[int]struct{ rows, loops string }{
+		0: {"190", "1"},
+		2: {"190", "1"},
+		4: {"20", "1"},
+		5: {"400", "20"},
+	} {
+		if measures[pos] == nil || measures[pos][2] != test.rows || measures[pos][3] != test.loops {
+			t.Errorf("unexpected measures of %q: %v", lines[pos], measures[pos])
+		}
+	}
+	if pages, _ := strconv.Atoi(measures[5][4]); pages < 20 {
+		t.Errorf("expected the inner scan to fetch pages every loop, got: %d", pages)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec("EXPLAIN SELECT * FROM items WHERE id = 5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RowsAffected != 4 {
+		t.Errorf("expected 4 lines, got: %d", result.RowsAffected)
+	}
+	rows, err := tx.Query("EXPLAIN SELECT * FROM items WHERE id = 5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows.Columns) != 1 || rows.Columns[0] != "QUERY PLAN" {
+		t.Errorf("unexpected columns: %v", rows.Columns)
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// EXPLAIN does not run the query.
+	if _, err := db.Exec("EXPLAIN SELECT 1 / 0"); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		sql  string
+		code string
+	}{
+		{"EXPLAIN ANALYZE SELECT 1 / 0", "22012"},
+		{"EXPLAIN ANALYZE SELECT missing FROM items", "42703"},
+		{"EXPLAIN SELECT 1 FROM missing", "42P01"},
+		{"EXPLAIN DELETE FROM items", "42601"},
+	} {
+		_, err := db.Exec(test.sql)
+		expectSQLState(t, err, test.code)
+	}
+}
@@ -0,0 +1,507 @@
+package gopherql
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ArgType is the kind of value a function argument accepts.
+type ArgType uint8
+
+const (
+	AnyArg ArgType = iota
+	StringArg
+	NumericArg
+	IntegerArg
+	BoolArg
+	// DateTimeArg accepts dates, times and timestamps.
+	DateTimeArg
+)
+
+func (a ArgType) accepts(t ColumnType) bool {
+	switch a {
+	case StringArg:
+		return isString(t)
+	case NumericArg:
+		return isNumeric(t)
+	case IntegerArg:
+		return isInteger(t)
+	case BoolArg:
+		return t == BoolColumn
+	case DateTimeArg:
+		return t == DateColumn || t == TimeColumn || t == TimestampColumn || t == TimestampTzColumn
+	}
+	return true
+}
+
+// Function is a scalar SQL function implemented in Go. Its arguments are
+// checked against Args before Call sees them, and a call that does not fit
+// fails with SQLSTATE 42883, as for a function that does not exist.
+type Function struct {
+	Name string
+	Args []ArgType
+	// Optional is the number of trailing arguments that may be left out.
+	Optional int
+	// Variadic lets the last argument repeat any number of times.
+	Variadic bool
+	// CalledOnNull passes NULL arguments to Call. Otherwise any NULL argument
+	// makes the result NULL without calling it.
+	CalledOnNull bool
+	Call         func(args []Value) (Value, error)
+}
+
+func (f *Function) accepts(args []Value) bool {
+
+	if len(args) < len(f.Args)-f.Optional || (len(args) > len(f.Args) && !f.Variadic) {
+		return false
+	}
+	for idx, arg := range args {
+		kind := f.Args[len(f.Args)-1]
+		if idx < len(f.Args) {
+			kind = f.Args[idx]
+		}
+		if arg.Type != unknownType && !kind.accepts(arg.Type) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *Function) call(args []Value) (Value, error) {
+
+	if !f.accepts(args) {
+		return Value{}, f.undefined(args)
+	}
+
+	if !f.CalledOnNull {
+		for _, arg := range args {
+			if arg.IsNull {
+				return NewNullValue(unknownType), nil
+			}
+		}
+	}
+	return f.Call(args)
+}
+
+// undefined reports that no function takes arguments of the types of args.
+func (f *Function) undefined(args []Value) error {
+	types := make([]string, len(args))
+	for idx, arg := range args {
+		types[idx] = arg.Type.String()
+		if arg.Type == unknownType {
+			types[idx] = "UNKNOWN"
+		}
+	}
+	return SQLStateError{
+		Code: "42883",
+		Msg:  fmt.Sprintf("function %s(%s) does not exist", f.Name, strings.Join(types, ", ")),
+	}
+}
+
+// RegisterFunction makes a Go function callable from SQL under fn.Name, which
+// is folded to upper case like an unquoted identifier. Built-in functions
+// and aggregates cannot be replaced.
+func (db *DB) RegisterFunction(fn Function) error {
+
+	fn.Name = strings.ToUpper(fn.Name)
+	if fn.Call == nil {
+		return fmt.Errorf("function %s has no implementation", fn.Name)
+	}
+	if fn.Optional > len(fn.Args) || (fn.Variadic && len(fn.Args) == 0) {
+		return fmt.Errorf("function %s has an invalid signature", fn.Name)
+	}
+	if _, ok := builtinFunctions[fn.Name]; ok || aggregateFunctions[fn.Name] || fn.Name == "COALESCE" || fn.Name == "NULLIF" {
+		return SQLStateError{Code: "42723", Msg: fmt.Sprintf("function %s already exists", fn.Name)}
+	}
+
+	if db.transactions.functions == nil {
+		db.transactions.functions = map[string]*Function{}
+	}
+	db.transactions.functions[fn.Name] = &fn
+	return nil
+}
+
+// bindFunctions points the function calls of an expression at the functions
+// registered with the database, and those reading the clock at the start of
+// the transaction. Calls left unbound, as in a CHECK read from the catalog,
+// can only reach the built-in functions, which read the clock when called.
+func (tx *Transaction) bindFunctions(exprs ...Expr) {
+	for _, expr := range exprs {
+		walkExpr(expr, func(expr Expr) {
+			if call, ok := expr.(*FunctionCall); ok {
+				call.fn = tx.manager.functions[call.Name]
+				if _, ok := clockFunctions[call.Name]; ok {
+					call.fn = clockFunction(call.Name, tx.started)
+				}
+			}
+		})
+	}
+}
+
+// checkCalls looks up the function every call of exprs names and checks its
+// arguments against the function's signature, so that a call of a missing
+// function or with arguments of the wrong types fails as the statement is
+// planned rather than when a row first reaches it. columnType tells the
+// types of column references; an argument whose type cannot be told before
+// it is evaluated is taken to fit.
+func (tx *Transaction) checkCalls(columnType func(*Identifier) ColumnType, exprs ...Expr) error {
+	var err error
+	for _, expr := range exprs {
+		walkExpr(expr, func(expr Expr) {
+			call, ok := expr.(*FunctionCall)
+			if !ok || err != nil {
+				return
+			}
+			switch {
+			case call.Name == "COALESCE" && len(call.Args) > 0,
+				call.Name == "NULLIF" && len(call.Args) == 2,
+				aggregateFunctions[call.Name]:
+				return
+			}
+			fn := call.fn
+			if fn == nil {
+				fn = builtinFunctions[call.Name]
+			}
+			if fn == nil {
+				err = SQLStateError{Code: "42883", Msg: fmt.Sprintf("function %s does not exist", call.Name)}
+				return
+			}
+			args := make([]Value, len(call.Args))
+			for idx, arg := range call.Args {
+				args[idx] = NewNullValue(exprType(arg, columnType))
+			}
+			if !fn.accepts(args) {
+				err = fn.undefined(args)
+			}
+		})
+	}
+	return err
+}
+
+// tableColumnType returns the function telling checkCalls the types of the
+// columns of table.
+func tableColumnType(table *Table) func(*Identifier) ColumnType {
+	return func(ident *Identifier) ColumnType {
+		if idx := table.ColumnIndex(ident.Name); idx >= 0 {
+			return table.Columns[idx].Type
+		}
+		return unknownType
+	}
+}
+
+// exprType returns the type of the values of expr, as far as it can be told
+// without evaluating it, and unknownType otherwise.
+func exprType(expr Expr, columnType func(*Identifier) ColumnType) ColumnType {
+
+	switch e := expr.(type) {
+	case *BoolLiteral, *IsNullExpr, *BetweenExpr, *InExpr, *LikeExpr:
+		return BoolColumn
+	case *IntegerLiteral:
+		return Int64Column
+	case *NumberLiteral:
+		if val, err := numberValue(e.Value); err == nil {
+			return val.Type
+		}
+	case *StringLiteral:
+		return StringColumn
+	case *Identifier:
+		if columnType != nil {
+			return columnType(e)
+		}
+	case *CastExpr:
+		return e.Target.Type
+	case *UnaryExpr:
+		if e.Op == "NOT" {
+			return BoolColumn
+		}
+		if t := exprType(e.Operand, columnType); isNumeric(t) {
+			return t
+		}
+	case *BinaryExpr:
+		switch e.Op {
+		case "AND", "OR", "=", "<>", "<", "<=", ">", ">=":
+			return BoolColumn
+		case "||":
+			return StringColumn
+		}
+		left, right := exprType(e.Left, columnType), exprType(e.Right, columnType)
+		if isNumeric(left) && isNumeric(right) {
+			return arithmeticType(left, right)
+		}
+	}
+	return unknownType
+}
+
+// clockFunctions give the value of each function reading the clock at a
+// given time.
+var clockFunctions = map[string]func(time.Time) Value{
+	"CURRENT_DATE":      NewDateValue,
+	"CURRENT_TIMESTAMP": NewTimestampTzValue,
+	"NOW":               NewTimestampTzValue,
+}
+
+// clockFunction returns the clock function name reading the clock at now,
+// or, when now is zero, whenever it is called.
+func clockFunction(name string, now time.Time) *Function {
+	value := clockFunctions[name]
+	return &Function{Name: name, Call: func([]Value) (Value, error) {
+		if now.IsZero() {
+			return value(time.Now()), nil
+		}
+		return value(now), nil
+	}}
+}
+
+// sqlValueFunctions are called without parentheses.
+var sqlValueFunctions = map[string]bool{"CURRENT_DATE": true, "CURRENT_TIMESTAMP": true}
+
+var builtinFunctions = map[string]*Function{}
+
+func init() {
+	for _, fn := range []*Function{
+		{Name: "UPPER", Args: []ArgType{StringArg}, Call: stringFunction(strings.ToUpper)},
+		{Name: "LOWER", Args: []ArgType{StringArg}, Call: stringFunction(strings.ToLower)},
+		{Name: "TRIM", Args: []ArgType{StringArg, StringArg}, Optional: 1, Call: trimFunction(strings.Trim)},
+		{Name: "LTRIM", Args: []ArgType{StringArg, StringArg}, Optional: 1, Call: trimFunction(strings.TrimLeft)},
+		{Name: "RTRIM", Args: []ArgType{StringArg, StringArg}, Optional: 1, Call: trimFunction(strings.TrimRight)},
+		{Name: "SUBSTRING", Args: []ArgType{StringArg, IntegerArg, IntegerArg}, Optional: 1, Call: substring},
+		{Name: "POSITION", Args: []ArgType{StringArg, StringArg}, Call: position},
+		{Name: "CHAR_LENGTH", Args: []ArgType{StringArg}, Call: charLength},
+		{Name: "CHARACTER_LENGTH", Args: []ArgType{StringArg}, Call: charLength},
+		{Name: "LENGTH", Args: []ArgType{StringArg}, Call: charLength},
+		{Name: "CONCAT", Args: []ArgType{AnyArg}, Variadic: true, CalledOnNull: true, Call: concat},
+
+		{Name: "ABS", Args: []ArgType{NumericArg}, Call: abs},
+		{Name: "MOD", Args: []ArgType{NumericArg, NumericArg}, Call: mod},
+		{Name: "ROUND", Args: []ArgType{NumericArg, IntegerArg}, Optional: 1, Call: round},
+		{Name: "FLOOR", Args: []ArgType{NumericArg}, Call: roundingFunction(math.Floor, -1)},
+		{Name: "CEIL", Args: []ArgType{NumericArg}, Call: roundingFunction(math.Ceil, 1)},
+		{Name: "CEILING", Args: []ArgType{NumericArg}, Call: roundingFunction(math.Ceil, 1)},
+		{Name: "POWER", Args: []ArgType{NumericArg, NumericArg}, Call: power},
+		{Name: "SQRT", Args: []ArgType{NumericArg}, Call: squareRoot},
+
+		clockFunction("CURRENT_DATE", time.Time{}),
+		clockFunction("CURRENT_TIMESTAMP", time.Time{}),
+		clockFunction("NOW", time.Time{}),
+		{Name: "EXTRACT", Args: []ArgType{StringArg, DateTimeArg}, Call: extract},
+	} {
+		builtinFunctions[fn.Name] = fn
+	}
+}
+
+func stringFunction(apply func(string) string) func([]Value) (Value, error) {
+	return func(args []Value) (Value, error) {
+		return NewStringValue(apply(stringText(args[0]))), nil
+	}
+}
+
+// trimFunction removes the characters of the second argument, or spaces
+// when there is none, from the ends of the first.
+func trimFunction(trim func(string, string) string) func([]Value) (Value, error) {
+	return func(args []Value) (Value, error) {
+		chars := " "
+		if len(args) > 1 {
+			chars = stringText(args[1])
+		}
+		return NewStringValue(trim(stringText(args[0]), chars)), nil
+	}
+}
+
+// substring returns the characters of a string from a 1-based start, for at
+// most a given count. A start before the string shortens the count.
+func substring(args []Value) (Value, error) {
+
+	chars := []rune(stringText(args[0]))
+	start := args[1].IntValue
+	end := int64(len(chars)) + 1
+	if len(args) > 2 {
+		if args[2].IntValue < 0 {
+			return Value{}, SQLStateError{Code: "22011", Msg: "negative substring length not allowed"}
+		}
+		if start <= end-args[2].IntValue {
+			end = start + args[2].IntValue
+		}
+	}
+	if start < 1 {
+		start = 1
+	}
+	if start >= end {
+		return NewStringValue(""), nil
+	}
+	return NewStringValue(string(chars[start-1 : end-1])), nil
+}
+
+// position returns the 1-based character position of the first argument in
+// the second, or 0 when it does not occur.
+func position(args []Value) (Value, error) {
+	text := stringText(args[1])
+	idx := strings.Index(text, stringText(args[0]))
+	if idx < 0 {
+		return NewInt32Value(0), nil
+	}
+	return NewInt32Value(int32(utf8.RuneCountInString(text[:idx]) + 1)), nil
+}
+
+func charLength(args []Value) (Value, error) {
+	return NewInt32Value(int32(utf8.RuneCountInString(stringText(args[0])))), nil
+}
+
+// concat joins the text of its arguments, skipping NULLs.
+func concat(args []Value) (Value, error) {
+	var builder strings.Builder
+	for _, arg := range args {
+		if !arg.IsNull {
+			builder.WriteString(stringText(arg))
+		}
+	}
+	return NewStringValue(builder.String()), nil
+}
+
+func abs(args []Value) (Value, error) {
+	val := args[0]
+	switch {
+	case val.Type == Float32Column || val.Type == Float64Column:
+		val.FloatValue = math.Abs(val.FloatValue)
+	case val.IntValue == math.MinInt64:
+		return Value{}, numericOutOfRange(val.Type)
+	case val.IntValue < 0:
+		val.IntValue = -val.IntValue
+	}
+	if val.Type == Int16Column || val.Type == Int32Column {
+		return castToInteger(val, val.Type)
+	}
+	return val, nil
+}
+
+func mod(args []Value) (Value, error) {
+	return evalArithmetic("%", args[0], args[1])
+}
+
+// round rounds a number to a number of decimal places, which may be
+// negative, halves away from zero. Exact numbers give a DECIMAL when places
+// are given, and a float rounded to a whole number rounds halves to even.
+func round(args []Value) (Value, error) {
+
+	val := args[0]
+	isFloat := val.Type == Float32Column || val.Type == Float64Column
+	if len(args) == 1 {
+		switch {
+		case isFloat:
+			val.FloatValue = math.RoundToEven(val.FloatValue)
+			return val, nil
+		case val.Type == DecimalColumn:
+			return decimalFromRat(decimalRat(val), &Column{Type: DecimalColumn})
+		}
+		return val, nil
+	}
+
+	places := args[1].IntValue
+	if places > maxDecimalPrecision || places < -maxDecimalPrecision {
+		return Value{}, numericOutOfRange(DecimalColumn)
+	}
+	if isFloat {
+		scale := math.Pow(10, float64(places))
+		return NewFloat64Value(math.Round(val.FloatValue*scale) / scale), nil
+	}
+
+	if places >= 0 {
+		return decimalFromRat(decimalRat(val), &Column{Type: DecimalColumn, Scale: int(places)})
+	}
+	shift := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(-places), nil))
+	rounded, err := decimalFromRat(new(big.Rat).Quo(decimalRat(val), shift), &Column{Type: DecimalColumn})
+	if err != nil {
+		return Value{}, err
+	}
+	return decimalFromRat(new(big.Rat).Mul(decimalRat(rounded), shift), &Column{Type: DecimalColumn})
+}
+
+// roundingFunction gives FLOOR or CEIL: apply rounds floats, and direction,
+// -1 or 1, the way a DECIMAL with a fraction is rounded to a whole number.
+func roundingFunction(apply func(float64) float64, direction int64) func([]Value) (Value, error) {
+	return func(args []Value) (Value, error) {
+		val := args[0]
+		switch {
+		case val.Type == Float32Column || val.Type == Float64Column:
+			val.FloatValue = apply(val.FloatValue)
+		case val.Type == DecimalColumn:
+			scale := int64(math.Pow10(val.Scale))
+			whole := val.IntValue / scale
+			if remainder := val.IntValue % scale; remainder != 0 && (remainder > 0) == (direction > 0) {
+				whole += direction
+			}
+			val = NewDecimalValue(whole, 0)
+		}
+		return val, nil
+	}
+}
+
+func invalidPowerArgument(msg string) SQLStateError {
+	return SQLStateError{Code: "2201F", Msg: msg}
+}
+
+func power(args []Value) (Value, error) {
+	base, exponent := numericFloat(args[0]), numericFloat(args[1])
+	switch {
+	case base == 0 && exponent < 0:
+		return Value{}, invalidPowerArgument("zero raised to a negative power is undefined")
+	case base < 0 && exponent != math.Trunc(exponent):
+		return Value{}, invalidPowerArgument("a negative number raised to a non-integer power yields a complex result")
+	}
+	result := math.Pow(base, exponent)
+	if math.IsInf(result, 0) {
+		return Value{}, numericOutOfRange(Float64Column)
+	}
+	return NewFloat64Value(result), nil
+}
+
+func squareRoot(args []Value) (Value, error) {
+	val := numericFloat(args[0])
+	if val < 0 {
+		return Value{}, SQLStateError{Code: "2201F", Msg: "cannot take square root of a negative number"}
+	}
+	return NewFloat64Value(math.Sqrt(val)), nil
+}
+
+// extract returns a field of a date, time or timestamp as a number.
+func extract(args []Value) (Value, error) {
+
+	field, val := strings.ToUpper(stringText(args[0])), args[1]
+	t := val.Time()
+	hasDate, hasTime := val.Type != TimeColumn, val.Type != DateColumn
+
+	var result float64
+	switch {
+	case field == "YEAR" && hasDate:
+		result = float64(t.Year())
+	case field == "QUARTER" && hasDate:
+		result = float64((t.Month()-1)/3 + 1)
+	case field == "MONTH" && hasDate:
+		result = float64(t.Month())
+	case field == "WEEK" && hasDate:
+		_, week := t.ISOWeek()
+		result = float64(week)
+	case field == "DAY" && hasDate:
+		result = float64(t.Day())
+	case field == "DOW" && hasDate:
+		result = float64(t.Weekday())
+	case field == "DOY" && hasDate:
+		result = float64(t.YearDay())
+	case field == "HOUR" && hasTime:
+		result = float64(t.Hour())
+	case field == "MINUTE" && hasTime:
+		result = float64(t.Minute())
+	case field == "SECOND" && hasTime:
+		result = float64(t.Second()) + float64(t.Nanosecond())/1e9
+	case field == "EPOCH" && val.Type == TimeColumn:
+		result = float64(t.Hour()*3600+t.Minute()*60+t.Second()) + float64(t.Nanosecond())/1e9
+	case field == "EPOCH":
+		result = float64(t.UnixNano()) / 1e9
+	default:
+		return Value{}, SQLStateError{Code: "22023", Msg: fmt.Sprintf("unit %s not supported for type %s", field, val.Type)}
+	}
+	return NewFloat64Value(result), nil
+}
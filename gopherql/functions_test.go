@@ -0,0 +1,233 @@
+package gopherql
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFunctions_BuiltIn(t *testing.T) {
+
+	for _, test := range []struct {
+		sql      string
+		expected string
+	}{
+		{"UPPER('abc') || LOWER('DEF')", "ABCdef"},
+		{"TRIM('  a b  ')", "a b"},
+		{"TRIM(LEADING 'x' FROM 'xxaxx')", "axx"},
+		{"TRIM(TRAILING FROM '  a  ')", "  a"},
+		{"TRIM('xy' FROM 'xyaxy')", "a"},
+		{"SUBSTRING('hello', 2, 3)", "ell"},
+		{"SUBSTRING('hello' FROM 3)", "llo"},
+		{"SUBSTRING('hello' FROM 0 FOR 3)", "he"},
+		{"SUBSTRING('hello' FOR 2)", "he"},
+		{"POSITION('lo' IN 'hello')", "4"},
+		{"POSITION('x' IN 'hello')", "0"},
+		{"CHAR_LENGTH('héllo')", "5"},
+		{"CONCAT('a', NULL, 1, TRUE)", "a1TRUE"},
+		{"UPPER(NULL) IS NULL", "TRUE"},
+		{"ABS(-3) + ABS(-1.5)", "4.5"},
+		{"MOD(7, 3)", "1"},
+		{"ROUND(2.5)", "3"},
+		{"ROUND(CAST(2.5 AS FLOAT8))", "2"},
+		{"ROUND(CAST(2.5 AS DECIMAL(3, 1)))", "3"},
+		{"ROUND(CAST(1234.567 AS DECIMAL(8, 3)), 1)", "1234.6"},
+		{"ROUND(1250, -2)", "1300"},
+		{"FLOOR(-1.5)", "-2"},
+		{"CEIL(CAST(-1.5 AS DECIMAL(3, 1)))", "-1"},
+		{"CEILING(CAST(1.2 AS DECIMAL(3, 1)))", "2"},
+		{"POWER(2, 10)", "1024"},
+		{"SQRT(16)", "4"},
+		{"EXTRACT(YEAR FROM CAST('2021-03-04' AS DATE))", "2021"},
+		{"EXTRACT(DOW FROM CAST('2021-03-04' AS DATE))", "4"},
+		{"EXTRACT(SECOND FROM CAST('2021-03-04 10:20:30.5' AS TIMESTAMP))", "30.5"},
+		{"EXTRACT(EPOCH FROM CAST('1970-01-02 00:00:00' AS TIMESTAMP))", "86400"},
+		{"CURRENT_DATE = CAST(CURRENT_TIMESTAMP AS DATE)", "TRUE"},
+	} {
+		found, err := evalText(t, test.sql)
+		if err != nil {
+			t.Errorf("unexpected error for %q: %s", test.sql, err)
+		} else if found != test.expected {
+			t.Errorf("expected %q to be %s, got: %s", test.sql, test.expected, found)
+		}
+	}
+
+	if year, err := evalText(t, "EXTRACT(YEAR FROM CURRENT_DATE)"); err != nil || year != time.Now().Format("2006") {
+		t.Errorf("unexpected current year: %s %v", year, err)
+	}
+
+	for _, test := range []struct {
+		sql  string
+		code string
+	}{
+		{"MISSING(1)", "42883"},
+		{"UPPER(1)", "42883"},
+		{"SUBSTRING('a')", "42883"},
+		{"ABS(1, 2)", "42883"},
+		{"SUBSTRING('abc', 1, -1)", "22011"},
+		{"SQRT(-1)", "2201F"},
+		{"POWER(0, -1)", "2201F"},
+		{"MOD(1, 0)", "22012"},
+		{"ABS(CAST(-32768 AS SMALLINT))", "22003"},
+		{"EXTRACT(HOUR FROM CAST('2021-03-04' AS DATE))", "22023"},
+	} {
+		_, err := evalText(t, test.sql)
+		expectSQLState(t, err, test.code)
+	}
+
+	for _, sql := range []string{
+		"EXTRACT(MONTH FROM A)",
+		"POSITION('a' IN B)",
+		"CURRENT_TIMESTAMP",
+		"LTRIM(A, 'x')",
+		"SUBSTRING(A, 1, 2)",
+	} {
+		expr, err := ParseExpr(sql)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", sql, err)
+		} else if expr.String() != sql {
+			t.Errorf("expected %q to round trip, got: %s", sql, expr)
+		}
+	}
+}
+
+func TestFunctions_Register(t *testing.T) {
+	dbFile := "functionsTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	db := openExecutorDB(t, dbFile,
+		"CREATE TABLE words (id INTEGER PRIMARY KEY, word TEXT)",
+		"INSERT INTO words VALUES (1, 'level'), (2, 'gopher'), (3, NULL)",
+	)
+	defer db.Close()
+
+	err := db.RegisterFunction(Function{
+		Name: "reverse",
+		Args: []ArgType{StringArg},
+		Call: func(args []Value) (Value, error) {
+			chars := []rune(args[0].StringValue)
+			for i, j := 0, len(chars)-1; i < j; i, j = i+1, j-1 {
+				chars[i], chars[j] = chars[j], chars[i]
+			}
+			return NewStringValue(string(chars)), nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := joinRows(queryRows(t, db, "SELECT id, REVERSE(word) FROM words WHERE reverse(word) = word OR word IS NULL"))
+	if found != "1,level;3,NULL" {
+		t.Errorf("unexpected rows: %s", found)
+	}
+	if _, err := db.Exec("UPDATE words SET word = UPPER(reverse(word)) WHERE id = 2"); err != nil {
+		t.Fatal(err)
+	}
+	if rows := tableRows(t, db, "WORDS"); rows[1][1] != "REHPOG" {
+		t.Errorf("unexpected update: %v", rows)
+	}
+
+	_, err = db.Exec("SELECT reverse(id) FROM words")
+	expectSQLState(t, err, "42883")
+	if err != nil && !strings.Contains(err.Error(), "REVERSE(INTEGER)") {
+		t.Errorf("expected the argument types in the message, got: %s", err)
+	}
+	err = db.RegisterFunction(Function{Name: "upper", Call: func([]Value) (Value, error) { return Value{}, nil }})
+	expectSQLState(t, err, "42723")
+	if err := db.RegisterFunction(Function{Name: "broken"}); err == nil {
+		t.Error("expected a function without an implementation to be rejected")
+	}
+}
+
+func TestFunctions_CheckedWhenPlanned(t *testing.T) {
+	dbFile := "checkCallsTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	db := openExecutorDB(t, dbFile,
+		"CREATE TABLE empty (id INTEGER PRIMARY KEY, name TEXT, price DECIMAL(6, 2))",
+		"CREATE TABLE other (id INTEGER PRIMARY KEY)",
+	)
+	defer db.Close()
+
+	// No row ever reaches the calls, so they must be checked as the
+	// statement is planned.
+	for _, sql := range []string{
+		"SELECT nosuch_fn(id) FROM empty",
+		"SELECT id FROM empty WHERE nosuch_fn(id) = 1",
+		"SELECT id FROM empty ORDER BY nosuch_fn(id)",
+		"SELECT id FROM empty GROUP BY nosuch_fn(id)",
+		"SELECT id FROM empty GROUP BY id HAVING nosuch_fn(MAX(id)) > 1",
+		"SELECT e.id FROM empty e JOIN other o ON nosuch_fn(e.id) = o.id",
+		"SELECT UPPER(id) FROM empty",
+		"SELECT SUBSTRING(name, price) FROM empty",
+		"SELECT SUBSTRING(name, id + 1.5) FROM empty",
+		"SELECT ABS(name || 'x') FROM empty",
+		"SELECT id FROM empty WHERE UPPER(id > 1) = 'TRUE'",
+		"SELECT NULLIF(id) FROM empty",
+		"EXPLAIN SELECT UPPER(id) FROM empty",
+	} {
+		_, err := db.Query(sql)
+		expectSQLState(t, err, "42883")
+	}
+	for _, sql := range []string{
+		"UPDATE empty SET name = nosuch_fn(name)",
+		"UPDATE empty SET name = 'x' WHERE LOWER(id) = 'x'",
+		"DELETE FROM empty WHERE ROUND(name) = 1",
+	} {
+		_, err := db.Exec(sql)
+		expectSQLState(t, err, "42883")
+	}
+
+	// Arguments whose types are known only once evaluated are taken to fit.
+	for _, sql := range []string{
+		"SELECT SUBSTRING(name, id + 1), ROUND(price * 2, 1), UPPER(CAST(id AS TEXT)) FROM empty",
+		"SELECT UPPER(NULL), ABS(-id), LENGTH(COALESCE(name, 'x')), UPPER(LOWER(name)) FROM empty",
+		"SELECT id FROM empty GROUP BY id HAVING ABS(MAX(id)) > 1",
+	} {
+		if rows := queryRows(t, db, sql); len(rows) != 0 {
+			t.Errorf("unexpected rows for %q: %v", sql, rows)
+		}
+	}
+}
+
+func TestFunctions_ClockReadOncePerTransaction(t *testing.T) {
+	dbFile := "functionsClockTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	db := openExecutorDB(t, dbFile,
+		"CREATE TABLE events (id INTEGER PRIMARY KEY, at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP, noted TIMESTAMPTZ)",
+	)
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("INSERT INTO events (id, noted) VALUES (1, NOW())"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err := tx.Exec("INSERT INTO events (id, noted) VALUES (2, NOW()), (3, CURRENT_TIMESTAMP)"); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := tx.Query("SELECT COUNT(*) FROM events WHERE at = noted AND noted = NOW()")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := joinRows(drainRows(t, rows.Next))
+	rows.Close()
+	if found != "3" {
+		t.Errorf("expected every row to hold the start of the transaction, got: %s", found)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if found := joinRows(queryRows(t, db, "SELECT COUNT(*) FROM events WHERE noted < NOW()")); found != "3" {
+		t.Errorf("expected a later transaction to read the clock again, got: %s", found)
+	}
+}
@@ -6,17 +6,21 @@ import (
 )
 
 const (
-	currentVersion = 1
-	defaultPgSize  = 4096
+	currentVersion        = 3
+	defaultPgSize         = 4096
+	headerFixedSize       = 26
+	maxActiveTransactions = (defaultPgSize - headerFixedSize) / uint32Size
 )
 
 type Header struct {
-	Version       uint16
-	SchemaVersion uint32
-	PageSize      uint16
-	RootPage      uint32
-	TransactionID uint32
-	// TODO - Active Transaction IDs
+	Version            uint16
+	SchemaVersion      uint32
+	PageSize           uint16
+	RootPage           uint32
+	TransactionID      uint32
+	FreeListPage       uint32
+	FreePageCount      uint32
+	ActiveTransactions []uint32
 }
 
 func (h *Header) Bytes() []byte {
@@ -27,6 +31,15 @@ func (h *Header) Bytes() []byte {
 	binary.BigEndian.PutUint16(page[6:8], h.PageSize)
 	binary.BigEndian.PutUint32(page[8:12], h.RootPage)
 	binary.BigEndian.PutUint32(page[12:16], h.TransactionID)
+	binary.BigEndian.PutUint32(page[16:20], h.FreeListPage)
+	binary.BigEndian.PutUint32(page[20:24], h.FreePageCount)
+	binary.BigEndian.PutUint16(page[24:26], uint16(len(h.ActiveTransactions)))
+
+	offset := headerFixedSize
+	for _, id := range h.ActiveTransactions {
+		binary.BigEndian.PutUint32(page[offset:offset+uint32Size], id)
+		offset += uint32Size
+	}
 
 	return page
 }
@@ -39,16 +52,25 @@ func HeaderFromBytes(contents []byte) *Header {
 	h.PageSize = uint16(bReader.ReadUint16())
 	h.RootPage = uint32(bReader.ReadUint32())
 	h.TransactionID = uint32(bReader.ReadUint32())
+	h.FreeListPage = uint32(bReader.ReadUint32())
+	h.FreePageCount = uint32(bReader.ReadUint32())
+
+	activeCount := bReader.ReadUint16()
+	h.ActiveTransactions = make([]uint32, activeCount)
+	for idx := range h.ActiveTransactions {
+		h.ActiveTransactions[idx] = uint32(bReader.ReadUint32())
+	}
 	return h
 }
 
 func NewHeader() *Header {
 	return &Header{
-		Version:       currentVersion,
-		SchemaVersion: 0,
-		PageSize:      defaultPgSize,
-		RootPage:      0,
-		TransactionID: 2,
+		Version:            currentVersion,
+		SchemaVersion:      0,
+		PageSize:           defaultPgSize,
+		RootPage:           0,
+		TransactionID:      2,
+		ActiveTransactions: []uint32{},
 	}
 }
 
@@ -0,0 +1,345 @@
+package gopherql
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Index entries are stored in the index's own Btree under 'I' followed by the
+// indexed values. Every value is either 0x01 and its key encoding, or a lone
+// 0x02 for NULL, so NULLs sort last. The entry's value is the part of the row
+// key after the table's row prefix, which is also added to the entry key
+// whenever it may share its values with another row.
+const indexKeyPrefix = 'I'
+
+// Index is an index over some of a table's columns, with its entries in the
+// tree rooted at RootPage. Indexes created with CREATE INDEX are stored in
+// the catalog, while those backing a UNIQUE constraint are described by the
+// table itself.
+type Index struct {
+	Schema   string
+	Name     string
+	Table    string
+	Columns  []string
+	Unique   bool
+	RootPage int
+}
+
+func (i *Index) Bytes() []byte {
+
+	bwriter := NewByteWriter()
+
+	bwriter.WriteUint32(len(i.Name))
+	bwriter.WriteString(i.Name)
+	bwriter.WriteUint32(len(i.Table))
+	bwriter.WriteString(i.Table)
+	bwriter.WriteBool(i.Unique)
+	bwriter.WriteUint32(i.RootPage)
+	bwriter.AppendBytes(PrimaryKeys(i.Columns).Bytes())
+
+	return bwriter.Bytes()
+}
+
+func IndexFromBytes(contents []byte) (*Index, error) {
+
+	i := &Index{}
+
+	reader := NewByteReader(contents)
+	i.Name = reader.ReadString(reader.ReadUint32())
+	i.Table = reader.ReadString(reader.ReadUint32())
+	i.Unique = reader.ReadBool()
+	i.RootPage = reader.ReadUint32()
+	i.Columns = PrimaryKeysFromBytes(contents[reader.Offset:])
+
+	return i, nil
+}
+
+// columnIndexes returns the positions of the indexed columns in the table.
+func (i *Index) columnIndexes(table *Table) ([]int, error) {
+
+	indexes := make([]int, len(i.Columns))
+	for idx, name := range i.Columns {
+		indexes[idx] = table.ColumnIndex(name)
+		if indexes[idx] < 0 {
+			return nil, undefinedColumn("", name)
+		}
+	}
+	return indexes, nil
+}
+
+// constraintIndexes returns the indexes backing the table's UNIQUE
+// constraints.
+func (t *Table) constraintIndexes() []*Index {
+
+	indexes := make([]*Index, len(t.Uniques))
+	for idx, unique := range t.Uniques {
+		indexes[idx] = &Index{
+			Schema:   schemaOrDefault(t.Schema),
+			Name:     unique.Name,
+			Table:    t.Name,
+			Columns:  unique.Columns,
+			Unique:   true,
+			RootPage: unique.RootPage,
+		}
+	}
+	return indexes
+}
+
+// entriesPrefix is the key every entry of an index starts with.
+func entriesPrefix() []byte {
+	return []byte{indexKeyPrefix}
+}
+
+// prefixEnd returns the first key after every key starting with prefix, or
+// nil when there is none.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for idx := len(end) - 1; idx >= 0; idx-- {
+		if end[idx] != 0xFF {
+			end[idx]++
+			return end[:idx+1]
+		}
+	}
+	return nil
+}
+
+func appendIndexValues(key []byte, values Row) (encoded []byte, hasNull bool) {
+	for _, val := range values {
+		if val.IsNull {
+			hasNull = true
+			key = append(key, 2)
+			continue
+		}
+		key = appendKeyValue(append(key, 1), val)
+	}
+	return key, hasNull
+}
+
+// indexEntry returns the key and value of the row's entry in index. Unique is
+// false when the entry takes no part in enforcing uniqueness, as the index is
+// not unique or one of the values is NULL.
+func (t *Table) indexEntry(index *Index, row Row, rowKey []byte) (key []byte, value []byte, unique bool, err error) {
+
+	positions, err := index.columnIndexes(t)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	values := make(Row, len(positions))
+	for idx, pos := range positions {
+		values[idx] = row[pos]
+	}
+
+	key, hasNull := appendIndexValues(entriesPrefix(), values)
+
+	value = rowKey[len(t.rowPrefix()):]
+	unique = index.Unique && !hasNull
+	if !unique {
+		key = append(key, value...)
+	}
+	return key, value, unique, nil
+}
+
+// checkUnique fails when a version of key is visible to the transaction, or
+// when one written by a concurrent transaction could still commit.
+func (v *treeView) checkUnique(key []byte, constraint string) error {
+
+	existing, err := v.Get(key)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return SQLStateError{
+			Code: "23505",
+			Msg:  fmt.Sprintf("duplicate key value violates unique constraint %s", quoteIdentifier(constraint)),
+		}
+	}
+
+	versions, err := v.btree.Versions(key)
+	if err != nil {
+		return err
+	}
+	for _, obj := range versions {
+		if v.tx.Snapshot.Conflicts(obj) {
+			return v.tx.abortOnConflict(SQLStateError{
+				Code: "40001",
+				Msg:  "could not serialize access due to concurrent insert of a duplicate key",
+			})
+		}
+	}
+	return nil
+}
+
+func (tx *Transaction) deleteIndexEntry(index *Index, key []byte) error {
+
+	entries := tx.tree(index.RootPage)
+	obj, err := entries.Get(key)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		return fmt.Errorf("index %s is missing an entry", index.Name)
+	}
+	return entries.Delete(obj)
+}
+
+// updateIndexes replaces the index entries of oldRow by those of newRow. A
+// nil row has no entries, so inserts and deletes go through here as well.
+func (tx *Transaction) updateIndexes(table *Table, oldRow Row, oldKey []byte, newRow Row, newKey []byte) error {
+
+	indexes, err := tx.TableIndexes(table)
+	if err != nil {
+		return err
+	}
+
+	type entry struct {
+		index  *Index
+		key    []byte
+		value  []byte
+		unique bool
+	}
+	removed, added := []entry{}, []entry{}
+
+	for _, index := range indexes {
+		oldEntry, newEntry := entry{index: index}, entry{index: index}
+		if oldRow != nil {
+			if oldEntry.key, oldEntry.value, _, err = table.indexEntry(index, oldRow, oldKey); err != nil {
+				return err
+			}
+		}
+		if newRow != nil {
+			if newEntry.key, newEntry.value, newEntry.unique, err = table.indexEntry(index, newRow, newKey); err != nil {
+				return err
+			}
+		}
+		if oldRow != nil && newRow != nil && bytes.Equal(oldEntry.key, newEntry.key) && bytes.Equal(oldEntry.value, newEntry.value) {
+			continue
+		}
+		if oldRow != nil {
+			removed = append(removed, oldEntry)
+		}
+		if newRow != nil {
+			added = append(added, newEntry)
+		}
+	}
+
+	for _, e := range removed {
+		if err := tx.deleteIndexEntry(e.index, e.key); err != nil {
+			return err
+		}
+	}
+	for _, e := range added {
+		if e.unique {
+			if err := tx.tree(e.index.RootPage).checkUnique(e.key, e.index.Name); err != nil {
+				return err
+			}
+		}
+	}
+	for _, e := range added {
+		if err := tx.tree(e.index.RootPage).Add(e.key, e.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildIndex adds an entry for every existing row of the table.
+func (tx *Transaction) buildIndex(table *Table, index *Index) error {
+
+	it, err := tx.ScanRows(table)
+	if err != nil {
+		return err
+	}
+
+	rows := []storedRow{}
+	for {
+		obj, row, err := it.Next()
+		if err != nil {
+			return err
+		}
+		if obj == nil {
+			break
+		}
+		rows = append(rows, storedRow{obj: obj, row: row})
+	}
+
+	entries := tx.tree(index.RootPage)
+	for _, stored := range rows {
+		key, value, unique, err := table.indexEntry(index, stored.row, stored.obj.Key)
+		if err != nil {
+			return err
+		}
+		if unique {
+			if err := entries.checkUnique(key, index.Name); err != nil {
+				return err
+			}
+		}
+		if err := entries.Add(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IndexIterator walks the rows found through an index, in index order.
+type IndexIterator struct {
+	rows  *treeView
+	table *Table
+	it    *Iterator
+}
+
+// ScanIndex returns the rows whose leading indexed columns equal prefix,
+// which may hold fewer values than the index has columns. A NULL in prefix
+// matches the rows holding NULL there.
+func (tx *Transaction) ScanIndex(table *Table, index *Index, prefix Row) (*IndexIterator, error) {
+	return tx.ScanIndexRange(table, index, KeyRange{Prefix: prefix})
+}
+
+// ScanIndexRange returns the rows whose indexed columns lie in keyRange, in
+// index order. A bounded range never holds NULLs.
+func (tx *Transaction) ScanIndexRange(table *Table, index *Index, keyRange KeyRange) (*IndexIterator, error) {
+
+	columns := len(keyRange.Prefix)
+	if keyRange.Low != nil || keyRange.High != nil {
+		columns++
+	}
+	if columns > len(index.Columns) {
+		return nil, fmt.Errorf("index %s has %d columns, got %d values", index.Name, len(index.Columns), columns)
+	}
+
+	encode := func(key []byte, val Value) []byte {
+		key, _ = appendIndexValues(key, Row{val})
+		return key
+	}
+	start, end := keyRange.keys(entriesPrefix(), encode, []byte{1})
+	it, err := tx.tree(index.RootPage).Scan(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return &IndexIterator{rows: tx.tree(table.RootPage), table: table, it: it}, nil
+}
+
+// Next returns the stored version of the next row along with its decoded
+// values. Both are nil once the index is exhausted.
+func (i *IndexIterator) Next() (*PageObject, Row, error) {
+
+	for {
+		entry, err := i.it.Next()
+		if err != nil || entry == nil {
+			return nil, nil, err
+		}
+
+		obj, err := i.rows.Get(append(i.table.rowPrefix(), entry.Value...))
+		if err != nil {
+			return nil, nil, err
+		}
+		if obj == nil {
+			continue
+		}
+
+		row, err := i.table.DecodeRow(obj.Key, obj.Value)
+		if err != nil {
+			return nil, nil, err
+		}
+		return obj, row, nil
+	}
+}
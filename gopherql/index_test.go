@@ -0,0 +1,181 @@
+package gopherql
+
+import (
+	"strings"
+	"testing"
+)
+
+// indexLookup returns the first column of the rows found through an index.
+func indexLookup(t *testing.T, db *DB, indexName string, prefix Row) []string {
+	t.Helper()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	index, err := tx.Index("", indexName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := tx.Table(index.Schema, index.Table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it, err := tx.ScanIndex(table, index, prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := []string{}
+	for {
+		obj, row, err := it.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if obj == nil {
+			return found
+		}
+		found = append(found, row[0].String())
+	}
+}
+
+func TestIndex_CreateScanDrop(t *testing.T) {
+	dbFile := "indexTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	db, err := Open(dbFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, sql := range []string{
+		"CREATE TABLE people (id INTEGER PRIMARY KEY, city TEXT, age INTEGER)",
+		"INSERT INTO people VALUES (1, 'Oslo', 30), (2, 'Rome', 25), (3, 'Oslo', 25), (4, NULL, 40)",
+		"CREATE INDEX people_city ON people (city, age)",
+		"INSERT INTO people VALUES (5, 'Oslo', 20)",
+	} {
+		if _, err := db.Exec(sql); err != nil {
+			t.Fatalf("unexpected error for %q: %s", sql, err)
+		}
+	}
+
+	// Entries are ordered by the indexed values, then by primary key.
+	if found := strings.Join(indexLookup(t, db, "PEOPLE_CITY", Row{NewStringValue("Oslo")}), ","); found != "5,3,1" {
+		t.Errorf("unexpected rows for Oslo: %s", found)
+	}
+	if found := strings.Join(indexLookup(t, db, "PEOPLE_CITY", Row{NewStringValue("Oslo"), NewInt64Value(25)}), ","); found != "3" {
+		t.Errorf("unexpected rows for Oslo, 25: %s", found)
+	}
+	if found := strings.Join(indexLookup(t, db, "PEOPLE_CITY", Row{NewNullValue(StringColumn)}), ","); found != "4" {
+		t.Errorf("unexpected rows for NULL: %s", found)
+	}
+
+	if _, err := db.Exec("UPDATE people SET city = 'Rome' WHERE id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("DELETE FROM people WHERE id = 5"); err != nil {
+		t.Fatal(err)
+	}
+	if found := strings.Join(indexLookup(t, db, "PEOPLE_CITY", Row{NewStringValue("Oslo")}), ","); found != "3" {
+		t.Errorf("unexpected rows for Oslo after changes: %s", found)
+	}
+	if found := strings.Join(indexLookup(t, db, "PEOPLE_CITY", nil), ","); found != "3,2,1,4" {
+		t.Errorf("unexpected rows in index order: %s", found)
+	}
+
+	_, err = db.Exec("CREATE INDEX people_city ON people (age)")
+	expectSQLState(t, err, "42P07")
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS people_city ON people (age)"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	_, err = db.Exec("CREATE INDEX people_missing ON people (missing)")
+	expectSQLState(t, err, "42703")
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := tx.Index("", "PEOPLE_CITY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec("DROP INDEX people_city"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("DROP INDEX people_city")
+	expectSQLState(t, err, "42704")
+	if _, err := db.Exec("DROP INDEX IF EXISTS people_city"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	free, err := db.btree.Allocator.FreePages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	freed := false
+	for _, page := range free {
+		freed = freed || page == index.RootPage
+	}
+	if !freed {
+		t.Errorf("expected dropping the index to free its pages, free: %v", free)
+	}
+}
+
+func TestIndex_Unique(t *testing.T) {
+	dbFile := "uniqueIndexTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	db, err := Open(dbFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, sql := range []string{
+		"CREATE TABLE codes (id INTEGER PRIMARY KEY, code TEXT)",
+		"INSERT INTO codes VALUES (1, 'a'), (2, 'a'), (3, NULL), (4, NULL)",
+	} {
+		if _, err := db.Exec(sql); err != nil {
+			t.Fatalf("unexpected error for %q: %s", sql, err)
+		}
+	}
+
+	_, err = db.Exec("CREATE UNIQUE INDEX codes_code ON codes (code)")
+	expectSQLState(t, err, "23505")
+
+	if _, err := db.Exec("DELETE FROM codes WHERE id = 2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("CREATE UNIQUE INDEX codes_code ON codes (code)"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("INSERT INTO codes VALUES (5, 'a')")
+	expectSQLState(t, err, "23505")
+	if _, err := db.Exec("INSERT INTO codes VALUES (5, NULL)"); err != nil {
+		t.Errorf("expected NULLs not to clash, got: %s", err)
+	}
+
+	// Dropping the table drops its indexes, so the name is free again.
+	for _, sql := range []string{
+		"DROP TABLE codes",
+		"CREATE TABLE codes (id INTEGER PRIMARY KEY, code TEXT)",
+		"INSERT INTO codes VALUES (1, 'a'), (2, 'a')",
+		"CREATE INDEX codes_code ON codes (code)",
+	} {
+		if _, err := db.Exec(sql); err != nil {
+			t.Fatalf("unexpected error for %q: %s", sql, err)
+		}
+	}
+	if found := strings.Join(indexLookup(t, db, "CODES_CODE", Row{NewStringValue("a")}), ","); found != "1,2" {
+		t.Errorf("unexpected rows: %s", found)
+	}
+}
@@ -0,0 +1,227 @@
+package gopherql
+
+import "bytes"
+
+// Iterator is a cursor over the objects of a Btree in key order, bounded to
+// keys in [start, end). A nil start or end leaves that side unbounded.
+//
+// The cursor sits between two objects: Next returns the object after it and
+// Prev the object before it. Both return nil once the range is exhausted.
+// Iterators created by Scan only return versions visible to their snapshot.
+type Iterator struct {
+	btree    Btree
+	start    []byte
+	end      []byte
+	snapshot *Snapshot
+	path     []int
+	depth    []int
+	objects  []*PageObject
+	index    int
+}
+
+func (bt Btree) Iterator(start, end []byte) (*Iterator, error) {
+	it := &Iterator{
+		btree: bt,
+		start: start,
+		end:   end,
+	}
+	return it, it.Seek(start)
+}
+
+// Seek positions the cursor immediately before the first object with a key
+// greater than or equal to key. A nil key seeks to the start of the tree.
+// A key before the start of the range seeks to the start of the range.
+func (it *Iterator) Seek(key []byte) error {
+
+	if it.start != nil && (key == nil || bytes.Compare(key, it.start) < 0) {
+		key = it.start
+	}
+
+	it.reset()
+	if it.btree.isEmpty() {
+		return nil
+	}
+
+	if key == nil {
+		return it.descend(it.btree.rootPage(), true)
+	}
+
+	path, depthIterator, err := it.btree.SearchPage(key)
+	if err != nil {
+		return err
+	}
+
+	leaf, err := it.btree.Pager.FetchPage(path[len(path)-1])
+	if err != nil {
+		return err
+	}
+
+	it.path = path
+	it.depth = depthIterator
+	it.objects = leaf.Objects()
+	for it.index < len(it.objects) && bytes.Compare(it.objects[it.index].Key, key) < 0 {
+		it.index++
+	}
+	return nil
+}
+
+// SeekEnd positions the cursor after the last object in the range, ready for
+// a backwards scan with Prev.
+func (it *Iterator) SeekEnd() error {
+
+	if it.end != nil {
+		return it.Seek(it.end)
+	}
+
+	it.reset()
+	if it.btree.isEmpty() {
+		return nil
+	}
+	return it.descend(it.btree.rootPage(), false)
+}
+
+func (it *Iterator) Next() (*PageObject, error) {
+	obj, err := it.nextRaw()
+	if err != nil || obj == nil {
+		return obj, err
+	}
+	return it.resolve(obj)
+}
+
+// nextRaw returns a copy of the next object exactly as stored, leaving blob
+// references unresolved.
+func (it *Iterator) nextRaw() (*PageObject, error) {
+
+	for {
+		for it.index >= len(it.objects) {
+			found, err := it.nextLeaf()
+			if err != nil || !found {
+				return nil, err
+			}
+		}
+
+		obj := it.objects[it.index]
+		if it.end != nil && bytes.Compare(obj.Key, it.end) >= 0 {
+			return nil, nil
+		}
+		it.index++
+
+		if it.snapshot == nil || it.snapshot.Visible(obj) {
+			return copyObject(obj), nil
+		}
+	}
+}
+
+func (it *Iterator) Prev() (*PageObject, error) {
+
+	for {
+		for it.index <= 0 {
+			found, err := it.prevLeaf()
+			if err != nil || !found {
+				return nil, err
+			}
+		}
+
+		obj := it.objects[it.index-1]
+		if it.start != nil && bytes.Compare(obj.Key, it.start) < 0 {
+			return nil, nil
+		}
+		it.index--
+
+		if it.snapshot == nil || it.snapshot.Visible(obj) {
+			return it.resolve(copyObject(obj))
+		}
+	}
+}
+
+func (it *Iterator) reset() {
+	it.path = []int{}
+	it.depth = []int{}
+	it.objects = nil
+	it.index = 0
+}
+
+func (it *Iterator) resolve(obj *PageObject) (*PageObject, error) {
+	if obj.IsBlobRef {
+		return it.btree.resolveBlob(obj)
+	}
+	return obj, nil
+}
+
+// copyObject detaches an object from the page it was read from, so later
+// writes to that page cannot change it.
+func copyObject(obj *PageObject) *PageObject {
+	return &PageObject{
+		Key:           append([]byte{}, obj.Key...),
+		Value:         append([]byte{}, obj.Value...),
+		IsBlobRef:     obj.IsBlobRef,
+		TransactionID: obj.TransactionID,
+		DeleteID:      obj.DeleteID,
+	}
+}
+
+// descend walks from pageNumber down to a leaf, always following either the
+// first or last child, and leaves the cursor at that end of the leaf.
+func (it *Iterator) descend(pageNumber int, first bool) error {
+
+	for {
+		page, err := it.btree.Pager.FetchPage(pageNumber)
+		if err != nil {
+			return err
+		}
+		it.path = append(it.path, pageNumber)
+
+		objects := page.Objects()
+		if page.Kind == kindLeaf {
+			it.objects = objects
+			it.index = 0
+			if !first {
+				it.index = len(objects)
+			}
+			return nil
+		}
+
+		idx := 0
+		if !first {
+			idx = len(objects) - 1
+		}
+		it.depth = append(it.depth, idx)
+		pageNumber = pointerPage(objects[idx])
+	}
+}
+
+func (it *Iterator) nextLeaf() (bool, error) {
+	return it.siblingLeaf(1)
+}
+
+func (it *Iterator) prevLeaf() (bool, error) {
+	return it.siblingLeaf(-1)
+}
+
+// siblingLeaf climbs the parent path until an ancestor has a child in the
+// given direction, then descends to the nearest leaf on that side.
+func (it *Iterator) siblingLeaf(direction int) (bool, error) {
+
+	for level := len(it.depth) - 1; level >= 0; level-- {
+		page, err := it.btree.Pager.FetchPage(it.path[level])
+		if err != nil {
+			return false, err
+		}
+
+		objects := page.Objects()
+		next := it.depth[level] + direction
+		if next < 0 || next >= len(objects) {
+			continue
+		}
+
+		it.depth = append(it.depth[:level], next)
+		it.path = it.path[:level+1]
+		return true, it.descend(pointerPage(objects[next]), direction > 0)
+	}
+
+	return false, nil
+}
+
+func pointerPage(obj *PageObject) int {
+	return NewByteReader(obj.Value).ReadUint32()
+}
@@ -0,0 +1,162 @@
+package gopherql
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func iteratorTestTree(t *testing.T, count int) Btree {
+	bt := NewBTree(NewMemoryPager())
+	for _, k := range rand.New(rand.NewSource(2)).Perm(count) {
+		key := []byte(fmt.Sprintf("key-%05d", k))
+		if err := bt.Add(NewPageObject(key, []byte("value"), 2, 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return *bt
+}
+
+func TestIterator_Next(t *testing.T) {
+
+	bt := iteratorTestTree(t, 1500)
+
+	it, err := bt.Iterator(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+	for {
+		obj, err := it.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if obj == nil {
+			break
+		}
+		if expected := fmt.Sprintf("key-%05d", count); string(obj.Key) != expected {
+			t.Fatalf("expected %s, got: %s", expected, obj.Key)
+		}
+		count++
+	}
+
+	if count != 1500 {
+		t.Errorf("expected 1500 objects, got: %d", count)
+	}
+}
+
+func TestIterator_Range(t *testing.T) {
+
+	bt := iteratorTestTree(t, 1500)
+
+	it, err := bt.Iterator([]byte("key-00250"), []byte("key-01000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+	for {
+		obj, err := it.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if obj == nil {
+			break
+		}
+		if expected := fmt.Sprintf("key-%05d", 250+count); string(obj.Key) != expected {
+			t.Fatalf("expected %s, got: %s", expected, obj.Key)
+		}
+		count++
+	}
+	if count != 750 {
+		t.Errorf("expected 750 objects, got: %d", count)
+	}
+
+	if err := it.SeekEnd(); err != nil {
+		t.Fatal(err)
+	}
+
+	for k := 999; k >= 250; k-- {
+		obj, err := it.Prev()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if obj == nil || string(obj.Key) != fmt.Sprintf("key-%05d", k) {
+			t.Fatalf("unexpected object walking backwards at %d: %v", k, obj)
+		}
+	}
+
+	obj, err := it.Prev()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj != nil {
+		t.Errorf("expected range start to stop iteration, got: %s", obj.Key)
+	}
+}
+
+func TestIterator_Seek(t *testing.T) {
+
+	bt := iteratorTestTree(t, 1500)
+
+	it, err := bt.Iterator(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := it.Seek([]byte("key-00700x")); err != nil {
+		t.Fatal(err)
+	}
+
+	next, err := it.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next == nil || string(next.Key) != "key-00701" {
+		t.Errorf("unexpected key after seek: %v", next)
+	}
+
+	if _, err := it.Prev(); err != nil {
+		t.Fatal(err)
+	}
+	prev, err := it.Prev()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prev == nil || string(prev.Key) != "key-00700" {
+		t.Errorf("unexpected key walking back: %v", prev)
+	}
+
+	// Seeking before the start of the range stops at the start.
+	it, err = bt.Iterator([]byte("key-00500"), []byte("key-00600"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, target := range [][]byte{[]byte("key-00100"), nil} {
+		if err := it.Seek(target); err != nil {
+			t.Fatal(err)
+		}
+		next, err := it.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next == nil || string(next.Key) != "key-00500" {
+			t.Errorf("expected seeking to %q to stop at the range start, got: %v", target, next)
+		}
+	}
+}
+
+func TestIterator_Empty(t *testing.T) {
+
+	bt := NewBTree(NewMemoryPager())
+	it, err := bt.Iterator(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := it.Next()
+	if err != nil || obj != nil {
+		t.Errorf("expected empty iteration, got: %v, %v", obj, err)
+	}
+}
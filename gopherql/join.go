@@ -0,0 +1,627 @@
+package gopherql
+
+import "fmt"
+
+// keepsLeft reports whether rows of the left input without a match are
+// returned, padded with NULLs.
+func (t JoinType) keepsLeft() bool {
+	return t == LeftJoin || t == FullJoin
+}
+
+// keepsRight reports whether rows of the right input without a match are
+// returned, padded with NULLs.
+func (t JoinType) keepsRight() bool {
+	return t == RightJoin || t == FullJoin
+}
+
+func joinColumns(left, right Operator) []ResultColumn {
+	return append(append([]ResultColumn{}, left.Columns()...), right.Columns()...)
+}
+
+// joinRow evaluates the join condition against a pair of rows, returning the
+// joined row when it holds.
+func joinRow(cond Expr, columns []ResultColumn, left, right Row) (Row, error) {
+	row := append(append(make(Row, 0, len(left)+len(right)), left...), right...)
+	if cond == nil {
+		return row, nil
+	}
+	result, _, err := EvalCondition(cond, resultScope{columns: columns, row: row})
+	if err != nil || !result {
+		return nil, err
+	}
+	return row, nil
+}
+
+// padLeft returns a row of the right input joined to NULLs in place of the
+// left input, and padRight the reverse.
+func padLeft(left Operator, right Row) Row {
+	return append(nullRow(len(left.Columns())), right...)
+}
+
+func padRight(left Row, right Operator) Row {
+	return append(append(Row{}, left...), nullRow(len(right.Columns()))...)
+}
+
+func nullRow(count int) Row {
+	row := make(Row, count)
+	for idx := range row {
+		row[idx] = NewNullValue(unknownType)
+	}
+	return row
+}
+
+// NestedLoopJoin pairs every row of Left with every row of Right for which
+// Cond is true, or with every row when Cond is nil. Right is opened again
+// for each row of Left, and once more at the end when rows of Right without
+// a match are kept.
+type NestedLoopJoin struct {
+	Left  Operator
+	Right Operator
+	Cond  Expr
+	Type  JoinType
+
+	left        Row
+	leftMatched bool
+	leftDone    bool
+	rightOpen   bool
+	// rightPos is the position of the next row of Right, and matched
+	// records which positions were matched.
+	rightPos int
+	matched  []bool
+}
+
+func (j *NestedLoopJoin) Open() error {
+	j.left, j.leftDone, j.matched = nil, false, nil
+	return j.Left.Open()
+}
+
+func (j *NestedLoopJoin) openRight() error {
+	if err := j.Right.Open(); err != nil {
+		return err
+	}
+	j.rightOpen, j.rightPos = true, 0
+	return nil
+}
+
+func (j *NestedLoopJoin) closeRight() error {
+	j.rightOpen = false
+	return j.Right.Close()
+}
+
+func (j *NestedLoopJoin) Next() (Row, error) {
+
+	columns := j.Columns()
+	for {
+		if j.leftDone {
+			return j.nextUnmatched()
+		}
+
+		if j.left == nil {
+			left, err := j.Left.Next()
+			if err != nil {
+				return nil, err
+			}
+			if left == nil {
+				if !j.Type.keepsRight() {
+					return nil, nil
+				}
+				j.leftDone = true
+				if err := j.openRight(); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if err := j.openRight(); err != nil {
+				return nil, err
+			}
+			j.left, j.leftMatched = left, false
+		}
+
+		right, err := j.Right.Next()
+		if err != nil {
+			return nil, err
+		}
+		if right == nil {
+			left := j.left
+			j.left = nil
+			if err := j.closeRight(); err != nil {
+				return nil, err
+			}
+			if !j.leftMatched && j.Type.keepsLeft() {
+				return padRight(left, j.Right), nil
+			}
+			continue
+		}
+
+		pos := j.rightPos
+		j.rightPos++
+		row, err := joinRow(j.Cond, columns, j.left, right)
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			continue
+		}
+		j.leftMatched = true
+		if j.Type.keepsRight() {
+			for len(j.matched) <= pos {
+				j.matched = append(j.matched, false)
+			}
+			j.matched[pos] = true
+		}
+		return row, nil
+	}
+}
+
+// nextUnmatched returns the rows of Right no row of Left matched.
+func (j *NestedLoopJoin) nextUnmatched() (Row, error) {
+
+	for j.rightOpen {
+		right, err := j.Right.Next()
+		if err != nil {
+			return nil, err
+		}
+		if right == nil {
+			return nil, j.closeRight()
+		}
+		pos := j.rightPos
+		j.rightPos++
+		if pos >= len(j.matched) || !j.matched[pos] {
+			return padLeft(j.Left, right), nil
+		}
+	}
+	return nil, nil
+}
+
+func (j *NestedLoopJoin) Close() error {
+	j.matched = nil
+	if j.rightOpen {
+		if err := j.closeRight(); err != nil {
+			j.Left.Close()
+			return err
+		}
+	}
+	return j.Left.Close()
+}
+
+func (j *NestedLoopJoin) Columns() []ResultColumn {
+	return joinColumns(j.Left, j.Right)
+}
+
+// buildRow is a row of the input a join reads first, with whether a row of
+// the other input matched it.
+type buildRow struct {
+	row     Row
+	matched bool
+}
+
+// HashJoin pairs the rows of Left and Right whose keys are equal and for
+// which Cond, when set, is true. LeftKeys are evaluated against rows of Left
+// and RightKeys against rows of Right. Right is read into a hash table when
+// the join is opened and Left is streamed past it. Keys holding a NULL never
+// match.
+type HashJoin struct {
+	Left      Operator
+	Right     Operator
+	LeftKeys  []Expr
+	RightKeys []Expr
+	Cond      Expr
+	Type      JoinType
+
+	table       map[string][]*buildRow
+	all         []*buildRow
+	left        Row
+	leftMatched bool
+	matches     []*buildRow
+	leftDone    bool
+}
+
+func (j *HashJoin) Open() error {
+
+	if len(j.LeftKeys) != len(j.RightKeys) {
+		return fmt.Errorf("hash join has %d left keys and %d right keys", len(j.LeftKeys), len(j.RightKeys))
+	}
+
+	if err := j.Right.Open(); err != nil {
+		return err
+	}
+	defer j.Right.Close()
+
+	columns := j.Right.Columns()
+	j.table, j.all, j.left, j.matches, j.leftDone = map[string][]*buildRow{}, nil, nil, nil, false
+	for {
+		row, err := j.Right.Next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+		keys, err := evalAll(j.RightKeys, resultScope{columns: columns, row: row})
+		if err != nil {
+			return err
+		}
+		build := &buildRow{row: row}
+		if j.Type.keepsRight() {
+			j.all = append(j.all, build)
+		}
+		if key, hasNull := hashKey(keys); !hasNull {
+			j.table[key] = append(j.table[key], build)
+		}
+	}
+
+	return j.Left.Open()
+}
+
+func (j *HashJoin) Next() (Row, error) {
+
+	columns, leftColumns := j.Columns(), j.Left.Columns()
+	for !j.leftDone {
+		for len(j.matches) > 0 {
+			right := j.matches[0]
+			j.matches = j.matches[1:]
+			row, err := joinRow(j.Cond, columns, j.left, right.row)
+			if err != nil {
+				return nil, err
+			}
+			if row != nil {
+				j.leftMatched, right.matched = true, true
+				return row, nil
+			}
+		}
+		if j.left != nil {
+			left := j.left
+			j.left = nil
+			if !j.leftMatched && j.Type.keepsLeft() {
+				return padRight(left, j.Right), nil
+			}
+		}
+
+		left, err := j.Left.Next()
+		if err != nil {
+			return nil, err
+		}
+		if left == nil {
+			j.leftDone = true
+			break
+		}
+		keys, err := evalAll(j.LeftKeys, resultScope{columns: leftColumns, row: left})
+		if err != nil {
+			return nil, err
+		}
+		j.left, j.leftMatched = left, false
+		if key, hasNull := hashKey(keys); !hasNull {
+			j.matches = j.table[key]
+		}
+	}
+
+	for len(j.all) > 0 {
+		right := j.all[0]
+		j.all = j.all[1:]
+		if !right.matched {
+			return padLeft(j.Left, right.row), nil
+		}
+	}
+	return nil, nil
+}
+
+func (j *HashJoin) Close() error {
+	j.table, j.all, j.matches = nil, nil, nil
+	return j.Left.Close()
+}
+
+func (j *HashJoin) Columns() []ResultColumn {
+	return joinColumns(j.Left, j.Right)
+}
+
+// rowSource is a scan of stored rows, through the primary key or an index.
+type rowSource interface {
+	Next() (*PageObject, Row, error)
+}
+
+// IndexNestedLoopJoin looks up the rows of Table matching each row of Left
+// through an index, or the primary key when Index is nil. Keys, evaluated
+// against rows of Left, give the values of the leading indexed columns, and
+// Cond, when set, must hold as well. Only inner and left joins are
+// supported, as the rows of Table are never read in full.
+type IndexNestedLoopJoin struct {
+	Left  Operator
+	Tx    *Transaction
+	Table *Table
+	Alias string
+	Index *Index
+	Keys  []Expr
+	Cond  Expr
+	Type  JoinType
+
+	positions   []int
+	left        Row
+	leftMatched bool
+	rows        rowSource
+}
+
+func (j *IndexNestedLoopJoin) Open() error {
+
+	if j.Type != InnerJoin && j.Type != LeftJoin {
+		return fmt.Errorf("index nested loop join does not support %s", j.Type)
+	}
+
+	var err error
+	if j.Index != nil {
+		j.positions, err = j.Index.columnIndexes(j.Table)
+	} else {
+		j.positions, err = j.Table.primaryKeyIndexes()
+	}
+	if err != nil {
+		return err
+	}
+	if len(j.Keys) == 0 || len(j.Keys) > len(j.positions) {
+		return fmt.Errorf("index nested loop join has %d keys for %d columns", len(j.Keys), len(j.positions))
+	}
+
+	j.left, j.rows = nil, nil
+	return j.Left.Open()
+}
+
+// lookup starts the scan of the rows matching a row of Left. Keys are
+// converted to the types of the indexed columns, as the index is ordered by
+// their encoding. A NULL key matches no row.
+func (j *IndexNestedLoopJoin) lookup(left Row) error {
+
+	keys, err := evalAll(j.Keys, resultScope{columns: j.Left.Columns(), row: left})
+	if err != nil {
+		return err
+	}
+	for idx, key := range keys {
+		if key.IsNull {
+			return nil
+		}
+		if keys[idx], err = castValue(key, j.Table.Columns[j.positions[idx]]); err != nil {
+			return err
+		}
+	}
+
+	if j.Index != nil {
+		j.rows, err = j.Tx.ScanIndex(j.Table, j.Index, keys)
+	} else {
+		j.rows, err = j.Tx.ScanPrimaryKey(j.Table, keys)
+	}
+	return err
+}
+
+func (j *IndexNestedLoopJoin) Next() (Row, error) {
+
+	columns := j.Columns()
+	for {
+		if j.left == nil {
+			left, err := j.Left.Next()
+			if err != nil || left == nil {
+				return nil, err
+			}
+			j.left, j.leftMatched, j.rows = left, false, nil
+			if err := j.lookup(left); err != nil {
+				return nil, err
+			}
+		}
+
+		var right Row
+		if j.rows != nil {
+			var err error
+			if _, right, err = j.rows.Next(); err != nil {
+				return nil, err
+			}
+		}
+		if right == nil {
+			left := j.left
+			j.left, j.rows = nil, nil
+			if !j.leftMatched && j.Type.keepsLeft() {
+				return append(append(Row{}, left...), nullRow(len(j.Table.Columns))...), nil
+			}
+			continue
+		}
+
+		row, err := joinRow(j.Cond, columns, j.left, right)
+		if err != nil {
+			return nil, err
+		}
+		if row != nil {
+			j.leftMatched = true
+			return row, nil
+		}
+	}
+}
+
+func (j *IndexNestedLoopJoin) Close() error {
+	j.left, j.rows = nil, nil
+	return j.Left.Close()
+}
+
+func (j *IndexNestedLoopJoin) Columns() []ResultColumn {
+	return append(append([]ResultColumn{}, j.Left.Columns()...), tableColumns(j.Table, j.Alias)...)
+}
+
+// MergeJoin pairs the rows of Left and Right whose keys are equal and for
+// which Cond, when set, is true. Both inputs must be sorted in ascending
+// order of their keys, with NULLs last as Sort orders them, so the join
+// reads each once, holding only the rows of Right sharing a key. Keys
+// holding a NULL never match.
+type MergeJoin struct {
+	Left      Operator
+	Right     Operator
+	LeftKeys  []Expr
+	RightKeys []Expr
+	Cond      Expr
+	Type      JoinType
+
+	order []SortKey
+	// right is the next row of Right not yet in group, the rows of Right
+	// with the keys of groupKeys.
+	right     Row
+	rightKeys Row
+	group     []*buildRow
+	groupKeys Row
+	leftDone  bool
+	pending   []Row
+}
+
+func (j *MergeJoin) Open() error {
+
+	if len(j.LeftKeys) != len(j.RightKeys) {
+		return fmt.Errorf("merge join has %d left keys and %d right keys", len(j.LeftKeys), len(j.RightKeys))
+	}
+	j.order = make([]SortKey, len(j.LeftKeys))
+	j.group, j.groupKeys, j.leftDone, j.pending = nil, nil, false, nil
+
+	if err := j.Left.Open(); err != nil {
+		return err
+	}
+	if err := j.Right.Open(); err != nil {
+		j.Left.Close()
+		return err
+	}
+	return j.advanceRight()
+}
+
+func (j *MergeJoin) advanceRight() error {
+
+	row, err := j.Right.Next()
+	if err != nil || row == nil {
+		j.right, j.rightKeys = nil, nil
+		return err
+	}
+	keys, err := evalAll(j.RightKeys, resultScope{columns: j.Right.Columns(), row: row})
+	if err != nil {
+		return err
+	}
+	j.right, j.rightKeys = row, keys
+	return nil
+}
+
+// releaseGroup drops the rows of Right sharing a key, keeping those no row
+// of Left matched when the join returns them.
+func (j *MergeJoin) releaseGroup() {
+	for _, right := range j.group {
+		if !right.matched && j.Type.keepsRight() {
+			j.pending = append(j.pending, padLeft(j.Left, right.row))
+		}
+	}
+	j.group, j.groupKeys = nil, nil
+}
+
+// skipRight passes over the rows of Right whose keys sort before keys, or
+// every remaining row when keys is nil.
+func (j *MergeJoin) skipRight(keys Row) error {
+	for j.right != nil {
+		if keys != nil {
+			cmp, err := compareSortKeys(j.order, j.rightKeys, keys)
+			if err != nil || cmp >= 0 {
+				return err
+			}
+		}
+		if j.Type.keepsRight() {
+			j.pending = append(j.pending, padLeft(j.Left, j.right))
+		}
+		if err := j.advanceRight(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// step joins the next row of Left, adding what it produces to pending.
+func (j *MergeJoin) step() error {
+
+	left, err := j.Left.Next()
+	if err != nil {
+		return err
+	}
+	if left == nil {
+		j.leftDone = true
+		j.releaseGroup()
+		return j.skipRight(nil)
+	}
+
+	keys, err := evalAll(j.LeftKeys, resultScope{columns: j.Left.Columns(), row: left})
+	if err != nil {
+		return err
+	}
+	if _, hasNull := hashKey(keys); hasNull {
+		if j.Type.keepsLeft() {
+			j.pending = append(j.pending, padRight(left, j.Right))
+		}
+		return nil
+	}
+
+	same := false
+	if j.groupKeys != nil {
+		cmp, err := compareSortKeys(j.order, j.groupKeys, keys)
+		if err != nil {
+			return err
+		}
+		same = cmp == 0
+	}
+	if !same {
+		j.releaseGroup()
+		if err := j.skipRight(keys); err != nil {
+			return err
+		}
+		for j.right != nil {
+			cmp, err := compareSortKeys(j.order, j.rightKeys, keys)
+			if err != nil {
+				return err
+			}
+			if cmp != 0 {
+				break
+			}
+			j.group = append(j.group, &buildRow{row: j.right})
+			if err := j.advanceRight(); err != nil {
+				return err
+			}
+		}
+		j.groupKeys = keys
+	}
+
+	columns := j.Columns()
+	matched := false
+	for _, right := range j.group {
+		row, err := joinRow(j.Cond, columns, left, right.row)
+		if err != nil {
+			return err
+		}
+		if row != nil {
+			j.pending = append(j.pending, row)
+			matched, right.matched = true, true
+		}
+	}
+	if !matched && j.Type.keepsLeft() {
+		j.pending = append(j.pending, padRight(left, j.Right))
+	}
+	return nil
+}
+
+func (j *MergeJoin) Next() (Row, error) {
+	for len(j.pending) == 0 {
+		if j.leftDone {
+			return nil, nil
+		}
+		if err := j.step(); err != nil {
+			return nil, err
+		}
+	}
+	row := j.pending[0]
+	j.pending = j.pending[1:]
+	return row, nil
+}
+
+func (j *MergeJoin) Close() error {
+	j.group, j.pending, j.right = nil, nil, nil
+	err := j.Right.Close()
+	if leftErr := j.Left.Close(); err == nil {
+		err = leftErr
+	}
+	return err
+}
+
+func (j *MergeJoin) Columns() []ResultColumn {
+	return joinColumns(j.Left, j.Right)
+}
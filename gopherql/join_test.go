@@ -0,0 +1,281 @@
+package gopherql
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func openJoinDB(t *testing.T, dbFile string) *DB {
+	t.Helper()
+	return openExecutorDB(t, dbFile,
+		"CREATE TABLE authors (id INTEGER PRIMARY KEY, name TEXT)",
+		"CREATE TABLE books (id INTEGER PRIMARY KEY, author INTEGER, title TEXT)",
+		"CREATE TABLE awards (author_id INTEGER PRIMARY KEY, prize TEXT)",
+		"CREATE INDEX books_author ON books (author)",
+		"INSERT INTO authors VALUES (1, 'Ann'), (2, 'Bob'), (3, 'Cid')",
+		"INSERT INTO books VALUES (10, 1, 'A1'), (11, 2, 'B1'), (12, 1, 'A2'), (13, NULL, 'X'), (14, 9, 'Y')",
+		"INSERT INTO awards VALUES (1, 'gold'), (3, 'silver'), (5, 'bronze')",
+	)
+}
+
+func TestJoin_Select(t *testing.T) {
+	dbFile := "joinTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	db := openJoinDB(t, dbFile)
+	defer db.Close()
+
+	for _, test := range []struct {
+		sql      string
+		expected string
+	}{
+		{"SELECT a.name, b.title FROM authors a JOIN books b ON b.author = a.id ORDER BY b.id", "Ann,A1;Bob,B1;Ann,A2"},
+		{"SELECT a.name, b.title FROM books b LEFT JOIN authors a ON b.author = a.id ORDER BY b.id", "Ann,A1;Bob,B1;Ann,A2;NULL,X;NULL,Y"},
+		{"SELECT a.name, b.title FROM books AS b RIGHT OUTER JOIN authors AS a ON b.author = a.id ORDER BY a.id, b.id", "Ann,A1;Ann,A2;Bob,B1;Cid,NULL"},
+		{"SELECT a.name, b.title FROM books b FULL JOIN authors a ON b.author = a.id ORDER BY a.id, b.id", "Ann,A1;Ann,A2;Bob,B1;Cid,NULL;NULL,X;NULL,Y"},
+		{"SELECT name, prize FROM authors FULL JOIN awards ON author_id = id", "Ann,gold;Bob,NULL;Cid,silver;NULL,bronze"},
+		{"SELECT name, prize FROM authors LEFT JOIN awards ON author_id = id AND prize <> 'gold'", "Ann,NULL;Bob,NULL;Cid,silver"},
+		{"SELECT name, prize FROM authors LEFT JOIN awards ON author_id = id WHERE prize IS NULL", "Bob,NULL"},
+		{"SELECT COUNT(*) FROM authors CROSS JOIN books", "15"},
+		{"SELECT a.name, title FROM authors a, books WHERE author = a.id AND title LIKE 'A%'", "Ann,A1;Ann,A2"},
+		{"SELECT a.name, COUNT(b.id) FROM authors a LEFT JOIN books b ON a.id = b.author GROUP BY a.name ORDER BY 1", "Ann,2;Bob,1;Cid,0"},
+		{"SELECT * FROM authors JOIN awards ON id = author_id", "1,Ann,1,gold;3,Cid,3,silver"},
+		{"SELECT b.title, p.prize FROM authors a JOIN (books b JOIN awards p ON p.author_id = b.author) ON a.id = b.author", "A1,gold;A2,gold"},
+		{"SELECT x.name, y.name FROM authors x JOIN authors y ON x.id + 1 = y.id ORDER BY 1", "Ann,Bob;Bob,Cid"},
+	} {
+		if found := joinRows(queryRows(t, db, test.sql)); found != test.expected {
+			t.Errorf("unexpected result for %q: %s", test.sql, found)
+		}
+	}
+
+	for _, test := range []struct {
+		sql  string
+		code string
+	}{
+		{"SELECT id FROM authors JOIN books ON books.author = authors.id", "42702"},
+		{"SELECT name FROM authors JOIN books ON id = author", "42702"},
+		{"SELECT 1 FROM authors JOIN authors ON 1 = 1", "42712"},
+		{"SELECT authors.name FROM authors a", "42703"},
+		{"SELECT 1 FROM authors JOIN missing ON 1 = 1", "42P01"},
+		{"SELECT 1 FROM authors JOIN books ON COUNT(*) > 1", "42803"},
+	} {
+		_, err := db.Exec(test.sql)
+		expectSQLState(t, err, test.code)
+	}
+}
+
+func TestJoin_AmbiguousWithoutRows(t *testing.T) {
+	dbFile := "joinAmbiguousTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	db := openExecutorDB(t, dbFile,
+		"CREATE TABLE a (id INTEGER PRIMARY KEY, name TEXT)",
+		"CREATE TABLE b (id INTEGER PRIMARY KEY, a_id INTEGER)",
+		"CREATE TABLE c (id INTEGER PRIMARY KEY)",
+	)
+	defer db.Close()
+
+	// The inputs are empty, or the WHERE clause keeps no rows, so nothing is
+	// ever evaluated: ambiguous references must be found as the query is
+	// planned.
+	for _, sql := range []string{
+		"SELECT id FROM a JOIN b ON a.id = b.id WHERE FALSE",
+		"SELECT a.id FROM a JOIN b ON a.id = b.id WHERE id > 1",
+		"SELECT a.name FROM a JOIN b ON id = a_id",
+		"SELECT a.name FROM a LEFT JOIN b ON b.a_id = a.id AND id > 1",
+		"SELECT a.name FROM a JOIN (b JOIN c ON c.id = id) ON a.id = a_id WHERE FALSE",
+		"SELECT a.name FROM a JOIN b ON a.id = b.a_id JOIN c ON c.id = id",
+		"EXPLAIN SELECT a.name FROM a JOIN b ON id = a_id",
+	} {
+		_, err := db.Query(sql)
+		expectSQLState(t, err, "42702")
+	}
+
+	if rows := queryRows(t, db, "SELECT a.name FROM a JOIN b ON a.id = a_id WHERE name IS NULL"); len(rows) != 0 {
+		t.Errorf("unexpected rows: %v", rows)
+	}
+}
+
+func TestJoin_Strategies(t *testing.T) {
+	dbFile := "joinStrategyTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	db := openJoinDB(t, dbFile)
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	authors, err := tx.Table("", "AUTHORS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	books, err := tx.Table("", "BOOKS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := tx.Index("", "BOOKS_AUTHOR")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authorID := &Identifier{Table: "AUTHORS", Name: "ID"}
+	bookAuthor := &Identifier{Table: "BOOKS", Name: "AUTHOR"}
+	notX := &BinaryExpr{Op: "<>", Left: &Identifier{Name: "TITLE"}, Right: &StringLiteral{Value: "A2"}}
+
+	// Every strategy gives the same rows for the joins it supports.
+	for _, test := range []struct {
+		joinType JoinType
+		expected string
+	}{
+		{InnerJoin, "1,Ann,10,1,A1;2,Bob,11,2,B1"},
+		{LeftJoin, "1,Ann,10,1,A1;2,Bob,11,2,B1;3,Cid,NULL,NULL,NULL"},
+		{RightJoin, "1,Ann,10,1,A1;2,Bob,11,2,B1;NULL,NULL,12,1,A2;NULL,NULL,13,NULL,X;NULL,NULL,14,9,Y"},
+		{FullJoin, "1,Ann,10,1,A1;2,Bob,11,2,B1;3,Cid,NULL,NULL,NULL;NULL,NULL,12,1,A2;NULL,NULL,13,NULL,X;NULL,NULL,14,9,Y"},
+	} {
+		joins := map[string]Operator{
+			"nested loop": &NestedLoopJoin{
+				Left:  &TableScan{Tx: tx, Table: authors},
+				Right: &TableScan{Tx: tx, Table: books},
+				Cond:  &BinaryExpr{Op: "AND", Left: &BinaryExpr{Op: "=", Left: authorID, Right: bookAuthor}, Right: notX},
+				Type:  test.joinType,
+			},
+			"hash": &HashJoin{
+				Left:     &TableScan{Tx: tx, Table: authors},
+				Right:    &TableScan{Tx: tx, Table: books},
+				LeftKeys: []Expr{authorID}, RightKeys: []Expr{bookAuthor},
+				Cond: notX, Type: test.joinType,
+			},
+			"merge": &MergeJoin{
+				Left:     &TableScan{Tx: tx, Table: authors},
+				Right:    &Sort{Input: &TableScan{Tx: tx, Table: books}, Keys: []SortKey{{Expr: bookAuthor}}},
+				LeftKeys: []Expr{authorID}, RightKeys: []Expr{bookAuthor},
+				Cond: notX, Type: test.joinType,
+			},
+		}
+		if test.joinType == InnerJoin || test.joinType == LeftJoin {
+			joins["index nested loop"] = &IndexNestedLoopJoin{
+				Left: &TableScan{Tx: tx, Table: authors},
+				Tx:   tx, Table: books, Index: index,
+				Keys: []Expr{authorID}, Cond: notX, Type: test.joinType,
+			}
+		}
+
+		for name, join := range joins {
+			if err := join.Open(); err != nil {
+				t.Fatal(err)
+			}
+			rows := drainRows(t, join.Next)
+			if err := join.Close(); err != nil {
+				t.Fatal(err)
+			}
+			sort.Slice(rows, func(i, j int) bool {
+				return strings.Join(rows[i], ",") < strings.Join(rows[j], ",")
+			})
+			if found := joinRows(rows); found != test.expected {
+				t.Errorf("unexpected %s %s: %s", name, test.joinType, found)
+			}
+		}
+	}
+
+	lookup := &IndexNestedLoopJoin{
+		Left: &TableScan{Tx: tx, Table: books},
+		Tx:   tx, Table: authors, Alias: "A",
+		Keys: []Expr{bookAuthor}, Type: LeftJoin,
+	}
+	if found := runOperator(t, lookup); found != "10,1,A1,1,Ann;11,2,B1,2,Bob;12,1,A2,1,Ann;13,NULL,X,NULL,NULL;14,9,Y,NULL,NULL" {
+		t.Errorf("unexpected primary key lookup: %s", found)
+	}
+}
+
+// runOperator opens an operator and returns its rows as strings.
+func runOperator(t *testing.T, op Operator) string {
+	t.Helper()
+	if err := op.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer op.Close()
+	return joinRows(drainRows(t, op.Next))
+}
+
+func TestJoin_KeysOfDifferentTypes(t *testing.T) {
+	dbFile := "joinTypesTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	db := openExecutorDB(t, dbFile,
+		"CREATE TABLE ints (id INTEGER PRIMARY KEY, name TEXT)",
+		"CREATE TABLE prices (id INTEGER PRIMARY KEY, amount DECIMAL(5,1), ratio FLOAT8)",
+		"CREATE TABLE events (id INTEGER PRIMARY KEY, day DATE, label TEXT)",
+		"INSERT INTO ints VALUES (1, 'one'), (2, 'two'), (3, 'three')",
+		"INSERT INTO prices VALUES (10, 1.0, 2.0), (11, 2.5, 3.0), (12, 3.0, 0.5)",
+		"INSERT INTO events VALUES (1, '2024-01-02', '2024-01-02'), (2, '2024-01-03', '2024-01-04')",
+	)
+	defer db.Close()
+
+	for _, test := range []struct {
+		sql      string
+		expected string
+	}{
+		{"SELECT i.name, p.id FROM ints i JOIN prices p ON p.amount = i.id ORDER BY 1", "one,10;three,12"},
+		{"SELECT i.name, p.id FROM ints i LEFT JOIN prices p ON p.amount = i.id ORDER BY 1", "one,10;three,12;two,NULL"},
+		{"SELECT i.name, p.id FROM ints i JOIN prices p ON p.ratio = i.id ORDER BY 1", "three,11;two,10"},
+		{"SELECT i.name, p.id FROM ints i LEFT JOIN prices p ON i.id = p.ratio ORDER BY 1", "one,NULL;three,11;two,10"},
+		{"SELECT a.id, b.id FROM prices a JOIN prices b ON a.amount = b.ratio ORDER BY 1", "12,11"},
+		{"SELECT a.id, b.id FROM events a JOIN events b ON a.day = b.label ORDER BY 1", "1,1"},
+	} {
+		if found := joinRows(queryRows(t, db, test.sql)); found != test.expected {
+			t.Errorf("unexpected result for %q: %s", test.sql, found)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	ints, err := tx.Table("", "INTS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prices, err := tx.Table("", "PRICES")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Numbers are hashed by value, so an integer key meets the DECIMAL and
+	// DOUBLE keys equal to it.
+	for _, test := range []struct {
+		column   string
+		expected string
+	}{
+		{"AMOUNT", "1,one,10,1.0,2;2,two,NULL,NULL,NULL;3,three,12,3.0,0.5"},
+		{"RATIO", "1,one,NULL,NULL,NULL;2,two,10,1.0,2;3,three,11,2.5,3"},
+	} {
+		join := &HashJoin{
+			Left:      &TableScan{Tx: tx, Table: ints},
+			Right:     &TableScan{Tx: tx, Table: prices},
+			LeftKeys:  []Expr{&Identifier{Table: "INTS", Name: "ID"}},
+			RightKeys: []Expr{&Identifier{Table: "PRICES", Name: test.column}},
+			Type:      LeftJoin,
+		}
+		if found := runOperator(t, join); found != test.expected {
+			t.Errorf("unexpected hash join on %s: %s", test.column, found)
+		}
+	}
+
+	if _, err := db.Exec("ANALYZE"); err != nil {
+		t.Fatal(err)
+	}
+	if found := joinRows(queryRows(t, db, "SELECT i.name, p.id FROM ints i LEFT JOIN prices p ON p.amount = i.id ORDER BY 1")); found != "one,10;three,12;two,NULL" {
+		t.Errorf("unexpected result after ANALYZE: %s", found)
+	}
+}
@@ -0,0 +1,272 @@
+package gopherql
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type TokenKind uint8
+
+const (
+	TokenEOF TokenKind = iota
+	TokenKeyword
+	TokenIdentifier
+	TokenInteger
+	TokenNumber
+	TokenString
+	TokenOperator
+)
+
+type Token struct {
+	Kind   TokenKind
+	Value  string
+	Line   int
+	Column int
+}
+
+func (t Token) String() string {
+	if t.Kind == TokenEOF {
+		return "end of input"
+	}
+	return fmt.Sprintf("%q", t.Value)
+}
+
+var keywords = map[string]bool{
+	"ANALYZE": true, "AND": true, "AS": true, "ASC": true, "BETWEEN": true,
+	"BY": true, "CASE": true, "CAST": true, "CHECK": true,
+	"CONSTRAINT": true, "CREATE": true, "CROSS": true, "DEFAULT": true,
+	"DELETE": true, "DESC": true, "DISTINCT": true, "DROP": true,
+	"ELSE": true, "END": true, "ESCAPE": true, "EXISTS": true,
+	"EXPLAIN": true, "FALSE": true, "FROM": true, "FULL": true,
+	"GROUP": true, "HAVING": true, "IF": true, "ILIKE": true, "IN": true,
+	"INDEX": true, "INNER": true, "INSERT": true, "INTO": true, "IS": true,
+	"JOIN": true, "KEY": true, "LEFT": true, "LIKE": true, "LIMIT": true,
+	"NOT": true, "NULL": true, "OFFSET": true, "ON": true, "OR": true,
+	"ORDER": true, "OUTER": true, "PRIMARY": true, "RIGHT": true,
+	"SELECT": true, "SET": true, "TABLE": true, "THEN": true, "TRUE": true,
+	"UNIQUE": true, "UPDATE": true, "VACUUM": true, "VALUES": true,
+	"WHEN": true, "WHERE": true,
+}
+
+// Operators are matched longest first.
+var operators = []string{
+	"<>", "!=", "<=", ">=", "||", "::",
+	"=", "<", ">", "+", "-", "*", "/", "%", "(", ")", ",", ";", ".",
+}
+
+func syntaxError(line, column int, format string, args ...interface{}) SQLStateError {
+	return SQLStateError{
+		Code: "42601",
+		Msg:  fmt.Sprintf("syntax error at line %d, column %d: %s", line, column, fmt.Sprintf(format, args...)),
+	}
+}
+
+// Lexer splits SQL text into tokens. Unquoted identifiers and keywords are
+// folded to upper case, quoted identifiers keep their case.
+type Lexer struct {
+	input  []rune
+	pos    int
+	line   int
+	column int
+}
+
+func NewLexer(sql string) *Lexer {
+	return &Lexer{
+		input:  []rune(sql),
+		line:   1,
+		column: 1,
+	}
+}
+
+func Tokenize(sql string) ([]Token, error) {
+	lexer := NewLexer(sql)
+	tokens := []Token{}
+
+	for {
+		token, err := lexer.Next()
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+		if token.Kind == TokenEOF {
+			return tokens, nil
+		}
+	}
+}
+
+func (l *Lexer) peek(offset int) rune {
+	if l.pos+offset >= len(l.input) {
+		return 0
+	}
+	return l.input[l.pos+offset]
+}
+
+func (l *Lexer) hasPrefix(s string) bool {
+	for idx, r := range []rune(s) {
+		if l.peek(idx) != r {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *Lexer) advance() rune {
+	r := l.input[l.pos]
+	l.pos++
+	if r == '\n' {
+		l.line++
+		l.column = 1
+	} else {
+		l.column++
+	}
+	return r
+}
+
+func (l *Lexer) skipWhitespaceAndComments() error {
+
+	for l.pos < len(l.input) {
+		switch {
+		case unicode.IsSpace(l.peek(0)):
+			l.advance()
+		case l.peek(0) == '-' && l.peek(1) == '-':
+			for l.pos < len(l.input) && l.peek(0) != '\n' {
+				l.advance()
+			}
+		case l.peek(0) == '/' && l.peek(1) == '*':
+			line, column := l.line, l.column
+			l.advance()
+			l.advance()
+			for !(l.peek(0) == '*' && l.peek(1) == '/') {
+				if l.pos >= len(l.input) {
+					return syntaxError(line, column, "unterminated comment")
+				}
+				l.advance()
+			}
+			l.advance()
+			l.advance()
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (l *Lexer) Next() (Token, error) {
+
+	if err := l.skipWhitespaceAndComments(); err != nil {
+		return Token{}, err
+	}
+
+	line, column := l.line, l.column
+	token := func(kind TokenKind, value string) (Token, error) {
+		return Token{Kind: kind, Value: value, Line: line, Column: column}, nil
+	}
+
+	if l.pos >= len(l.input) {
+		return token(TokenEOF, "")
+	}
+
+	r := l.peek(0)
+	switch {
+	case unicode.IsLetter(r) || r == '_':
+		start := l.pos
+		for l.pos < len(l.input) && (unicode.IsLetter(l.peek(0)) || unicode.IsDigit(l.peek(0)) || l.peek(0) == '_') {
+			l.advance()
+		}
+		word := strings.ToUpper(string(l.input[start:l.pos]))
+		if keywords[word] {
+			return token(TokenKeyword, word)
+		}
+		return token(TokenIdentifier, word)
+
+	case unicode.IsDigit(r) || (r == '.' && unicode.IsDigit(l.peek(1))):
+		return l.number(line, column)
+
+	case r == '\'':
+		value, err := l.quoted('\'')
+		if err != nil {
+			return Token{}, err
+		}
+		return token(TokenString, value)
+
+	case r == '"':
+		value, err := l.quoted('"')
+		if err != nil {
+			return Token{}, err
+		}
+		if value == "" {
+			return Token{}, syntaxError(line, column, "zero-length delimited identifier")
+		}
+		return token(TokenIdentifier, value)
+	}
+
+	for _, op := range operators {
+		if l.hasPrefix(op) {
+			for range op {
+				l.advance()
+			}
+			return token(TokenOperator, op)
+		}
+	}
+
+	return Token{}, syntaxError(line, column, "unexpected character %q", r)
+}
+
+func (l *Lexer) number(line, column int) (Token, error) {
+
+	start := l.pos
+	kind := TokenInteger
+
+	for unicode.IsDigit(l.peek(0)) {
+		l.advance()
+	}
+	if l.peek(0) == '.' {
+		kind = TokenNumber
+		l.advance()
+		for unicode.IsDigit(l.peek(0)) {
+			l.advance()
+		}
+	}
+	if l.peek(0) == 'e' || l.peek(0) == 'E' {
+		kind = TokenNumber
+		l.advance()
+		if l.peek(0) == '+' || l.peek(0) == '-' {
+			l.advance()
+		}
+		if !unicode.IsDigit(l.peek(0)) {
+			return Token{}, syntaxError(l.line, l.column, "invalid exponent in number")
+		}
+		for unicode.IsDigit(l.peek(0)) {
+			l.advance()
+		}
+	}
+	if unicode.IsLetter(l.peek(0)) {
+		return Token{}, syntaxError(l.line, l.column, "trailing junk after number")
+	}
+
+	return Token{Kind: kind, Value: string(l.input[start:l.pos]), Line: line, Column: column}, nil
+}
+
+// quoted reads text up to the closing quote, where a doubled quote stands
+// for the quote character itself.
+func (l *Lexer) quoted(quote rune) (string, error) {
+
+	line, column := l.line, l.column
+	l.advance()
+
+	builder := strings.Builder{}
+	for {
+		if l.pos >= len(l.input) {
+			return "", syntaxError(line, column, "unterminated quoted string")
+		}
+		r := l.advance()
+		if r == quote {
+			if l.peek(0) != quote {
+				return builder.String(), nil
+			}
+			l.advance()
+		}
+		builder.WriteRune(r)
+	}
+}
@@ -0,0 +1,47 @@
+//go:build windows
+
+package gopherql
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	lockfileFailImmediately = 0x00000001
+	lockfileExclusiveLock   = 0x00000002
+	errorLockViolation      = syscall.Errno(33)
+)
+
+// The whole file is locked, from offset 0 to the largest offset there is.
+const lockedBytes = 0xFFFFFFFF
+
+func lockFile(file *os.File) error {
+	overlapped := syscall.Overlapped{}
+	r1, _, err := procLockFileEx.Call(file.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0,
+		lockedBytes, lockedBytes, uintptr(unsafe.Pointer(&overlapped)))
+	if r1 != 0 {
+		return nil
+	}
+	if err == errorLockViolation {
+		return errors.New("database is locked by another process")
+	}
+	return err
+}
+
+func unlockFile(file *os.File) error {
+	overlapped := syscall.Overlapped{}
+	r1, _, err := procUnlockFileEx.Call(file.Fd(), 0, lockedBytes, lockedBytes, uintptr(unsafe.Pointer(&overlapped)))
+	if r1 != 0 {
+		return nil
+	}
+	return err
+}
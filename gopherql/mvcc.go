@@ -0,0 +1,131 @@
+package gopherql
+
+import "bytes"
+
+// Snapshot is the view of the database held by a single transaction. Every
+// transaction with a lower ID that was not still active when the snapshot
+// was taken is treated as committed. Rolled back transactions leave nothing
+// behind, so they never need to be distinguished from committed ones.
+type Snapshot struct {
+	TransactionID int
+	Active        map[int]bool
+}
+
+func NewSnapshot(transID int, active []int) Snapshot {
+	s := Snapshot{
+		TransactionID: transID,
+		Active:        make(map[int]bool, len(active)),
+	}
+	for _, id := range active {
+		if id != transID {
+			s.Active[id] = true
+		}
+	}
+	return s
+}
+
+// xmin returns the lowest ID of a transaction whose writes the snapshot may
+// not see: the lowest of those active when it was taken, or its own when
+// none were.
+func (s Snapshot) xmin() int {
+	xmin := s.TransactionID
+	for id := range s.Active {
+		if id < xmin {
+			xmin = id
+		}
+	}
+	return xmin
+}
+
+// Committed reports whether the writes of transID are visible to the
+// snapshot. ID 0 marks system objects which are always visible.
+func (s Snapshot) Committed(transID int) bool {
+	if transID == 0 || transID == s.TransactionID {
+		return true
+	}
+	return transID < s.TransactionID && !s.Active[transID]
+}
+
+// Visible reports whether the version held by obj exists for this snapshot.
+// A version is visible when its creator is committed and it has not been
+// deleted by a transaction that is also committed.
+func (s Snapshot) Visible(obj *PageObject) bool {
+	if !s.Committed(int(obj.TransactionID)) {
+		return false
+	}
+	return obj.DeleteID == 0 || !s.Committed(int(obj.DeleteID))
+}
+
+// Conflicts reports whether obj is a live version written by a transaction
+// the snapshot cannot see, because it was still running when the snapshot was
+// taken or began after it.
+func (s Snapshot) Conflicts(obj *PageObject) bool {
+	return obj.DeleteID == 0 && !s.Committed(int(obj.TransactionID))
+}
+
+func (p *Page) GetVisible(key []byte, snapshot Snapshot) *PageObject {
+
+	for _, obj := range p.Objects() {
+		if bytes.Compare(key, obj.Key) == 0 && snapshot.Visible(obj) {
+			return obj
+		}
+	}
+	return nil
+}
+
+// Lookup returns the version of key visible to snapshot, or nil if no version
+// of the key is visible.
+func (bt Btree) Lookup(key []byte, snapshot Snapshot) (*PageObject, error) {
+
+	if bt.isEmpty() {
+		return nil, nil
+	}
+
+	_, page, err := bt.leafFor(key)
+	if err != nil {
+		return nil, err
+	}
+
+	obj := page.GetVisible(key, snapshot)
+	if obj == nil {
+		return nil, nil
+	}
+	if obj.IsBlobRef {
+		return bt.resolveBlob(obj)
+	}
+	return obj, nil
+}
+
+// Versions returns every version of key, whichever transaction wrote it.
+// Blob references are returned unresolved.
+func (bt Btree) Versions(key []byte) ([]*PageObject, error) {
+
+	if bt.isEmpty() {
+		return nil, nil
+	}
+
+	_, page, err := bt.leafFor(key)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []*PageObject{}
+	for _, obj := range page.Objects() {
+		if bytes.Equal(key, obj.Key) {
+			versions = append(versions, obj)
+		}
+	}
+	return versions, nil
+}
+
+// Scan returns an iterator over [start, end) that skips every version not
+// visible to snapshot.
+func (bt Btree) Scan(start, end []byte, snapshot Snapshot) (*Iterator, error) {
+	it := &Iterator{
+		btree:    bt,
+		start:    start,
+		end:      end,
+		snapshot: &snapshot,
+	}
+	return it, it.Seek(start)
+}
@@ -0,0 +1,84 @@
+package gopherql
+
+import "testing"
+
+func TestSnapshot_Visible(t *testing.T) {
+
+	snapshot := NewSnapshot(10, []int{7, 10})
+
+	cases := []struct {
+		obj     *PageObject
+		visible bool
+	}{
+		{NewPageObject([]byte("a"), nil, 5, 0), true},
+		{NewPageObject([]byte("a"), nil, 7, 0), false},
+		{NewPageObject([]byte("a"), nil, 10, 0), true},
+		{NewPageObject([]byte("a"), nil, 12, 0), false},
+		{NewPageObject([]byte("a"), nil, 5, 7), true},
+		{NewPageObject([]byte("a"), nil, 5, 6), false},
+		{NewPageObject([]byte("a"), nil, 5, 10), false},
+		{NewPageObject([]byte("a"), nil, 5, 11), true},
+		{NewPageObject([]byte("a"), nil, 10, 10), false},
+	}
+
+	for idx, c := range cases {
+		if snapshot.Visible(c.obj) != c.visible {
+			t.Errorf("case %d: expected visible to be %t", idx, c.visible)
+		}
+	}
+}
+
+func TestBtree_LookupAndScan(t *testing.T) {
+
+	bt := NewBTree(NewMemoryPager())
+
+	old := NewPageObject([]byte("row"), []byte("old"), 2, 0)
+	if err := bt.Add(old); err != nil {
+		t.Fatal(err)
+	}
+	if err := bt.Add(NewPageObject([]byte("other"), []byte("other"), 4, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bt.Update(old, NewPageObject([]byte("row"), []byte("new"), 0, 0), 3); err != nil {
+		t.Fatal(err)
+	}
+
+	before := NewSnapshot(5, []int{3, 5})
+	obj, err := bt.Lookup([]byte("row"), before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj == nil || string(obj.Value) != "old" {
+		t.Errorf("expected old version while updater is active, got: %v", obj)
+	}
+
+	after := NewSnapshot(5, []int{5})
+	obj, err = bt.Lookup([]byte("row"), after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj == nil || string(obj.Value) != "new" {
+		t.Errorf("expected new version once updater committed, got: %v", obj)
+	}
+
+	it, err := bt.Scan(nil, nil, NewSnapshot(4, []int{4}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := []string{}
+	for {
+		obj, err := it.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if obj == nil {
+			break
+		}
+		values = append(values, string(obj.Value))
+	}
+
+	if len(values) != 2 || values[0] != "other" || values[1] != "new" {
+		t.Errorf("unexpected scan result: %v", values)
+	}
+}
@@ -12,6 +12,8 @@ const (
 	pageObjectPrefixLength = 15
 	kindLeaf               = 0
 	kindNotLeaf            = 1
+	kindFree               = 2
+	kindSpill              = 3
 )
 
 type PageObject struct {
@@ -167,6 +169,23 @@ func NewPage(kind byte, size int) *Page {
 	}
 }
 
+func pageFromBytes(contents []byte) *Page {
+	nb := NewByteReader(contents)
+	return &Page{
+		Kind: nb.ReadByte(),
+		Used: uint16(nb.ReadUint16()),
+		Data: append([]byte{}, nb.ReadBytes(len(contents)-pageHeaderSize)...),
+	}
+}
+
+func (p *Page) Bytes() []byte {
+	bWriter := NewByteWriter()
+	bWriter.WriteByte(p.Kind)
+	bWriter.WriteUint16(int(p.Used))
+	bWriter.WriteBytes(p.Data)
+	return bWriter.Bytes()
+}
+
 func (p *Page) IsEmpty() bool {
 	return p.Used == pageHeaderSize
 }
@@ -189,9 +208,13 @@ func (p *Page) Update(old PageObject, tid int) {
 
 }
 
+func (p *Page) CanFit(obj *PageObject) bool {
+	return int(p.Used)+obj.Length() <= p.Size()
+}
+
 func (p *Page) Add(obj *PageObject) error {
 
-	if int(p.Used)+obj.Length() > p.Size() {
+	if !p.CanFit(obj) {
 		panic("page cannot fit object")
 	}
 
@@ -206,17 +229,16 @@ func (p *Page) Add(obj *PageObject) error {
 	objects = append(objects, obj)
 	sort.Sort(PageObjects(objects))
 
+	// Objects alias p.Data, so they must be written into a fresh buffer,
+	// otherwise shifting them right overwrites objects not yet copied.
+	data := make([]byte, len(p.Data))
 	offset := 0
 
 	for _, object := range objects {
-		s := object.Bytes()
-
-		for idx := range s {
-			p.Data[offset] = s[idx]
-			offset++
-		}
+		offset += copy(data[offset:], object.Bytes())
 	}
 
+	p.Data = data
 	p.Used += uint16(obj.Length())
 
 	return nil
@@ -2,8 +2,6 @@ package gopherql
 
 import (
 	"errors"
-	"fmt"
-	"io/ioutil"
 	"os"
 )
 
@@ -16,6 +14,7 @@ type Pager interface {
 	TotalPages() int
 	GetRootPage() int
 	SetRootPage(num int) error
+	Flush() error
 }
 
 type MemoryPager struct {
@@ -69,71 +68,91 @@ func (m *MemoryPager) SetRootPage(num int) error {
 	return nil
 }
 
+func (m *MemoryPager) Flush() error {
+	return nil
+}
+
 func NewMemoryPager() *MemoryPager {
 	return &MemoryPager{}
 }
 
+// walCheckpointPages is the number of dirty pages after which a flush also
+// checkpoints the write-ahead log back into the database file.
+const walCheckpointPages = 1024
+
+// FilePager stores page n at offset n*pageSize, the first page of the file
+// holding the Header, so page numbers start at 1. Stored pages go to a
+// write-ahead log and are only written into the file itself at a checkpoint.
 type FilePager struct {
 	pageSize   int
 	file       *os.File
 	totalPages int
 	rootPage   int
+	wal        *writeAheadLog
+	dirty      map[int][]byte
 }
 
+// NewFilePager opens a pager over file, first replaying any committed
+// batches left in its write-ahead log by a crash.
 func NewFilePager(file *os.File, pageSize int, rootPage int) (*FilePager, error) {
 
-	contents, err := ioutil.ReadAll(file)
+	fp := &FilePager{
+		pageSize: defaultPgSize,
+		file:     file,
+		rootPage: rootPage,
+		wal:      newWriteAheadLog(walPath(file.Name()), defaultPgSize),
+		dirty:    map[int][]byte{},
+	}
+
+	info, err := file.Stat()
 	if err != nil {
 		return nil, err
 	}
+	fp.totalPages = int(info.Size()) / fp.pageSize
 
-	totalPages := len(contents) / defaultPgSize
-	return &FilePager{
-		pageSize:   defaultPgSize,
-		file:       file,
-		totalPages: totalPages,
-		rootPage:   rootPage,
-	}, nil
+	committedPages, end, err := fp.wal.replay(func(pageNumber int, data []byte) error {
+		fp.dirty[pageNumber] = data
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Whatever a torn write left after the last commit frame is cut off, or
+	// later commits would be appended after it and never replayed.
+	if err := fp.wal.truncate(end); err != nil {
+		return nil, err
+	}
+	if committedPages >= 0 {
+		fp.totalPages = committedPages
+		if err := fp.Checkpoint(); err != nil {
+			return nil, err
+		}
+	}
+
+	return fp, nil
 }
 
 func (fp *FilePager) FetchPage(num int) (*Page, error) {
 
-	start := int64(defaultPgSize + (defaultPgSize * num))
-	if _, err := fp.file.Seek(start, 0); err != nil {
-		return nil, err
+	if contents, ok := fp.dirty[num]; ok {
+		return pageFromBytes(contents), nil
 	}
-	fmt.Println(start)
-
-	buffer := make([]byte, defaultPgSize)
 
-	if _, err := fp.file.Read(buffer); err != nil {
+	buffer := make([]byte, fp.pageSize)
+	if _, err := fp.file.ReadAt(buffer, int64(fp.pageSize*num)); err != nil {
 		return nil, err
 	}
 
-	nb := NewByteReader(buffer)
-	return &Page{
-		Kind: nb.ReadByte(),
-		Used: uint16(nb.ReadUint16()),
-		Data: nb.ReadBytes(defaultPgSize - pageHeaderSize),
-	}, nil
+	return pageFromBytes(buffer), nil
 }
 
 func (fp *FilePager) StorePage(num int, p *Page) error {
 
-	start := int64(defaultPgSize + (defaultPgSize * num))
-	if _, err := fp.file.Seek(start, 0); err != nil {
-		return err
-	}
+	contents := p.Bytes()
+	fp.dirty[num] = contents
+	fp.wal.append(num, contents)
 
-	bWriter := NewByteWriter()
-	bWriter.WriteByte(p.Kind)
-	bWriter.WriteUint16(int(p.Used))
-	bWriter.WriteBytes(p.Data)
-
-	if _, err := fp.file.Write(bWriter.Bytes()); err != nil {
-		return err
-	}
-	return fp.file.Sync()
+	return nil
 }
 
 func (fp *FilePager) AppendPage(page *Page) (int, error) {
@@ -146,11 +165,14 @@ func (fp *FilePager) AppendPage(page *Page) (int, error) {
 }
 
 func (fp *FilePager) TruncateAll() error {
-	fp.totalPages = 0
+	fp.totalPages = 1
 	return nil
 }
 
 func (fp *FilePager) TruncateLastPage() error {
+	if fp.totalPages <= 1 {
+		return errors.New("page out of idx")
+	}
 	fp.totalPages--
 	return nil
 }
@@ -167,3 +189,89 @@ func (fp *FilePager) SetRootPage(num int) error {
 	fp.rootPage = num
 	return nil
 }
+
+// ReadHeader returns the Header from page 0, including any change not yet
+// checkpointed into the file.
+func (fp *FilePager) ReadHeader() (*Header, error) {
+
+	if contents, ok := fp.dirty[0]; ok {
+		return HeaderFromBytes(contents), nil
+	}
+
+	contents := make([]byte, fp.pageSize)
+	if _, err := fp.file.ReadAt(contents, 0); err != nil {
+		return nil, err
+	}
+	return HeaderFromBytes(contents), nil
+}
+
+// WriteHeader logs the Header as page 0, so it is committed together with
+// the pages it describes.
+func (fp *FilePager) WriteHeader(header *Header) error {
+
+	contents := header.Bytes()
+	fp.dirty[0] = contents
+	fp.wal.append(0, contents)
+
+	return nil
+}
+
+// Flush commits every page stored since the last flush to the write-ahead
+// log with a single sync, checkpointing once enough pages are dirty.
+func (fp *FilePager) Flush() error {
+
+	if err := fp.wal.commit(fp.totalPages); err != nil {
+		return err
+	}
+
+	if len(fp.dirty) >= walCheckpointPages {
+		return fp.Checkpoint()
+	}
+	return nil
+}
+
+// Checkpoint flushes pending pages, writes every dirty page into the database
+// file and empties the write-ahead log.
+func (fp *FilePager) Checkpoint() error {
+
+	if err := fp.wal.commit(fp.totalPages); err != nil {
+		return err
+	}
+
+	for num, contents := range fp.dirty {
+		if num >= fp.totalPages {
+			continue
+		}
+		if _, err := fp.file.WriteAt(contents, int64(fp.pageSize*num)); err != nil {
+			return err
+		}
+	}
+
+	if err := fp.file.Truncate(int64(fp.pageSize * fp.totalPages)); err != nil {
+		return err
+	}
+	if err := fp.file.Sync(); err != nil {
+		return err
+	}
+
+	fp.dirty = map[int][]byte{}
+	return fp.wal.reset()
+}
+
+// Close checkpoints the write-ahead log and removes it. The database file
+// itself is left open for its owner to close.
+func (fp *FilePager) Close() error {
+
+	if err := fp.Checkpoint(); err != nil {
+		return err
+	}
+	if err := fp.wal.close(); err != nil {
+		return err
+	}
+
+	err := os.Remove(fp.wal.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
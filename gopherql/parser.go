@@ -0,0 +1,1458 @@
+package gopherql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Parser is a recursive descent parser over the tokens of a single statement.
+type Parser struct {
+	tokens []Token
+	pos    int
+}
+
+// Parse parses a single SQL statement, optionally terminated by a semicolon.
+func Parse(sql string) (Statement, error) {
+
+	tokens, err := Tokenize(sql)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Parser{tokens: tokens}
+	stmt, err := p.parseStatement()
+	if err != nil {
+		return nil, err
+	}
+
+	p.acceptOperator(";")
+	if p.peek().Kind != TokenEOF {
+		return nil, p.unexpected()
+	}
+	return stmt, nil
+}
+
+// ParseExpr parses a standalone expression, such as a stored DEFAULT.
+func ParseExpr(sql string) (Expr, error) {
+
+	tokens, err := Tokenize(sql)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Parser{tokens: tokens}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek().Kind != TokenEOF {
+		return nil, p.unexpected()
+	}
+	return expr, nil
+}
+
+func (p *Parser) peek() Token {
+	return p.tokens[p.pos]
+}
+
+func (p *Parser) peekAt(offset int) Token {
+	if p.pos+offset >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.pos+offset]
+}
+
+func (p *Parser) next() Token {
+	token := p.tokens[p.pos]
+	if token.Kind != TokenEOF {
+		p.pos++
+	}
+	return token
+}
+
+func (p *Parser) unexpected() error {
+	token := p.peek()
+	return syntaxError(token.Line, token.Column, "unexpected %s", token)
+}
+
+func (p *Parser) expected(what string) error {
+	token := p.peek()
+	return syntaxError(token.Line, token.Column, "expected %s but found %s", what, token)
+}
+
+func (p *Parser) isKeyword(words ...string) bool {
+	token := p.peek()
+	if token.Kind != TokenKeyword {
+		return false
+	}
+	for _, word := range words {
+		if token.Value == word {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Parser) acceptKeyword(word string) bool {
+	if p.isKeyword(word) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *Parser) expectKeyword(words ...string) error {
+	for _, word := range words {
+		if !p.acceptKeyword(word) {
+			return p.expected(word)
+		}
+	}
+	return nil
+}
+
+func (p *Parser) isOperator(op string) bool {
+	token := p.peek()
+	return token.Kind == TokenOperator && token.Value == op
+}
+
+func (p *Parser) acceptOperator(op string) bool {
+	if p.isOperator(op) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *Parser) expectOperator(op string) error {
+	if !p.acceptOperator(op) {
+		return p.expected(strconv.Quote(op))
+	}
+	return nil
+}
+
+func (p *Parser) parseIdentifier() (string, error) {
+	token := p.peek()
+	if token.Kind != TokenIdentifier {
+		return "", p.expected("identifier")
+	}
+	p.next()
+	return token.Value, nil
+}
+
+func (p *Parser) parseIdentifierList() ([]string, error) {
+
+	if err := p.expectOperator("("); err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for {
+		name, err := p.parseIdentifier()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+
+		if !p.acceptOperator(",") {
+			break
+		}
+	}
+
+	return names, p.expectOperator(")")
+}
+
+func (p *Parser) parseTableName() (TableName, error) {
+
+	name, err := p.parseIdentifier()
+	if err != nil {
+		return TableName{}, err
+	}
+
+	if !p.acceptOperator(".") {
+		return TableName{Name: name}, nil
+	}
+
+	table, err := p.parseIdentifier()
+	if err != nil {
+		return TableName{}, err
+	}
+	return TableName{Schema: name, Name: table}, nil
+}
+
+func (p *Parser) parseStatement() (Statement, error) {
+
+	switch {
+	case p.isKeyword("CREATE"):
+		return p.parseCreate()
+	case p.isKeyword("DROP"):
+		return p.parseDrop()
+	case p.isKeyword("INSERT"):
+		return p.parseInsert()
+	case p.isKeyword("SELECT"):
+		return p.parseSelect()
+	case p.isKeyword("UPDATE"):
+		return p.parseUpdate()
+	case p.isKeyword("DELETE"):
+		return p.parseDelete()
+	case p.acceptKeyword("VACUUM"):
+		return &VacuumStmt{}, nil
+	case p.acceptKeyword("ANALYZE"):
+		return p.parseAnalyze()
+	case p.acceptKeyword("EXPLAIN"):
+		return p.parseExplain()
+	}
+
+	return nil, p.expected("statement")
+}
+
+func (p *Parser) parseAnalyze() (Statement, error) {
+
+	stmt := &AnalyzeStmt{}
+	if p.peek().Kind != TokenIdentifier {
+		return stmt, nil
+	}
+	name, err := p.parseTableName()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Table = &name
+	return stmt, nil
+}
+
+func (p *Parser) parseExplain() (Statement, error) {
+
+	stmt := &ExplainStmt{Analyze: p.acceptKeyword("ANALYZE")}
+	query, err := p.parseSelect()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Query = query.(*SelectStmt)
+	return stmt, nil
+}
+
+func (p *Parser) parseCreate() (Statement, error) {
+
+	if err := p.expectKeyword("CREATE"); err != nil {
+		return nil, err
+	}
+	if p.isKeyword("UNIQUE") || p.isKeyword("INDEX") {
+		return p.parseCreateIndex()
+	}
+	if err := p.expectKeyword("TABLE"); err != nil {
+		return nil, err
+	}
+
+	stmt := &CreateTableStmt{}
+	if p.acceptKeyword("IF") {
+		if err := p.expectKeyword("NOT", "EXISTS"); err != nil {
+			return nil, err
+		}
+		stmt.IfNotExists = true
+	}
+
+	name, err := p.parseTableName()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Name = name
+
+	if err := p.expectOperator("("); err != nil {
+		return nil, err
+	}
+
+	for {
+		if p.isKeyword("CONSTRAINT") || p.isKeyword("PRIMARY") || p.isKeyword("UNIQUE") || p.isKeyword("CHECK") {
+			if err := p.parseTableConstraint(stmt); err != nil {
+				return nil, err
+			}
+		} else {
+			column, err := p.parseColumnDef(stmt)
+			if err != nil {
+				return nil, err
+			}
+			stmt.Columns = append(stmt.Columns, column)
+		}
+
+		if !p.acceptOperator(",") {
+			break
+		}
+	}
+
+	if err := p.expectOperator(")"); err != nil {
+		return nil, err
+	}
+
+	// A primary key is declared once, either on a column or for the table.
+	for _, column := range stmt.Columns {
+		if !column.PrimaryKey {
+			continue
+		}
+		if stmt.PrimaryKey != nil {
+			return nil, multiplePrimaryKeys(stmt.Name)
+		}
+		stmt.PrimaryKey = []string{column.Name}
+	}
+
+	return stmt, nil
+}
+
+func multiplePrimaryKeys(table TableName) SQLStateError {
+	return SQLStateError{Code: "42P16", Msg: fmt.Sprintf("multiple primary keys for table %s are not allowed", table)}
+}
+
+// parseConstraintName reads an optional CONSTRAINT name prefix.
+func (p *Parser) parseConstraintName() (string, error) {
+	if !p.acceptKeyword("CONSTRAINT") {
+		return "", nil
+	}
+	return p.parseIdentifier()
+}
+
+func (p *Parser) parseCheck() (Expr, error) {
+
+	if err := p.expectKeyword("CHECK"); err != nil {
+		return nil, err
+	}
+	if err := p.expectOperator("("); err != nil {
+		return nil, err
+	}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	return expr, p.expectOperator(")")
+}
+
+func (p *Parser) parseTableConstraint(stmt *CreateTableStmt) error {
+
+	name, err := p.parseConstraintName()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case p.acceptKeyword("PRIMARY"):
+		if stmt.PrimaryKey != nil {
+			return multiplePrimaryKeys(stmt.Name)
+		}
+		if err := p.expectKeyword("KEY"); err != nil {
+			return err
+		}
+		keys, err := p.parseIdentifierList()
+		if err != nil {
+			return err
+		}
+		stmt.PrimaryKey = keys
+
+	case p.acceptKeyword("UNIQUE"):
+		columns, err := p.parseIdentifierList()
+		if err != nil {
+			return err
+		}
+		stmt.Uniques = append(stmt.Uniques, UniqueDef{Name: name, Columns: columns})
+
+	case p.isKeyword("CHECK"):
+		expr, err := p.parseCheck()
+		if err != nil {
+			return err
+		}
+		stmt.Checks = append(stmt.Checks, CheckDef{Name: name, Expr: expr})
+
+	default:
+		return p.expected("PRIMARY KEY, UNIQUE or CHECK")
+	}
+	return nil
+}
+
+// parseColumnDef reads a column and its constraints. CHECK and UNIQUE
+// constraints are added to the statement, as if declared for the table.
+func (p *Parser) parseColumnDef(stmt *CreateTableStmt) (ColumnDef, error) {
+
+	column := ColumnDef{}
+
+	name, err := p.parseIdentifier()
+	if err != nil {
+		return column, err
+	}
+	column.Name = name
+
+	if err := p.parseColumnType(&column); err != nil {
+		return column, err
+	}
+
+	for {
+		constraintName, err := p.parseConstraintName()
+		if err != nil {
+			return column, err
+		}
+
+		switch {
+		case p.acceptKeyword("NOT"):
+			if err := p.expectKeyword("NULL"); err != nil {
+				return column, err
+			}
+			column.NotNull = true
+		case p.acceptKeyword("NULL"):
+			column.NotNull = false
+		case p.acceptKeyword("PRIMARY"):
+			if err := p.expectKeyword("KEY"); err != nil {
+				return column, err
+			}
+			column.PrimaryKey = true
+			column.NotNull = true
+		case p.acceptKeyword("UNIQUE"):
+			stmt.Uniques = append(stmt.Uniques, UniqueDef{Name: constraintName, Columns: []string{name}})
+		case p.isKeyword("CHECK"):
+			expr, err := p.parseCheck()
+			if err != nil {
+				return column, err
+			}
+			stmt.Checks = append(stmt.Checks, CheckDef{Name: constraintName, Column: name, Expr: expr})
+		case p.acceptKeyword("DEFAULT"):
+			expr, err := p.parseExpr()
+			if err != nil {
+				return column, err
+			}
+			column.Default = expr
+		case constraintName != "":
+			return column, p.expected("constraint")
+		default:
+			return column, nil
+		}
+	}
+}
+
+var columnTypeNames = map[string]ColumnType{
+	"BIGINT":      Int64Column,
+	"BOOL":        BoolColumn,
+	"BOOLEAN":     BoolColumn,
+	"BYTEA":       BytesColumn,
+	"CHAR":        CharColumn,
+	"CHARACTER":   CharColumn,
+	"DATE":        DateColumn,
+	"DEC":         DecimalColumn,
+	"DECIMAL":     DecimalColumn,
+	"DOUBLE":      Float64Column,
+	"FLOAT":       Float64Column,
+	"FLOAT4":      Float32Column,
+	"FLOAT8":      Float64Column,
+	"INT":         Int32Column,
+	"INT2":        Int16Column,
+	"INT4":        Int32Column,
+	"INT8":        Int64Column,
+	"INTEGER":     Int32Column,
+	"NUMERIC":     DecimalColumn,
+	"REAL":        Float32Column,
+	"SMALLINT":    Int16Column,
+	"TEXT":        StringColumn,
+	"TIME":        TimeColumn,
+	"TIMESTAMP":   TimestampColumn,
+	"TIMESTAMPTZ": TimestampTzColumn,
+	"VARCHAR":     StringColumn,
+}
+
+func invalidTypeParameter(format string, args ...interface{}) SQLStateError {
+	return SQLStateError{Code: "22023", Msg: fmt.Sprintf(format, args...)}
+}
+
+// acceptWords consumes a run of non-keyword identifiers, such as the
+// PRECISION of DOUBLE PRECISION, only when all of them are present.
+func (p *Parser) acceptWords(words ...string) bool {
+	for idx, word := range words {
+		token := p.peekAt(idx)
+		if token.Kind != TokenIdentifier || token.Value != word {
+			return false
+		}
+	}
+	for range words {
+		p.next()
+	}
+	return true
+}
+
+// parseTypeParameters reads an optional parenthesised list of up to max
+// integers.
+func (p *Parser) parseTypeParameters(max int) ([]int, error) {
+
+	params := []int{}
+	if !p.acceptOperator("(") {
+		return params, nil
+	}
+	for {
+		token := p.peek()
+		if token.Kind != TokenInteger || len(params) == max {
+			return nil, p.expected("type modifier")
+		}
+		p.next()
+		val, err := strconv.Atoi(token.Value)
+		if err != nil {
+			return nil, syntaxError(token.Line, token.Column, "type modifier out of range")
+		}
+		params = append(params, val)
+		if !p.acceptOperator(",") {
+			break
+		}
+	}
+	if err := p.expectOperator(")"); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+func (p *Parser) parseColumnType(column *ColumnDef) error {
+
+	token := p.peek()
+	columnType, ok := columnTypeNames[token.Value]
+	if token.Kind != TokenIdentifier || !ok {
+		return p.expected("data type")
+	}
+	p.next()
+
+	switch token.Value {
+	case "DOUBLE":
+		if !p.acceptWords("PRECISION") {
+			return p.expected("PRECISION")
+		}
+	case "CHAR", "CHARACTER":
+		if p.acceptWords("VARYING") {
+			columnType = StringColumn
+		}
+	case "TIME", "TIMESTAMP":
+		if p.acceptWords("WITH", "TIME", "ZONE") {
+			if columnType == TimeColumn {
+				return SQLStateError{Code: "0A000", Msg: "TIME WITH TIME ZONE is not supported"}
+			}
+			columnType = TimestampTzColumn
+		} else {
+			p.acceptWords("WITHOUT", "TIME", "ZONE")
+		}
+	}
+	column.Type = columnType
+
+	switch {
+	case columnType == StringColumn && token.Value != "TEXT", columnType == CharColumn:
+		params, err := p.parseTypeParameters(1)
+		if err != nil {
+			return err
+		}
+		if columnType == CharColumn {
+			column.Size = 1
+		}
+		if len(params) == 1 {
+			if params[0] < 1 {
+				return invalidTypeParameter("length for type %s must be at least 1", columnType)
+			}
+			column.Size = params[0]
+		}
+
+	case columnType == DecimalColumn:
+		params, err := p.parseTypeParameters(2)
+		if err != nil {
+			return err
+		}
+		column.Precision = maxDecimalPrecision
+		if len(params) > 0 {
+			column.Precision = params[0]
+		}
+		if len(params) > 1 {
+			column.Scale = params[1]
+		}
+		if column.Precision < 1 || column.Precision > maxDecimalPrecision {
+			return invalidTypeParameter("DECIMAL precision %d must be between 1 and %d", column.Precision, maxDecimalPrecision)
+		}
+		if column.Scale > column.Precision {
+			return invalidTypeParameter("DECIMAL scale %d must be between 0 and precision %d", column.Scale, column.Precision)
+		}
+
+	case token.Value == "FLOAT":
+		params, err := p.parseTypeParameters(1)
+		if err != nil {
+			return err
+		}
+		if len(params) == 1 {
+			switch {
+			case params[0] < 1 || params[0] > 53:
+				return invalidTypeParameter("precision for type FLOAT must be between 1 and 53 bits")
+			case params[0] <= 24:
+				column.Type = Float32Column
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p *Parser) parseCreateIndex() (Statement, error) {
+
+	stmt := &CreateIndexStmt{Unique: p.acceptKeyword("UNIQUE")}
+	if err := p.expectKeyword("INDEX"); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("IF") {
+		if err := p.expectKeyword("NOT", "EXISTS"); err != nil {
+			return nil, err
+		}
+		stmt.IfNotExists = true
+	}
+
+	name, err := p.parseIdentifier()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Name = name
+
+	if err := p.expectKeyword("ON"); err != nil {
+		return nil, err
+	}
+	if stmt.Table, err = p.parseTableName(); err != nil {
+		return nil, err
+	}
+	if stmt.Columns, err = p.parseIdentifierList(); err != nil {
+		return nil, err
+	}
+
+	return stmt, nil
+}
+
+func (p *Parser) parseDrop() (Statement, error) {
+
+	if err := p.expectKeyword("DROP"); err != nil {
+		return nil, err
+	}
+	if p.acceptKeyword("INDEX") {
+		return p.parseDropIndex()
+	}
+	if err := p.expectKeyword("TABLE"); err != nil {
+		return nil, err
+	}
+
+	stmt := &DropTableStmt{}
+	if p.acceptKeyword("IF") {
+		if err := p.expectKeyword("EXISTS"); err != nil {
+			return nil, err
+		}
+		stmt.IfExists = true
+	}
+
+	name, err := p.parseTableName()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Name = name
+
+	return stmt, nil
+}
+
+func (p *Parser) parseInsert() (Statement, error) {
+
+	if err := p.expectKeyword("INSERT", "INTO"); err != nil {
+		return nil, err
+	}
+
+	stmt := &InsertStmt{}
+	name, err := p.parseTableName()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Table = name
+
+	if p.isOperator("(") {
+		columns, err := p.parseIdentifierList()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Columns = columns
+	}
+
+	if err := p.expectKeyword("VALUES"); err != nil {
+		return nil, err
+	}
+
+	for {
+		if err := p.expectOperator("("); err != nil {
+			return nil, err
+		}
+		row, err := p.parseExprList()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectOperator(")"); err != nil {
+			return nil, err
+		}
+		stmt.Rows = append(stmt.Rows, row)
+
+		if !p.acceptOperator(",") {
+			return stmt, nil
+		}
+	}
+}
+
+func (p *Parser) parseSelect() (Statement, error) {
+
+	if err := p.expectKeyword("SELECT"); err != nil {
+		return nil, err
+	}
+
+	stmt := &SelectStmt{}
+	for {
+		column, err := p.parseSelectColumn()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Columns = append(stmt.Columns, column)
+
+		if !p.acceptOperator(",") {
+			break
+		}
+	}
+
+	if p.acceptKeyword("FROM") {
+		from, err := p.parseFrom()
+		if err != nil {
+			return nil, err
+		}
+		stmt.From = from
+	}
+
+	if p.acceptKeyword("WHERE") {
+		where, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Where = where
+	}
+
+	if p.acceptKeyword("GROUP") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		groupBy, err := p.parseExprList()
+		if err != nil {
+			return nil, err
+		}
+		stmt.GroupBy = groupBy
+	}
+
+	if p.acceptKeyword("HAVING") {
+		having, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Having = having
+	}
+
+	if p.acceptKeyword("ORDER") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			expr, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			term := OrderTerm{Expr: expr}
+			if p.acceptKeyword("DESC") {
+				term.Desc = true
+			} else {
+				p.acceptKeyword("ASC")
+			}
+			stmt.OrderBy = append(stmt.OrderBy, term)
+
+			if !p.acceptOperator(",") {
+				break
+			}
+		}
+	}
+
+	if p.acceptKeyword("LIMIT") {
+		limit, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Limit = limit
+	}
+
+	if p.acceptKeyword("OFFSET") {
+		offset, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Offset = offset
+	}
+
+	return stmt, nil
+}
+
+// parseFrom parses the tables of a FROM clause. Tables listed with commas
+// are cross joined, and joins associate to the left.
+func (p *Parser) parseFrom() (FromItem, error) {
+
+	item, err := p.parseFromItem()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		join := &JoinExpr{Left: item}
+		switch {
+		case p.acceptOperator(","):
+			join.Type = CrossJoin
+		case p.acceptKeyword("CROSS"):
+			join.Type = CrossJoin
+			if err := p.expectKeyword("JOIN"); err != nil {
+				return nil, err
+			}
+		case p.isKeyword("JOIN", "INNER", "LEFT", "RIGHT", "FULL"):
+			if join.Type, err = p.parseJoinType(); err != nil {
+				return nil, err
+			}
+		default:
+			return item, nil
+		}
+
+		if join.Right, err = p.parseFromItem(); err != nil {
+			return nil, err
+		}
+		if join.Type != CrossJoin {
+			if err := p.expectKeyword("ON"); err != nil {
+				return nil, err
+			}
+			if join.On, err = p.parseExpr(); err != nil {
+				return nil, err
+			}
+		}
+		item = join
+	}
+}
+
+func (p *Parser) parseJoinType() (JoinType, error) {
+
+	joinType := InnerJoin
+	switch {
+	case p.acceptKeyword("INNER"):
+	case p.acceptKeyword("LEFT"):
+		joinType = LeftJoin
+	case p.acceptKeyword("RIGHT"):
+		joinType = RightJoin
+	case p.acceptKeyword("FULL"):
+		joinType = FullJoin
+	}
+	if joinType != InnerJoin {
+		p.acceptKeyword("OUTER")
+	}
+	return joinType, p.expectKeyword("JOIN")
+}
+
+// parseFromItem parses a table, with an optional alias, or a parenthesized
+// join.
+func (p *Parser) parseFromItem() (FromItem, error) {
+
+	if p.acceptOperator("(") {
+		item, err := p.parseFrom()
+		if err != nil {
+			return nil, err
+		}
+		return item, p.expectOperator(")")
+	}
+
+	name, err := p.parseTableName()
+	if err != nil {
+		return nil, err
+	}
+	ref := &TableRef{Table: name}
+	if p.acceptKeyword("AS") || p.peek().Kind == TokenIdentifier {
+		if ref.Alias, err = p.parseIdentifier(); err != nil {
+			return nil, err
+		}
+	}
+	return ref, nil
+}
+
+func (p *Parser) parseSelectColumn() (SelectColumn, error) {
+
+	if p.acceptOperator("*") {
+		return SelectColumn{Expr: &Star{}}, nil
+	}
+
+	dot, star := p.peekAt(1), p.peekAt(2)
+	if p.peek().Kind == TokenIdentifier && dot.Kind == TokenOperator && dot.Value == "." &&
+		star.Kind == TokenOperator && star.Value == "*" {
+		table := p.next().Value
+		p.next()
+		p.next()
+		return SelectColumn{Expr: &Star{Table: table}}, nil
+	}
+
+	expr, err := p.parseExpr()
+	if err != nil {
+		return SelectColumn{}, err
+	}
+
+	column := SelectColumn{Expr: expr}
+	if p.acceptKeyword("AS") || p.peek().Kind == TokenIdentifier {
+		alias, err := p.parseIdentifier()
+		if err != nil {
+			return SelectColumn{}, err
+		}
+		column.Alias = alias
+	}
+
+	return column, nil
+}
+
+func (p *Parser) parseUpdate() (Statement, error) {
+
+	if err := p.expectKeyword("UPDATE"); err != nil {
+		return nil, err
+	}
+
+	stmt := &UpdateStmt{}
+	name, err := p.parseTableName()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Table = name
+
+	if err := p.expectKeyword("SET"); err != nil {
+		return nil, err
+	}
+
+	for {
+		column, err := p.parseIdentifier()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectOperator("="); err != nil {
+			return nil, err
+		}
+		value, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Set = append(stmt.Set, Assignment{Column: column, Value: value})
+
+		if !p.acceptOperator(",") {
+			break
+		}
+	}
+
+	if p.acceptKeyword("WHERE") {
+		where, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Where = where
+	}
+
+	return stmt, nil
+}
+
+func (p *Parser) parseDelete() (Statement, error) {
+
+	if err := p.expectKeyword("DELETE", "FROM"); err != nil {
+		return nil, err
+	}
+
+	stmt := &DeleteStmt{}
+	name, err := p.parseTableName()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Table = name
+
+	if p.acceptKeyword("WHERE") {
+		where, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Where = where
+	}
+
+	return stmt, nil
+}
+
+func (p *Parser) parseExprList() ([]Expr, error) {
+
+	exprs := []Expr{}
+	for {
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+
+		if !p.acceptOperator(",") {
+			return exprs, nil
+		}
+	}
+}
+
+// Expressions are parsed with one function per precedence level, lowest
+// first: OR, AND, NOT, comparison, additive, multiplicative, unary.
+func (p *Parser) parseExpr() (Expr, error) {
+	return p.parseOr()
+}
+
+func (p *Parser) parseOr() (Expr, error) {
+
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptKeyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Op: "OR", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *Parser) parseAnd() (Expr, error) {
+
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.acceptKeyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Op: "AND", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *Parser) parseNot() (Expr, error) {
+
+	if p.acceptKeyword("NOT") {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryExpr{Op: "NOT", Operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+// negatedPredicates are the predicates NOT may precede, as in NOT IN.
+var negatedPredicates = map[string]bool{"BETWEEN": true, "IN": true, "LIKE": true, "ILIKE": true}
+
+var comparisonOperators = map[string]string{
+	"=": "=", "<>": "<>", "!=": "<>", "<": "<", "<=": "<=", ">": ">", ">=": ">=",
+}
+
+func (p *Parser) parseComparison() (Expr, error) {
+
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.acceptKeyword("IS") {
+		not := p.acceptKeyword("NOT")
+		if err := p.expectKeyword("NULL"); err != nil {
+			return nil, err
+		}
+		return &IsNullExpr{Expr: left, Not: not}, nil
+	}
+
+	not := false
+	if next := p.peekAt(1); p.isKeyword("NOT") && next.Kind == TokenKeyword && negatedPredicates[next.Value] {
+		p.next()
+		not = true
+	}
+
+	switch {
+	case p.acceptKeyword("BETWEEN"):
+		low, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("AND"); err != nil {
+			return nil, err
+		}
+		high, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return &BetweenExpr{Expr: left, Low: low, High: high, Not: not}, nil
+
+	case p.acceptKeyword("IN"):
+		if err := p.expectOperator("("); err != nil {
+			return nil, err
+		}
+		list, err := p.parseExprList()
+		if err != nil {
+			return nil, err
+		}
+		return &InExpr{Expr: left, List: list, Not: not}, p.expectOperator(")")
+
+	case p.isKeyword("LIKE") || p.isKeyword("ILIKE"):
+		like := &LikeExpr{Expr: left, IgnoreCase: p.next().Value == "ILIKE", Not: not}
+		if like.Pattern, err = p.parseAdditive(); err != nil {
+			return nil, err
+		}
+		if p.acceptKeyword("ESCAPE") {
+			if like.Escape, err = p.parseAdditive(); err != nil {
+				return nil, err
+			}
+		}
+		return like, nil
+	}
+
+	token := p.peek()
+	if op, ok := comparisonOperators[token.Value]; ok && token.Kind == TokenOperator {
+		p.next()
+		right, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return &BinaryExpr{Op: op, Left: left, Right: right}, nil
+	}
+
+	return left, nil
+}
+
+func (p *Parser) parseAdditive() (Expr, error) {
+
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isOperator("+") || p.isOperator("-") || p.isOperator("||") {
+		op := p.next().Value
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Op: op, Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *Parser) parseMultiplicative() (Expr, error) {
+
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isOperator("*") || p.isOperator("/") || p.isOperator("%") {
+		op := p.next().Value
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Op: op, Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *Parser) parseUnary() (Expr, error) {
+
+	if p.isOperator("-") || p.isOperator("+") {
+		op := p.next().Value
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryExpr{Op: op, Operand: operand}, nil
+	}
+	return p.parseTypeCast()
+}
+
+// parseTypeCast reads a primary expression followed by any number of
+// PostgreSQL style casts, as in '1'::INTEGER.
+func (p *Parser) parseTypeCast() (Expr, error) {
+
+	expr, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptOperator("::") {
+		target, err := p.parseCastType()
+		if err != nil {
+			return nil, err
+		}
+		expr = &CastExpr{Expr: expr, Target: target}
+	}
+	return expr, nil
+}
+
+func (p *Parser) parseCastType() (*Column, error) {
+
+	def := &ColumnDef{}
+	if err := p.parseColumnType(def); err != nil {
+		return nil, err
+	}
+	return &Column{Type: def.Type, Size: def.Size, Precision: def.Precision, Scale: def.Scale}, nil
+}
+
+func (p *Parser) parsePrimary() (Expr, error) {
+
+	token := p.peek()
+
+	switch token.Kind {
+	case TokenInteger:
+		p.next()
+		value, err := strconv.ParseInt(token.Value, 10, 64)
+		if err != nil {
+			return &NumberLiteral{Value: token.Value}, nil
+		}
+		return &IntegerLiteral{Value: value}, nil
+
+	case TokenNumber:
+		p.next()
+		return &NumberLiteral{Value: token.Value}, nil
+
+	case TokenString:
+		p.next()
+		return &StringLiteral{Value: token.Value}, nil
+
+	case TokenKeyword:
+		switch token.Value {
+		case "NULL":
+			p.next()
+			return &NullLiteral{}, nil
+		case "TRUE", "FALSE":
+			p.next()
+			return &BoolLiteral{Value: token.Value == "TRUE"}, nil
+		case "CASE":
+			return p.parseCase()
+		case "CAST":
+			p.next()
+			if err := p.expectOperator("("); err != nil {
+				return nil, err
+			}
+			expr, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectKeyword("AS"); err != nil {
+				return nil, err
+			}
+			target, err := p.parseCastType()
+			if err != nil {
+				return nil, err
+			}
+			return &CastExpr{Expr: expr, Target: target}, p.expectOperator(")")
+		}
+
+	case TokenIdentifier:
+		p.next()
+		if p.isOperator("(") {
+			return p.parseFunctionCall(token.Value)
+		}
+		if sqlValueFunctions[token.Value] {
+			return &FunctionCall{Name: token.Value, Args: []Expr{}}, nil
+		}
+		if p.acceptOperator(".") {
+			name, err := p.parseIdentifier()
+			if err != nil {
+				return nil, err
+			}
+			return &Identifier{Table: token.Value, Name: name}, nil
+		}
+		return &Identifier{Name: token.Value}, nil
+
+	case TokenOperator:
+		if token.Value == "(" {
+			p.next()
+			expr, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			return expr, p.expectOperator(")")
+		}
+	}
+
+	return nil, p.expected("expression")
+}
+
+func (p *Parser) parseCase() (Expr, error) {
+
+	if err := p.expectKeyword("CASE"); err != nil {
+		return nil, err
+	}
+
+	expr := &CaseExpr{}
+	if !p.isKeyword("WHEN") {
+		operand, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		expr.Operand = operand
+	}
+
+	for p.acceptKeyword("WHEN") {
+		cond, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("THEN"); err != nil {
+			return nil, err
+		}
+		result, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		expr.Whens = append(expr.Whens, WhenClause{Cond: cond, Result: result})
+	}
+	if len(expr.Whens) == 0 {
+		return nil, p.expected("WHEN")
+	}
+
+	if p.acceptKeyword("ELSE") {
+		result, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		expr.Else = result
+	}
+
+	return expr, p.expectKeyword("END")
+}
+
+func (p *Parser) parseFunctionCall(name string) (Expr, error) {
+
+	if err := p.expectOperator("("); err != nil {
+		return nil, err
+	}
+
+	call := &FunctionCall{Name: name, Args: []Expr{}}
+	if p.acceptOperator(")") {
+		return call, nil
+	}
+
+	switch name {
+	case "EXTRACT", "POSITION", "SUBSTRING", "TRIM":
+		if err := p.parseSpecialArgs(call); err != nil {
+			return nil, err
+		}
+		return call, p.expectOperator(")")
+	}
+
+	if p.acceptOperator("*") {
+		call.Args = append(call.Args, &Star{})
+		return call, p.expectOperator(")")
+	}
+	call.Distinct = p.acceptKeyword("DISTINCT")
+
+	args, err := p.parseExprList()
+	if err != nil {
+		return nil, err
+	}
+	call.Args = args
+
+	return call, p.expectOperator(")")
+}
+
+// parseSpecialArgs reads the arguments of the functions SQL gives a syntax
+// of their own: EXTRACT(field FROM source), POSITION(substring IN string),
+// SUBSTRING(string FROM start FOR count) and TRIM(BOTH chars FROM string).
+// The usual comma separated form is accepted too, except by EXTRACT.
+func (p *Parser) parseSpecialArgs(call *FunctionCall) error {
+
+	if call.Name == "EXTRACT" {
+		token := p.peek()
+		if token.Kind != TokenIdentifier && token.Kind != TokenString {
+			return p.expected("field name")
+		}
+		p.next()
+		if err := p.expectKeyword("FROM"); err != nil {
+			return err
+		}
+		source, err := p.parseExpr()
+		if err != nil {
+			return err
+		}
+		call.Args = []Expr{&StringLiteral{Value: strings.ToUpper(token.Value)}, source}
+		return nil
+	}
+
+	if call.Name == "TRIM" {
+		switch {
+		case p.acceptWords("LEADING"):
+			call.Name = "LTRIM"
+		case p.acceptWords("TRAILING"):
+			call.Name = "RTRIM"
+		default:
+			p.acceptWords("BOTH")
+		}
+		if p.acceptKeyword("FROM") {
+			str, err := p.parseExpr()
+			call.Args = []Expr{str}
+			return err
+		}
+	}
+
+	// The first argument stops short of comparisons, so that the IN of
+	// POSITION is not read as an IN list.
+	first, err := p.parseAdditive()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case call.Name == "POSITION" && p.acceptKeyword("IN"):
+		str, err := p.parseExpr()
+		call.Args = []Expr{first, str}
+		return err
+
+	case call.Name != "SUBSTRING" && call.Name != "POSITION" && p.acceptKeyword("FROM"):
+		str, err := p.parseExpr()
+		call.Args = []Expr{str, first}
+		return err
+
+	case call.Name == "SUBSTRING" && (p.isKeyword("FROM") || p.acceptWords("FOR")):
+		call.Args = []Expr{first, &IntegerLiteral{Value: 1}}
+		if p.acceptKeyword("FROM") {
+			if call.Args[1], err = p.parseExpr(); err != nil {
+				return err
+			}
+			if !p.acceptWords("FOR") {
+				return nil
+			}
+		}
+		count, err := p.parseExpr()
+		call.Args = append(call.Args, count)
+		return err
+	}
+
+	call.Args = []Expr{first}
+	if p.acceptOperator(",") {
+		rest, err := p.parseExprList()
+		if err != nil {
+			return err
+		}
+		call.Args = append(call.Args, rest...)
+	}
+	return nil
+}
+
+func (p *Parser) parseDropIndex() (Statement, error) {
+
+	stmt := &DropIndexStmt{}
+	if p.acceptKeyword("IF") {
+		if err := p.expectKeyword("EXISTS"); err != nil {
+			return nil, err
+		}
+		stmt.IfExists = true
+	}
+
+	name, err := p.parseTableName()
+	if err != nil {
+		return nil, err
+	}
+	stmt.Name = name
+
+	return stmt, nil
+}
@@ -0,0 +1,341 @@
+package gopherql
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+
+	tokens, err := Tokenize("select \"Mixed\", 'it''s' -- comment\n FROM t WHERE x >= 1.5e3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Token{
+		{TokenKeyword, "SELECT", 1, 1},
+		{TokenIdentifier, "Mixed", 1, 8},
+		{TokenOperator, ",", 1, 15},
+		{TokenString, "it's", 1, 17},
+		{TokenKeyword, "FROM", 2, 2},
+		{TokenIdentifier, "T", 2, 7},
+		{TokenKeyword, "WHERE", 2, 9},
+		{TokenIdentifier, "X", 2, 15},
+		{TokenOperator, ">=", 2, 17},
+		{TokenNumber, "1.5e3", 2, 20},
+		{TokenEOF, "", 2, 25},
+	}
+
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected %d tokens, got: %v", len(expected), tokens)
+	}
+	for idx, token := range tokens {
+		if token != expected[idx] {
+			t.Errorf("token %d: expected %+v, got: %+v", idx, expected[idx], token)
+		}
+	}
+}
+
+func TestParse_CreateTable(t *testing.T) {
+
+	stmt, err := Parse("CREATE TABLE IF NOT EXISTS app.users (id BIGINT PRIMARY KEY, name VARCHAR(32) NOT NULL, active BOOLEAN);")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	create, ok := stmt.(*CreateTableStmt)
+	if !ok {
+		t.Fatalf("unexpected statement: %T", stmt)
+	}
+	if create.Name != (TableName{Schema: "APP", Name: "USERS"}) || !create.IfNotExists {
+		t.Errorf("unexpected table: %+v", create.Name)
+	}
+	if len(create.Columns) != 3 || create.Columns[1].Type != StringColumn || !create.Columns[1].NotNull {
+		t.Errorf("unexpected columns: %+v", create.Columns)
+	}
+	if len(create.PrimaryKey) != 1 || create.PrimaryKey[0] != "ID" {
+		t.Errorf("unexpected primary key: %v", create.PrimaryKey)
+	}
+}
+
+func TestParse_Select(t *testing.T) {
+
+	stmt, err := Parse("SELECT id, name AS n, -price * 2 FROM items WHERE NOT a = 1 OR b IS NOT NULL AND c <> 'x' ORDER BY id DESC, name LIMIT 10 OFFSET 5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sel := stmt.(*SelectStmt)
+	if len(sel.Columns) != 3 || sel.Columns[1].Alias != "N" {
+		t.Errorf("unexpected columns: %+v", sel.Columns)
+	}
+	if sel.Columns[2].Expr.String() != "(-(PRICE) * 2)" {
+		t.Errorf("unexpected expression: %s", sel.Columns[2].Expr)
+	}
+	if sel.Where.String() != "((NOT (A = 1)) OR ((B IS NOT NULL) AND (C <> 'x')))" {
+		t.Errorf("unexpected where: %s", sel.Where)
+	}
+	if len(sel.OrderBy) != 2 || !sel.OrderBy[0].Desc || sel.OrderBy[1].Desc {
+		t.Errorf("unexpected order by: %+v", sel.OrderBy)
+	}
+	if sel.Limit.String() != "10" || sel.Offset.String() != "5" {
+		t.Errorf("unexpected limit and offset: %s %s", sel.Limit, sel.Offset)
+	}
+
+	reparsed, err := ParseExpr(sel.Where.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reparsed.String() != sel.Where.String() {
+		t.Errorf("expected expression to round trip, got: %s", reparsed)
+	}
+
+	stmt, err = Parse("SELECT city, COUNT(*) FROM people GROUP BY city, 2 HAVING SUM(age) > 10 ORDER BY city")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sel = stmt.(*SelectStmt)
+	if len(sel.GroupBy) != 2 || sel.GroupBy[0].String() != "CITY" || sel.Having.String() != "(SUM(AGE) > 10)" {
+		t.Errorf("unexpected grouping: %v %v", sel.GroupBy, sel.Having)
+	}
+}
+
+func TestParse_Modifications(t *testing.T) {
+
+	for _, sql := range []string{
+		"INSERT INTO t (a, b) VALUES (1, 'one'), (2, NULL)",
+		"INSERT INTO t VALUES (TRUE)",
+		"UPDATE t SET a = a + 1, b = 'two' WHERE a = 1",
+		"DELETE FROM t WHERE a > 1",
+		"DELETE FROM t",
+		"DROP TABLE IF EXISTS t",
+	} {
+		if _, err := Parse(sql); err != nil {
+			t.Errorf("unexpected error parsing %q: %s", sql, err)
+		}
+	}
+
+	stmt, err := Parse("INSERT INTO t (a, b) VALUES (1, 'one'), (2, NULL)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	insert := stmt.(*InsertStmt)
+	if len(insert.Rows) != 2 || len(insert.Columns) != 2 {
+		t.Errorf("unexpected insert: %+v", insert)
+	}
+}
+
+func TestParse_SyntaxErrors(t *testing.T) {
+
+	cases := map[string]string{
+		"SELECT FROM t":                    "line 1, column 8",
+		"SELECT a\nFROM t WHERE":           "line 2, column 13",
+		"CREATE TABLE t (a UNKNOWN)":       "line 1, column 19",
+		"SELECT 'unterminated":             "line 1, column 8",
+		"SELECT a FROM t WHERE a = 1 junk": "line 1, column 29",
+	}
+
+	for sql, position := range cases {
+		_, err := Parse(sql)
+		sqlErr, ok := err.(SQLStateError)
+		if !ok || sqlErr.Code != "42601" {
+			t.Errorf("expected syntax error for %q, got: %v", sql, err)
+			continue
+		}
+		if !strings.Contains(sqlErr.Msg, position) {
+			t.Errorf("expected %s in error for %q, got: %s", position, sql, sqlErr.Msg)
+		}
+	}
+}
+
+func TestParse_ColumnTypes(t *testing.T) {
+
+	stmt, err := Parse(`CREATE TABLE t (
+		a SMALLINT, b INTEGER, c BIGINT, d REAL, e DOUBLE PRECISION, f FLOAT(10),
+		g DECIMAL(10, 2), h NUMERIC, i DATE, j TIME WITHOUT TIME ZONE,
+		k TIMESTAMP, l TIMESTAMP WITH TIME ZONE, m CHARACTER VARYING(20),
+		n CHAR(3), o CHAR, p TEXT, q BYTEA
+	)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []ColumnDef{
+		{Name: "A", Type: Int16Column},
+		{Name: "B", Type: Int32Column},
+		{Name: "C", Type: Int64Column},
+		{Name: "D", Type: Float32Column},
+		{Name: "E", Type: Float64Column},
+		{Name: "F", Type: Float32Column},
+		{Name: "G", Type: DecimalColumn, Precision: 10, Scale: 2},
+		{Name: "H", Type: DecimalColumn, Precision: maxDecimalPrecision},
+		{Name: "I", Type: DateColumn},
+		{Name: "J", Type: TimeColumn},
+		{Name: "K", Type: TimestampColumn},
+		{Name: "L", Type: TimestampTzColumn},
+		{Name: "M", Type: StringColumn, Size: 20},
+		{Name: "N", Type: CharColumn, Size: 3},
+		{Name: "O", Type: CharColumn, Size: 1},
+		{Name: "P", Type: StringColumn},
+		{Name: "Q", Type: BytesColumn},
+	}
+
+	columns := stmt.(*CreateTableStmt).Columns
+	if len(columns) != len(expected) {
+		t.Fatalf("expected %d columns, got: %+v", len(expected), columns)
+	}
+	for idx, column := range columns {
+		if column != expected[idx] {
+			t.Errorf("expected %+v, got: %+v", expected[idx], column)
+		}
+	}
+
+	for _, sql := range []string{
+		"CREATE TABLE t (a DECIMAL(19))",
+		"CREATE TABLE t (a DECIMAL(4, 5))",
+		"CREATE TABLE t (a VARCHAR(0))",
+		"CREATE TABLE t (a FLOAT(54))",
+	} {
+		_, err := Parse(sql)
+		if sqlErr, ok := err.(SQLStateError); !ok || sqlErr.Code != "22023" {
+			t.Errorf("expected invalid parameter for %q, got: %v", sql, err)
+		}
+	}
+}
+
+func TestParse_Constraints(t *testing.T) {
+
+	stmt, err := Parse(`CREATE TABLE t (
+		a INT DEFAULT 1 + 2 NOT NULL CONSTRAINT a_positive CHECK (a > 0),
+		b VARCHAR UNIQUE,
+		CHECK (a < 10),
+		CONSTRAINT ab UNIQUE (a, b)
+	)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	create := stmt.(*CreateTableStmt)
+	if create.Columns[0].Default.String() != "(1 + 2)" || !create.Columns[0].NotNull {
+		t.Errorf("unexpected column: %+v", create.Columns[0])
+	}
+	if len(create.Checks) != 2 || create.Checks[0].Name != "A_POSITIVE" || create.Checks[0].Column != "A" || create.Checks[1].Column != "" {
+		t.Errorf("unexpected checks: %+v", create.Checks)
+	}
+	if len(create.Uniques) != 2 || create.Uniques[0].Columns[0] != "B" || create.Uniques[1].Name != "AB" {
+		t.Errorf("unexpected uniques: %+v", create.Uniques)
+	}
+}
+
+func TestParse_MultiplePrimaryKeys(t *testing.T) {
+
+	for _, sql := range []string{
+		"CREATE TABLE t (a INT PRIMARY KEY, b INT PRIMARY KEY)",
+		"CREATE TABLE t (a INT PRIMARY KEY, b INT, PRIMARY KEY (b))",
+		"CREATE TABLE t (a INT, PRIMARY KEY (a), b INT PRIMARY KEY)",
+		"CREATE TABLE t (a INT, b INT, PRIMARY KEY (a), PRIMARY KEY (b))",
+	} {
+		_, err := Parse(sql)
+		expectSQLState(t, err, "42P16")
+	}
+
+	stmt, err := Parse("CREATE TABLE t (a INT, b INT, PRIMARY KEY (a, b))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := stmt.(*CreateTableStmt).PrimaryKey; len(keys) != 2 {
+		t.Errorf("unexpected primary key: %v", keys)
+	}
+}
+
+func TestParse_Indexes(t *testing.T) {
+
+	stmt, err := Parse("CREATE UNIQUE INDEX IF NOT EXISTS by_email ON app.users (email, id)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := stmt.(*CreateIndexStmt)
+	if create.Name != "BY_EMAIL" || create.Table != (TableName{Schema: "APP", Name: "USERS"}) ||
+		!create.Unique || !create.IfNotExists || len(create.Columns) != 2 {
+		t.Errorf("unexpected statement: %+v", create)
+	}
+
+	stmt, err = Parse("DROP INDEX IF EXISTS app.by_email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	drop := stmt.(*DropIndexStmt)
+	if drop.Name != (TableName{Schema: "APP", Name: "BY_EMAIL"}) || !drop.IfExists {
+		t.Errorf("unexpected statement: %+v", drop)
+	}
+}
+
+func TestParse_Predicates(t *testing.T) {
+
+	for _, test := range []struct {
+		sql      string
+		expected string
+	}{
+		{"a NOT BETWEEN 1 AND 2 + 3", "(A NOT BETWEEN 1 AND (2 + 3))"},
+		{"a IN (1, b) AND c NOT IN ('x')", "((A IN (1, B)) AND (C NOT IN ('x')))"},
+		{"name NOT ILIKE 'a!%' ESCAPE '!'", "(NAME NOT ILIKE 'a!%' ESCAPE '!')"},
+		{"CASE a WHEN 1 THEN 'one' ELSE 'many' END", "CASE A WHEN 1 THEN 'one' ELSE 'many' END"},
+		{"CASE WHEN a > 1 THEN b END", "CASE WHEN (A > 1) THEN B END"},
+		{"CAST(a AS VARCHAR(3))", "CAST(A AS VARCHAR(3))"},
+		{"-'1'::NUMERIC(4, 1)", "-(CAST('1' AS DECIMAL(4,1)))"},
+		{"NOT a LIKE 'x%'", "(NOT (A LIKE 'x%'))"},
+		{"count(DISTINCT a) > 1", "(COUNT(DISTINCT A) > 1)"},
+	} {
+		expr, err := ParseExpr(test.sql)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", test.sql, err)
+			continue
+		}
+		if expr.String() != test.expected {
+			t.Errorf("expected %q to parse as %s, got: %s", test.sql, test.expected, expr)
+		}
+		reparsed, err := ParseExpr(expr.String())
+		if err != nil || reparsed.String() != expr.String() {
+			t.Errorf("expected %s to round trip, got: %v %v", expr, reparsed, err)
+		}
+	}
+
+	for _, sql := range []string{"CASE END", "a BETWEEN 1", "a IN ()", "CAST(a AS NOTATYPE)"} {
+		_, err := ParseExpr(sql)
+		expectSQLState(t, err, "42601")
+	}
+}
+
+func TestParse_Joins(t *testing.T) {
+
+	for _, test := range []struct {
+		sql      string
+		expected string
+	}{
+		{"SELECT * FROM a JOIN b ON a.id = b.id", "A JOIN B ON (A.ID = B.ID)"},
+		{"SELECT * FROM a x INNER JOIN b AS y ON x.id = y.id", "A AS X JOIN B AS Y ON (X.ID = Y.ID)"},
+		{"SELECT * FROM a LEFT OUTER JOIN b ON true RIGHT JOIN c ON false", "A LEFT JOIN B ON TRUE RIGHT JOIN C ON FALSE"},
+		{"SELECT * FROM a, s.b CROSS JOIN c", "A CROSS JOIN S.B CROSS JOIN C"},
+		{"SELECT * FROM a FULL JOIN (b JOIN c ON b.x = c.x) ON a.x = b.x", "A FULL JOIN (B JOIN C ON (B.X = C.X)) ON (A.X = B.X)"},
+	} {
+		stmt, err := Parse(test.sql)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", test.sql, err)
+			continue
+		}
+		if from := stmt.(*SelectStmt).From.String(); from != test.expected {
+			t.Errorf("expected %q to join %s, got: %s", test.sql, test.expected, from)
+		}
+	}
+
+	for _, sql := range []string{
+		"SELECT * FROM a JOIN b",
+		"SELECT * FROM a CROSS JOIN b ON true",
+		"SELECT * FROM a LEFT b ON true",
+		"SELECT * FROM (a JOIN b ON true",
+	} {
+		if _, err := Parse(sql); err == nil {
+			t.Errorf("expected an error parsing %q", sql)
+		}
+	}
+}
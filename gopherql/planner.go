@@ -0,0 +1,1175 @@
+package gopherql
+
+import (
+	"fmt"
+	"math"
+)
+
+// Costs are counted in units of a page read in sequence, as PostgreSQL counts
+// them. A page read at random, as when a B-tree is descended, costs more,
+// and every row returned and every operator evaluated costs a little CPU
+// time.
+const (
+	seqPageCost     = 1.0
+	randomPageCost  = 2.0
+	cpuTupleCost    = 0.01
+	cpuOperatorCost = 0.0025
+)
+
+// A table not analyzed is taken to hold defaultRows rows on defaultPages
+// pages. Conditions whose selectivity the statistics cannot tell hold for
+// the default fractions of rows, and a grouping value not analyzed has
+// defaultGroups distinct values.
+const (
+	defaultRows     = 1000
+	defaultPages    = 10
+	defaultEqualSel = 0.005
+	defaultRangeSel = 1.0 / 3
+	defaultMatchSel = 0.005
+	defaultNullSel  = 0.005
+	defaultSel      = 0.5
+	defaultGroups   = 200
+)
+
+// A B-tree page is taken to hold treeFanout keys, to estimate the levels a
+// lookup descends.
+const treeFanout = 100
+
+// Join orders are searched exhaustively for up to maxJoinSearchLen inputs.
+const maxJoinSearchLen = 8
+
+// estimate is the number of rows an operator is expected to return and the
+// cost of returning all of them.
+type estimate struct {
+	Rows float64
+	Cost float64
+}
+
+// planner builds the operators of a query, choosing among the ways of
+// running each part the one estimated to cost least from the statistics of
+// its tables. It records the estimate of every operator it builds.
+//
+// For the FROM clause it holds the tables, every column of the clause,
+// against which column references must not be ambiguous, the table each
+// column belongs to, and the conjuncts of the WHERE clause not yet applied.
+type planner struct {
+	tx        *Transaction
+	estimates map[Operator]estimate
+
+	tables  map[*TableRef]*fromTable
+	columns []ResultColumn
+	owners  []*fromTable
+	where   []Expr
+}
+
+func (tx *Transaction) newPlanner() *planner {
+	return &planner{tx: tx, estimates: map[Operator]estimate{}, tables: map[*TableRef]*fromTable{}}
+}
+
+// add records the estimate of an operator and returns it. An operator is
+// taken to return at least one row, as estimates are rarely so precise.
+func (p *planner) add(op Operator, rows, cost float64) Operator {
+	if rows < 1 {
+		rows = 1
+	}
+	p.estimates[op] = estimate{Rows: rows, Cost: cost}
+	return op
+}
+
+// fromTable is a table of the FROM clause. Alias is the name its columns
+// are qualified by, stats its statistics, nil when it has not been analyzed,
+// and filters the conditions applied to its rows as they are read.
+type fromTable struct {
+	table   *Table
+	alias   string
+	stats   *TableStats
+	filters []Expr
+}
+
+func (f *fromTable) rows() float64 {
+	if f.stats == nil {
+		return defaultRows
+	}
+	return float64(f.stats.Rows)
+}
+
+func (f *fromTable) pages() float64 {
+	if f.stats == nil {
+		return defaultPages
+	}
+	return float64(f.stats.Pages)
+}
+
+// descentCost is the cost of finding a key in a B-tree as large as the
+// table, reading a page at random at each level.
+func (f *fromTable) descentCost() float64 {
+	levels := 1.0
+	if pages := f.pages(); pages > 1 {
+		levels += math.Ceil(math.Log(pages) / math.Log(treeFanout))
+	}
+	return levels * randomPageCost
+}
+
+// resolveFrom looks up the tables of the FROM clause and their statistics.
+func (p *planner) resolveFrom(from FromItem, where []Expr) error {
+
+	p.where = where
+	aliases := map[string]bool{}
+	on := []Expr{}
+
+	var resolve func(item FromItem) error
+	resolve = func(item FromItem) error {
+		switch e := item.(type) {
+		case *TableRef:
+			table, err := p.tx.Table(e.Table.Schema, e.Table.Name)
+			if err != nil {
+				return err
+			}
+			alias := e.Alias
+			if alias == "" {
+				alias = table.Name
+			}
+			if aliases[alias] {
+				return SQLStateError{Code: "42712", Msg: fmt.Sprintf("table name %s specified more than once", quoteIdentifier(alias))}
+			}
+			aliases[alias] = true
+			stats, err := p.tx.TableStats(table)
+			if err != nil {
+				return err
+			}
+			from := &fromTable{table: table, alias: alias, stats: stats}
+			p.tables[e] = from
+			for _, column := range tableColumns(table, alias) {
+				p.columns = append(p.columns, column)
+				p.owners = append(p.owners, from)
+			}
+			return nil
+
+		case *JoinExpr:
+			if containsAggregate(e.On) {
+				return misplacedAggregate("JOIN conditions")
+			}
+			p.tx.bindFunctions(e.On)
+			on = append(on, e.On)
+			if err := resolve(e.Left); err != nil {
+				return err
+			}
+			return resolve(e.Right)
+		}
+		return fmt.Errorf("unexpected FROM item %T", item)
+	}
+	if err := resolve(from); err != nil {
+		return err
+	}
+
+	// Join conditions are evaluated against the rows of joins whose inputs
+	// the planner chooses, so their references must name exactly one column
+	// of the whole FROM clause.
+	if err := bindColumns(p.columns, on...); err != nil {
+		return err
+	}
+	return p.tx.checkCalls(p.columnType, on...)
+}
+
+// columnType returns the type of the column of the FROM clause a reference
+// names, or unknownType when it names none.
+func (p *planner) columnType(ident *Identifier) ColumnType {
+	idx, err := findColumn(p.columns, ident.Table, ident.Name)
+	if err != nil {
+		return unknownType
+	}
+	return tableColumnType(p.owners[idx].table)(ident)
+}
+
+// refersTo reports whether expr reads columns, and only columns, of side.
+// The references of the WHERE and ON clauses have been bound to the columns
+// of the FROM clause, so each names exactly one column, which side either
+// has or not.
+func (p *planner) refersTo(expr Expr, side []ResultColumn) bool {
+	found, only := false, true
+	walkExpr(expr, func(expr Expr) {
+		if ident, ok := expr.(*Identifier); ok {
+			found = true
+			if _, err := findColumn(side, ident.Table, ident.Name); err != nil {
+				only = false
+			}
+		}
+	})
+	return found && only
+}
+
+// takeWhere removes the conjuncts of the WHERE clause that only read the
+// columns of side and returns them.
+func (p *planner) takeWhere(side []ResultColumn) []Expr {
+	taken, kept := []Expr{}, []Expr{}
+	for _, cond := range p.where {
+		if p.refersTo(cond, side) {
+			taken = append(taken, cond)
+		} else {
+			kept = append(kept, cond)
+		}
+	}
+	p.where = kept
+	return taken
+}
+
+// plan builds the operators of a FROM item. Nullable is set for the items
+// an outer join pads with NULLs, which the WHERE clause must not filter
+// before they are joined.
+func (p *planner) plan(item FromItem, nullable bool) (Operator, error) {
+
+	if ref, ok := item.(*TableRef); ok {
+		from := p.tables[ref]
+		if !nullable {
+			from.filters = append(from.filters, p.takeWhere(tableColumns(from.table, from.alias))...)
+		}
+		return p.planTable(from)
+	}
+
+	join := item.(*JoinExpr)
+	if join.Type == InnerJoin || join.Type == CrossJoin {
+		return p.planInner(join, nullable)
+	}
+
+	left, err := p.plan(join.Left, nullable || join.Type.keepsRight())
+	if err != nil {
+		return nil, err
+	}
+	right, err := p.plan(join.Right, nullable || join.Type.keepsLeft())
+	if err != nil {
+		return nil, err
+	}
+	return p.join(join.Type, left, right, join.Right, conjuncts(join.On))
+}
+
+// joinItem is an input of a run of inner and cross joins, with the columns
+// it reads.
+type joinItem struct {
+	item    FromItem
+	plan    Operator
+	columns []ResultColumn
+}
+
+// joinCond is a condition of a run of inner and cross joins, with the set of
+// inputs it reads, a bit for each.
+type joinCond struct {
+	cond  Expr
+	items int
+}
+
+// planInner plans a run of inner and cross joins, whose inputs may be joined
+// in any order. The order is searched for the cheapest, exhaustively for up
+// to maxJoinSearchLen inputs and greedily for more, always joining one
+// input at a time so that it may be read through an index. The conditions
+// of the joins, and those of the WHERE clause, are applied as soon as the
+// inputs they read are joined.
+func (p *planner) planInner(join *JoinExpr, nullable bool) (Operator, error) {
+
+	items := []*joinItem{}
+	on := []Expr{}
+	var flatten func(item FromItem)
+	flatten = func(item FromItem) {
+		if join, ok := item.(*JoinExpr); ok && (join.Type == InnerJoin || join.Type == CrossJoin) {
+			flatten(join.Left)
+			flatten(join.Right)
+			on = append(on, conjuncts(join.On)...)
+			return
+		}
+		items = append(items, &joinItem{item: item})
+	}
+	flatten(join)
+
+	// Conditions reading a single table filter it as it is read.
+	for _, item := range items {
+		ref, ok := item.item.(*TableRef)
+		if !ok {
+			continue
+		}
+		from := p.tables[ref]
+		columns := tableColumns(from.table, from.alias)
+		kept := []Expr{}
+		for _, cond := range on {
+			if p.refersTo(cond, columns) {
+				from.filters = append(from.filters, cond)
+			} else {
+				kept = append(kept, cond)
+			}
+		}
+		on = kept
+	}
+
+	all := []ResultColumn{}
+	for _, item := range items {
+		var err error
+		if item.plan, err = p.plan(item.item, nullable); err != nil {
+			return nil, err
+		}
+		item.columns = item.plan.Columns()
+		all = append(all, item.columns...)
+	}
+	if !nullable {
+		on = append(on, p.takeWhere(all)...)
+	}
+
+	// Conditions reading one input, or none, are applied to it before it is
+	// joined. Those whose references cannot be resolved are applied last,
+	// for evaluation to report.
+	conds := []joinCond{}
+	for _, cond := range on {
+		reads := 0
+		walkExpr(cond, func(expr Expr) {
+			ident, ok := expr.(*Identifier)
+			if !ok {
+				return
+			}
+			if _, err := findColumn(p.columns, ident.Table, ident.Name); err != nil {
+				reads = 1<<len(items) - 1
+				return
+			}
+			for idx, item := range items {
+				if _, err := findColumn(item.columns, ident.Table, ident.Name); err == nil {
+					reads |= 1 << idx
+				}
+			}
+		})
+		if reads&(reads-1) == 0 {
+			idx := 0
+			for reads > 1 {
+				reads >>= 1
+				idx++
+			}
+			items[idx].plan = p.filter(items[idx].plan, []Expr{cond})
+			continue
+		}
+		conds = append(conds, joinCond{cond: cond, items: reads})
+	}
+
+	// step joins an input to a plan of the inputs in joined, applying the
+	// conditions the input completes.
+	step := func(plan Operator, joined int, idx int) (Operator, error) {
+		on := []Expr{}
+		for _, cond := range conds {
+			if cond.items&(1<<idx) != 0 && cond.items&^(joined|1<<idx) == 0 {
+				on = append(on, cond.cond)
+			}
+		}
+		return p.join(InnerJoin, plan, items[idx].plan, items[idx].item, on)
+	}
+
+	type joinPlan struct {
+		plan  Operator
+		order []int
+	}
+	var best joinPlan
+
+	if len(items) <= maxJoinSearchLen {
+		plans := make([]*joinPlan, 1<<len(items))
+		for idx, item := range items {
+			plans[1<<idx] = &joinPlan{plan: item.plan, order: []int{idx}}
+		}
+		for joined := 1; joined < len(plans); joined++ {
+			if joined&(joined-1) == 0 {
+				continue
+			}
+			// Inputs are tried last first, so that among equally cheap
+			// orders the one written is kept.
+			for idx := len(items) - 1; idx >= 0; idx-- {
+				rest := joined &^ (1 << idx)
+				if rest == joined || plans[rest] == nil {
+					continue
+				}
+				plan, err := step(plans[rest].plan, rest, idx)
+				if err != nil {
+					return nil, err
+				}
+				if plans[joined] == nil || p.estimates[plan].Cost < p.estimates[plans[joined].plan].Cost {
+					order := append(append([]int{}, plans[rest].order...), idx)
+					plans[joined] = &joinPlan{plan: plan, order: order}
+				}
+			}
+		}
+		best = *plans[len(plans)-1]
+	} else {
+		// The greedy search starts from the input returning fewest rows and
+		// joins the input adding least cost next.
+		first := 0
+		for idx, item := range items {
+			if p.estimates[item.plan].Rows < p.estimates[items[first].plan].Rows {
+				first = idx
+			}
+		}
+		best = joinPlan{plan: items[first].plan, order: []int{first}}
+		joined := 1 << first
+		for len(best.order) < len(items) {
+			var next Operator
+			nextIdx := -1
+			for idx := range items {
+				if joined&(1<<idx) != 0 {
+					continue
+				}
+				plan, err := step(best.plan, joined, idx)
+				if err != nil {
+					return nil, err
+				}
+				if next == nil || p.estimates[plan].Cost < p.estimates[next].Cost {
+					next, nextIdx = plan, idx
+				}
+			}
+			best.plan, best.order = next, append(best.order, nextIdx)
+			joined |= 1 << nextIdx
+		}
+	}
+
+	// The columns of the joined inputs come back in the order they are
+	// written in.
+	for pos, idx := range best.order {
+		if pos != idx {
+			exprs := make([]Expr, len(all))
+			for idx, column := range all {
+				exprs[idx] = &Identifier{Table: column.Table, Name: column.Name}
+			}
+			input := p.estimates[best.plan]
+			return p.add(&Project{Input: best.plan, Exprs: exprs, Names: all}, input.Rows, input.Cost), nil
+		}
+	}
+	return best.plan, nil
+}
+
+// join picks how to join two inputs on the conjuncts of a join condition.
+// Without equalities between the two sides every pair of rows is tried by a
+// nested loop. With them the join may instead merge the sides, when both are
+// read in the order of their keys, look up the rows of the right side
+// through an index, when it is a table with its keys indexed, or hash the
+// right side, whichever is estimated to cost least.
+func (p *planner) join(joinType JoinType, left, right Operator, rightItem FromItem, on []Expr) (Operator, error) {
+
+	if joinType == CrossJoin {
+		joinType = InnerJoin
+	}
+
+	var leftKeys, rightKeys, rest []Expr
+	for _, cond := range on {
+		binary, ok := cond.(*BinaryExpr)
+		switch {
+		case !ok || binary.Op != "=":
+		case p.refersTo(binary.Left, left.Columns()) && p.refersTo(binary.Right, right.Columns()):
+			leftKeys, rightKeys = append(leftKeys, binary.Left), append(rightKeys, binary.Right)
+			continue
+		case p.refersTo(binary.Right, left.Columns()) && p.refersTo(binary.Left, right.Columns()):
+			leftKeys, rightKeys = append(leftKeys, binary.Right), append(rightKeys, binary.Left)
+			continue
+		}
+		rest = append(rest, cond)
+	}
+
+	leftEst, rightEst := p.estimates[left], p.estimates[right]
+	rows := leftEst.Rows * rightEst.Rows * p.selectivity(andAll(on))
+	if joinType.keepsLeft() {
+		rows = math.Max(rows, leftEst.Rows)
+	}
+	if joinType.keepsRight() {
+		rows = math.Max(rows, rightEst.Rows)
+	}
+	output := rows * cpuTupleCost
+	conds := float64(len(on))
+
+	nestedLoop := &NestedLoopJoin{Left: left, Right: right, Cond: andAll(on), Type: joinType}
+	cost := leftEst.Cost + leftEst.Rows*rightEst.Cost + leftEst.Rows*rightEst.Rows*conds*cpuOperatorCost + output
+	if len(leftKeys) == 0 {
+		return p.add(nestedLoop, rows, cost), nil
+	}
+	var best Operator = nestedLoop
+	bestCost := cost
+
+	keys := float64(len(leftKeys))
+	if merge := mergeKeys(left, right, leftKeys, rightKeys); merge != nil {
+		join := &MergeJoin{Left: left, Right: right, Type: joinType}
+		cond := append([]Expr{}, rest...)
+		for idx := range leftKeys {
+			if merge[idx] {
+				join.LeftKeys = append(join.LeftKeys, leftKeys[idx])
+				join.RightKeys = append(join.RightKeys, rightKeys[idx])
+			} else {
+				cond = append(cond, &BinaryExpr{Op: "=", Left: leftKeys[idx], Right: rightKeys[idx]})
+			}
+		}
+		join.Cond = andAll(cond)
+		cost := leftEst.Cost + rightEst.Cost + (leftEst.Rows+rightEst.Rows)*keys*cpuOperatorCost + output
+		if cost < bestCost {
+			best, bestCost = join, cost
+		}
+	}
+
+	if ref, ok := rightItem.(*TableRef); ok && (joinType == InnerJoin || joinType == LeftJoin) {
+		from := p.tables[ref]
+		index, keys, err := p.tx.lookupIndex(from, leftKeys, rightKeys)
+		if err != nil {
+			return nil, err
+		}
+		if keys != nil {
+			// The filters of the table are checked with the join condition,
+			// as its rows are read through the index instead of its scan.
+			cond := append(append([]Expr{}, on...), from.filters...)
+			join := &IndexNestedLoopJoin{
+				Left: left, Tx: p.tx, Table: from.table, Alias: from.alias, Index: index,
+				Keys: keys, Cond: andAll(cond), Type: joinType,
+			}
+
+			// Each lookup finds the rows whose indexed columns equal the
+			// keys, stored together in the table when it reads the primary
+			// key, and each read at random through another index.
+			matched := from.rows()
+			for _, key := range keys {
+				for idx := range leftKeys {
+					if leftKeys[idx] == key {
+						matched *= p.equalSelectivity(leftKeys[idx], rightKeys[idx])
+						break
+					}
+				}
+			}
+			lookup := from.descentCost() + matched*cpuTupleCost
+			if index == nil {
+				lookup += math.Ceil(matched/from.rows()*from.pages()) * seqPageCost
+			} else {
+				lookup += matched * randomPageCost
+			}
+			cost := leftEst.Cost + leftEst.Rows*(lookup+matched*float64(len(cond))*cpuOperatorCost) + output
+			if cost < bestCost {
+				best, bestCost = join, cost
+			}
+		}
+	}
+
+	hash := &HashJoin{Left: left, Right: right, LeftKeys: leftKeys, RightKeys: rightKeys, Cond: andAll(rest), Type: joinType}
+	cost = leftEst.Cost + rightEst.Cost + rightEst.Rows*(cpuTupleCost+keys*cpuOperatorCost) + leftEst.Rows*keys*cpuOperatorCost + output
+	if cost < bestCost && p.hashable(leftKeys, rightKeys) {
+		best, bestCost = hash, cost
+	}
+	return p.add(best, rows, bestCost), nil
+}
+
+// hashable reports whether keys that compare equal also hash alike. Numbers
+// hash by their value whatever their type, but other values only match one
+// of the same type, while a string, say, compares equal with the date it
+// reads as.
+func (p *planner) hashable(leftKeys, rightKeys []Expr) bool {
+	for idx := range leftKeys {
+		left, right := exprType(leftKeys[idx], p.columnType), exprType(rightKeys[idx], p.columnType)
+		if left != right && !(isNumeric(left) && isNumeric(right)) {
+			return false
+		}
+	}
+	return true
+}
+
+// filter applies conditions to the rows of input.
+func (p *planner) filter(input Operator, conds []Expr) Operator {
+	in := p.estimates[input]
+	return p.add(
+		&Filter{Input: input, Cond: andAll(conds)},
+		in.Rows*p.selectivity(andAll(conds)),
+		in.Cost+in.Rows*float64(len(conds))*cpuOperatorCost,
+	)
+}
+
+// sort orders the rows of input by keys.
+func (p *planner) sort(input Operator, keys []SortKey) Operator {
+	in := p.estimates[input]
+	return p.add(&Sort{Input: input, Keys: keys, MaxRows: p.tx.manager.spillRows}, in.Rows, in.Cost+sortCost(in.Rows, len(keys)))
+}
+
+// sortCost is the cost of comparing the keys of rows to sort them.
+func sortCost(rows float64, keys int) float64 {
+	return rows * math.Log2(math.Max(rows, 2)) * float64(keys) * cpuOperatorCost
+}
+
+// planTable picks how to read a table given its filters: by a full scan, a
+// range of its primary key or a range of one of its indexes, whichever is
+// estimated to cost least. The filters are applied to every row read.
+func (p *planner) planTable(from *fromTable) (Operator, error) {
+
+	rows, pages := from.rows(), from.pages()
+	var best Operator = &TableScan{Tx: p.tx, Table: from.table, Alias: from.alias}
+	bestRows, bestCost := rows, pages*seqPageCost+rows*cpuTupleCost
+
+	positions, err := from.table.primaryKeyIndexes()
+	if err != nil {
+		return nil, err
+	}
+	if keyRange, used := p.keyRange(from, positions); used != nil {
+		fraction := p.selectivity(andAll(used))
+		cost := from.descentCost() + math.Ceil(pages*fraction)*seqPageCost + rows*fraction*cpuTupleCost
+		if cost < bestCost {
+			best = &PrimaryKeyScan{
+				Tx: p.tx, Table: from.table, Alias: from.alias,
+				Prefix: keyRange.Prefix, Low: keyRange.Low, High: keyRange.High,
+			}
+			bestRows, bestCost = rows*fraction, cost
+		}
+	}
+
+	indexes, err := p.tx.TableIndexes(from.table)
+	if err != nil {
+		return nil, err
+	}
+	for _, index := range indexes {
+		positions, err := index.columnIndexes(from.table)
+		if err != nil {
+			return nil, err
+		}
+		keyRange, used := p.keyRange(from, positions)
+		if used == nil {
+			continue
+		}
+		// Every row found is read at random from the table.
+		fraction := p.selectivity(andAll(used))
+		cost := from.descentCost() + rows*fraction*(randomPageCost+cpuTupleCost)
+		if cost < bestCost {
+			best = &IndexScan{
+				Tx: p.tx, Table: from.table, Alias: from.alias, Index: index,
+				Prefix: keyRange.Prefix, Low: keyRange.Low, High: keyRange.High,
+			}
+			bestRows, bestCost = rows*fraction, cost
+		}
+	}
+
+	best = p.add(best, bestRows, bestCost)
+	if len(from.filters) == 0 {
+		return best, nil
+	}
+	// The rows left are estimated from the whole table, as the range read is
+	// among the filters.
+	cond := andAll(from.filters)
+	cost := bestCost + bestRows*float64(len(from.filters))*cpuOperatorCost
+	return p.add(&Filter{Input: best, Cond: cond}, rows*p.selectivity(cond), cost), nil
+}
+
+// keyRange finds the range of keys of the columns at positions, those of the
+// primary key or an index, that the filters of a table limit its rows to:
+// leading columns equal to constants and a range of the next one. It returns
+// the filters the range is taken from, or nil when none limits the leading
+// column.
+func (p *planner) keyRange(from *fromTable, positions []int) (KeyRange, []Expr) {
+
+	keyRange := KeyRange{}
+	used := []Expr{}
+	for _, pos := range positions {
+		fixed := false
+		for _, cond := range from.filters {
+			if column, val, ok := columnEquality(from.table, from.alias, cond); ok && column == pos {
+				keyRange.Prefix = append(keyRange.Prefix, val)
+				used = append(used, cond)
+				fixed = true
+				break
+			}
+		}
+		if fixed {
+			continue
+		}
+
+		for _, cond := range from.filters {
+			column, low, high, ok := columnBounds(from.table, from.alias, cond)
+			if !ok || column != pos {
+				continue
+			}
+			if low != nil && (keyRange.Low == nil || narrower(low, keyRange.Low, true)) {
+				keyRange.Low = low
+			}
+			if high != nil && (keyRange.High == nil || narrower(high, keyRange.High, false)) {
+				keyRange.High = high
+			}
+			used = append(used, cond)
+		}
+		break
+	}
+
+	if len(used) == 0 {
+		return KeyRange{}, nil
+	}
+	return keyRange, used
+}
+
+// narrower reports whether bound a leaves out more values than b, as low
+// bounds or as high bounds.
+func narrower(a, b *Bound, low bool) bool {
+	cmp, err := compareValues(a.Value, b.Value)
+	switch {
+	case err != nil:
+		return false
+	case cmp == 0:
+		return !a.Inclusive && b.Inclusive
+	case low:
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+// columnBounds recognises a column of table compared with constants by <,
+// <=, >, >= or BETWEEN, returning the column's position and the bounds the
+// comparison sets on it, converted to its type. A bound that changes in the
+// conversion becomes inclusive, so that the range holds every row the
+// comparison may hold for.
+func columnBounds(table *Table, alias string, cond Expr) (int, *Bound, *Bound, bool) {
+
+	bound := func(column int, expr Expr, inclusive bool) *Bound {
+		val, err := Eval(expr, emptyScope{})
+		if err != nil || val.IsNull {
+			return nil
+		}
+		converted, err := castValue(val, table.Columns[column])
+		if err != nil {
+			return nil
+		}
+		if cmp, err := compareValues(converted, val); err != nil || cmp != 0 {
+			inclusive = true
+		}
+		return &Bound{Value: converted, Inclusive: inclusive}
+	}
+	columnOf := func(expr Expr) int {
+		ident, ok := expr.(*Identifier)
+		if !ok || (ident.Table != "" && ident.Table != alias) {
+			return -1
+		}
+		return table.ColumnIndex(ident.Name)
+	}
+
+	switch e := cond.(type) {
+	case *BinaryExpr:
+		op, colExpr, valExpr := e.Op, e.Left, e.Right
+		if columnOf(colExpr) < 0 {
+			op, colExpr, valExpr = flippedComparisons[op], e.Right, e.Left
+		}
+		column := columnOf(colExpr)
+		if column < 0 {
+			break
+		}
+		switch op {
+		case "<", "<=":
+			if high := bound(column, valExpr, op == "<="); high != nil {
+				return column, nil, high, true
+			}
+		case ">", ">=":
+			if low := bound(column, valExpr, op == ">="); low != nil {
+				return column, low, nil, true
+			}
+		}
+
+	case *BetweenExpr:
+		column := columnOf(e.Expr)
+		if e.Not || column < 0 {
+			break
+		}
+		low, high := bound(column, e.Low, true), bound(column, e.High, true)
+		if low != nil && high != nil {
+			return column, low, high, true
+		}
+	}
+	return 0, nil, nil, false
+}
+
+// flippedComparisons gives the comparison holding with its operands swapped.
+var flippedComparisons = map[string]string{
+	"=": "=", "<>": "<>", "<": ">", "<=": ">=", ">": "<", ">=": "<=",
+}
+
+// scanOrder returns the columns of the primary key an input is read in the
+// order of, qualified the way the input's columns are.
+func scanOrder(input Operator) []ResultColumn {
+
+	if filter, ok := input.(*Filter); ok {
+		input = filter.Input
+	}
+	var table *Table
+	var alias string
+	switch scan := input.(type) {
+	case *TableScan:
+		table, alias = scan.Table, scan.Alias
+	case *PrimaryKeyScan:
+		table, alias = scan.Table, scan.Alias
+	default:
+		return nil
+	}
+	if alias == "" {
+		alias = table.Name
+	}
+	order := make([]ResultColumn, len(table.PrimaryKeys))
+	for idx, name := range table.PrimaryKeys {
+		order[idx] = ResultColumn{Table: alias, Name: name}
+	}
+	return order
+}
+
+// mergeKeys finds the keys a merge join can use, those pairing the leading
+// primary key columns of two table scans. It returns which keys are used,
+// or nil when the inputs are not both read in the order of a key.
+func mergeKeys(left, right Operator, leftKeys, rightKeys []Expr) []bool {
+
+	leftOrder, rightOrder := scanOrder(left), scanOrder(right)
+	used := make([]bool, len(leftKeys))
+	found := false
+	for pos := 0; pos < len(leftOrder) && pos < len(rightOrder); pos++ {
+		matched := false
+		for idx := range leftKeys {
+			if !used[idx] && namesColumn(leftKeys[idx], leftOrder[pos]) && namesColumn(rightKeys[idx], rightOrder[pos]) {
+				used[idx], matched, found = true, true, true
+				break
+			}
+		}
+		if !matched {
+			break
+		}
+	}
+	if !found {
+		return nil
+	}
+	return used
+}
+
+// namesColumn reports whether expr is a reference to column.
+func namesColumn(expr Expr, column ResultColumn) bool {
+	ident, ok := expr.(*Identifier)
+	return ok && ident.Name == column.Name && (ident.Table == "" || ident.Table == column.Table)
+}
+
+// lookupIndex finds the index, or the primary key when the returned index is
+// nil, with the most leading columns among the right keys of a join on a
+// table. It returns the matching left keys in the order of the indexed
+// columns, or nil keys when no index serves.
+func (tx *Transaction) lookupIndex(from *fromTable, leftKeys, rightKeys []Expr) (*Index, []Expr, error) {
+
+	columns := tableColumns(from.table, from.alias)
+	keyFor := func(pos int) Expr {
+		for idx, key := range rightKeys {
+			if namesColumn(key, columns[pos]) {
+				return leftKeys[idx]
+			}
+		}
+		return nil
+	}
+	leading := func(positions []int) []Expr {
+		keys := []Expr{}
+		for _, pos := range positions {
+			key := keyFor(pos)
+			if key == nil {
+				break
+			}
+			keys = append(keys, key)
+		}
+		return keys
+	}
+
+	var best *Index
+	var bestKeys []Expr
+	if positions, err := from.table.primaryKeyIndexes(); err != nil {
+		return nil, nil, err
+	} else if keys := leading(positions); len(keys) > 0 {
+		bestKeys = keys
+	}
+
+	indexes, err := tx.TableIndexes(from.table)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, index := range indexes {
+		positions, err := index.columnIndexes(from.table)
+		if err != nil {
+			return nil, nil, err
+		}
+		if keys := leading(positions); len(keys) > len(bestKeys) {
+			best, bestKeys = index, keys
+		}
+	}
+	return best, bestKeys, nil
+}
+
+// columnStats returns the statistics of the column expr refers to, or nil
+// when it is not a column of an analyzed table.
+func (p *planner) columnStats(expr Expr) (*ColumnStats, *fromTable) {
+	ident, ok := expr.(*Identifier)
+	if !ok {
+		return nil, nil
+	}
+	pos, err := findColumn(p.columns, ident.Table, ident.Name)
+	if err != nil || p.owners[pos].stats == nil {
+		return nil, nil
+	}
+	return p.owners[pos].stats.Column(p.columns[pos].Name), p.owners[pos]
+}
+
+// selectivity estimates the fraction of rows a condition holds for.
+func (p *planner) selectivity(cond Expr) float64 {
+
+	switch e := cond.(type) {
+	case nil:
+		return 1
+	case *BoolLiteral:
+		if e.Value {
+			return 1
+		}
+		return 0
+	case *UnaryExpr:
+		if e.Op == "NOT" {
+			return 1 - p.selectivity(e.Operand)
+		}
+	case *BinaryExpr:
+		switch e.Op {
+		case "AND":
+			return p.selectivity(e.Left) * p.selectivity(e.Right)
+		case "OR":
+			left, right := p.selectivity(e.Left), p.selectivity(e.Right)
+			return left + right - left*right
+		case "=":
+			return p.equalSelectivity(e.Left, e.Right)
+		case "<>":
+			return 1 - p.equalSelectivity(e.Left, e.Right)
+		case "<", "<=", ">", ">=":
+			return p.rangeSelectivity(e.Op, e.Left, e.Right)
+		}
+	case *IsNullExpr:
+		sel := defaultNullSel
+		if stats, from := p.columnStats(e.Expr); stats != nil && from.stats.Rows > 0 {
+			sel = float64(stats.Nulls) / float64(from.stats.Rows)
+		}
+		if e.Not {
+			return 1 - sel
+		}
+		return sel
+	case *BetweenExpr:
+		sel := p.rangeSelectivity(">=", e.Expr, e.Low) + p.rangeSelectivity("<=", e.Expr, e.High) - 1
+		if stats, _ := p.columnStats(e.Expr); stats == nil {
+			sel = defaultRangeSel * defaultRangeSel
+		}
+		sel = math.Max(sel, 0)
+		if e.Not {
+			return 1 - sel
+		}
+		return sel
+	case *InExpr:
+		sel := 0.0
+		for _, val := range e.List {
+			sel += p.equalSelectivity(e.Expr, val)
+		}
+		sel = math.Min(sel, 1)
+		if e.Not {
+			return 1 - sel
+		}
+		return sel
+	case *LikeExpr:
+		if e.Not {
+			return 1 - defaultMatchSel
+		}
+		return defaultMatchSel
+	}
+	return defaultSel
+}
+
+// constant evaluates an expression that reads no columns.
+func constant(expr Expr) (Value, bool) {
+	reads := false
+	walkExpr(expr, func(expr Expr) {
+		if _, ok := expr.(*Identifier); ok {
+			reads = true
+		}
+	})
+	if reads {
+		return Value{}, false
+	}
+	val, err := Eval(expr, emptyScope{})
+	return val, err == nil
+}
+
+// equalSelectivity estimates the fraction of rows for which two expressions
+// are equal: one in the distinct values of a column compared with a
+// constant, and one in the distinct values of the column with more of them
+// when two columns are compared.
+func (p *planner) equalSelectivity(left, right Expr) float64 {
+
+	leftStats, leftFrom := p.columnStats(left)
+	rightStats, rightFrom := p.columnStats(right)
+	if leftStats == nil {
+		left, right = right, left
+		leftStats, leftFrom, rightStats, rightFrom = rightStats, rightFrom, leftStats, leftFrom
+	}
+	if leftStats == nil {
+		return defaultEqualSel
+	}
+
+	nonNull := func(stats *ColumnStats, from *fromTable) float64 {
+		if from.stats.Rows == 0 {
+			return 1
+		}
+		return 1 - float64(stats.Nulls)/float64(from.stats.Rows)
+	}
+	distinct := math.Max(float64(leftStats.Distinct), 1)
+
+	if rightStats != nil {
+		distinct = math.Max(distinct, float64(rightStats.Distinct))
+		return nonNull(leftStats, leftFrom) * nonNull(rightStats, rightFrom) / distinct
+	}
+
+	if val, ok := constant(right); ok {
+		if val.IsNull {
+			return 0
+		}
+		// A value outside the histogram is not among the column's values.
+		if histogram := leftStats.Histogram; len(histogram) > 0 {
+			low, errLow := compareValues(val, histogram[0])
+			high, errHigh := compareValues(val, histogram[len(histogram)-1])
+			if errLow == nil && errHigh == nil && (low < 0 || high > 0) {
+				return 0
+			}
+		}
+	}
+	return nonNull(leftStats, leftFrom) / distinct
+}
+
+// rangeSelectivity estimates the fraction of rows for which left op right
+// holds, op being <, <=, > or >=, from the histogram of a column compared
+// with a constant.
+func (p *planner) rangeSelectivity(op string, left, right Expr) float64 {
+
+	stats, from := p.columnStats(left)
+	if stats == nil {
+		op, left, right = flippedComparisons[op], right, left
+		stats, from = p.columnStats(left)
+	}
+	if stats == nil {
+		return defaultRangeSel
+	}
+	val, ok := constant(right)
+	if !ok || len(stats.Histogram) == 0 {
+		return defaultRangeSel
+	}
+	if val.IsNull {
+		return 0
+	}
+	below, ok := histogramFraction(stats.Histogram, val)
+	if !ok {
+		return defaultRangeSel
+	}
+
+	equal := 1 / math.Max(float64(stats.Distinct), 1)
+	var sel float64
+	switch op {
+	case "<":
+		sel = below
+	case "<=":
+		sel = below + equal
+	case ">":
+		sel = 1 - below - equal
+	default:
+		sel = 1 - below
+	}
+	sel = math.Min(math.Max(sel, 0), 1)
+	if from.stats.Rows > 0 {
+		sel *= 1 - float64(stats.Nulls)/float64(from.stats.Rows)
+	}
+	return sel
+}
+
+// histogramFraction estimates the fraction of the values a histogram
+// describes that are below val, interpolating within the bucket holding it
+// when the values are numbers.
+func histogramFraction(histogram Row, val Value) (float64, bool) {
+
+	last := len(histogram) - 1
+	if cmp, err := compareValues(val, histogram[0]); err != nil {
+		return 0, false
+	} else if cmp <= 0 {
+		return 0, true
+	}
+	if cmp, err := compareValues(val, histogram[last]); err != nil {
+		return 0, false
+	} else if cmp > 0 {
+		return 1, true
+	}
+
+	bucket := 0
+	for bucket < last-1 {
+		if cmp, _ := compareValues(val, histogram[bucket+1]); cmp <= 0 {
+			break
+		}
+		bucket++
+	}
+	within := 0.5
+	low, high := histogram[bucket], histogram[bucket+1]
+	if isNumeric(val.Type) && isNumeric(low.Type) && numericFloat(high) > numericFloat(low) {
+		within = (numericFloat(val) - numericFloat(low)) / (numericFloat(high) - numericFloat(low))
+	}
+	return (float64(bucket) + within) / float64(last), true
+}
+
+// groupCount estimates the number of groups rows are grouped into by
+// exprs.
+func (p *planner) groupCount(exprs []Expr, rows float64) float64 {
+	groups := 1.0
+	for _, expr := range exprs {
+		if stats, _ := p.columnStats(expr); stats != nil {
+			groups *= math.Max(float64(stats.Distinct), 1)
+		} else {
+			groups *= defaultGroups
+		}
+	}
+	return math.Min(groups, rows)
+}
+
+// andAll joins conditions with AND, returning nil for none.
+func andAll(conds []Expr) Expr {
+	var result Expr
+	for _, cond := range conds {
+		if result == nil {
+			result = cond
+		} else {
+			result = &BinaryExpr{Op: "AND", Left: result, Right: cond}
+		}
+	}
+	return result
+}
+
+// conjuncts splits a condition into the terms joined by AND.
+func conjuncts(expr Expr) []Expr {
+	if binary, ok := expr.(*BinaryExpr); ok && binary.Op == "AND" {
+		return append(conjuncts(binary.Left), conjuncts(binary.Right)...)
+	}
+	if expr == nil {
+		return nil
+	}
+	return []Expr{expr}
+}
+
+// columnEquality recognises a column of table compared equal to a constant,
+// returning the column's position and the constant converted to its type.
+// Constants that cannot be stored in the column, or that change in the
+// conversion, as 7.5 does for an INTEGER, are not recognised, leaving the
+// filter to report the error or compare the original value.
+func columnEquality(table *Table, alias string, cond Expr) (int, Value, bool) {
+
+	binary, ok := cond.(*BinaryExpr)
+	if !ok || binary.Op != "=" {
+		return 0, Value{}, false
+	}
+
+	for _, sides := range [][2]Expr{{binary.Left, binary.Right}, {binary.Right, binary.Left}} {
+		ident, ok := sides[0].(*Identifier)
+		if !ok || (ident.Table != "" && ident.Table != alias) {
+			continue
+		}
+		column := table.ColumnIndex(ident.Name)
+		if column < 0 {
+			continue
+		}
+		val, err := Eval(sides[1], emptyScope{})
+		if err != nil || val.IsNull {
+			continue
+		}
+		converted, err := castValue(val, table.Columns[column])
+		if err != nil {
+			continue
+		}
+		if cmp, err := compareValues(converted, val); err != nil || cmp != 0 {
+			continue
+		}
+		return column, converted, true
+	}
+	return 0, Value{}, false
+}
@@ -0,0 +1,277 @@
+package gopherql
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// openPlannerDB holds 2000 items, in 20 categories with prices equal to their
+// ids and a NULL note on every tenth, and 20 categories, all analyzed.
+func openPlannerDB(t *testing.T, dbFile string) *DB {
+	t.Helper()
+
+	db := openExecutorDB(t, dbFile,
+		"CREATE TABLE items (id INTEGER PRIMARY KEY, category INTEGER, price INTEGER, note TEXT)",
+		"CREATE TABLE categories (id INTEGER PRIMARY KEY, name TEXT)",
+		"CREATE INDEX items_category ON items (category)",
+		"CREATE INDEX items_price ON items (price)",
+	)
+	items, categories := []string{}, []string{}
+	for id := 0; id < 2000; id++ {
+		note := "'n'"
+		if id%10 == 0 {
+			note = "NULL"
+		}
+		items = append(items, fmt.Sprintf("(%d, %d, %d, %s)", id, id%20, id, note))
+	}
+	for id := 0; id < 20; id++ {
+		categories = append(categories, fmt.Sprintf("(%d, 'c%d')", id, id))
+	}
+	for _, sql := range []string{
+		"INSERT INTO items VALUES " + strings.Join(items, ", "),
+		"INSERT INTO categories VALUES " + strings.Join(categories, ", "),
+		"ANALYZE",
+	} {
+		if _, err := db.Exec(sql); err != nil {
+			db.Close()
+			t.Fatalf("unexpected error for %q: %s", sql, err)
+		}
+	}
+	return db
+}
+
+func TestPlanner_Analyze(t *testing.T) {
+	dbFile := "analyzeTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	db := openPlannerDB(t, dbFile)
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := tx.Table("", "ITEMS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := tx.TableStats(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats == nil || stats.Rows != 2000 || stats.Pages < 2 {
+		t.Fatalf("unexpected table statistics: %+v", stats)
+	}
+	for _, test := range []struct {
+		column   string
+		distinct int
+		nulls    int
+		min, max string
+	}{
+		{"ID", 2000, 0, "0", "1999"},
+		{"CATEGORY", 20, 0, "0", "19"},
+		{"NOTE", 1, 200, "n", "n"},
+	} {
+		column := stats.Column(test.column)
+		if column.Distinct != test.distinct || column.Nulls != test.nulls {
+			t.Errorf("unexpected statistics of %s: %+v", test.column, column)
+		}
+		histogram := column.Histogram
+		if len(histogram) == 0 || histogram[0].String() != test.min || histogram[len(histogram)-1].String() != test.max {
+			t.Errorf("unexpected histogram of %s: %v", test.column, histogram)
+		}
+	}
+	if len(stats.Column("ID").Histogram) != histogramBuckets+1 {
+		t.Errorf("expected %d histogram bounds, got: %d", histogramBuckets+1, len(stats.Column("ID").Histogram))
+	}
+
+	// Statistics roll back with the transaction that collected them.
+	if _, err := tx.Exec("INSERT INTO items VALUES (5000, 1, 1, 'n')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("ANALYZE items"); err != nil {
+		t.Fatal(err)
+	}
+	if stats, err = tx.TableStats(items); err != nil || stats.Rows != 2001 {
+		t.Fatalf("unexpected statistics after ANALYZE: %+v, %v", stats, err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec("DROP TABLE categories"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("CREATE TABLE categories (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if stats, err = tx.TableStats(items); err != nil || stats.Rows != 2000 {
+		t.Fatalf("unexpected statistics after rollback: %+v, %v", stats, err)
+	}
+	categories, err := tx.Table("", "CATEGORIES")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats, err = tx.TableStats(categories); err != nil || stats != nil {
+		t.Errorf("expected a dropped table's statistics to be dropped, got: %+v, %v", stats, err)
+	}
+
+	_, err = db.Exec("ANALYZE missing")
+	expectSQLState(t, err, "42P01")
+}
+
+func TestPlanner_Selectivity(t *testing.T) {
+	dbFile := "selectivityTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	db := openPlannerDB(t, dbFile)
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	for _, test := range []struct {
+		cond     string
+		expected float64
+	}{
+		{"category = 3", 0.05},
+		{"category = 30", 0},
+		{"category <> 3", 0.95},
+		{"price < 500", 0.25},
+		{"price >= 1500", 0.25},
+		{"500 > price", 0.25},
+		{"price BETWEEN 100 AND 299", 0.1},
+		{"price < -1", 0},
+		{"note IS NULL", 0.1},
+		{"note IS NOT NULL", 0.9},
+		{"category IN (1, 2, 3)", 0.15},
+		{"category = 3 AND price < 1000", 0.025},
+		{"category = 3 OR category = 4", 0.0975},
+		{"NOT category = 3", 0.95},
+		{"name = 'c1'", 0.05},
+		{"items.category = categories.id", 0.05},
+		{"items.price = categories.id", 0.0005},
+		{"UPPER(note) = 'N'", defaultEqualSel},
+	} {
+		stmt, err := Parse("SELECT 1 FROM items, categories WHERE " + test.cond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := tx.newPlanner()
+		if err := p.resolveFrom(stmt.(*SelectStmt).From, nil); err != nil {
+			t.Fatal(err)
+		}
+		if found := p.selectivity(stmt.(*SelectStmt).Where); math.Abs(found-test.expected) > 0.01 {
+			t.Errorf("unexpected selectivity of %q: %f", test.cond, found)
+		}
+	}
+}
+
+func TestPlanner_Choices(t *testing.T) {
+	dbFile := "plannerTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	db := openPlannerDB(t, dbFile)
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	plan := func(sql string) Operator {
+		t.Helper()
+		stmt, err := Parse(sql)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := tx.planSelect(stmt.(*SelectStmt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan = plan.(*Project).Input
+		if filter, ok := plan.(*Filter); ok {
+			plan = filter.Input
+		}
+		return plan
+	}
+
+	for _, test := range []struct {
+		sql  string
+		scan string
+	}{
+		{"SELECT * FROM items WHERE id = 5", "*gopherql.PrimaryKeyScan"},
+		{"SELECT * FROM items WHERE id BETWEEN 10 AND 20", "*gopherql.PrimaryKeyScan"},
+		{"SELECT * FROM items WHERE id > 1000", "*gopherql.PrimaryKeyScan"},
+		{"SELECT * FROM items WHERE id >= 0", "*gopherql.TableScan"},
+		{"SELECT * FROM items WHERE price < 5", "*gopherql.IndexScan"},
+		{"SELECT * FROM items WHERE price < 1500", "*gopherql.TableScan"},
+		{"SELECT * FROM items WHERE category = 3", "*gopherql.TableScan"},
+		{"SELECT * FROM items WHERE note IS NULL", "*gopherql.TableScan"},
+	} {
+		if found := fmt.Sprintf("%T", plan(test.sql)); found != test.scan {
+			t.Errorf("unexpected scan for %q: %s", test.sql, found)
+		}
+	}
+
+	scan := plan("SELECT * FROM items WHERE id > 10 AND id <= 20.5 AND id > 12").(*PrimaryKeyScan)
+	if scan.Low.Value.IntValue != 12 || scan.Low.Inclusive || scan.High.Value.IntValue != 21 || !scan.High.Inclusive {
+		t.Errorf("unexpected primary key range: %+v, %+v", scan.Low, scan.High)
+	}
+	index := plan("SELECT * FROM items WHERE price >= 1 AND price < 3").(*IndexScan)
+	if index.Index.Name != "ITEMS_PRICE" || index.Low.Value.IntValue != 1 || !index.Low.Inclusive || index.High.Value.IntValue != 3 || index.High.Inclusive {
+		t.Errorf("unexpected index range: %s, %+v, %+v", index.Index.Name, index.Low, index.High)
+	}
+
+	for _, test := range []struct {
+		sql  string
+		join string
+	}{
+		{"SELECT 1 FROM categories c JOIN items i ON i.price = c.id WHERE c.name = 'c3'", "*gopherql.IndexNestedLoopJoin"},
+		{"SELECT 1 FROM items a JOIN items b ON a.id = b.id", "*gopherql.MergeJoin"},
+		{"SELECT 1 FROM items i JOIN categories c ON i.category = c.id", "*gopherql.HashJoin"},
+		{"SELECT 1 FROM categories a, categories b WHERE a.id < b.id", "*gopherql.NestedLoopJoin"},
+		{"SELECT 1 FROM categories c LEFT JOIN items i ON i.price = c.id WHERE c.id < 3", "*gopherql.IndexNestedLoopJoin"},
+		{"SELECT 1 FROM categories c LEFT JOIN items i ON i.price = c.id", "*gopherql.HashJoin"},
+		{"SELECT 1 FROM items i RIGHT JOIN categories c ON i.price = c.id", "*gopherql.HashJoin"},
+	} {
+		if found := fmt.Sprintf("%T", plan(test.sql)); found != test.join {
+			t.Errorf("unexpected join for %q: %s", test.sql, found)
+		}
+	}
+
+	// The category is read first, and the items through their index.
+	sql := "SELECT * FROM items i JOIN categories c ON i.price = c.id WHERE c.name = 'c3'"
+	join := plan(sql).(*Project).Input.(*IndexNestedLoopJoin)
+	if join.Table.Name != "ITEMS" || join.Index.Name != "ITEMS_PRICE" {
+		t.Errorf("unexpected join order: %T joined to %s", join.Left, join.Table.Name)
+	}
+	if found := joinRows(queryRows(t, db, sql)); found != "3,3,3,n,3,c3" {
+		t.Errorf("unexpected result of reordered join: %s", found)
+	}
+
+	// Past maxJoinSearchLen tables the order is searched greedily.
+	tables := []string{"categories c0"}
+	for idx := 1; idx <= maxJoinSearchLen; idx++ {
+		tables = append(tables, fmt.Sprintf("categories c%d ON c%d.id = c%d.id", idx, idx, idx-1))
+	}
+	sql = "SELECT COUNT(*), MAX(c8.name) FROM " + strings.Join(tables, " JOIN ") + " JOIN items ON items.id = c8.id"
+	if found := joinRows(queryRows(t, db, sql)); found != "20,c9" {
+		t.Errorf("unexpected result of a greedily ordered join: %s", found)
+	}
+}
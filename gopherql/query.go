@@ -0,0 +1,591 @@
+package gopherql
+
+import (
+	"fmt"
+	"math"
+)
+
+// Rows is the result of a query, read one row at a time. Rows are produced
+// as they are read, so a result may be larger than memory, and Close must be
+// called once the caller is done with it.
+type Rows struct {
+	Columns []string
+
+	plan Operator
+	// tx is the transaction the query started, finished when Rows is closed.
+	tx     *Transaction
+	closed bool
+}
+
+// Next returns the next row of the result, or nil once there are no more.
+func (r *Rows) Next() (Row, error) {
+	if r.closed {
+		return nil, fmt.Errorf("rows are closed")
+	}
+	return r.plan.Next()
+}
+
+func (r *Rows) Close() error {
+
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+
+	err := r.plan.Close()
+	if r.tx != nil && !r.tx.finished {
+		if rollbackErr := r.tx.Rollback(); err == nil {
+			err = rollbackErr
+		}
+	}
+	return err
+}
+
+// Query runs a SELECT, or EXPLAIN of one, in its own transaction, which lasts until the returned
+// Rows is closed.
+func (db *DB) Query(sql string) (*Rows, error) {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(sql)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	rows.tx = tx
+	return rows, nil
+}
+
+// Query runs a SELECT, or EXPLAIN of one, in the transaction. The
+// transaction must stay active until the returned Rows is closed.
+func (tx *Transaction) Query(sql string) (*Rows, error) {
+
+	if err := tx.checkActive(); err != nil {
+		return nil, err
+	}
+
+	stmt, err := Parse(sql)
+	if err != nil {
+		return nil, err
+	}
+	switch stmt := stmt.(type) {
+	case *SelectStmt:
+		return tx.query(stmt)
+	case *ExplainStmt:
+		return tx.explain(stmt)
+	}
+	return nil, SQLStateError{Code: "42601", Msg: "Query expects a SELECT or EXPLAIN statement"}
+}
+
+func (tx *Transaction) query(stmt *SelectStmt) (*Rows, error) {
+
+	plan, err := tx.planSelect(stmt)
+	if err != nil {
+		return nil, err
+	}
+	if err := plan.Open(); err != nil {
+		plan.Close()
+		return nil, err
+	}
+
+	columns := plan.Columns()
+	names := make([]string, len(columns))
+	for idx, column := range columns {
+		names[idx] = column.Name
+	}
+	return &Rows{Columns: names, plan: plan}, nil
+}
+
+// execQuery reads the rows of a query run by Exec, which reports how many
+// rows it returned.
+func (tx *Transaction) execQuery(rows *Rows, err error) (Result, error) {
+
+	if err != nil {
+		return Result{}, err
+	}
+	defer rows.Close()
+
+	count := 0
+	for {
+		row, err := rows.Next()
+		if err != nil {
+			return Result{}, err
+		}
+		if row == nil {
+			return Result{RowsAffected: count}, rows.Close()
+		}
+		count++
+	}
+}
+
+// planSelect builds the operators of a SELECT.
+func (tx *Transaction) planSelect(stmt *SelectStmt) (Operator, error) {
+	return tx.newPlanner().planSelect(stmt)
+}
+
+// planSelect builds the operators of a SELECT: the scans and joins of the
+// FROM clause, then the filter, the grouping and aggregation, sort, limit
+// and the projection of the select list.
+func (p *planner) planSelect(stmt *SelectStmt) (Operator, error) {
+
+	tx := p.tx
+	tx.bindFunctions(stmt.Where, stmt.Having, stmt.Limit, stmt.Offset)
+	tx.bindFunctions(stmt.GroupBy...)
+	for _, column := range stmt.Columns {
+		tx.bindFunctions(column.Expr)
+	}
+	for _, term := range stmt.OrderBy {
+		tx.bindFunctions(term.Expr)
+	}
+
+	if containsAggregate(stmt.Where) {
+		return nil, misplacedAggregate("WHERE")
+	}
+
+	plan := p.add(&singleRow{}, 1, 0)
+	where := conjuncts(stmt.Where)
+	if stmt.From != nil {
+		if err := p.resolveFrom(stmt.From, where); err != nil {
+			return nil, err
+		}
+		if err := bindColumns(p.columns, stmt.Where); err != nil {
+			return nil, err
+		}
+		if err := tx.checkCalls(p.columnType, stmt.Where); err != nil {
+			return nil, err
+		}
+		var err error
+		if plan, err = p.plan(stmt.From, false); err != nil {
+			return nil, err
+		}
+		where = p.where
+	} else if err := bindColumns(nil, stmt.Where); err != nil {
+		return nil, err
+	} else if err := tx.checkCalls(nil, stmt.Where); err != nil {
+		return nil, err
+	}
+
+	if len(where) > 0 {
+		plan = p.filter(plan, where)
+	}
+
+	input := plan.Columns()
+	exprs, names, err := selectList(stmt.Columns, input)
+	if err != nil {
+		return nil, err
+	}
+	if err := bindColumns(input, exprs...); err != nil {
+		return nil, err
+	}
+	if err := tx.checkCalls(p.columnType, exprs...); err != nil {
+		return nil, err
+	}
+
+	keys := make([]SortKey, len(stmt.OrderBy))
+	for idx, term := range stmt.OrderBy {
+		expr, err := clauseExpr("ORDER BY", term.Expr, exprs, names)
+		if err != nil {
+			return nil, err
+		}
+		if err := bindColumns(input, expr); err != nil {
+			return nil, err
+		}
+		if err := tx.checkCalls(p.columnType, expr); err != nil {
+			return nil, err
+		}
+		keys[idx] = SortKey{Expr: expr, Desc: term.Desc}
+	}
+
+	aggregated := len(stmt.GroupBy) > 0 || stmt.Having != nil
+	for _, expr := range exprs {
+		aggregated = aggregated || containsAggregate(expr)
+	}
+	for _, key := range keys {
+		aggregated = aggregated || containsAggregate(key.Expr)
+	}
+	if aggregated {
+		if plan, exprs, keys, err = p.planAggregate(stmt, plan, exprs, names, keys); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(keys) > 0 {
+		plan = p.sort(plan, keys)
+	}
+
+	if stmt.Limit != nil || stmt.Offset != nil {
+		count, err := limitValue(stmt.Limit, "LIMIT", "2201W")
+		if err != nil {
+			return nil, err
+		}
+		offset, err := limitValue(stmt.Offset, "OFFSET", "2201X")
+		if err != nil {
+			return nil, err
+		}
+		if offset < 0 {
+			offset = 0
+		}
+		in := p.estimates[plan]
+		rows := math.Max(in.Rows-float64(offset), 0)
+		if count >= 0 {
+			rows = math.Min(rows, float64(count))
+		}
+		plan = p.add(&Limit{Input: plan, Count: count, Offset: offset}, rows, in.Cost)
+	}
+
+	in := p.estimates[plan]
+	return p.add(&Project{Input: plan, Exprs: exprs, Names: names}, in.Rows, in.Cost+in.Rows*float64(len(exprs))*cpuOperatorCost), nil
+}
+
+// planAggregate groups the rows of plan by the GROUP BY clause and computes
+// every aggregate of the query. The grouping values and aggregate results
+// come out as columns named $group0, $group1... and $agg0, $agg1..., which
+// the returned select list and sort keys, like the HAVING filter, refer to
+// in place of the expressions they replace.
+//
+// The rows are grouped by a HashAggregate, or by a SortAggregate over rows
+// sorted by their grouping values, whichever is estimated to cost least.
+// When the sort keys name only grouping values, sorting the input by them
+// puts the groups in order too, and the returned keys are nil.
+func (p *planner) planAggregate(stmt *SelectStmt, plan Operator, exprs []Expr, names []ResultColumn, keys []SortKey) (Operator, []Expr, []SortKey, error) {
+
+	input := plan.Columns()
+
+	groupBy := make([]Expr, len(stmt.GroupBy))
+	for idx, term := range stmt.GroupBy {
+		expr, err := clauseExpr("GROUP BY", term, exprs, names)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if containsAggregate(expr) {
+			return nil, nil, nil, misplacedAggregate("GROUP BY")
+		}
+		groupBy[idx] = expr
+	}
+	if err := bindColumns(input, append([]Expr{stmt.Having}, groupBy...)...); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := p.tx.checkCalls(p.columnType, append([]Expr{stmt.Having}, groupBy...)...); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Aggregates written alike are computed once.
+	calls := []AggregateCall{}
+	callColumns := map[string]string{}
+	var err error
+	collect := func(expr Expr) {
+		walkExpr(expr, func(expr Expr) {
+			call, ok := expr.(*FunctionCall)
+			if !ok || !aggregateFunctions[call.Name] || err != nil {
+				return
+			}
+			if _, ok := callColumns[call.String()]; ok {
+				return
+			}
+			for _, arg := range call.Args {
+				if containsAggregate(arg) {
+					err = SQLStateError{Code: "42803", Msg: "aggregate function calls cannot be nested"}
+					return
+				}
+			}
+			var aggregate AggregateCall
+			if aggregate, err = aggregateCall(call); err == nil {
+				callColumns[call.String()] = fmt.Sprintf("$agg%d", len(calls))
+				calls = append(calls, aggregate)
+			}
+		})
+	}
+	for _, expr := range exprs {
+		collect(expr)
+	}
+	collect(stmt.Having)
+	for _, key := range keys {
+		collect(key.Expr)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	columns := []ResultColumn{}
+	for idx := range groupBy {
+		columns = append(columns, ResultColumn{Name: fmt.Sprintf("$group%d", idx)})
+	}
+	for idx := range calls {
+		columns = append(columns, ResultColumn{Name: fmt.Sprintf("$agg%d", idx)})
+	}
+
+	// rewrite replaces grouping values and aggregates by the columns holding
+	// them. Any column reference left over is neither.
+	rewrite := func(expr Expr) (Expr, error) {
+		var err error
+		expr = transformExpr(expr, func(expr Expr) (Expr, bool) {
+			for idx, group := range groupBy {
+				if sameExpr(expr, group, input) {
+					return &Identifier{Name: columns[idx].Name}, true
+				}
+			}
+			switch e := expr.(type) {
+			case *FunctionCall:
+				if name, ok := callColumns[e.String()]; ok {
+					return &Identifier{Name: name}, true
+				}
+			case *Identifier:
+				if err != nil {
+					break
+				}
+				if _, err = findColumn(input, e.Table, e.Name); err == nil {
+					err = SQLStateError{
+						Code: "42803",
+						Msg:  fmt.Sprintf("column %s must appear in the GROUP BY clause or be used in an aggregate function", e),
+					}
+				}
+			}
+			return nil, false
+		})
+		return expr, err
+	}
+
+	grouped := make([]Expr, len(exprs))
+	for idx, expr := range exprs {
+		if grouped[idx], err = rewrite(expr); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	sortKeys := make([]SortKey, len(keys))
+	for idx, key := range keys {
+		if sortKeys[idx].Expr, err = rewrite(key.Expr); err != nil {
+			return nil, nil, nil, err
+		}
+		sortKeys[idx].Desc = key.Desc
+	}
+
+	in := p.estimates[plan]
+	groups := p.groupCount(groupBy, in.Rows)
+	aggregateCost := in.Rows*float64(len(groupBy)+len(calls))*cpuOperatorCost + groups*cpuTupleCost
+
+	hash := &HashAggregate{
+		Input:      plan,
+		GroupBy:    groupBy,
+		Aggregates: calls,
+		Names:      columns,
+		MaxGroups:  p.tx.manager.spillRows,
+	}
+	hashCost := in.Cost + aggregateCost
+	if len(sortKeys) > 0 {
+		hashCost += sortCost(groups, len(sortKeys))
+	}
+	plan = p.add(hash, groups, hashCost)
+
+	if len(groupBy) > 0 {
+		// ordered is set when the groups come out in the order of the sort
+		// keys, or need none.
+		ordered := true
+		inputKeys := groupSortKeys(sortKeys, groupBy, columns)
+		if inputKeys == nil {
+			ordered = len(sortKeys) == 0
+			for _, expr := range groupBy {
+				inputKeys = append(inputKeys, SortKey{Expr: expr})
+			}
+		}
+
+		// Rows read in the order of their grouping values need no sort.
+		var sorted Operator
+		if order := scanOrder(hash.Input); len(inputKeys) <= len(order) {
+			sorted = hash.Input
+			for idx, key := range inputKeys {
+				if key.Desc || !namesColumn(key.Expr, order[idx]) {
+					sorted = nil
+					break
+				}
+			}
+		}
+		if sorted == nil {
+			sorted = p.sort(hash.Input, inputKeys)
+		}
+
+		sortedCost := p.estimates[sorted].Cost + aggregateCost
+		if !ordered {
+			sortedCost += sortCost(groups, len(sortKeys))
+		}
+		if sortedCost < hashCost {
+			plan = p.add(&SortAggregate{Input: sorted, GroupBy: groupBy, Aggregates: calls, Names: columns}, groups, sortedCost)
+			if ordered {
+				sortKeys = nil
+			}
+		}
+	}
+
+	if stmt.Having != nil {
+		having, err := rewrite(stmt.Having)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		plan = p.filter(plan, []Expr{having})
+	}
+	return plan, grouped, sortKeys, nil
+}
+
+// groupSortKeys returns the keys to sort the input of an aggregation by so
+// that its groups come out ordered by keys, which must each name a distinct
+// grouping column. Grouping values the keys leave out follow them. It
+// returns nil when keys order the groups any other way, or not at all.
+func groupSortKeys(keys []SortKey, groupBy []Expr, columns []ResultColumn) []SortKey {
+
+	if len(keys) == 0 || len(groupBy) == 0 {
+		return nil
+	}
+
+	used := make([]bool, len(groupBy))
+	inputKeys := []SortKey{}
+	for _, key := range keys {
+		ident, ok := key.Expr.(*Identifier)
+		if !ok || ident.Table != "" {
+			return nil
+		}
+		pos, err := findColumn(columns[:len(groupBy)], "", ident.Name)
+		if err != nil || used[pos] {
+			return nil
+		}
+		used[pos] = true
+		inputKeys = append(inputKeys, SortKey{Expr: groupBy[pos], Desc: key.Desc})
+	}
+	for pos, expr := range groupBy {
+		if !used[pos] {
+			inputKeys = append(inputKeys, SortKey{Expr: expr})
+		}
+	}
+	return inputKeys
+}
+
+// aggregateCall converts a call of an aggregate function for an operator.
+func aggregateCall(call *FunctionCall) (AggregateCall, error) {
+
+	aggregate := AggregateCall{Func: call.Name, Distinct: call.Distinct}
+	if len(call.Args) == 1 {
+		if _, ok := call.Args[0].(*Star); !ok {
+			aggregate.Arg = call.Args[0]
+			return aggregate, nil
+		}
+		if call.Name == "COUNT" && !call.Distinct {
+			return aggregate, nil
+		}
+	}
+	return AggregateCall{}, SQLStateError{Code: "42883", Msg: fmt.Sprintf("function %s does not exist", call)}
+}
+
+func containsAggregate(expr Expr) bool {
+	found := false
+	walkExpr(expr, func(expr Expr) {
+		if call, ok := expr.(*FunctionCall); ok && aggregateFunctions[call.Name] {
+			found = true
+		}
+	})
+	return found
+}
+
+// sameExpr reports whether two expressions compute the same value, taking
+// column references to the same input column to be the same.
+func sameExpr(a, b Expr, columns []ResultColumn) bool {
+	identA, okA := a.(*Identifier)
+	identB, okB := b.(*Identifier)
+	if okA && okB {
+		posA, errA := findColumn(columns, identA.Table, identA.Name)
+		posB, errB := findColumn(columns, identB.Table, identB.Name)
+		return errA == nil && errB == nil && posA == posB
+	}
+	return a.String() == b.String()
+}
+
+// selectList expands the stars of a select list and names its columns.
+func selectList(columns []SelectColumn, input []ResultColumn) ([]Expr, []ResultColumn, error) {
+
+	exprs, names := []Expr{}, []ResultColumn{}
+	for _, column := range columns {
+		star, ok := column.Expr.(*Star)
+		if !ok {
+			exprs = append(exprs, column.Expr)
+			names = append(names, ResultColumn{Name: columnName(column)})
+			continue
+		}
+
+		if len(input) == 0 {
+			return nil, nil, SQLStateError{Code: "42601", Msg: "SELECT * with no tables specified is not valid"}
+		}
+		found := false
+		for _, in := range input {
+			if star.Table == "" || star.Table == in.Table {
+				exprs = append(exprs, &Identifier{Table: in.Table, Name: in.Name})
+				names = append(names, in)
+				found = true
+			}
+		}
+		if !found {
+			return nil, nil, SQLStateError{Code: "42P01", Msg: fmt.Sprintf("missing FROM-clause entry for table %s", quoteIdentifier(star.Table))}
+		}
+	}
+	return exprs, names, nil
+}
+
+// columnName names a column of the result the way PostgreSQL does.
+func columnName(column SelectColumn) string {
+	if column.Alias != "" {
+		return column.Alias
+	}
+	switch e := column.Expr.(type) {
+	case *Identifier:
+		return e.Name
+	case *FunctionCall:
+		return e.Name
+	}
+	return "?column?"
+}
+
+// clauseExpr resolves an ORDER BY or GROUP BY term, which may name a column
+// of the select list by alias or by its position.
+func clauseExpr(clause string, term Expr, exprs []Expr, names []ResultColumn) (Expr, error) {
+
+	switch e := term.(type) {
+	case *IntegerLiteral:
+		if e.Value < 1 || e.Value > int64(len(exprs)) {
+			return nil, SQLStateError{Code: "42P10", Msg: fmt.Sprintf("%s position %d is not in select list", clause, e.Value)}
+		}
+		return exprs[e.Value-1], nil
+
+	case *Identifier:
+		if e.Table != "" {
+			break
+		}
+		for idx, name := range names {
+			if name.Table == "" && name.Name == e.Name {
+				return exprs[idx], nil
+			}
+		}
+	}
+	return term, nil
+}
+
+// limitValue evaluates a LIMIT or OFFSET, returning -1 when it is absent or
+// NULL.
+func limitValue(expr Expr, clause string, negativeCode string) (int64, error) {
+
+	if expr == nil {
+		return -1, nil
+	}
+	val, err := Eval(expr, emptyScope{})
+	if err != nil {
+		return 0, err
+	}
+	if val.IsNull {
+		return -1, nil
+	}
+	if !isInteger(val.Type) {
+		return 0, datatypeMismatch("argument of %s must be type BIGINT, not type %s", clause, val.Type)
+	}
+	if val.IntValue < 0 {
+		return 0, SQLStateError{Code: negativeCode, Msg: clause + " must not be negative"}
+	}
+	return val.IntValue, nil
+}
@@ -0,0 +1,390 @@
+package gopherql
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+// Rows are stored in the table's own Btree under 'R' followed by the primary
+// key values in key encoding, so that the bytes.Compare order of two keys
+// matches the SQL order of their primary keys. The prefix keeps rows apart
+// from the pieces of large values, which the Btree stores under 'B' and 'F'.
+// The value holds the remaining columns behind a null bitmap.
+const rowKeyPrefix = 'R'
+
+// rowPrefix is the key every row of the table starts with.
+func (t *Table) rowPrefix() []byte {
+	return []byte{rowKeyPrefix}
+}
+
+// rowRange returns the key range holding every row of the table.
+func (t *Table) rowRange() ([]byte, []byte) {
+	start := t.rowPrefix()
+	return start, prefixEnd(start)
+}
+
+// primaryKeyIndexes returns the column positions of the primary key, in key
+// order.
+func (t *Table) primaryKeyIndexes() ([]int, error) {
+
+	indexes := make([]int, len(t.PrimaryKeys))
+	for idx, name := range t.PrimaryKeys {
+		indexes[idx] = t.ColumnIndex(name)
+		if indexes[idx] < 0 {
+			return nil, SQLStateError{Code: "42703", Msg: fmt.Sprintf("column %s does not exist", quoteIdentifier(name))}
+		}
+	}
+	return indexes, nil
+}
+
+// valueIndexes returns the positions of the columns stored in the row value,
+// which is every column that is not part of the primary key.
+func (t *Table) valueIndexes(keyIndexes []int) []int {
+
+	inKey := make(map[int]bool, len(keyIndexes))
+	for _, idx := range keyIndexes {
+		inKey[idx] = true
+	}
+
+	indexes := []int{}
+	for idx := range t.Columns {
+		if !inKey[idx] {
+			indexes = append(indexes, idx)
+		}
+	}
+	return indexes
+}
+
+func (t *Table) checkRow(row Row) error {
+
+	if len(row) != len(t.Columns) {
+		return fmt.Errorf("row has %d values, table %s has %d columns", len(row), t.Name, len(t.Columns))
+	}
+	for idx, c := range t.Columns {
+		if err := c.checkValue(row[idx]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkValue reports a value that does not have the column's type or does
+// not fit its parameters.
+func (c *Column) checkValue(v Value) error {
+
+	if v.Type != c.Type {
+		return SQLStateError{
+			Code: "42804",
+			Msg:  fmt.Sprintf("column %s is of type %s but value is of type %s", quoteIdentifier(c.Name), c.TypeName(), v.Type),
+		}
+	}
+	if v.IsNull {
+		return nil
+	}
+
+	switch c.Type {
+	case StringColumn, CharColumn:
+		if c.Size > 0 && utf8.RuneCountInString(v.StringValue) > c.Size {
+			return SQLStateError{
+				Code: "22001",
+				Msg:  fmt.Sprintf("value too long for type %s", c.TypeName()),
+			}
+		}
+	case DecimalColumn:
+		if v.Scale != c.Scale {
+			return fmt.Errorf("decimal of scale %d stored in column %s of scale %d", v.Scale, quoteIdentifier(c.Name), c.Scale)
+		}
+		limit := int64(1)
+		for idx := 0; idx < c.Precision; idx++ {
+			limit *= 10
+		}
+		if v.IntValue >= limit || v.IntValue <= -limit {
+			return SQLStateError{
+				Code: "22003",
+				Msg:  fmt.Sprintf("numeric field overflow: %s does not fit %s", v, c.TypeName()),
+			}
+		}
+	}
+	return nil
+}
+
+// EncodeRow returns the Btree key and value for a row of the table.
+func (t *Table) EncodeRow(row Row) ([]byte, []byte, error) {
+
+	if err := t.checkRow(row); err != nil {
+		return nil, nil, err
+	}
+	keyIndexes, err := t.primaryKeyIndexes()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key := t.rowPrefix()
+	for _, idx := range keyIndexes {
+		if row[idx].IsNull {
+			return nil, nil, SQLStateError{
+				Code: "23502",
+				Msg:  fmt.Sprintf("null value in column %s violates not-null constraint", quoteIdentifier(t.Columns[idx].Name)),
+			}
+		}
+		key = appendKeyValue(key, row[idx])
+	}
+
+	return key, encodeValues(row, t.valueIndexes(keyIndexes)), nil
+}
+
+// DecodeRow rebuilds a row from a key and value written by EncodeRow.
+func (t *Table) DecodeRow(key, value []byte) (Row, error) {
+
+	keyIndexes, err := t.primaryKeyIndexes()
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := t.rowPrefix()
+	if len(key) < len(prefix) || string(key[:len(prefix)]) != string(prefix) {
+		return nil, fmt.Errorf("key does not belong to table %s", t.Name)
+	}
+
+	row := make(Row, len(t.Columns))
+	rest := key[len(prefix):]
+	for _, idx := range keyIndexes {
+		row[idx], rest, err = readKeyValue(rest, t.Columns[idx])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := decodeValues(value, row, t.Columns, t.valueIndexes(keyIndexes)); err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+// valueEncoding groups the column types that share a key and value encoding.
+type valueEncoding uint8
+
+const (
+	encodeAsInt valueEncoding = iota
+	encodeAsFloat
+	encodeAsBool
+	encodeAsBytes
+)
+
+func encodingOf(columnType ColumnType) valueEncoding {
+	switch columnType {
+	case Float32Column, Float64Column:
+		return encodeAsFloat
+	case BoolColumn:
+		return encodeAsBool
+	case StringColumn, CharColumn, BytesColumn:
+		return encodeAsBytes
+	}
+	return encodeAsInt
+}
+
+// newColumnValue returns a non-null value of the column's type.
+func newColumnValue(c *Column, intValue int64, floatValue float64, boolValue bool, stringValue string) Value {
+	v := Value{Type: c.Type, IntValue: intValue, FloatValue: floatValue, BoolValue: boolValue, StringValue: stringValue}
+	if c.Type == DecimalColumn {
+		v.Scale = c.Scale
+	}
+	return v
+}
+
+// appendKeyValue appends the order-preserving encoding of a non-null value.
+// Integers are big endian with the sign bit flipped, so negative numbers
+// sort first. Floats flip the sign bit of positive numbers and every bit of
+// negative ones. Strings escape 0x00 as 0x00 0xFF and end with 0x00 0x01, so
+// a string sorts before every longer string it prefixes and the values after
+// it in a composite key cannot affect the order.
+func appendKeyValue(key []byte, v Value) []byte {
+
+	var buf [8]byte
+	switch encodingOf(v.Type) {
+	case encodeAsInt:
+		binary.BigEndian.PutUint64(buf[:], uint64(v.IntValue)^(1<<63))
+		return append(key, buf[:]...)
+	case encodeAsFloat:
+		binary.BigEndian.PutUint64(buf[:], floatKeyBits(v.FloatValue))
+		return append(key, buf[:]...)
+	case encodeAsBool:
+		if v.BoolValue {
+			return append(key, 1)
+		}
+		return append(key, 0)
+	default:
+		for idx := 0; idx < len(v.StringValue); idx++ {
+			b := v.StringValue[idx]
+			key = append(key, b)
+			if b == 0 {
+				key = append(key, 0xFF)
+			}
+		}
+		return append(key, 0, 1)
+	}
+}
+
+func floatKeyBits(val float64) uint64 {
+	if val == 0 {
+		val = 0 // -0 and 0 are the same key
+	}
+	bits := math.Float64bits(val)
+	if bits&(1<<63) != 0 {
+		return ^bits
+	}
+	return bits | 1<<63
+}
+
+func readKeyValue(key []byte, c *Column) (Value, []byte, error) {
+
+	truncated := fmt.Errorf("truncated key")
+
+	switch encodingOf(c.Type) {
+	case encodeAsInt:
+		if len(key) < 8 {
+			return Value{}, nil, truncated
+		}
+		return newColumnValue(c, int64(binary.BigEndian.Uint64(key)^(1<<63)), 0, false, ""), key[8:], nil
+	case encodeAsFloat:
+		if len(key) < 8 {
+			return Value{}, nil, truncated
+		}
+		bits := binary.BigEndian.Uint64(key)
+		if bits&(1<<63) != 0 {
+			bits &^= 1 << 63
+		} else {
+			bits = ^bits
+		}
+		return newColumnValue(c, 0, math.Float64frombits(bits), false, ""), key[8:], nil
+	case encodeAsBool:
+		if len(key) < 1 {
+			return Value{}, nil, truncated
+		}
+		return newColumnValue(c, 0, 0, key[0] == 1, ""), key[1:], nil
+	default:
+		str := []byte{}
+		for idx := 0; idx+1 < len(key); idx++ {
+			if key[idx] != 0 {
+				str = append(str, key[idx])
+				continue
+			}
+			idx++
+			switch key[idx] {
+			case 0xFF:
+				str = append(str, 0)
+			case 1:
+				return newColumnValue(c, 0, 0, false, string(str)), key[idx+1:], nil
+			default:
+				return Value{}, nil, fmt.Errorf("invalid escape in key")
+			}
+		}
+		return Value{}, nil, truncated
+	}
+}
+
+// encodeValues writes a bitmap with a bit set for every null column, followed
+// by the non-null columns: integers as varints, floats as their IEEE 754
+// bits, booleans as a byte and strings as a length prefixed run of bytes.
+func encodeValues(row Row, indexes []int) []byte {
+
+	value := make([]byte, (len(indexes)+7)/8)
+	for bit, idx := range indexes {
+		if row[idx].IsNull {
+			value[bit/8] |= 1 << (bit % 8)
+		}
+	}
+
+	var buf [binary.MaxVarintLen64]byte
+	for _, idx := range indexes {
+		v := row[idx]
+		if v.IsNull {
+			continue
+		}
+		switch encodingOf(v.Type) {
+		case encodeAsInt:
+			n := binary.PutVarint(buf[:], v.IntValue)
+			value = append(value, buf[:n]...)
+		case encodeAsFloat:
+			if v.Type == Float32Column {
+				binary.BigEndian.PutUint32(buf[:], math.Float32bits(float32(v.FloatValue)))
+				value = append(value, buf[:4]...)
+			} else {
+				binary.BigEndian.PutUint64(buf[:], math.Float64bits(v.FloatValue))
+				value = append(value, buf[:8]...)
+			}
+		case encodeAsBool:
+			if v.BoolValue {
+				value = append(value, 1)
+			} else {
+				value = append(value, 0)
+			}
+		default:
+			n := binary.PutUvarint(buf[:], uint64(len(v.StringValue)))
+			value = append(value, buf[:n]...)
+			value = append(value, v.StringValue...)
+		}
+	}
+	return value
+}
+
+func decodeValues(value []byte, row Row, columns Columns, indexes []int) error {
+
+	truncated := fmt.Errorf("truncated row value")
+
+	bitmapSize := (len(indexes) + 7) / 8
+	if len(value) < bitmapSize {
+		return truncated
+	}
+	bitmap, rest := value[:bitmapSize], value[bitmapSize:]
+
+	for bit, idx := range indexes {
+		c := columns[idx]
+		if bitmap[bit/8]&(1<<(bit%8)) != 0 {
+			row[idx] = NewNullValue(c.Type)
+			continue
+		}
+
+		switch encodingOf(c.Type) {
+		case encodeAsInt:
+			val, n := binary.Varint(rest)
+			if n <= 0 {
+				return truncated
+			}
+			row[idx] = newColumnValue(c, val, 0, false, "")
+			rest = rest[n:]
+		case encodeAsFloat:
+			var val float64
+			if c.Type == Float32Column {
+				if len(rest) < 4 {
+					return truncated
+				}
+				val = float64(math.Float32frombits(binary.BigEndian.Uint32(rest)))
+				rest = rest[4:]
+			} else {
+				if len(rest) < 8 {
+					return truncated
+				}
+				val = math.Float64frombits(binary.BigEndian.Uint64(rest))
+				rest = rest[8:]
+			}
+			row[idx] = newColumnValue(c, 0, val, false, "")
+		case encodeAsBool:
+			if len(rest) < 1 {
+				return truncated
+			}
+			row[idx] = newColumnValue(c, 0, 0, rest[0] == 1, "")
+			rest = rest[1:]
+		default:
+			size, n := binary.Uvarint(rest)
+			if n <= 0 || uint64(len(rest)-n) < size {
+				return truncated
+			}
+			row[idx] = newColumnValue(c, 0, 0, false, string(rest[n:n+int(size)]))
+			rest = rest[n+int(size):]
+		}
+	}
+	return nil
+}
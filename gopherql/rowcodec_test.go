@@ -0,0 +1,184 @@
+package gopherql
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRowCodec_RoundTrip(t *testing.T) {
+
+	table := &Table{
+		Name: "USERS",
+		Columns: Columns{
+			{Name: "NAME", Type: StringColumn},
+			{Name: "ID", Type: Int64Column, NotNull: true},
+			{Name: "ACTIVE", Type: BoolColumn},
+			{Name: "EMAIL", Type: StringColumn},
+		},
+		PrimaryKeys: PrimaryKeys{"ID", "NAME"},
+	}
+
+	row := Row{NewStringValue("a\x00b"), NewInt64Value(-42), NewNullValue(BoolColumn), NewStringValue("a@b.c")}
+	key, value, err := table.EncodeRow(row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(key, table.rowPrefix()) {
+		t.Errorf("expected key to start with the table prefix, got: %v", key)
+	}
+
+	decoded, err := table.DecodeRow(key, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for idx := range row {
+		if decoded[idx] != row[idx] {
+			t.Errorf("column %d: expected %v, got: %v", idx, row[idx], decoded[idx])
+		}
+	}
+
+	_, _, err = table.EncodeRow(Row{NewNullValue(StringColumn), NewInt64Value(1), NewBoolValue(true), NewStringValue("")})
+	if sqlErr, ok := err.(SQLStateError); !ok || sqlErr.Code != "23502" {
+		t.Errorf("expected not null violation, got: %v", err)
+	}
+
+	_, _, err = table.EncodeRow(Row{NewInt64Value(1), NewInt64Value(1), NewBoolValue(true), NewStringValue("")})
+	if sqlErr, ok := err.(SQLStateError); !ok || sqlErr.Code != "42804" {
+		t.Errorf("expected datatype mismatch, got: %v", err)
+	}
+}
+
+func TestRowCodec_KeyOrder(t *testing.T) {
+
+	ints := &Table{
+		Name:        "INTS",
+		Columns:     Columns{{Name: "ID", Type: Int64Column}},
+		PrimaryKeys: PrimaryKeys{"ID"},
+	}
+	intValues := []int64{math.MinInt64, -1000, -1, 0, 1, 255, 256, math.MaxInt64}
+
+	var previous []byte
+	for _, val := range intValues {
+		key, _, err := ints.EncodeRow(Row{NewInt64Value(val)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if previous != nil && bytes.Compare(previous, key) >= 0 {
+			t.Errorf("expected key for %d to sort after the previous key", val)
+		}
+		previous = key
+	}
+
+	// Composite keys: the first column decides before the second is looked at.
+	strs := &Table{
+		Name:        "STRS",
+		Columns:     Columns{{Name: "A", Type: StringColumn}, {Name: "B", Type: StringColumn}},
+		PrimaryKeys: PrimaryKeys{"A", "B"},
+	}
+	strValues := [][2]string{{"", "z"}, {"a", "z"}, {"a\x00", ""}, {"a\x00a", ""}, {"ab", ""}, {"b", ""}}
+
+	previous = nil
+	for _, val := range strValues {
+		key, _, err := strs.EncodeRow(Row{NewStringValue(val[0]), NewStringValue(val[1])})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if previous != nil && bytes.Compare(previous, key) >= 0 {
+			t.Errorf("expected key for %q to sort after the previous key", val)
+		}
+		previous = key
+	}
+}
+
+func TestRowCodec_ColumnTypes(t *testing.T) {
+
+	at := time.Date(2021, 3, 14, 15, 9, 26, 535897000, time.FixedZone("", 3600))
+	table := &Table{
+		Name: "TYPES",
+		Columns: Columns{
+			{Name: "A", Type: Int16Column},
+			{Name: "B", Type: Int32Column},
+			{Name: "C", Type: Float32Column},
+			{Name: "D", Type: Float64Column},
+			{Name: "E", Type: DecimalColumn, Precision: 6, Scale: 2},
+			{Name: "F", Type: DateColumn},
+			{Name: "G", Type: TimeColumn},
+			{Name: "H", Type: TimestampColumn},
+			{Name: "I", Type: TimestampTzColumn},
+			{Name: "J", Type: CharColumn, Size: 2},
+			{Name: "K", Type: BytesColumn},
+		},
+		PrimaryKeys: PrimaryKeys{"D", "E", "I"},
+	}
+
+	row := Row{
+		NewInt16Value(-7), NewInt32Value(1 << 20), NewFloat32Value(1.5), NewFloat64Value(-2.25),
+		NewDecimalValue(-12345, 2), NewDateValue(at), NewTimeValue(at), NewTimestampValue(at),
+		NewTimestampTzValue(at), NewCharValue("ab"), NewBytesValue([]byte{0, 1, 0xFF}),
+	}
+	key, value, err := table.EncodeRow(row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := table.DecodeRow(key, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for idx := range row {
+		if decoded[idx] != row[idx] {
+			t.Errorf("column %d: expected %v, got: %v", idx, row[idx], decoded[idx])
+		}
+	}
+
+	expected := []string{
+		"-7", "1048576", "1.5", "-2.25", "-123.45", "2021-03-14", "15:09:26.535897",
+		"2021-03-14 15:09:26.535897", "2021-03-14 14:09:26.535897+00", "ab", `\x0001ff`,
+	}
+	for idx, str := range expected {
+		if decoded[idx].String() != str {
+			t.Errorf("column %d: expected %s, got: %s", idx, str, decoded[idx])
+		}
+	}
+
+	row[9] = NewCharValue("abc")
+	_, _, err = table.EncodeRow(row)
+	if sqlErr, ok := err.(SQLStateError); !ok || sqlErr.Code != "22001" {
+		t.Errorf("expected string too long, got: %v", err)
+	}
+	row[9] = NewCharValue("ab")
+
+	row[4] = NewDecimalValue(1000000, 2)
+	_, _, err = table.EncodeRow(row)
+	if sqlErr, ok := err.(SQLStateError); !ok || sqlErr.Code != "22003" {
+		t.Errorf("expected numeric overflow, got: %v", err)
+	}
+}
+
+func TestRowCodec_FloatKeyOrder(t *testing.T) {
+
+	table := &Table{
+		Name:        "FLOATS",
+		Columns:     Columns{{Name: "ID", Type: Float64Column}},
+		PrimaryKeys: PrimaryKeys{"ID"},
+	}
+
+	var previous []byte
+	for _, val := range []float64{math.Inf(-1), -1e300, -1, -1e-300, 0, 1e-300, 1, 1e300, math.Inf(1)} {
+		key, _, err := table.EncodeRow(Row{NewFloat64Value(val)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if previous != nil && bytes.Compare(previous, key) >= 0 {
+			t.Errorf("expected key for %g to sort after the previous key", val)
+		}
+		previous = key
+	}
+
+	negativeZero, _, _ := table.EncodeRow(Row{NewFloat64Value(math.Copysign(0, -1))})
+	zero, _, _ := table.EncodeRow(Row{NewFloat64Value(0)})
+	if !bytes.Equal(negativeZero, zero) {
+		t.Errorf("expected -0 and 0 to share a key")
+	}
+}
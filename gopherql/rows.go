@@ -0,0 +1,263 @@
+package gopherql
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// RowIterator walks the rows of a table visible to a transaction, in primary
+// key order.
+type RowIterator struct {
+	table *Table
+	it    *Iterator
+}
+
+func (tx *Transaction) ScanRows(table *Table) (*RowIterator, error) {
+
+	start, end := table.rowRange()
+	it, err := tx.tree(table.RootPage).Scan(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return &RowIterator{table: table, it: it}, nil
+}
+
+// ScanPrimaryKey returns the rows whose leading primary key columns equal
+// prefix, in primary key order. The values of prefix must have the types of
+// the columns.
+func (tx *Transaction) ScanPrimaryKey(table *Table, prefix Row) (*RowIterator, error) {
+	return tx.ScanPrimaryKeyRange(table, KeyRange{Prefix: prefix})
+}
+
+// KeyRange selects the keys whose leading columns equal Prefix and whose
+// next column lies between Low and High, either of which may be nil to
+// leave that end open. The values must have the types of the columns.
+type KeyRange struct {
+	Prefix Row
+	Low    *Bound
+	High   *Bound
+}
+
+// Bound is an end of a KeyRange.
+type Bound struct {
+	Value     Value
+	Inclusive bool
+}
+
+// keys returns the start and end of the range as keys of a B-tree beginning
+// with prefix, encoding each value with encode. A NULL column sorts after
+// every value, which a bounded range leaves out when nonNull is given.
+func (r KeyRange) keys(prefix []byte, encode func(key []byte, val Value) []byte, nonNull []byte) ([]byte, []byte) {
+
+	for _, val := range r.Prefix {
+		prefix = encode(prefix, val)
+	}
+	start, end := prefix, prefixEnd(prefix)
+	if nonNull != nil && (r.Low != nil || r.High != nil) {
+		start = append(append([]byte{}, prefix...), nonNull...)
+		end = prefixEnd(start)
+	}
+
+	if r.Low != nil {
+		start = encode(append([]byte{}, prefix...), r.Low.Value)
+		if !r.Low.Inclusive {
+			start = prefixEnd(start)
+		}
+	}
+	if r.High != nil {
+		end = encode(append([]byte{}, prefix...), r.High.Value)
+		if r.High.Inclusive {
+			end = prefixEnd(end)
+		}
+	}
+	return start, end
+}
+
+// ScanPrimaryKeyRange returns the rows whose primary key lies in keyRange, in
+// primary key order.
+func (tx *Transaction) ScanPrimaryKeyRange(table *Table, keyRange KeyRange) (*RowIterator, error) {
+
+	columns := len(keyRange.Prefix)
+	if keyRange.Low != nil || keyRange.High != nil {
+		columns++
+	}
+	if columns > len(table.PrimaryKeys) {
+		return nil, fmt.Errorf("primary key of %s has %d columns, got %d values", table.Name, len(table.PrimaryKeys), columns)
+	}
+
+	start, end := keyRange.keys(table.rowPrefix(), appendKeyValue, nil)
+	it, err := tx.tree(table.RootPage).Scan(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return &RowIterator{table: table, it: it}, nil
+}
+
+// Next returns the stored version of the next row along with its decoded
+// values. Both are nil once the table is exhausted.
+func (r *RowIterator) Next() (*PageObject, Row, error) {
+
+	obj, err := r.it.Next()
+	if err != nil || obj == nil {
+		return nil, nil, err
+	}
+
+	row, err := r.table.DecodeRow(obj.Key, obj.Value)
+	if err != nil {
+		return nil, nil, err
+	}
+	return obj, row, nil
+}
+
+// assignValue converts a value for storage in column c.
+func assignValue(c *Column, v Value) (Value, error) {
+
+	converted, err := castValue(v, c)
+	if sqlErr, ok := err.(SQLStateError); ok && sqlErr.Code == "42804" {
+		return Value{}, datatypeMismatch("column %s is of type %s but expression is of type %s", quoteIdentifier(c.Name), c.TypeName(), v.Type)
+	}
+	return converted, err
+}
+
+// defaultValue evaluates the DEFAULT of a column, which is NULL when the
+// column has none.
+func (tx *Transaction) defaultValue(c *Column) (Value, error) {
+
+	if c.Default == "" {
+		return NewNullValue(c.Type), nil
+	}
+
+	expr, err := ParseExpr(c.Default)
+	if err != nil {
+		return Value{}, err
+	}
+	tx.bindFunctions(expr)
+	val, err := Eval(expr, emptyScope{})
+	if err != nil {
+		return Value{}, err
+	}
+	return assignValue(c, val)
+}
+
+// checkConstraints rejects a row with a NULL in a NOT NULL column, or which
+// makes a CHECK constraint false. A CHECK that evaluates to NULL passes.
+func (t *Table) checkConstraints(row Row) error {
+
+	for idx, c := range t.Columns {
+		if c.NotNull && row[idx].IsNull {
+			return SQLStateError{
+				Code: "23502",
+				Msg: fmt.Sprintf("null value in column %s of relation %s violates not-null constraint",
+					quoteIdentifier(c.Name), quoteIdentifier(t.Name)),
+			}
+		}
+	}
+
+	for _, check := range t.Checks {
+		expr, err := ParseExpr(check.Expr)
+		if err != nil {
+			return err
+		}
+		result, unknown, err := EvalCondition(expr, rowScope{table: t, row: row})
+		if err != nil {
+			return err
+		}
+		if !result && !unknown {
+			return SQLStateError{
+				Code: "23514",
+				Msg: fmt.Sprintf("new row for relation %s violates check constraint %s",
+					quoteIdentifier(t.Name), quoteIdentifier(check.Name)),
+			}
+		}
+	}
+	return nil
+}
+
+// primaryKeyName names the constraint the primary key is enforced as.
+func (t *Table) primaryKeyName() string {
+	return t.Name + "_PKEY"
+}
+
+// nextRowKey gives a row of a table without a primary key a key of its own,
+// made from the transaction ID and a counter, so rows keep insertion order.
+func (tx *Transaction) nextRowKey(prefix []byte) []byte {
+
+	tx.rowSequence++
+
+	var buf [8]byte
+	binary.BigEndian.PutUint32(buf[:4], uint32(tx.ID))
+	binary.BigEndian.PutUint32(buf[4:], uint32(tx.rowSequence))
+	return append(prefix, buf[:]...)
+}
+
+// InsertRow checks a row against the table's constraints and stores it along
+// with its index entries.
+func (tx *Transaction) InsertRow(table *Table, row Row) error {
+
+	if err := table.checkConstraints(row); err != nil {
+		return err
+	}
+
+	key, value, err := table.EncodeRow(row)
+	if err != nil {
+		return err
+	}
+	rows := tx.tree(table.RootPage)
+	if len(table.PrimaryKeys) == 0 {
+		key = tx.nextRowKey(key)
+	} else if err := rows.checkUnique(key, table.primaryKeyName()); err != nil {
+		return err
+	}
+
+	if err := tx.updateIndexes(table, nil, nil, row, key); err != nil {
+		return err
+	}
+	return rows.Add(key, value)
+}
+
+// UpdateRow replaces the stored row obj, as returned by a RowIterator, with
+// row.
+func (tx *Transaction) UpdateRow(table *Table, obj *PageObject, row Row) error {
+
+	oldRow, err := table.DecodeRow(obj.Key, obj.Value)
+	if err != nil {
+		return err
+	}
+	if err := table.checkConstraints(row); err != nil {
+		return err
+	}
+
+	key, value, err := table.EncodeRow(row)
+	if err != nil {
+		return err
+	}
+	rows := tx.tree(table.RootPage)
+	if len(table.PrimaryKeys) == 0 {
+		key = obj.Key
+	} else if !bytes.Equal(key, obj.Key) {
+		if err := rows.checkUnique(key, table.primaryKeyName()); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.updateIndexes(table, oldRow, obj.Key, row, key); err != nil {
+		return err
+	}
+	return rows.Update(obj, key, value)
+}
+
+// DeleteRow deletes the stored row obj, as returned by a RowIterator, and its
+// index entries.
+func (tx *Transaction) DeleteRow(table *Table, obj *PageObject) error {
+
+	row, err := table.DecodeRow(obj.Key, obj.Value)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.updateIndexes(table, row, obj.Key, nil, nil); err != nil {
+		return err
+	}
+	return tx.tree(table.RootPage).Delete(obj)
+}
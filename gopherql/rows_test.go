@@ -0,0 +1,345 @@
+package gopherql
+
+import "testing"
+
+// tableRows returns the rows of a table as strings, in key order.
+func tableRows(t *testing.T, db *DB, name string) [][]string {
+	t.Helper()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	table, err := tx.Table("", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it, err := tx.ScanRows(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows := [][]string{}
+	for {
+		obj, row, err := it.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if obj == nil {
+			return rows
+		}
+		values := make([]string, len(row))
+		for idx, val := range row {
+			values[idx] = val.String()
+		}
+		rows = append(rows, values)
+	}
+}
+
+func expectSQLState(t *testing.T, err error, code string) {
+	t.Helper()
+	if sqlErr, ok := err.(SQLStateError); !ok || sqlErr.Code != code {
+		t.Errorf("expected SQLSTATE %s, got: %v", code, err)
+	}
+}
+
+func TestRows_InsertUpdateDelete(t *testing.T) {
+	dbFile := "rowsTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	db, err := Open(dbFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY, name VARCHAR(10), price DECIMAL(6, 2), added DATE)"); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := db.Exec("INSERT INTO items VALUES (2, 'two', 2.5, '2021-01-02'), (1, 'one', 1, NULL)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RowsAffected != 2 {
+		t.Errorf("expected 2 rows inserted, got: %d", result.RowsAffected)
+	}
+
+	if _, err := db.Exec("UPDATE items SET price = price * 2, name = name || '!' WHERE id = 2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO items (id) VALUES (3)"); err != nil {
+		t.Fatal(err)
+	}
+	result, err = db.Exec("DELETE FROM items WHERE id = 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RowsAffected != 1 {
+		t.Errorf("expected 1 row deleted, got: %d", result.RowsAffected)
+	}
+
+	rows := tableRows(t, db, "ITEMS")
+	expected := [][]string{{"1", "one", "1.00", "NULL"}, {"2", "two!", "5.00", "2021-01-02"}}
+	if len(rows) != len(expected) {
+		t.Fatalf("expected %v, got: %v", expected, rows)
+	}
+	for idx := range rows {
+		for col := range rows[idx] {
+			if rows[idx][col] != expected[idx][col] {
+				t.Errorf("expected %v, got: %v", expected, rows)
+			}
+		}
+	}
+
+	_, err = db.Exec("INSERT INTO items VALUES (4, 'four', 1, TRUE)")
+	expectSQLState(t, err, "42804")
+	_, err = db.Exec("INSERT INTO items VALUES (4, 'much too long')")
+	expectSQLState(t, err, "42601")
+	_, err = db.Exec("INSERT INTO items (id, name) VALUES (4, 'much too long')")
+	expectSQLState(t, err, "22001")
+	_, err = db.Exec("UPDATE items SET missing = 1")
+	expectSQLState(t, err, "42703")
+}
+
+func TestRows_Constraints(t *testing.T) {
+	dbFile := "constraintsTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	db, err := Open(dbFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE accounts (
+		id BIGINT PRIMARY KEY,
+		email VARCHAR(64) NOT NULL UNIQUE,
+		balance INTEGER DEFAULT 10 CHECK (balance >= 0),
+		region CHAR(2),
+		code INTEGER,
+		CONSTRAINT region_code UNIQUE (region, code)
+	)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The constraints must survive being written to and read from the catalog.
+	db, err = Open(dbFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("INSERT INTO accounts (id, email) VALUES (1, 'a@x')"); err != nil {
+		t.Fatal(err)
+	}
+	rows := tableRows(t, db, "ACCOUNTS")
+	if len(rows) != 1 || rows[0][2] != "10" {
+		t.Errorf("expected default balance, got: %v", rows)
+	}
+
+	_, err = db.Exec("INSERT INTO accounts (id) VALUES (2)")
+	expectSQLState(t, err, "23502")
+
+	_, err = db.Exec("INSERT INTO accounts (id, email, balance) VALUES (2, 'b@x', -1)")
+	expectSQLState(t, err, "23514")
+	if _, err := db.Exec("INSERT INTO accounts (id, email, balance) VALUES (2, 'b@x', NULL)"); err != nil {
+		t.Errorf("expected a NULL check result to pass, got: %s", err)
+	}
+	_, err = db.Exec("UPDATE accounts SET balance = balance - 20 WHERE id = 1")
+	expectSQLState(t, err, "23514")
+
+	_, err = db.Exec("INSERT INTO accounts (id, email) VALUES (3, 'a@x')")
+	expectSQLState(t, err, "23505")
+	if sqlErr, ok := err.(SQLStateError); ok && sqlErr.Msg != "duplicate key value violates unique constraint ACCOUNTS_EMAIL_KEY" {
+		t.Errorf("unexpected message: %s", sqlErr.Msg)
+	}
+
+	// NULLs never clash, in a composite key either.
+	for _, sql := range []string{
+		"INSERT INTO accounts (id, email, region) VALUES (3, 'c@x', 'EU')",
+		"INSERT INTO accounts (id, email, region) VALUES (4, 'd@x', 'EU')",
+		"INSERT INTO accounts (id, email, region, code) VALUES (5, 'e@x', 'EU', 1)",
+	} {
+		if _, err := db.Exec(sql); err != nil {
+			t.Fatalf("unexpected error for %q: %s", sql, err)
+		}
+	}
+	_, err = db.Exec("UPDATE accounts SET code = 1 WHERE id = 4")
+	expectSQLState(t, err, "23505")
+
+	// Freeing a value, by update or delete, lets another row take it.
+	if _, err := db.Exec("UPDATE accounts SET email = 'z@x' WHERE id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO accounts (id, email) VALUES (6, 'a@x')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("DELETE FROM accounts WHERE id = 5"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE accounts SET code = 1 WHERE id = 4"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A duplicate written by a transaction that has not committed yet is a
+	// serialization failure rather than a silent second row.
+	first, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := first.Exec("INSERT INTO accounts (id, email) VALUES (7, 'new@x')"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = second.Exec("INSERT INTO accounts (id, email) VALUES (8, 'new@x')")
+	expectSQLState(t, err, "40001")
+	if err := first.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRows_PrimaryKeyUniqueness(t *testing.T) {
+	dbFile := "primaryKeyTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	db, err := Open(dbFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER, name TEXT, PRIMARY KEY (id))"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO users VALUES (1, 'one'), (2, 'two')"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.Exec("INSERT INTO users VALUES (1, 'again')")
+	expectSQLState(t, err, "23505")
+	if sqlErr, ok := err.(SQLStateError); ok && sqlErr.Msg != "duplicate key value violates unique constraint USERS_PKEY" {
+		t.Errorf("unexpected message: %s", sqlErr.Msg)
+	}
+	_, err = db.Exec("UPDATE users SET id = 2 WHERE id = 1")
+	expectSQLState(t, err, "23505")
+
+	// Deleted versions are left in the tree until no transaction can see
+	// them, and must not stop the key being used again.
+	for round := 0; round < 5; round++ {
+		if _, err := db.Exec("DELETE FROM users WHERE id = 1"); err != nil {
+			t.Fatalf("round %d: %s", round, err)
+		}
+		if _, err := db.Exec("INSERT INTO users VALUES (1, 'back')"); err != nil {
+			t.Fatalf("round %d: %s", round, err)
+		}
+	}
+
+	// A snapshot that can still see the deleted row keeps it alive, but
+	// does not block the insert.
+	reader, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("DELETE FROM users WHERE id = 2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO users VALUES (2, 'new')"); err != nil {
+		t.Fatal(err)
+	}
+	table, err := reader.Table("", "USERS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	it, err := reader.ScanRows(table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for {
+		obj, row, err := it.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if obj == nil {
+			break
+		}
+		names = append(names, row[1].String())
+	}
+	if len(names) != 2 || names[1] != "two" {
+		t.Errorf("expected the reader to keep seeing the old row, got: %v", names)
+	}
+	if err := reader.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	rows := tableRows(t, db, "USERS")
+	if len(rows) != 2 || rows[0][1] != "back" || rows[1][1] != "new" {
+		t.Errorf("unexpected rows: %v", rows)
+	}
+}
+
+func TestRows_PruneKeepsSnapshotVersions(t *testing.T) {
+	dbFile := "pruneTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	db, err := Open(dbFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO users VALUES (1, 'original')"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The reader begins while the deleter is still running, so it keeps
+	// seeing the row the deleter commits the delete of, even though the
+	// reader's ID is higher.
+	deleter, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Rollback()
+	if _, err := deleter.Exec("DELETE FROM users WHERE id = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := deleter.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO users VALUES (1, 'again')"); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := reader.Query("SELECT name FROM users WHERE id = 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	row, err := rows.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row == nil || row[0].String() != "original" {
+		t.Errorf("expected the reader to keep seeing the original row, got: %v", row)
+	}
+}
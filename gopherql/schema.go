@@ -1,6 +1,16 @@
 package gopherql
 
+// defaultSchema is used for every table name without a schema.
+const defaultSchema = "PUBLIC"
+
 type Schema struct {
 	TransactionID int
 	Name          string
 }
+
+func schemaOrDefault(name string) string {
+	if name == "" {
+		return defaultSchema
+	}
+	return name
+}
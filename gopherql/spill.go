@@ -0,0 +1,274 @@
+package gopherql
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// defaultSpillRows is the number of rows a sort, or groups a hash
+// aggregation, holds in memory before spilling to temporary pages.
+const defaultSpillRows = 100000
+
+// tempPager keeps pages in a temporary file that is removed when it is
+// closed. It has no write-ahead log, as nothing it holds outlives the query
+// that wrote it.
+type tempPager struct {
+	file       *os.File
+	pageSize   int
+	totalPages int
+}
+
+func newTempPager() (*tempPager, error) {
+	file, err := os.CreateTemp("", "gopherql-spill-*")
+	if err != nil {
+		return nil, err
+	}
+	return &tempPager{file: file, pageSize: defaultPgSize}, nil
+}
+
+func (tp *tempPager) FetchPage(num int) (*Page, error) {
+	if num >= tp.totalPages {
+		return nil, errors.New("page out of idx")
+	}
+	buffer := make([]byte, tp.pageSize)
+	if _, err := tp.file.ReadAt(buffer, int64(tp.pageSize*num)); err != nil {
+		return nil, err
+	}
+	return pageFromBytes(buffer), nil
+}
+
+func (tp *tempPager) StorePage(num int, p *Page) error {
+	if num >= tp.totalPages {
+		return errors.New("page out of idx")
+	}
+	_, err := tp.file.WriteAt(p.Bytes(), int64(tp.pageSize*num))
+	return err
+}
+
+func (tp *tempPager) AppendPage(page *Page) (int, error) {
+	tp.totalPages++
+	if err := tp.StorePage(tp.totalPages-1, page); err != nil {
+		tp.totalPages--
+		return -1, err
+	}
+	return tp.totalPages - 1, nil
+}
+
+func (tp *tempPager) TruncateAll() error {
+	tp.totalPages = 0
+	return tp.file.Truncate(0)
+}
+
+func (tp *tempPager) TruncateLastPage() error {
+	if tp.totalPages == 0 {
+		return errors.New("page out of idx")
+	}
+	tp.totalPages--
+	return tp.file.Truncate(int64(tp.pageSize * tp.totalPages))
+}
+
+func (tp *tempPager) TotalPages() int {
+	return tp.totalPages
+}
+
+func (tp *tempPager) GetRootPage() int {
+	return 0
+}
+
+func (tp *tempPager) SetRootPage(num int) error {
+	return nil
+}
+
+func (tp *tempPager) Flush() error {
+	return nil
+}
+
+func (tp *tempPager) Close() error {
+	closeErr := tp.file.Close()
+	if err := os.Remove(tp.file.Name()); err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// spillRun is a sequence of rows written to pages of a Pager. The rows form
+// one stream of bytes split across the pages, each page recording in its
+// first two bytes how much of it is used, so a row may span pages.
+type spillRun struct {
+	pager Pager
+	pages []int
+	rows  int
+
+	buffer []byte
+}
+
+func newSpillRun(pager Pager) *spillRun {
+	return &spillRun{pager: pager}
+}
+
+// pageCapacity is the number of row bytes a spill page holds.
+func pageCapacity() int {
+	return defaultPgSize - pageHeaderSize - 2
+}
+
+// encodeTypedRow writes the type of every value ahead of the values
+// themselves, for rows that belong to no table, such as those spilled by an
+// operator, which may hold computed values of any type.
+func encodeTypedRow(row Row) []byte {
+
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(len(row)))
+	record := append([]byte{}, buf[:n]...)
+
+	indexes := make([]int, len(row))
+	for idx, val := range row {
+		record = append(record, byte(val.Type), byte(val.Scale))
+		indexes[idx] = idx
+	}
+	return append(record, encodeValues(row, indexes)...)
+}
+
+func decodeTypedRow(record []byte) (Row, error) {
+
+	count, n := binary.Uvarint(record)
+	if n <= 0 || uint64(len(record)-n) < 2*count {
+		return nil, fmt.Errorf("truncated row")
+	}
+	record = record[n:]
+
+	columns := make(Columns, count)
+	indexes := make([]int, count)
+	for idx := range columns {
+		columns[idx] = &Column{Type: ColumnType(record[2*idx]), Scale: int(record[2*idx+1])}
+		indexes[idx] = idx
+	}
+
+	row := make(Row, count)
+	if err := decodeValues(record[2*count:], row, columns, indexes); err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+func (r *spillRun) Write(row Row) error {
+
+	record := encodeTypedRow(row)
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(len(record)))
+	r.buffer = append(append(r.buffer, buf[:n]...), record...)
+	r.rows++
+
+	for len(r.buffer) >= pageCapacity() {
+		if err := r.writePage(r.buffer[:pageCapacity()]); err != nil {
+			return err
+		}
+		r.buffer = r.buffer[pageCapacity():]
+	}
+	return nil
+}
+
+func (r *spillRun) writePage(data []byte) error {
+
+	page := NewPage(kindSpill, defaultPgSize)
+	binary.BigEndian.PutUint16(page.Data, uint16(len(data)))
+	copy(page.Data[2:], data)
+
+	num, err := r.pager.AppendPage(page)
+	if err != nil {
+		return err
+	}
+	r.pages = append(r.pages, num)
+	return nil
+}
+
+// Finish writes out the last, partly filled, page of the run.
+func (r *spillRun) Finish() error {
+	if len(r.buffer) == 0 {
+		return nil
+	}
+	err := r.writePage(r.buffer)
+	r.buffer = nil
+	return err
+}
+
+// Reader returns a reader over the rows of a finished run.
+func (r *spillRun) Reader() *spillReader {
+	return &spillReader{stream: bufio.NewReader(&spillPages{pager: r.pager, pages: r.pages})}
+}
+
+// spillPages reads the byte stream of a run page by page.
+type spillPages struct {
+	pager Pager
+	pages []int
+	data  []byte
+}
+
+func (s *spillPages) Read(p []byte) (int, error) {
+	for len(s.data) == 0 {
+		if len(s.pages) == 0 {
+			return 0, io.EOF
+		}
+		page, err := s.pager.FetchPage(s.pages[0])
+		if err != nil {
+			return 0, err
+		}
+		s.pages = s.pages[1:]
+		s.data = page.Data[2 : 2+int(binary.BigEndian.Uint16(page.Data))]
+	}
+	n := copy(p, s.data)
+	s.data = s.data[n:]
+	return n, nil
+}
+
+type spillReader struct {
+	stream *bufio.Reader
+}
+
+// Next returns the next row of the run, or nil at its end.
+func (r *spillReader) Next() (Row, error) {
+
+	length, err := binary.ReadUvarint(r.stream)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	record := make([]byte, length)
+	if _, err := io.ReadFull(r.stream, record); err != nil {
+		return nil, err
+	}
+	return decodeTypedRow(record)
+}
+
+// spillScan returns the rows of a spilled run as an operator, with the
+// columns of the operator that produced them.
+type spillScan struct {
+	run     *spillRun
+	columns []ResultColumn
+
+	reader *spillReader
+}
+
+func (s *spillScan) Open() error {
+	s.reader = s.run.Reader()
+	return nil
+}
+
+func (s *spillScan) Next() (Row, error) {
+	return s.reader.Next()
+}
+
+func (s *spillScan) Close() error {
+	s.reader = nil
+	return nil
+}
+
+func (s *spillScan) Columns() []ResultColumn {
+	return s.columns
+}
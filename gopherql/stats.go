@@ -0,0 +1,259 @@
+package gopherql
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// The statistics ANALYZE collects for a table are stored in the catalog under
+// 'S' schema 0x00 name, beside the table's definition, so that they commit
+// and roll back like it.
+const catalogStatsPrefix = 'S'
+
+// ANALYZE reads every row of a table but keeps a random sample of at most
+// analyzeSampleRows of them, from which the distinct values and histograms
+// of the columns are estimated. Histograms have up to histogramBuckets
+// buckets.
+const (
+	analyzeSampleRows = 30000
+	histogramBuckets  = 32
+)
+
+// TableStats describes the rows of a table as of its last ANALYZE, for the
+// planner to estimate how many rows its operators return and what reading
+// them costs. Pages estimates how many pages the rows fill.
+type TableStats struct {
+	Rows    int
+	Pages   int
+	Columns []ColumnStats
+}
+
+// ColumnStats describes the values of a column. Histogram holds the bounds of
+// buckets that each hold about as many of the non-NULL values, in order, so
+// that the first is the smallest value and the last the largest.
+type ColumnStats struct {
+	Name      string
+	Nulls     int
+	Distinct  int
+	Histogram Row
+}
+
+// Column returns the statistics of the named column, or nil when there are
+// none.
+func (s *TableStats) Column(name string) *ColumnStats {
+	for idx := range s.Columns {
+		if s.Columns[idx].Name == name {
+			return &s.Columns[idx]
+		}
+	}
+	return nil
+}
+
+func (s *TableStats) Bytes() []byte {
+
+	bwriter := NewByteWriter()
+
+	bwriter.WriteUint32(s.Rows)
+	bwriter.WriteUint32(s.Pages)
+	bwriter.WriteUint32(len(s.Columns))
+	for _, column := range s.Columns {
+		bwriter.WriteUint32(len(column.Name))
+		bwriter.WriteString(column.Name)
+		bwriter.WriteUint32(column.Nulls)
+		bwriter.WriteUint32(column.Distinct)
+		histogram := encodeTypedRow(column.Histogram)
+		bwriter.WriteUint32(len(histogram))
+		bwriter.AppendBytes(histogram)
+	}
+
+	return bwriter.Bytes()
+}
+
+func TableStatsFromBytes(contents []byte) (*TableStats, error) {
+
+	s := &TableStats{}
+
+	reader := NewByteReader(contents)
+	s.Rows = reader.ReadUint32()
+	s.Pages = reader.ReadUint32()
+	s.Columns = make([]ColumnStats, reader.ReadUint32())
+	for idx := range s.Columns {
+		column := &s.Columns[idx]
+		column.Name = reader.ReadString(reader.ReadUint32())
+		column.Nulls = reader.ReadUint32()
+		column.Distinct = reader.ReadUint32()
+		histogram, err := decodeTypedRow(reader.ReadBytes(reader.ReadUint32()))
+		if err != nil {
+			return nil, err
+		}
+		if len(histogram) > 0 {
+			column.Histogram = histogram
+		}
+	}
+
+	return s, nil
+}
+
+// TableStats returns the statistics of a table, or nil when it has not been
+// analyzed.
+func (tx *Transaction) TableStats(table *Table) (*TableStats, error) {
+
+	obj, err := tx.Get(catalogKey(catalogStatsPrefix, schemaOrDefault(table.Schema), table.Name))
+	if err != nil || obj == nil {
+		return nil, err
+	}
+	return TableStatsFromBytes(obj.Value)
+}
+
+// Analyze collects the statistics of a table and stores them in the catalog,
+// replacing those of an earlier ANALYZE.
+func (tx *Transaction) Analyze(table *Table) (*TableStats, error) {
+
+	rows, err := tx.ScanRows(table)
+	if err != nil {
+		return nil, err
+	}
+
+	// The sample is drawn by reservoir sampling, seeded alike every time so
+	// that analyzing the same rows gives the same statistics.
+	random := rand.New(rand.NewSource(1))
+	sample := []Row{}
+	count, size := 0, 0
+	for {
+		obj, row, err := rows.Next()
+		if err != nil {
+			return nil, err
+		}
+		if obj == nil {
+			break
+		}
+		count++
+		size += len(obj.Key) + len(obj.Value)
+		if len(sample) < analyzeSampleRows {
+			sample = append(sample, row)
+		} else if pos := random.Intn(count); pos < analyzeSampleRows {
+			sample[pos] = row
+		}
+	}
+
+	stats := &TableStats{
+		Rows:  count,
+		Pages: (size + pageCapacity() - 1) / pageCapacity(),
+	}
+	for idx, column := range table.Columns {
+		stats.Columns = append(stats.Columns, columnStats(column.Name, sample, idx, count))
+	}
+
+	key := catalogKey(catalogStatsPrefix, schemaOrDefault(table.Schema), table.Name)
+	obj, err := tx.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		err = tx.Add(key, stats.Bytes())
+	} else {
+		err = tx.Update(obj, key, stats.Bytes())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// columnStats estimates the statistics of the column at pos from a sample of
+// the rows of a table holding count rows.
+func columnStats(name string, sample []Row, pos int, count int) ColumnStats {
+
+	values := Row{}
+	for _, row := range sample {
+		if !row[pos].IsNull {
+			values = append(values, row[pos])
+		}
+	}
+	stats := ColumnStats{Name: name}
+	if len(sample) == 0 {
+		return stats
+	}
+	stats.Nulls = (len(sample) - len(values)) * count / len(sample)
+
+	occurrences := map[string]int{}
+	for _, val := range values {
+		key, _ := hashKey(Row{val})
+		occurrences[key]++
+	}
+	singles := 0
+	for _, times := range occurrences {
+		if times == 1 {
+			singles++
+		}
+	}
+	stats.Distinct = estimateDistinct(len(values), count-stats.Nulls, len(occurrences), singles)
+
+	// Values of a column share a type, so comparing them cannot fail.
+	sort.SliceStable(values, func(i, j int) bool {
+		cmp, _ := compareValues(values[i], values[j])
+		return cmp < 0
+	})
+	buckets := histogramBuckets
+	if len(values)-1 < buckets {
+		buckets = len(values) - 1
+	}
+	for bucket := 0; bucket <= buckets && len(values) > 0; bucket++ {
+		pos := 0
+		if buckets > 0 {
+			pos = bucket * (len(values) - 1) / buckets
+		}
+		stats.Histogram = append(stats.Histogram, values[pos])
+	}
+	return stats
+}
+
+// estimateDistinct estimates the distinct values among total values from a
+// sample of sampled of them, which holds distinct values, singles of which
+// occur only once. It uses the estimator of Haas and Stokes that PostgreSQL
+// uses too.
+func estimateDistinct(sampled, total, distinct, singles int) int {
+
+	switch {
+	case sampled >= total:
+		return distinct
+	case singles == sampled:
+		// Every sampled value is unique, as the column's values likely are.
+		return total
+	}
+
+	n, N := float64(sampled), float64(total)
+	estimate := int(n * float64(distinct) / (n - float64(singles) + float64(singles)*n/N))
+	if estimate < distinct {
+		return distinct
+	}
+	if estimate > total {
+		return total
+	}
+	return estimate
+}
+
+// execAnalyze analyzes the named table, or every table of the default schema.
+func (tx *Transaction) execAnalyze(stmt *AnalyzeStmt) error {
+
+	var tables []*Table
+	if stmt.Table != nil {
+		table, err := tx.Table(stmt.Table.Schema, stmt.Table.Name)
+		if err != nil {
+			return err
+		}
+		tables = []*Table{table}
+	} else {
+		var err error
+		if tables, err = tx.Tables(""); err != nil {
+			return err
+		}
+	}
+
+	for _, table := range tables {
+		if _, err := tx.Analyze(table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -5,22 +5,84 @@ import (
 	"fmt"
 )
 
-// TODO - Go generate - plus do we need richer types?
+// TODO - Go generate
+//
+// New types are only ever appended, as the value is persisted with the
+// column. Every integer-like type, including DECIMAL (the value scaled by
+// 10^Scale) and the date and time types (days, or microseconds, since the
+// Unix epoch or midnight), is held in Value.IntValue.
 const (
 	StringColumn ColumnType = iota
 	BoolColumn
 	Int64Column
+	Int16Column
+	Int32Column
+	Float32Column
+	Float64Column
+	DecimalColumn
+	DateColumn
+	TimeColumn
+	TimestampColumn
+	TimestampTzColumn
+	CharColumn
+	BytesColumn
 )
 
+// maxDecimalPrecision is the most digits an unscaled DECIMAL fits in an
+// int64 with.
+const maxDecimalPrecision = 18
+
 const uint16Size = 2
 const uint32Size = 4
 
 type ColumnType uint8
 
+func (c ColumnType) String() string {
+	switch c {
+	case StringColumn:
+		return "VARCHAR"
+	case BoolColumn:
+		return "BOOLEAN"
+	case Int64Column:
+		return "BIGINT"
+	case Int16Column:
+		return "SMALLINT"
+	case Int32Column:
+		return "INTEGER"
+	case Float32Column:
+		return "REAL"
+	case Float64Column:
+		return "DOUBLE PRECISION"
+	case DecimalColumn:
+		return "DECIMAL"
+	case DateColumn:
+		return "DATE"
+	case TimeColumn:
+		return "TIME"
+	case TimestampColumn:
+		return "TIMESTAMP"
+	case TimestampTzColumn:
+		return "TIMESTAMP WITH TIME ZONE"
+	case CharColumn:
+		return "CHAR"
+	case BytesColumn:
+		return "BYTEA"
+	}
+	return fmt.Sprintf("<unknown type %d>", uint8(c))
+}
+
+// Column is a column definition. Size is the length limit of a VARCHAR or
+// CHAR, where 0 means unlimited, and Precision and Scale the digits of a
+// DECIMAL in total and after the point. Default is the SQL text of the
+// DEFAULT expression, or empty when there is none.
 type Column struct {
-	Name    string
-	Type    ColumnType
-	NotNull bool
+	Name      string
+	Type      ColumnType
+	NotNull   bool
+	Size      int
+	Precision int
+	Scale     int
+	Default   string
 }
 
 func (c *Column) Bytes() []byte {
@@ -31,6 +93,11 @@ func (c *Column) Bytes() []byte {
 	bWriter.WriteString(c.Name)
 	bWriter.WriteUint8(int(c.Type))
 	bWriter.WriteBool(c.NotNull)
+	bWriter.WriteUint32(c.Size)
+	bWriter.WriteUint8(c.Precision)
+	bWriter.WriteUint8(c.Scale)
+	bWriter.WriteUint32(len(c.Default))
+	bWriter.WriteString(c.Default)
 
 	return bWriter.Bytes()
 }
@@ -45,11 +112,33 @@ func ColumnFromBytes(contents []byte) (*Column, error) {
 
 	c.Name = breader.ReadString(nameSize)
 	c.Type = ColumnType(breader.ReadUint8())
-	c.NotNull = breader.ReadByteAsBool(contents[len(contents)-1])
+	c.NotNull = breader.ReadBool()
+
+	// Columns written before types took parameters end here.
+	if breader.Offset < len(contents) {
+		c.Size = breader.ReadUint32()
+		c.Precision = breader.ReadUint8()
+		c.Scale = breader.ReadUint8()
+	}
+	if breader.Offset < len(contents) {
+		c.Default = breader.ReadString(breader.ReadUint32())
+	}
 
 	return c, nil
 }
 
+// TypeName renders the column type with its parameters, as it would be
+// declared.
+func (c *Column) TypeName() string {
+	switch {
+	case c.Type == DecimalColumn:
+		return fmt.Sprintf("DECIMAL(%d,%d)", c.Precision, c.Scale)
+	case (c.Type == StringColumn || c.Type == CharColumn) && c.Size > 0:
+		return fmt.Sprintf("%s(%d)", c.Type, c.Size)
+	}
+	return c.Type.String()
+}
+
 func (c *Column) String() string {
 	return fmt.Sprintf("%s %d", c.Name, c.Type)
 }
@@ -141,11 +230,34 @@ func PrimaryKeysFromBytes(contents []byte) PrimaryKeys {
 	return pks
 }
 
+// Table is a table definition. Schema is not part of Bytes, as the catalog
+// records it in the key the table is stored under. RootPage is the root of
+// the tree holding the table's rows.
 type Table struct {
+	Schema      string
 	Name        string
 	Columns     Columns
 	PrimaryKeys PrimaryKeys
 	Virtual     bool
+	Checks      []CheckConstraint
+	Uniques     []UniqueConstraint
+	RootPage    int
+}
+
+// CheckConstraint holds the SQL text of a CHECK expression, which a row must
+// not make false.
+type CheckConstraint struct {
+	Name string
+	Expr string
+}
+
+// UniqueConstraint is a set of columns no two rows may share values for,
+// unless one of them is NULL. It is backed by a unique index of the same
+// name, whose tree is rooted at RootPage.
+type UniqueConstraint struct {
+	Name     string
+	Columns  []string
+	RootPage int
 }
 
 func (t *Table) ColumnNames() []string {
@@ -166,6 +278,16 @@ func (t *Table) Column(name string) (*Column, error) {
 	return nil, SQLStateError{Code: "42703", Msg: "Column does not exist"}
 }
 
+// ColumnIndex returns the position of the named column, or -1.
+func (t *Table) ColumnIndex(name string) int {
+	for idx, c := range t.Columns {
+		if c.Name == name {
+			return idx
+		}
+	}
+	return -1
+}
+
 func (t *Table) Bytes() []byte {
 
 	bwriter := NewByteWriter()
@@ -183,6 +305,24 @@ func (t *Table) Bytes() []byte {
 
 	bwriter.WriteBool(t.Virtual)
 
+	bwriter.WriteUint16(len(t.Checks))
+	for _, check := range t.Checks {
+		bwriter.WriteUint32(len(check.Name))
+		bwriter.WriteString(check.Name)
+		bwriter.WriteUint32(len(check.Expr))
+		bwriter.WriteString(check.Expr)
+	}
+
+	bwriter.WriteUint16(len(t.Uniques))
+	for _, unique := range t.Uniques {
+		bwriter.WriteUint32(len(unique.Name))
+		bwriter.WriteString(unique.Name)
+		bwriter.AppendBytes(PrimaryKeys(unique.Columns).Bytes())
+		bwriter.WriteUint32(unique.RootPage)
+	}
+
+	bwriter.WriteUint32(t.RootPage)
+
 	return bwriter.Bytes()
 }
 
@@ -206,11 +346,74 @@ func TableFromBytes(contents []byte) (*Table, error) {
 	t.PrimaryKeys = pks
 	reader.Advance(pkSize)
 
-	t.Virtual = reader.ReadByteAsBool(contents[len(contents)-1])
+	t.Virtual = reader.ReadBool()
+
+	// Tables written before constraints were stored end here, and like those
+	// written before each table had its own tree, they record no root page.
+	if reader.Remaining() == 0 {
+		return nil, olderTableFormat(t.Name)
+	}
+
+	checkCount := reader.ReadUint16()
+	for idx := 0; idx < checkCount; idx++ {
+		check := CheckConstraint{}
+		check.Name = reader.ReadString(reader.ReadUint32())
+		check.Expr = reader.ReadString(reader.ReadUint32())
+		t.Checks = append(t.Checks, check)
+	}
+
+	uniqueCount := reader.ReadUint16()
+	uniques, ok := readUniques(reader, uniqueCount)
+	if !ok || reader.Remaining() != uint32Size {
+		return nil, olderTableFormat(t.Name)
+	}
+	t.Uniques = uniques
+	t.RootPage = reader.ReadUint32()
 
 	return t, nil
 }
 
+// readUniques decodes count unique constraints along with their root pages.
+// It returns false when the contents end before the last of them does.
+func readUniques(reader *ByteReader, count int) ([]UniqueConstraint, bool) {
+
+	uniques := []UniqueConstraint{}
+	for idx := 0; idx < count; idx++ {
+		unique := UniqueConstraint{}
+		if reader.Remaining() < uint32Size {
+			return nil, false
+		}
+		nameSize := reader.ReadUint32()
+		if reader.Remaining() < nameSize+1 {
+			return nil, false
+		}
+		unique.Name = reader.ReadString(nameSize)
+
+		sizes := make([]int, reader.ReadUint8())
+		if reader.Remaining() < len(sizes)*uint32Size {
+			return nil, false
+		}
+		total := 0
+		for col := range sizes {
+			sizes[col] = reader.ReadUint32()
+			total += sizes[col]
+		}
+		if reader.Remaining() < total+uint32Size {
+			return nil, false
+		}
+		for _, size := range sizes {
+			unique.Columns = append(unique.Columns, reader.ReadString(size))
+		}
+		unique.RootPage = reader.ReadUint32()
+		uniques = append(uniques, unique)
+	}
+	return uniques, true
+}
+
+func olderTableFormat(name string) error {
+	return fmt.Errorf("table %s was written by an older version and records no root page", name)
+}
+
 type TableOperation struct {
 	// TODO
 }
@@ -37,14 +37,14 @@ func TestColumns_ByteTransformation(t *testing.T) {
 
 	c := []*Column{
 		{
-			"PrimaryKey",
-			Int64Column,
-			true,
+			Name:    "PrimaryKey",
+			Type:    Int64Column,
+			NotNull: true,
 		},
 		{
-			"ValueField",
-			StringColumn,
-			false,
+			Name:    "ValueField",
+			Type:    StringColumn,
+			NotNull: false,
 		},
 	}
 
@@ -139,3 +139,84 @@ func TestTable_Bytes(t *testing.T) {
 		t.Error("unexpected column count")
 	}
 }
+
+func TestColumn_TypeParameters(t *testing.T) {
+
+	for _, c := range []*Column{
+		{Name: "Price", Type: DecimalColumn, Precision: 10, Scale: 2},
+		{Name: "Code", Type: CharColumn, Size: 3, NotNull: true},
+		{Name: "At", Type: TimestampTzColumn},
+	} {
+		col, err := ColumnFromBytes(c.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *col != *c {
+			t.Errorf("expected %+v, got: %+v", c, col)
+		}
+	}
+
+	c := &Column{Name: "Price", Type: DecimalColumn, Precision: 10, Scale: 2}
+	if c.TypeName() != "DECIMAL(10,2)" {
+		t.Errorf("unexpected type name: %s", c.TypeName())
+	}
+}
+
+func TestTable_BytesRootPages(t *testing.T) {
+
+	table := &Table{
+		Name:        "ExampleTable",
+		Columns:     []*Column{{Name: "PK", Type: Int64Column, NotNull: true}, {Name: "Code", Type: StringColumn}},
+		PrimaryKeys: []string{"PK"},
+		Checks:      []CheckConstraint{{Name: "code_check", Expr: "Code <> ''"}},
+		Uniques: []UniqueConstraint{
+			{Name: "code_key", Columns: []string{"Code"}, RootPage: 7},
+			{Name: "both_key", Columns: []string{"PK", "Code"}, RootPage: 9},
+		},
+		RootPage: 5,
+	}
+
+	loaded, err := TableFromBytes(table.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.RootPage != 5 || len(loaded.Checks) != 1 || len(loaded.Uniques) != 2 {
+		t.Fatalf("unexpected table: %+v", loaded)
+	}
+	for idx, unique := range table.Uniques {
+		got := loaded.Uniques[idx]
+		if got.Name != unique.Name || got.RootPage != unique.RootPage || len(got.Columns) != len(unique.Columns) {
+			t.Errorf("expected %+v, got: %+v", unique, got)
+		}
+	}
+
+	// Tables written before constraints were stored end after Virtual.
+	bare := &Table{Name: table.Name, Columns: table.Columns, PrimaryKeys: table.PrimaryKeys}
+	encoded := bare.Bytes()
+	beforeConstraints := encoded[:len(encoded)-2*uint16Size-uint32Size]
+
+	// Tables written before each had its own tree store their constraints
+	// without any root pages.
+	bwriter := NewByteWriter()
+	bwriter.AppendBytes(beforeConstraints)
+	bwriter.WriteUint16(len(table.Checks))
+	for _, check := range table.Checks {
+		bwriter.WriteUint32(len(check.Name))
+		bwriter.WriteString(check.Name)
+		bwriter.WriteUint32(len(check.Expr))
+		bwriter.WriteString(check.Expr)
+	}
+	bwriter.WriteUint16(len(table.Uniques))
+	for _, unique := range table.Uniques {
+		bwriter.WriteUint32(len(unique.Name))
+		bwriter.WriteString(unique.Name)
+		bwriter.AppendBytes(PrimaryKeys(unique.Columns).Bytes())
+	}
+	beforeRootPages := bwriter.Bytes()
+
+	for _, contents := range [][]byte{beforeConstraints, beforeRootPages} {
+		if loaded, err := TableFromBytes(contents); err == nil {
+			t.Errorf("expected an error decoding an entry without root pages, got: %+v", loaded)
+		}
+	}
+}
@@ -0,0 +1,651 @@
+package gopherql
+
+import (
+	"sort"
+	"time"
+)
+
+// TransactionManager hands out transaction IDs from the Header and keeps the
+// set of active transactions in it. The header is persisted after every
+// change, so transactions left open by a crash can be rolled back by Recover.
+//
+// The btree is the catalog, which records the roots of every other tree.
+// Xmins holds the xmin of the snapshot of every running transaction, from
+// which the versions no snapshot can see any more are found. Functions holds
+// the SQL functions registered with the database, and spillRows the rows a
+// query holds in memory before spilling to temporary pages.
+type TransactionManager struct {
+	header    *Header
+	btree     *Btree
+	persist   func(*Header) error
+	dropped   []droppedTree
+	xmins     map[int]int
+	functions map[string]*Function
+	spillRows int
+}
+
+// droppedTree is a tree dropped by the committed transaction id. Its pages
+// are freed once no transaction that began before the commit is still
+// running, as those may still read the tree.
+type droppedTree struct {
+	root  int
+	id    int
+	until int
+}
+
+func NewTransactionManager(header *Header, btree *Btree, persist func(*Header) error) *TransactionManager {
+	return &TransactionManager{
+		header:  header,
+		btree:   btree,
+		persist: persist,
+		xmins:   map[int]int{},
+	}
+}
+
+type Transaction struct {
+	ID            int
+	Snapshot      Snapshot
+	manager       *TransactionManager
+	finished      bool
+	schemaChanged bool
+	rowSequence   int
+	droppedTrees  []int
+	// started is when the transaction began, which CURRENT_TIMESTAMP and
+	// the other functions reading the clock return throughout it.
+	started time.Time
+	// undoLog lists the versions the transaction wrote or expired, and
+	// createdTrees the trees it created, set while they still exist, so that
+	// Rollback only visits what the transaction changed, and a failed
+	// statement can undo its own changes alone.
+	undoLog      []undoEntry
+	createdTrees map[int]bool
+	// pagesFetched counts the pages the transaction has read from the
+	// Pager, for EXPLAIN ANALYZE.
+	pagesFetched int
+}
+
+// undoEntry records a version of key in the tree rooted at root that a
+// transaction wrote, or, when expired is set, the version written by creator
+// that the transaction expired. Previous is a version of the transaction's
+// own that the write replaced or removed, for undo to put back.
+type undoEntry struct {
+	root     int
+	key      []byte
+	expired  bool
+	creator  int
+	previous *PageObject
+}
+
+// savepoint marks the state of a transaction's undo log, so that the changes
+// made after it can be undone alone.
+type savepoint struct {
+	undoLog      int
+	createdTrees map[int]bool
+	droppedTrees int
+}
+
+// savepoint marks the changes the transaction has made so far.
+func (tx *Transaction) savepoint() savepoint {
+	created := make(map[int]bool, len(tx.createdTrees))
+	for root, live := range tx.createdTrees {
+		created[root] = live
+	}
+	return savepoint{undoLog: len(tx.undoLog), createdTrees: created, droppedTrees: len(tx.droppedTrees)}
+}
+
+func (tm *TransactionManager) Begin() (*Transaction, error) {
+
+	if len(tm.header.ActiveTransactions) >= maxActiveTransactions {
+		return nil, SQLStateError{Code: "53000", Msg: "too many active transactions"}
+	}
+
+	id := int(tm.header.TransactionID)
+	active := make([]int, len(tm.header.ActiveTransactions))
+	for idx, activeID := range tm.header.ActiveTransactions {
+		active[idx] = int(activeID)
+	}
+
+	tm.header.TransactionID++
+	tm.header.ActiveTransactions = append(tm.header.ActiveTransactions, uint32(id))
+	if err := tm.persist(tm.header); err != nil {
+		return nil, err
+	}
+
+	snapshot := NewSnapshot(id, active)
+	tm.xmins[id] = snapshot.xmin()
+
+	return &Transaction{
+		ID:           id,
+		Snapshot:     snapshot,
+		manager:      tm,
+		createdTrees: map[int]bool{},
+		started:      time.Now(),
+	}, nil
+}
+
+// Recover rolls back every transaction the header still lists as active, and
+// then frees the trees dropped by committed transactions that a close or
+// crash left behind. It must run before any new transaction begins.
+//
+// The undo logs of those transactions were lost with the process, so their
+// changes are found by scanning every tree, once for all of them.
+func (tm *TransactionManager) Recover() error {
+
+	if len(tm.header.ActiveTransactions) > 0 {
+		ids := map[int]bool{}
+		for _, id := range tm.header.ActiveTransactions {
+			ids[int(id)] = true
+		}
+		if err := tm.undoAll(ids); err != nil {
+			return err
+		}
+		for id := range ids {
+			if err := tm.finish(id); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := tm.releasePendingDrops(); err != nil {
+		return err
+	}
+	if err := tm.persist(tm.header); err != nil {
+		return err
+	}
+	return tm.btree.Pager.Flush()
+}
+
+func (tm *TransactionManager) finish(id int) error {
+
+	remaining := []uint32{}
+	for _, activeID := range tm.header.ActiveTransactions {
+		if int(activeID) != id {
+			remaining = append(remaining, activeID)
+		}
+	}
+	tm.header.ActiveTransactions = remaining
+	delete(tm.xmins, id)
+
+	if err := tm.releaseDroppedTrees(); err != nil {
+		return err
+	}
+	if err := tm.persist(tm.header); err != nil {
+		return err
+	}
+	return tm.btree.Pager.Flush()
+}
+
+// tree returns the tree rooted at root, which shares the catalog's pager and
+// allocator.
+func (tm *TransactionManager) tree(root int) Btree {
+	return *tm.btree.Allocator.Tree(root)
+}
+
+// releaseDroppedTrees frees the pages of every dropped tree no running
+// transaction can read any more.
+func (tm *TransactionManager) releaseDroppedTrees() error {
+
+	oldest := tm.oldestActive()
+	remaining := []droppedTree{}
+	for _, dropped := range tm.dropped {
+		if dropped.until > oldest {
+			remaining = append(remaining, dropped)
+			continue
+		}
+		if err := tm.releaseDroppedTree(dropped.root, dropped.id); err != nil {
+			return err
+		}
+	}
+	tm.dropped = remaining
+	return nil
+}
+
+// releaseDroppedTree frees the pages of a tree dropped by the transaction id,
+// removing the catalog entry recording it first, so that a crash in between
+// leaks the pages rather than freeing them twice.
+func (tm *TransactionManager) releaseDroppedTree(root, id int) error {
+	if err := tm.btree.Remove(droppedTreeKey(root), id, true); err != nil {
+		return err
+	}
+	return tm.tree(root).Drop()
+}
+
+// releasePendingDrops frees every tree a catalog entry records as dropped.
+// It runs once no transaction is left, when none can read them any more.
+func (tm *TransactionManager) releasePendingDrops() error {
+
+	it, err := tm.btree.Iterator([]byte{catalogDroppedPrefix}, []byte{catalogDroppedPrefix + 1})
+	if err != nil {
+		return err
+	}
+	pending := []*PageObject{}
+	for {
+		obj, err := it.nextRaw()
+		if err != nil {
+			return err
+		}
+		if obj == nil {
+			break
+		}
+		pending = append(pending, obj)
+	}
+
+	for _, obj := range pending {
+		root := NewByteReader(obj.Key[1:]).ReadUint32()
+		if err := tm.releaseDroppedTree(root, int(obj.TransactionID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// oldestActive returns the lowest ID of a running transaction. Every
+// transaction that began before a lower ID committed has finished.
+func (tm *TransactionManager) oldestActive() int {
+	oldest := int(tm.header.TransactionID)
+	for _, id := range tm.header.ActiveTransactions {
+		if int(id) < oldest {
+			oldest = int(id)
+		}
+	}
+	return oldest
+}
+
+// isActive reports whether the transaction id is still running.
+func (tm *TransactionManager) isActive(id int) bool {
+	for _, activeID := range tm.header.ActiveTransactions {
+		if int(activeID) == id {
+			return true
+		}
+	}
+	return false
+}
+
+// horizon returns the lowest xmin of a running transaction. A version
+// deleted by a lower ID that has finished is invisible to all of them, and to
+// any that begin later. A transaction that is still running is never below
+// it, as its own xmin is no higher than its ID.
+func (tm *TransactionManager) horizon() int {
+	horizon := int(tm.header.TransactionID)
+	for _, xmin := range tm.xmins {
+		if xmin < horizon {
+			horizon = xmin
+		}
+	}
+	return horizon
+}
+
+// pruneDeadVersions removes the versions of key that no transaction can see
+// any more, so that they do not count towards the versions a key may have.
+func (tm *TransactionManager) pruneDeadVersions(bt Btree, key []byte) error {
+
+	versions, err := bt.Versions(key)
+	if err != nil {
+		return err
+	}
+
+	horizon := tm.horizon()
+	for _, obj := range versions {
+		if obj.DeleteID != 0 && int(obj.DeleteID) < horizon {
+			if err := bt.Remove(obj.Key, int(obj.TransactionID), true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rollbackTo undoes the changes tx made after sp, latest first. Trees
+// created after sp are freed outright, so changes to them are skipped, as
+// are changes to trees that have been freed since.
+func (tx *Transaction) rollbackTo(sp savepoint) error {
+
+	tm := tx.manager
+	for idx := len(tx.undoLog) - 1; idx >= sp.undoLog; idx-- {
+		entry := tx.undoLog[idx]
+		if live, created := tx.createdTrees[entry.root]; created && (!live || !sp.createdTrees[entry.root]) {
+			continue
+		}
+		bt := tm.tree(entry.root)
+		if entry.root == tm.btree.Root {
+			bt = *tm.btree
+		}
+		if err := undoVersion(bt, entry, tx.ID); err != nil {
+			return err
+		}
+	}
+
+	created := []int{}
+	for root, live := range tx.createdTrees {
+		if _, before := sp.createdTrees[root]; live && !before {
+			created = append(created, root)
+		}
+	}
+	sort.Ints(created)
+	for _, root := range created {
+		if err := tm.tree(root).Drop(); err != nil {
+			return err
+		}
+	}
+
+	tx.undoLog = tx.undoLog[:sp.undoLog]
+	tx.createdTrees = sp.createdTrees
+	tx.droppedTrees = tx.droppedTrees[:sp.droppedTrees]
+	return nil
+}
+
+// undoVersion removes the version of an undo entry that id wrote, putting
+// back the one it replaced, or clears the DeleteID of the one it expired.
+// Versions already gone are skipped, as a key may be listed more than once.
+func undoVersion(bt Btree, entry undoEntry, id int) error {
+
+	if !entry.expired {
+		stored, err := bt.get(entry.key, id)
+		if err != nil {
+			return err
+		}
+		if stored != nil {
+			if err := bt.Remove(entry.key, id, true); err != nil {
+				return err
+			}
+		}
+		if entry.previous != nil {
+			return bt.Add(NewPageObject(entry.previous.Key, entry.previous.Value, uint32(id), 0))
+		}
+		return nil
+	}
+
+	stored, err := bt.get(entry.key, entry.creator)
+	if err != nil || stored == nil || int(stored.DeleteID) != id {
+		return err
+	}
+	_, err = bt.Expire(entry.key, entry.creator, 0)
+	return err
+}
+
+// undoAll rolls back every change made by the transactions of ids, whose
+// undo logs are not known, by scanning every tree. Trees created by them are
+// freed outright, while the others have their changes undone before the
+// catalog.
+func (tm *TransactionManager) undoAll(ids map[int]bool) error {
+
+	existing, created, err := tm.catalogTrees(ids)
+	if err != nil {
+		return err
+	}
+
+	for _, root := range existing {
+		if err := tm.undoTree(tm.tree(root), ids); err != nil {
+			return err
+		}
+	}
+	if err := tm.undoTree(*tm.btree, ids); err != nil {
+		return err
+	}
+
+	for _, root := range created {
+		if err := tm.tree(root).Drop(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// undoTree removes every version of bt written by ids and clears the DeleteID
+// of every version they expired. Changes are collected before any are
+// applied, so the scan never runs over pages that are being rewritten.
+func (tm *TransactionManager) undoTree(bt Btree, ids map[int]bool) error {
+
+	it, err := bt.Iterator(nil, nil)
+	if err != nil {
+		return err
+	}
+
+	written := []*PageObject{}
+	expired := []*PageObject{}
+	for {
+		obj, err := it.nextRaw()
+		if err != nil {
+			return err
+		}
+		if obj == nil {
+			break
+		}
+		if ids[int(obj.TransactionID)] {
+			written = append(written, obj)
+		} else if ids[int(obj.DeleteID)] {
+			expired = append(expired, obj)
+		}
+	}
+
+	for _, obj := range written {
+		if err := bt.Remove(obj.Key, int(obj.TransactionID), false); err != nil {
+			return err
+		}
+	}
+
+	for _, obj := range expired {
+		if _, err := bt.Expire(obj.Key, int(obj.TransactionID), 0); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (tx *Transaction) checkActive() error {
+	if tx.finished {
+		return SQLStateError{Code: "25P02", Msg: "transaction is not active"}
+	}
+	return nil
+}
+
+// Commit makes the writes of the transaction visible to every transaction
+// that begins afterwards. Committing catalog changes bumps the schema version.
+func (tx *Transaction) Commit() error {
+
+	if err := tx.checkActive(); err != nil {
+		return err
+	}
+	tx.finished = true
+
+	if tx.schemaChanged {
+		tx.manager.header.SchemaVersion++
+	}
+	for _, root := range tx.droppedTrees {
+		tx.manager.dropped = append(tx.manager.dropped, droppedTree{
+			root:  root,
+			id:    tx.ID,
+			until: int(tx.manager.header.TransactionID),
+		})
+	}
+
+	return tx.manager.finish(tx.ID)
+}
+
+// Rollback removes every row the transaction wrote and restores every row it
+// deleted or replaced.
+func (tx *Transaction) Rollback() error {
+
+	if err := tx.checkActive(); err != nil {
+		return err
+	}
+	tx.finished = true
+
+	if err := tx.rollbackTo(savepoint{}); err != nil {
+		return err
+	}
+	return tx.manager.finish(tx.ID)
+}
+
+// abortOnConflict rolls the transaction back when err is a serialization
+// failure, as the transaction can no longer commit consistently.
+func (tx *Transaction) abortOnConflict(err error) error {
+
+	sqlErr, ok := err.(SQLStateError)
+	if !ok || sqlErr.Code != "40001" || tx.finished {
+		return err
+	}
+
+	if rollbackErr := tx.Rollback(); rollbackErr != nil {
+		return rollbackErr
+	}
+	return err
+}
+
+// treeView gives a transaction access to one of the trees in the file.
+type treeView struct {
+	tx    *Transaction
+	btree Btree
+}
+
+// tree returns a view of the tree rooted at root.
+func (tx *Transaction) tree(root int) *treeView {
+	return tx.view(tx.manager.tree(root))
+}
+
+func (tx *Transaction) catalog() *treeView {
+	return tx.view(*tx.manager.btree)
+}
+
+// view reads btree through a Pager counting the pages the transaction
+// fetches.
+func (tx *Transaction) view(btree Btree) *treeView {
+	btree.Pager = countingPager{Pager: btree.Pager, fetched: &tx.pagesFetched}
+	return &treeView{tx: tx, btree: btree}
+}
+
+// countingPager counts the pages fetched through it.
+type countingPager struct {
+	Pager
+	fetched *int
+}
+
+func (p countingPager) FetchPage(num int) (*Page, error) {
+	*p.fetched++
+	return p.Pager.FetchPage(num)
+}
+
+// Get returns the catalog version of key visible to the transaction.
+func (tx *Transaction) Get(key []byte) (*PageObject, error) {
+	return tx.catalog().Get(key)
+}
+
+// Scan iterates over the catalog versions in [start, end) visible to the
+// transaction.
+func (tx *Transaction) Scan(start, end []byte) (*Iterator, error) {
+	return tx.catalog().Scan(start, end)
+}
+
+func (tx *Transaction) Add(key, value []byte) error {
+	return tx.catalog().Add(key, value)
+}
+
+func (tx *Transaction) Update(obj *PageObject, key, value []byte) error {
+	return tx.catalog().Update(obj, key, value)
+}
+
+func (tx *Transaction) Delete(obj *PageObject) error {
+	return tx.catalog().Delete(obj)
+}
+
+// Get returns the version of key visible to the transaction.
+func (v *treeView) Get(key []byte) (*PageObject, error) {
+	if err := v.tx.checkActive(); err != nil {
+		return nil, err
+	}
+	return v.btree.Lookup(key, v.tx.Snapshot)
+}
+
+// Scan iterates over the versions in [start, end) visible to the transaction.
+func (v *treeView) Scan(start, end []byte) (*Iterator, error) {
+	if err := v.tx.checkActive(); err != nil {
+		return nil, err
+	}
+	return v.btree.Scan(start, end, v.tx.Snapshot)
+}
+
+func (v *treeView) Add(key, value []byte) error {
+	if err := v.tx.checkActive(); err != nil {
+		return err
+	}
+
+	if err := v.tx.manager.pruneDeadVersions(v.btree, key); err != nil {
+		return err
+	}
+
+	v.logWrite(key)
+	obj := NewPageObject(key, value, uint32(v.tx.ID), 0)
+	return v.tx.abortOnConflict(v.btree.Add(obj))
+}
+
+// Update replaces the visible version obj with a new key and value.
+func (v *treeView) Update(obj *PageObject, key, value []byte) error {
+	if err := v.tx.checkActive(); err != nil {
+		return err
+	}
+
+	if err := v.tx.manager.pruneDeadVersions(v.btree, key); err != nil {
+		return err
+	}
+
+	v.logExpire(obj)
+	v.logWrite(key)
+	_, err := v.btree.Update(obj, NewPageObject(key, value, uint32(v.tx.ID), 0), v.tx.ID)
+	return v.tx.abortOnConflict(err)
+}
+
+// Delete removes the visible version obj. Versions written by another
+// transaction are only expired, so older snapshots can still read them.
+func (v *treeView) Delete(obj *PageObject) error {
+	if err := v.tx.checkActive(); err != nil {
+		return err
+	}
+
+	// The stored version is checked rather than obj, which may have been
+	// read before another transaction expired it.
+	stored, err := v.btree.get(obj.Key, int(obj.TransactionID))
+	if err != nil {
+		return err
+	}
+	if stored == nil || stored.DeleteID != 0 {
+		return v.tx.abortOnConflict(SQLStateError{
+			Code: "40001",
+			Msg:  "row has already been deleted or updated",
+		})
+	}
+
+	v.logExpire(obj)
+	if int(obj.TransactionID) == v.tx.ID {
+		return v.btree.Remove(obj.Key, v.tx.ID, true)
+	}
+
+	_, err = v.btree.Expire(obj.Key, int(obj.TransactionID), v.tx.ID)
+	return v.tx.abortOnConflict(err)
+}
+
+// logWrite adds the version of key the transaction is about to write to its
+// undo log.
+func (v *treeView) logWrite(key []byte) {
+	v.tx.undoLog = append(v.tx.undoLog, undoEntry{root: v.btree.Root, key: append([]byte{}, key...)})
+}
+
+// logExpire adds the version obj, which the transaction is about to expire,
+// replace or remove, to its undo log. A version of its own is kept whole, as
+// it is removed rather than expired.
+func (v *treeView) logExpire(obj *PageObject) {
+	if int(obj.TransactionID) == v.tx.ID {
+		v.tx.undoLog = append(v.tx.undoLog, undoEntry{
+			root:     v.btree.Root,
+			key:      append([]byte{}, obj.Key...),
+			previous: copyObject(obj),
+		})
+		return
+	}
+	v.tx.undoLog = append(v.tx.undoLog, undoEntry{
+		root:    v.btree.Root,
+		key:     append([]byte{}, obj.Key...),
+		expired: true,
+		creator: int(obj.TransactionID),
+	})
+}
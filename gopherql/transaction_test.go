@@ -0,0 +1,293 @@
+package gopherql
+
+import "testing"
+
+func newTestTransactionManager() (*TransactionManager, *Header) {
+	header := NewHeader()
+	persist := func(h *Header) error {
+		return nil
+	}
+	catalog, _ := NewPageAllocator(NewMemoryPager()).CreateTree()
+	return NewTransactionManager(header, catalog, persist), header
+}
+
+func TestTransaction_CommitVisibility(t *testing.T) {
+
+	tm, _ := newTestTransactionManager()
+
+	writer, err := tm.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Add([]byte("key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	concurrent, err := tm.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := concurrent.Get([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj != nil {
+		t.Error("expected write to be invisible to a transaction that began before commit")
+	}
+
+	later, err := tm.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err = later.Get([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj == nil || string(obj.Value) != "value" {
+		t.Error("expected committed write to be visible")
+	}
+}
+
+func TestTransaction_Rollback(t *testing.T) {
+
+	tm, header := newTestTransactionManager()
+
+	setup, _ := tm.Begin()
+	if err := setup.Add([]byte("existing"), []byte("before")); err != nil {
+		t.Fatal(err)
+	}
+	if err := setup.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, _ := tm.Begin()
+	existing, err := tx.Get([]byte("existing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Update(existing, existing.Key, []byte("after")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Add([]byte("new"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(header.ActiveTransactions) != 0 {
+		t.Errorf("expected no active transactions, got: %v", header.ActiveTransactions)
+	}
+
+	reader, _ := tm.Begin()
+	obj, err := reader.Get([]byte("existing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj == nil || string(obj.Value) != "before" || obj.DeleteID != 0 {
+		t.Errorf("expected original row to be restored, got: %v", obj)
+	}
+
+	obj, err = reader.Get([]byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj != nil {
+		t.Error("expected rolled back insert to be removed")
+	}
+}
+
+func TestTransaction_ConflictAborts(t *testing.T) {
+
+	tm, _ := newTestTransactionManager()
+
+	setup, _ := tm.Begin()
+	if err := setup.Add([]byte("key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if err := setup.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	first, _ := tm.Begin()
+	second, _ := tm.Begin()
+
+	firstRow, _ := first.Get([]byte("key"))
+
+	if err := first.Delete(firstRow); err != nil {
+		t.Fatal(err)
+	}
+
+	secondRow, _ := second.Get([]byte("key"))
+	err := second.Update(secondRow, secondRow.Key, []byte("changed"))
+	if sqlErr, ok := err.(SQLStateError); !ok || sqlErr.Code != "40001" {
+		t.Fatalf("expected serialization failure, got: %v", err)
+	}
+
+	if err := second.Add([]byte("other"), nil); err == nil {
+		t.Error("expected aborted transaction to reject writes")
+	}
+}
+
+func TestTransaction_DeleteStaleCopy(t *testing.T) {
+
+	tm, _ := newTestTransactionManager()
+
+	setup, _ := tm.Begin()
+	if err := setup.Add([]byte("key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if err := setup.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	first, _ := tm.Begin()
+	second, _ := tm.Begin()
+
+	// Both read the row before either deletes it.
+	firstRow, _ := first.Get([]byte("key"))
+	secondRow, _ := second.Get([]byte("key"))
+
+	if err := first.Delete(firstRow); err != nil {
+		t.Fatal(err)
+	}
+	expectSQLState(t, second.Delete(secondRow), "40001")
+	if err := second.Add([]byte("other"), nil); err == nil {
+		t.Error("expected aborted transaction to reject writes")
+	}
+	if err := first.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	later, _ := tm.Begin()
+	obj, err := later.Get([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj != nil {
+		t.Errorf("expected the committed delete to be kept, got: %v", obj)
+	}
+}
+
+func TestTransactionManager_Recover(t *testing.T) {
+
+	tm, header := newTestTransactionManager()
+
+	crashed, _ := tm.Begin()
+	if err := crashed.Add([]byte("key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := NewTransactionManager(header, tm.btree, tm.persist)
+	if err := restarted.Recover(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, _ := restarted.Begin()
+	obj, err := reader.Get([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj != nil {
+		t.Error("expected write of crashed transaction to be rolled back")
+	}
+}
+
+func TestTransaction_RollbackVisitsOnlyChanges(t *testing.T) {
+	dbFile := "rollbackCostTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	db := openExecutorDB(t, dbFile,
+		"CREATE TABLE big (id INTEGER PRIMARY KEY, body TEXT)",
+		"CREATE TABLE small (id INTEGER PRIMARY KEY, body TEXT)",
+		"INSERT INTO small VALUES (1, 'a')",
+	)
+	defer db.Close()
+	insertRows(t, db, "big", 1000)
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("INSERT INTO small VALUES (2, 'b')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("UPDATE small SET body = 'c' WHERE id = 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Count the pages the rollback reads: it must not scan the big table.
+	fetched := 0
+	pager := db.btree.Allocator.Pager
+	db.btree.Allocator.Pager = countingPager{Pager: pager, fetched: &fetched}
+	err = tx.Rollback()
+	db.btree.Allocator.Pager = pager
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetched > 20 {
+		t.Errorf("expected the rollback to read only the pages it changed, read: %d", fetched)
+	}
+
+	if found := joinRows(queryRows(t, db, "SELECT id, body FROM small")); found != "1,a" {
+		t.Errorf("unexpected rows after rollback: %s", found)
+	}
+}
+
+func TestTransaction_StatementAtomicity(t *testing.T) {
+	dbFile := "statementTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	db := openExecutorDB(t, dbFile,
+		"CREATE TABLE t (id INTEGER PRIMARY KEY, v TEXT NOT NULL UNIQUE)",
+		"INSERT INTO t VALUES (1, 'a')",
+	)
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sql := range []string{
+		"INSERT INTO t VALUES (2, 'b'), (3, 'c')",
+		"UPDATE t SET v = 'x' WHERE id = 1",
+	} {
+		if _, err := tx.Exec(sql); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each statement fails on a later row, after writing earlier ones.
+	for _, test := range []struct {
+		sql  string
+		code string
+	}{
+		{"INSERT INTO t VALUES (4, 'd'), (5, 'e'), (2, 'f')", "23505"},
+		{"INSERT INTO t VALUES (6, 'g'), (7, NULL)", "23502"},
+		{"UPDATE t SET v = CASE WHEN id = 3 THEN NULL ELSE v || 'y' END", "23502"},
+		{"UPDATE t SET v = 'b' WHERE id <> 2", "23505"},
+	} {
+		_, err := tx.Exec(test.sql)
+		expectSQLState(t, err, test.code)
+	}
+	if _, err := tx.Exec("DELETE FROM t WHERE id = 3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if found := joinRows(queryRows(t, db, "SELECT id, v FROM t ORDER BY id")); found != "1,x;2,b" {
+		t.Errorf("expected only the statements that succeeded to commit, got: %s", found)
+	}
+	if found := joinRows(queryRows(t, db, "SELECT id FROM t WHERE v = 'x'")); found != "1" {
+		t.Errorf("unexpected unique index entries: %s", found)
+	}
+}
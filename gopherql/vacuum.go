@@ -0,0 +1,250 @@
+package gopherql
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Vacuum removes every row version no transaction can see any more and then
+// compacts the file. Pages no tree can reach, such as those of trees dropped
+// just before a crash, are reclaimed, pages in use are moved from the end of
+// the file into free pages nearer its start, and the file is truncated after
+// the last page in use. It cannot run while a transaction is active.
+func (db *DB) Vacuum() error {
+
+	if db.file == nil {
+		return errors.New("database is closed")
+	}
+	if len(db.header.ActiveTransactions) > 0 {
+		return SQLStateError{Code: "55006", Msg: "VACUUM cannot run while transactions are active"}
+	}
+
+	if err := db.transactions.pruneAllDeadVersions(); err != nil {
+		return err
+	}
+	if err := db.transactions.compact(); err != nil {
+		return err
+	}
+
+	if err := db.writeHeader(db.header); err != nil {
+		return err
+	}
+	if err := db.btree.Pager.Flush(); err != nil {
+		return err
+	}
+	return db.filePager.Checkpoint()
+}
+
+// liveTrees returns the catalog root followed by the root of every tree a
+// live catalog entry records.
+func (tm *TransactionManager) liveTrees() ([]int, error) {
+
+	roots := []int{tm.btree.Root}
+	err := tm.catalogEntries(func(obj *PageObject, entryRoots []int) error {
+		if obj.DeleteID == 0 {
+			roots = append(roots, entryRoots...)
+		}
+		return nil
+	})
+	return roots, err
+}
+
+// pruneAllDeadVersions removes every deleted version from every tree. With no
+// transaction running, none of them can be seen any more.
+func (tm *TransactionManager) pruneAllDeadVersions() error {
+
+	roots, err := tm.liveTrees()
+	if err != nil {
+		return err
+	}
+
+	for _, root := range roots {
+		bt := tm.tree(root)
+
+		it, err := bt.Iterator(nil, nil)
+		if err != nil {
+			return err
+		}
+		dead := []*PageObject{}
+		for {
+			obj, err := it.nextRaw()
+			if err != nil {
+				return err
+			}
+			if obj == nil {
+				break
+			}
+			if obj.DeleteID != 0 {
+				dead = append(dead, obj)
+			}
+		}
+
+		for _, obj := range dead {
+			if err := bt.Remove(obj.Key, int(obj.TransactionID), true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// pageOwner records where the pointer to a page is kept: in its parent page,
+// or for the root of a tree, in the catalog or the header.
+type pageOwner struct {
+	parent int
+	root   bool
+}
+
+// compact moves every page in use below the first free page, working back
+// from the end of the file, and truncates the file behind them.
+func (tm *TransactionManager) compact() error {
+
+	allocator := tm.btree.Allocator
+	pager := allocator.Pager
+
+	roots, err := tm.liveTrees()
+	if err != nil {
+		return err
+	}
+
+	owners := map[int]pageOwner{}
+	for _, root := range roots {
+		if err := walkTree(pager, root, owners); err != nil {
+			return err
+		}
+	}
+
+	last := allocator.FirstPage + pager.TotalPages() - 1
+	free := []int{}
+	for num := allocator.FirstPage; num <= last; num++ {
+		if _, ok := owners[num]; !ok {
+			free = append(free, num)
+		}
+	}
+
+	for len(free) > 0 {
+		if free[len(free)-1] == last {
+			free = free[:len(free)-1]
+		} else {
+			if err := tm.movePage(last, free[0], owners); err != nil {
+				return err
+			}
+			free = free[1:]
+		}
+		if err := pager.TruncateLastPage(); err != nil {
+			return err
+		}
+		last--
+	}
+
+	return allocator.setFreePages(nil)
+}
+
+// walkTree records the owner of every page of the tree rooted at root.
+func walkTree(pager Pager, root int, owners map[int]pageOwner) error {
+
+	if _, ok := owners[root]; ok {
+		return fmt.Errorf("page %d belongs to more than one tree", root)
+	}
+	owners[root] = pageOwner{parent: -1, root: true}
+
+	pending := []int{root}
+	for len(pending) > 0 {
+		num := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		page, err := pager.FetchPage(num)
+		if err != nil {
+			return err
+		}
+		if page.Kind != kindNotLeaf {
+			continue
+		}
+		for _, obj := range page.Objects() {
+			child := pointerPage(obj)
+			if _, ok := owners[child]; ok {
+				return fmt.Errorf("page %d is reachable twice", child)
+			}
+			owners[child] = pageOwner{parent: num}
+			pending = append(pending, child)
+		}
+	}
+	return nil
+}
+
+// movePage copies page from into the free page to and points its owner at
+// the copy.
+func (tm *TransactionManager) movePage(from, to int, owners map[int]pageOwner) error {
+
+	pager := tm.btree.Allocator.Pager
+	page, err := pager.FetchPage(from)
+	if err != nil {
+		return err
+	}
+	page = pageFromBytes(page.Bytes())
+	if err := pager.StorePage(to, page); err != nil {
+		return err
+	}
+
+	owner := owners[from]
+	delete(owners, from)
+	owners[to] = owner
+
+	if page.Kind == kindNotLeaf {
+		for _, obj := range page.Objects() {
+			owners[pointerPage(obj)] = pageOwner{parent: to}
+		}
+	}
+
+	if owner.root {
+		return tm.moveRoot(from, to)
+	}
+
+	parent, err := pager.FetchPage(owner.parent)
+	if err != nil {
+		return err
+	}
+	for _, obj := range parent.Objects() {
+		if pointerPage(obj) == from {
+			key := append([]byte{}, obj.Key...)
+			parent.Delete(key, 0)
+			if err := parent.Add(newPointerObject(key, to)); err != nil {
+				return err
+			}
+			return pager.StorePage(owner.parent, parent)
+		}
+	}
+	return fmt.Errorf("page %d has no pointer to page %d", owner.parent, from)
+}
+
+// moveRoot records that a tree's root moved, in the header for the catalog
+// and in the catalog entry for any other tree.
+func (tm *TransactionManager) moveRoot(from, to int) error {
+
+	if from == tm.btree.Root {
+		tm.btree.Root = to
+		return tm.btree.Pager.SetRootPage(to)
+	}
+
+	var entry *PageObject
+	err := tm.catalogEntries(func(obj *PageObject, roots []int) error {
+		for _, root := range roots {
+			if root == from {
+				entry = obj
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return fmt.Errorf("no catalog entry records tree %d", from)
+	}
+
+	value, err := replaceEntryRoot(entry, from, to)
+	if err != nil {
+		return err
+	}
+	return tm.btree.rewrite(entry.Key, int(entry.TransactionID), value)
+}
@@ -0,0 +1,169 @@
+package gopherql
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// insertRows fills a table with rows of a padded id and a long text value.
+func insertRows(t *testing.T, db *DB, table string, count int) {
+	t.Helper()
+
+	values := []string{}
+	for id := 0; id < count; id++ {
+		values = append(values, fmt.Sprintf("(%d, '%s')", id, strings.Repeat("x", 200)))
+	}
+	if _, err := db.Exec(fmt.Sprintf("INSERT INTO %s VALUES %s", table, strings.Join(values, ", "))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+func TestFreeList_SurvivesRestart(t *testing.T) {
+	dbFile := "freeListTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	db, err := Open(dbFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sql := range []string{
+		"CREATE TABLE first (id INTEGER PRIMARY KEY, body TEXT)",
+		"CREATE TABLE second (id INTEGER PRIMARY KEY, body TEXT)",
+	} {
+		if _, err := db.Exec(sql); err != nil {
+			t.Fatal(err)
+		}
+	}
+	insertRows(t, db, "first", 200)
+	insertRows(t, db, "second", 10)
+	if _, err := db.Exec("DROP TABLE first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(dbFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	freed := int(db.header.FreePageCount)
+	if freed == 0 {
+		t.Fatal("expected the pages of the dropped table on the free list")
+	}
+	size := fileSize(t, dbFile)
+
+	if _, err := db.Exec("CREATE TABLE third (id INTEGER PRIMARY KEY, body TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	insertRows(t, db, "third", 50)
+	if err := db.filePager.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+
+	if fileSize(t, dbFile) != size {
+		t.Error("expected the new table to reuse free pages rather than grow the file")
+	}
+	if db.btree.Allocator.FreeCount >= freed {
+		t.Errorf("expected free pages to be taken, %d of %d left", db.btree.Allocator.FreeCount, freed)
+	}
+	if rows := tableRows(t, db, "SECOND"); len(rows) != 10 {
+		t.Errorf("expected the other table to be untouched, got %d rows", len(rows))
+	}
+}
+
+func TestVacuum_ShrinksFile(t *testing.T) {
+	dbFile := "vacuumTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	db, err := Open(dbFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("CREATE TABLE early (id INTEGER PRIMARY KEY, body TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	insertRows(t, db, "early", 300)
+
+	// The roots of the later trees sit behind the pages of the dropped table,
+	// so compaction has to move them and update the catalog.
+	for _, sql := range []string{
+		"CREATE TABLE late (id INTEGER PRIMARY KEY, body TEXT)",
+		"CREATE INDEX late_body ON late (body, id)",
+	} {
+		if _, err := db.Exec(sql); err != nil {
+			t.Fatal(err)
+		}
+	}
+	insertRows(t, db, "late", 100)
+	if _, err := db.Exec("DELETE FROM late WHERE id >= 50"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("DROP TABLE early"); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tx.Exec("VACUUM")
+	expectSQLState(t, err, "25001")
+	_, err = db.Exec("VACUUM")
+	expectSQLState(t, err, "55006")
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.filePager.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	before := fileSize(t, dbFile)
+	if _, err := db.Exec("VACUUM"); err != nil {
+		t.Fatal(err)
+	}
+	if after := fileSize(t, dbFile); after >= before/2 {
+		t.Errorf("expected the file to shrink, from %d to %d bytes", before, after)
+	}
+	if db.btree.Allocator.FreeCount != 0 {
+		t.Errorf("expected no free pages after compaction, got: %d", db.btree.Allocator.FreeCount)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = Open(dbFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if rows := tableRows(t, db, "LATE"); len(rows) != 50 || rows[49][0] != "49" {
+		t.Errorf("unexpected rows after vacuum: %d", len(rows))
+	}
+	body := strings.Repeat("x", 200)
+	if found := indexLookup(t, db, "LATE_BODY", Row{NewStringValue(body)}); len(found) != 50 {
+		t.Errorf("expected the index to survive compaction, found %d rows", len(found))
+	}
+	_, err = db.Exec(fmt.Sprintf("INSERT INTO late VALUES (10, '%s')", body))
+	expectSQLState(t, err, "23505")
+	if _, err := db.Exec("CREATE TABLE early (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Errorf("expected the dropped table to be gone: %s", err)
+	}
+}
@@ -0,0 +1,178 @@
+package gopherql
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	microsPerSecond = 1000000
+	secondsPerDay   = 24 * 60 * 60
+)
+
+// Value is a single SQL value. Only the field matching Type is meaningful,
+// and none of them are when IsNull is set. BYTEA values are kept in
+// StringValue, so that Values stay comparable with ==, and Scale is the
+// number of digits of IntValue after the decimal point of a DECIMAL.
+type Value struct {
+	Type        ColumnType
+	IsNull      bool
+	IntValue    int64
+	FloatValue  float64
+	BoolValue   bool
+	StringValue string
+	Scale       int
+}
+
+// Row holds one Value per column of a Table, in column order.
+type Row []Value
+
+func NewNullValue(columnType ColumnType) Value {
+	return Value{Type: columnType, IsNull: true}
+}
+
+func NewInt16Value(val int16) Value {
+	return Value{Type: Int16Column, IntValue: int64(val)}
+}
+
+func NewInt32Value(val int32) Value {
+	return Value{Type: Int32Column, IntValue: int64(val)}
+}
+
+func NewInt64Value(val int64) Value {
+	return Value{Type: Int64Column, IntValue: val}
+}
+
+func NewFloat32Value(val float32) Value {
+	return Value{Type: Float32Column, FloatValue: float64(val)}
+}
+
+func NewFloat64Value(val float64) Value {
+	return Value{Type: Float64Column, FloatValue: val}
+}
+
+// NewDecimalValue returns the DECIMAL unscaled / 10^scale.
+func NewDecimalValue(unscaled int64, scale int) Value {
+	return Value{Type: DecimalColumn, IntValue: unscaled, Scale: scale}
+}
+
+func NewBoolValue(val bool) Value {
+	return Value{Type: BoolColumn, BoolValue: val}
+}
+
+func NewStringValue(val string) Value {
+	return Value{Type: StringColumn, StringValue: val}
+}
+
+func NewCharValue(val string) Value {
+	return Value{Type: CharColumn, StringValue: val}
+}
+
+func NewBytesValue(val []byte) Value {
+	return Value{Type: BytesColumn, StringValue: string(val)}
+}
+
+// NewDateValue returns the calendar date of t in its own location.
+func NewDateValue(t time.Time) Value {
+	year, month, day := t.Date()
+	midnight := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	return Value{Type: DateColumn, IntValue: midnight.Unix() / secondsPerDay}
+}
+
+// NewTimeValue returns the time of day of t in its own location.
+func NewTimeValue(t time.Time) Value {
+	hour, min, sec := t.Clock()
+	seconds := int64(hour*60*60 + min*60 + sec)
+	return Value{Type: TimeColumn, IntValue: seconds*microsPerSecond + int64(t.Nanosecond()/1000)}
+}
+
+// NewTimestampValue returns the wall clock reading of t, dropping its
+// location.
+func NewTimestampValue(t time.Time) Value {
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	return Value{Type: TimestampColumn, IntValue: wall.UnixMicro()}
+}
+
+// NewTimestampTzValue returns the instant t.
+func NewTimestampTzValue(t time.Time) Value {
+	return Value{Type: TimestampTzColumn, IntValue: t.UnixMicro()}
+}
+
+// Time returns a DATE, TIME or TIMESTAMP as a time in UTC. A TIME is on
+// 1970-01-01.
+func (v Value) Time() time.Time {
+	if v.Type == DateColumn {
+		return time.Unix(v.IntValue*secondsPerDay, 0).UTC()
+	}
+	return time.UnixMicro(v.IntValue).UTC()
+}
+
+func (v Value) String() string {
+	if v.IsNull {
+		return "NULL"
+	}
+
+	switch v.Type {
+	case Int16Column, Int32Column, Int64Column:
+		return strconv.FormatInt(v.IntValue, 10)
+	case Float32Column:
+		return formatFloat(v.FloatValue, 32)
+	case Float64Column:
+		return formatFloat(v.FloatValue, 64)
+	case DecimalColumn:
+		return formatDecimal(v.IntValue, v.Scale)
+	case BoolColumn:
+		if v.BoolValue {
+			return "TRUE"
+		}
+		return "FALSE"
+	case StringColumn, CharColumn:
+		return v.StringValue
+	case BytesColumn:
+		return `\x` + hex.EncodeToString([]byte(v.StringValue))
+	case DateColumn:
+		return v.Time().Format("2006-01-02")
+	case TimeColumn:
+		return v.Time().Format("15:04:05.999999")
+	case TimestampColumn:
+		return v.Time().Format("2006-01-02 15:04:05.999999")
+	case TimestampTzColumn:
+		return v.Time().Format("2006-01-02 15:04:05.999999-07")
+	}
+	return fmt.Sprintf("<unknown type %d>", v.Type)
+}
+
+func formatFloat(val float64, bitSize int) string {
+	switch {
+	case math.IsInf(val, 1):
+		return "Infinity"
+	case math.IsInf(val, -1):
+		return "-Infinity"
+	case math.IsNaN(val):
+		return "NaN"
+	}
+	return strconv.FormatFloat(val, 'g', -1, bitSize)
+}
+
+func formatDecimal(unscaled int64, scale int) string {
+
+	digits := strconv.FormatUint(uint64(unscaled), 10)
+	sign := ""
+	if unscaled < 0 {
+		digits = strconv.FormatUint(uint64(-unscaled), 10)
+		sign = "-"
+	}
+	if scale == 0 {
+		return sign + digits
+	}
+
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	point := len(digits) - scale
+	return sign + digits[:point] + "." + digits[point:]
+}
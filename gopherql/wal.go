@@ -0,0 +1,176 @@
+package gopherql
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+)
+
+const (
+	walFramePage   = 1
+	walFrameCommit = 2
+	walFrameHeader = 9
+)
+
+// writeAheadLog appends page images to a file beside the database. Images are
+// buffered until commit, which writes them followed by a commit frame and
+// syncs once. Only batches ending in a commit frame are replayed on open.
+//
+// Each frame is a kind byte, a page number and a CRC32 of the page number and
+// image. A commit frame stores the total page count of the database instead
+// of a page number and carries no image.
+type writeAheadLog struct {
+	path     string
+	pageSize int
+	file     *os.File
+	pending  []byte
+}
+
+func walPath(dbPath string) string {
+	return dbPath + "-wal"
+}
+
+func newWriteAheadLog(path string, pageSize int) *writeAheadLog {
+	return &writeAheadLog{
+		path:     path,
+		pageSize: pageSize,
+	}
+}
+
+func walFrame(kind byte, pageNumber int, data []byte) []byte {
+	frame := make([]byte, walFrameHeader, walFrameHeader+len(data))
+	frame[0] = kind
+	binary.BigEndian.PutUint32(frame[1:5], uint32(pageNumber))
+	checksum := crc32.Update(crc32.ChecksumIEEE(frame[1:5]), crc32.IEEETable, data)
+	binary.BigEndian.PutUint32(frame[5:9], checksum)
+	return append(frame, data...)
+}
+
+func (w *writeAheadLog) append(pageNumber int, data []byte) {
+	w.pending = append(w.pending, walFrame(walFramePage, pageNumber, data)...)
+}
+
+func (w *writeAheadLog) hasPending() bool {
+	return len(w.pending) > 0
+}
+
+// commit durably writes every pending image. The log file is only created the
+// first time there is something to commit.
+func (w *writeAheadLog) commit(totalPages int) error {
+
+	if !w.hasPending() {
+		return nil
+	}
+
+	if w.file == nil {
+		file, err := os.OpenFile(w.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		w.file = file
+	}
+
+	w.pending = append(w.pending, walFrame(walFrameCommit, totalPages, nil)...)
+	if _, err := w.file.Write(w.pending); err != nil {
+		return err
+	}
+	w.pending = nil
+
+	return w.file.Sync()
+}
+
+// reset empties the log once its contents have been checkpointed.
+func (w *writeAheadLog) reset() error {
+	w.pending = nil
+	if w.file == nil {
+		err := os.Truncate(w.path, 0)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+func (w *writeAheadLog) close() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// truncate cuts the log off after its first size bytes.
+func (w *writeAheadLog) truncate(size int) error {
+	err := os.Truncate(w.path, int64(size))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// replay calls apply for every page image in a committed batch, in log order,
+// and returns the page count recorded by the last commit, or -1 when nothing
+// was committed, along with the offset at which the last commit frame ends.
+// A torn or corrupt frame ends the replay.
+func (w *writeAheadLog) replay(apply func(pageNumber int, data []byte) error) (int, int, error) {
+
+	contents, err := os.ReadFile(w.path)
+	if os.IsNotExist(err) {
+		return -1, 0, nil
+	}
+	if err != nil {
+		return -1, 0, err
+	}
+
+	type image struct {
+		pageNumber int
+		data       []byte
+	}
+
+	totalPages, end := -1, 0
+	batch := []image{}
+	reader := NewByteReader(contents)
+
+	for len(contents)-reader.Offset >= walFrameHeader {
+		kind := reader.ReadByte()
+		pageNumber := reader.ReadUint32()
+		checksum := uint32(reader.ReadUint32())
+
+		if kind != walFramePage && kind != walFrameCommit {
+			break
+		}
+
+		var data []byte
+		if kind == walFramePage {
+			if len(contents)-reader.Offset < w.pageSize {
+				break
+			}
+			data = reader.ReadBytes(w.pageSize)
+		}
+
+		expected := walFrame(kind, pageNumber, data)
+		if binary.BigEndian.Uint32(expected[5:9]) != checksum {
+			break
+		}
+
+		if kind == walFramePage {
+			batch = append(batch, image{pageNumber, data})
+			continue
+		}
+
+		for _, img := range batch {
+			if err := apply(img.pageNumber, img.data); err != nil {
+				return -1, 0, err
+			}
+		}
+		batch = batch[:0]
+		totalPages, end = pageNumber, reader.Offset
+	}
+
+	return totalPages, end, nil
+}
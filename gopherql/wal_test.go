@@ -0,0 +1,134 @@
+package gopherql
+
+import (
+	"os"
+	"testing"
+)
+
+func openTestFilePager(t *testing.T, dbFile string) (*os.File, *FilePager) {
+	file, err := os.OpenFile(dbFile, os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fp, err := NewFilePager(file, defaultPgSize, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file, fp
+}
+
+func TestFilePager_ReplaysCommittedPages(t *testing.T) {
+	dbFile := "walReplayTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	if err := NewDatabaseFile(dbFile); err != nil {
+		t.Fatal(err)
+	}
+
+	file, fp := openTestFilePager(t, dbFile)
+
+	committed := NewPage(kindLeaf, defaultPgSize)
+	if err := committed.Add(NewPageObject([]byte("committed"), []byte("value"), 2, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fp.AppendPage(committed); err != nil {
+		t.Fatal(err)
+	}
+	if err := fp.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	uncommitted := NewPage(kindLeaf, defaultPgSize)
+	if _, err := fp.AppendPage(uncommitted); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a crash, leaving the log behind with a torn frame on the end.
+	fp.wal.close()
+	file.Close()
+
+	wal, err := os.OpenFile(walPath(dbFile), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wal.Write([]byte{walFramePage, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+	wal.Close()
+
+	file, fp = openTestFilePager(t, dbFile)
+	defer file.Close()
+
+	if fp.TotalPages() != 1 {
+		t.Fatalf("expected only the committed page, got: %d pages", fp.TotalPages())
+	}
+
+	page, err := fp.FetchPage(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Get([]byte("committed"), 2) == nil {
+		t.Error("expected committed page to be replayed")
+	}
+
+	info, err := os.Stat(walPath(dbFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 0 {
+		t.Error("expected log to be emptied after replay")
+	}
+
+	if err := fp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(walPath(dbFile)); !os.IsNotExist(err) {
+		t.Error("expected log to be removed on close")
+	}
+}
+
+func TestFilePager_CommitsAfterTornTail(t *testing.T) {
+	dbFile := "walTornTst.db"
+	defer deleteFile(dbFile)
+	defer deleteFile(walPath(dbFile))
+
+	if err := NewDatabaseFile(dbFile); err != nil {
+		t.Fatal(err)
+	}
+
+	// A torn write leaves a partial batch with no commit frame.
+	torn := walFrame(walFramePage, 1, make([]byte, defaultPgSize))
+	if err := os.WriteFile(walPath(dbFile), torn[:len(torn)/2], 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	file, fp := openTestFilePager(t, dbFile)
+	committed := NewPage(kindLeaf, defaultPgSize)
+	if err := committed.Add(NewPageObject([]byte("committed"), []byte("value"), 2, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fp.AppendPage(committed); err != nil {
+		t.Fatal(err)
+	}
+	if err := fp.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a crash before the commit is checkpointed.
+	fp.wal.close()
+	file.Close()
+
+	file, fp = openTestFilePager(t, dbFile)
+	defer file.Close()
+	defer fp.Close()
+
+	page, err := fp.FetchPage(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Get([]byte("committed"), 2) == nil {
+		t.Error("expected the commit made after the torn write to be replayed")
+	}
+}